@@ -4,10 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -95,6 +99,9 @@ func main() {
 	if err := db.SeedAppSettings(ctx, database, cfg.RadioLLMEnabled); err != nil {
 		log.Fatalf("seed app settings: %v", err)
 	}
+	if err := db.SeedDefaultLibrary(ctx, database, cfg.MediaRoot, cfg.ScanExcludePattern); err != nil {
+		log.Fatalf("seed default library: %v", err)
+	}
 
 	if _, err := exec.LookPath(cfg.FFmpegPath); err != nil {
 		log.Fatalf("ffmpeg not found at %s: %v", cfg.FFmpegPath, err)
@@ -103,24 +110,82 @@ func main() {
 		log.Fatalf("ffprobe not found at %s: %v", cfg.FFprobePath, err)
 	}
 
-	scanner := services.NewScannerService(database, cfg.MediaRoot, cfg.FFprobePath, cfg.FFmpegPath, cfg.ScanExcludePattern, cfg.ScanEmbeddedCover, cfg.ScanWatch, cfg.ScanWorkers, cfg.CoverCachePath, cfg.ScanAutoPlaylists, cfg.MetadataEnrichEnabled, cfg.MetadataEnrichURL)
-	if cfg.ScanWatch {
+	var mirrorDir string
+	if cfg.PlaylistMirrorDir != "" {
+		mirrorDir = filepath.Join(cfg.MediaRoot, cfg.PlaylistMirrorDir)
+	}
+	// Background workers run until shutdown cancels background, which then
+	// waits for them
+	background, stopBackground := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	goBackground := func(run func(context.Context)) {
+		workers.Add(1)
 		go func() {
-			if err := scanner.Watch(context.Background()); err != nil {
+			defer workers.Done()
+			run(background)
+		}()
+	}
+
+	events := services.NewEventBus(database)
+	scanner := services.NewScannerService(database, events, services.ScannerConfig{
+		FFprobePath:       cfg.FFprobePath,
+		FFmpegPath:        cfg.FFmpegPath,
+		ScanEmbeddedCover: cfg.ScanEmbeddedCover,
+		Watch:             cfg.ScanWatch,
+		Workers:           cfg.ScanWorkers,
+		CoverCachePath:    cfg.CoverCachePath,
+		AutoPlaylists:     cfg.ScanAutoPlaylists,
+		EnrichEnabled:     cfg.MetadataEnrichEnabled,
+		MetadataURL:       cfg.MetadataEnrichURL,
+		ContentHash:       cfg.ScanContentHash,
+		PlaylistSync:      cfg.ScanPlaylistSync,
+		MirrorDir:         mirrorDir,
+		CoverPriority:     cfg.ScanCoverPriority,
+		ArtistImages:      cfg.ScanArtistImages,
+	})
+	if err := scanner.CancelInterruptedScans(ctx); err != nil {
+		log.Printf("mark interrupted scans: %v", err)
+	}
+	goBackground(scanner.Schedule)
+	if cfg.ScanWatch {
+		goBackground(func(ctx context.Context) {
+			if err := scanner.Watch(ctx); err != nil {
 				log.Printf("scanner watch stopped: %v", err)
 			}
-		}()
+		})
+	}
+	if cfg.ScanLoudness {
+		goBackground(services.NewLoudnessService(database, cfg.FFmpegPath).Run)
 	}
 	search := services.NewSearchService(database)
 	transcoder := services.NewTranscoder(cfg.FFmpegPath)
 	var mb *services.MusicBrainzService
 	var lb *services.ListenBrainzService
+	var lastFM *services.LastFMService
 	if cfg.EnableMusicBrainz {
 		mb = services.NewMusicBrainzService(cfg.MusicBrainzAgent)
 	}
 	if cfg.EnableListenBrainz {
-		lb = services.NewListenBrainzService(cfg.ListenBrainzToken, cfg.ListenBrainzUser)
+		lb = services.NewListenBrainzService(database, cfg.ListenBrainzMinPct, cfg.ListenBrainzMinSecs)
+	}
+	if cfg.LastFMAPIKey != "" && cfg.LastFMAPISecret != "" {
+		lastFM = services.NewLastFMService(database, cfg.LastFMAPIKey, cfg.LastFMAPISecret)
 	}
+	var scrobbles *services.ScrobbleService
+	if lb != nil || lastFM != nil {
+		scrobbles = services.NewScrobbleService(database, lb, lastFM)
+		goBackground(scrobbles.Run)
+	}
+
+	smartPlaylists := services.NewSmartPlaylistRefresher(database)
+	goBackground(smartPlaylists.Run)
+
+	var lyricsProvider services.LyricsProvider
+	if cfg.EnableLyricsProvider {
+		lyricsProvider = services.NewLRCLibProvider(cfg.LyricsProviderURL)
+	}
+	lyrics := services.NewLyricsService(database, lyricsProvider)
+	artwork := services.NewArtworkService(filepath.Join(cfg.CoverCachePath, "variants"), cfg.FFmpegPath)
 
 	var radio *services.RadioService
 	if cfg.RadioLLMEnabled && cfg.RadioLLMAPIKey != "" {
@@ -141,8 +206,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("hls service: %v", err)
 	}
-	hlsService.Start(ctx)
-	defer hlsService.Stop()
+	hlsService.Start(background)
 	log.Printf("HLS streaming enabled with %dMB cache at %s", cfg.HLSCacheSizeMB, cfg.HLSCacheDir)
 
 	e := api.New(api.Deps{
@@ -154,7 +218,13 @@ func main() {
 		Transcoder:        transcoder,
 		MusicBrainz:       mb,
 		ListenBrainz:      lb,
+		LastFM:            lastFM,
+		Scrobbles:         scrobbles,
+		Lyrics:            lyrics,
+		Artwork:           artwork,
 		Radio:             radio,
+		Events:            events,
+		SmartPlaylists:    smartPlaylists,
 		HLS:               hlsService,
 		MediaRoot:         cfg.MediaRoot,
 		AuthRate:          cfg.RateLimitAuthCount,
@@ -164,6 +234,13 @@ func main() {
 	})
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
+	// Requests run on contexts derived from requests, so cancelling it kills
+	// the ffmpeg processes of those still running when shutdown gives up on
+	// them. Event streams never finish on their own and are closed instead.
+	requests, cancelRequests := context.WithCancel(context.Background())
+	e.Server.BaseContext = func(net.Listener) context.Context { return requests }
+	e.Server.RegisterOnShutdown(events.Close)
+
 	go func() {
 		if err := e.Start(cfg.Addr); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("server error: %v", err)
@@ -171,13 +248,34 @@ func main() {
 	}()
 
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
-	ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	log.Printf("shutting down")
+	ctxShutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+
+	stopBackground()
+	if err := scanner.Close(ctxShutdown); err != nil {
+		log.Printf("scanner shutdown: %v", err)
+	}
 	if err := e.Shutdown(ctxShutdown); err != nil {
 		log.Printf("server shutdown: %v", err)
 	}
+	cancelRequests()
+	if err := hlsService.Shutdown(ctxShutdown); err != nil {
+		log.Printf("hls shutdown: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctxShutdown.Done():
+		log.Printf("background workers still running at shutdown")
+	}
 }
 
 func seedAdmin(ctx context.Context, auth *services.AuthService, dbConn *sql.DB, username, email, password string) error {
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/Aunali321/korus/internal/db"
@@ -18,20 +19,56 @@ import (
 
 // StartScan godoc
 // @Summary Start library scan
+// @Description Scans one library, or every library when library is omitted. Unchanged files are skipped unless full is set. Only admins can scan a single library or run a full scan
 // @Tags Library
 // @Produce json
+// @Param library query int false "library ID"
+// @Param full query bool false "re-read every file"
 // @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Failure 409 {object} map[string]string
 // @Router /scan [post]
 // @Security BearerAuth
 func (h *Handler) StartScan(c echo.Context) error {
-	scanID, err := h.scanner.StartScan(c.Request().Context())
+	full, _ := strconv.ParseBool(c.QueryParam("full"))
+	libraryID, _ := strconv.ParseInt(c.QueryParam("library"), 10, 64)
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if (full || libraryID != 0) && user.Role != "admin" {
+		return echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "only admins can run full or single-library scans", "code": "FORBIDDEN"})
+	}
+	if libraryID != 0 {
+		if _, err := db.GetLibrary(c.Request().Context(), h.db, libraryID); err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "library not found", "code": "NOT_FOUND"})
+		}
+	}
+	scanID, err := h.scanner.StartScan(c.Request().Context(), libraryID, full)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusConflict, map[string]string{"error": err.Error(), "code": "SCAN_FAILED"})
 	}
 	return c.JSON(http.StatusOK, map[string]interface{}{"scan_id": scanID, "status": "running"})
 }
 
+// CancelScan godoc
+// @Summary Cancel the running scan
+// @Description Stops the running scan. Files it already read are kept, but songs it didn't get to are not removed; its status becomes cancelled once it has stopped
+// @Tags Library
+// @Produce json
+// @Success 200 {object} map[string]bool
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /scan [delete]
+// @Security BearerAuth
+func (h *Handler) CancelScan(c echo.Context) error {
+	if err := h.scanner.CancelScan(); err != nil {
+		return echo.NewHTTPError(http.StatusConflict, map[string]string{"error": err.Error(), "code": "NO_SCAN_RUNNING"})
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"success": true})
+}
+
 // ScanStatus godoc
 // @Summary Get scan status
 // @Tags Library
@@ -47,6 +84,52 @@ func (h *Handler) ScanStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, status)
 }
 
+// ScanHistory godoc
+// @Summary List recent scans
+// @Description Recent scans, newest first, with counts of added, updated, removed and failed files
+// @Tags Admin
+// @Produce json
+// @Param limit query int false "max scans (default 20, at most 50)"
+// @Param offset query int false "scans to skip"
+// @Success 200 {array} services.ScanStatus
+// @Failure 403 {object} map[string]string
+// @Router /scan/history [get]
+// @Security BearerAuth
+func (h *Handler) ScanHistory(c echo.Context) error {
+	limit, offset := parseLimitOffset(c, 20, 50)
+	scans, err := h.scanner.History(c.Request().Context(), limit, offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "SCAN_STATUS_FAILED"})
+	}
+	return c.JSON(http.StatusOK, scans)
+}
+
+// ScanErrors godoc
+// @Summary List a scan's failed files
+// @Description Files the scan couldn't read, with a reason: unreadable, unreadable_tags, missing_title, ffprobe_failed or db_error
+// @Tags Admin
+// @Produce json
+// @Param id path int true "scan ID"
+// @Success 200 {array} services.ScanError
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /scan/{id}/errors [get]
+// @Security BearerAuth
+func (h *Handler) ScanErrors(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid scan id", "code": "INVALID_ID"})
+	}
+	scanErrors, err := h.scanner.Errors(c.Request().Context(), id)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "scan not found", "code": "NOT_FOUND"})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "SCAN_STATUS_FAILED"})
+	}
+	return c.JSON(http.StatusOK, scanErrors)
+}
+
 // SystemInfo godoc
 // @Summary System info
 // @Tags Admin
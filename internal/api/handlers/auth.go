@@ -149,6 +149,46 @@ func (h *Handler) CompleteOnboarding(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
+// CreateSubsonicPassword godoc
+// @Summary Generate a Subsonic app password
+// @Description Creates a new password for Subsonic clients, replacing any previous one. The value is only shown once.
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /auth/subsonic [post]
+// @Security BearerAuth
+func (h *Handler) CreateSubsonicPassword(c echo.Context) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, map[string]string{"error": "unauthorized", "code": "UNAUTHORIZED"})
+	}
+	password, err := h.auth.CreateSubsonicPassword(c.Request().Context(), user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to create password", "code": "UPDATE_FAILED"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"username": user.Username, "password": password})
+}
+
+// RevokeSubsonicPassword godoc
+// @Summary Revoke the Subsonic app password
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string]bool
+// @Failure 401 {object} map[string]string
+// @Router /auth/subsonic [delete]
+// @Security BearerAuth
+func (h *Handler) RevokeSubsonicPassword(c echo.Context) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, map[string]string{"error": "unauthorized", "code": "UNAUTHORIZED"})
+	}
+	if err := h.auth.RevokeSubsonicPassword(c.Request().Context(), user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to revoke password", "code": "UPDATE_FAILED"})
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"success": true})
+}
+
 func currentUser(c echo.Context) (models.User, error) {
 	v := c.Get("user")
 	if v == nil {
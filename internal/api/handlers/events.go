@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// eventsHeartbeat is how often an idle event stream sends a comment, so
+// proxies don't close it
+const eventsHeartbeat = 30 * time.Second
+
+// Events godoc
+// @Summary Live event stream
+// @Description Server-Sent Events for scan status, songs added or removed, playlist edits, favorites and player state, limited to what the user can see. Each event's name is its type and its data is JSON. Browsers can pass the access token as ?token= since EventSource can't set headers.
+// @Tags Events
+// @Produce text/event-stream
+// @Success 200 {string} string "event stream"
+// @Router /events [get]
+// @Security BearerAuth
+func (h *Handler) Events(c echo.Context) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	events, unsubscribe := h.events.Subscribe()
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			payload, ok := h.events.Payload(ctx, ev, user)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return nil
+			}
+		}
+		res.Flush()
+	}
+}
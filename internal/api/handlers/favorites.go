@@ -10,6 +10,7 @@ import (
 
 	"github.com/Aunali321/korus/internal/db"
 	"github.com/Aunali321/korus/internal/models"
+	"github.com/Aunali321/korus/internal/services"
 )
 
 // FavSong godoc
@@ -30,6 +31,7 @@ func (h *Handler) FavSong(c echo.Context) error {
 	if _, err := h.db.ExecContext(c.Request().Context(), `INSERT OR IGNORE INTO favorites_songs(user_id, song_id) VALUES(?, ?)`, user.ID, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "FAV_FAILED"})
 	}
+	h.publishFavorite(user.ID, services.EventFavoriteAdded, "song", id)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
@@ -47,6 +49,7 @@ func (h *Handler) UnfavSong(c echo.Context) error {
 	if _, err := h.db.ExecContext(c.Request().Context(), `DELETE FROM favorites_songs WHERE user_id = ? AND song_id = ?`, user.ID, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "UNFAV_FAILED"})
 	}
+	h.publishFavorite(user.ID, services.EventFavoriteRemoved, "song", id)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
@@ -68,6 +71,7 @@ func (h *Handler) FavAlbum(c echo.Context) error {
 	if _, err := h.db.ExecContext(c.Request().Context(), `INSERT OR IGNORE INTO favorites_albums(user_id, album_id) VALUES(?, ?)`, user.ID, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "FAV_FAILED"})
 	}
+	h.publishFavorite(user.ID, services.EventFavoriteAdded, "album", id)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
@@ -85,6 +89,7 @@ func (h *Handler) UnfavAlbum(c echo.Context) error {
 	if _, err := h.db.ExecContext(c.Request().Context(), `DELETE FROM favorites_albums WHERE user_id = ? AND album_id = ?`, user.ID, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "UNFAV_FAILED"})
 	}
+	h.publishFavorite(user.ID, services.EventFavoriteRemoved, "album", id)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
@@ -106,6 +111,7 @@ func (h *Handler) FollowArtist(c echo.Context) error {
 	if _, err := h.db.ExecContext(c.Request().Context(), `INSERT OR IGNORE INTO follows_artists(user_id, artist_id) VALUES(?, ?)`, user.ID, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "FOLLOW_FAILED"})
 	}
+	h.publishFavorite(user.ID, services.EventFavoriteAdded, "artist", id)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
@@ -123,9 +129,15 @@ func (h *Handler) UnfollowArtist(c echo.Context) error {
 	if _, err := h.db.ExecContext(c.Request().Context(), `DELETE FROM follows_artists WHERE user_id = ? AND artist_id = ?`, user.ID, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "UNFOLLOW_FAILED"})
 	}
+	h.publishFavorite(user.ID, services.EventFavoriteRemoved, "artist", id)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
+// publishFavorite tells the user's other clients that a favorite changed
+func (h *Handler) publishFavorite(userID int64, eventType, kind string, id int64) {
+	h.events.Publish(services.Event{Type: eventType, Data: services.FavoriteEvent{Kind: kind, ID: id}, UserIDs: []int64{userID}})
+}
+
 // ListFavorites godoc
 // @Summary List favorites
 // @Tags Favorites
@@ -136,7 +148,7 @@ func (h *Handler) UnfollowArtist(c echo.Context) error {
 func (h *Handler) ListFavorites(c echo.Context) error {
 	user, _ := currentUser(c)
 	ctx := c.Request().Context()
-	songs, _ := db.GetSongsByFavorites(ctx, h.db, user.ID)
+	songs, _ := db.GetSongsByFavorites(ctx, h.db, db.UserAccess(user), user.ID)
 	_ = db.PopulateSongArtists(ctx, h.db, songs)
 	albums, _ := h.fetchAlbumsByFav(ctx, user.ID)
 	artists, _ := h.fetchArtistsByFollow(ctx, user.ID)
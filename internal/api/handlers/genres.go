@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
+)
+
+// ListGenres godoc
+// @Summary List genres
+// @Tags Library
+// @Produce json
+// @Success 200 {array} models.Genre
+// @Router /genres [get]
+// @Security BearerAuth
+func (h *Handler) ListGenres(c echo.Context) error {
+	genres, err := db.GetGenres(c.Request().Context(), h.db, access(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to load genres", "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, genres)
+}
+
+// Genre godoc
+// @Summary Get genre by id
+// @Description Returns the genre with a page of its songs and the albums those songs belong to
+// @Tags Library
+// @Produce json
+// @Param id path int true "Genre ID"
+// @Param limit query int false "max songs (default 100, max 500)"
+// @Param offset query int false "offset"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /genres/{id} [get]
+// @Security BearerAuth
+func (h *Handler) Genre(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	limit, offset := parseLimitOffset(c, 100, 500)
+	acc := access(c)
+
+	var g models.Genre
+	err := h.db.QueryRowContext(ctx, `
+		SELECT g.id, g.name, (SELECT COUNT(*) FROM song_genres sg JOIN songs s ON s.id = sg.song_id WHERE sg.genre_id = g.id AND `+acc.Filter("s")+`)
+		FROM genres g WHERE g.id = ?
+	`, id).Scan(&g.ID, &g.Name, &g.SongCount)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "genre not found", "code": "NOT_FOUND"})
+	}
+
+	songs, _ := db.GetSongsByGenre(ctx, h.db, acc, id, limit, offset)
+	if songs == nil {
+		songs = []models.Song{}
+	}
+	_ = db.PopulateSongArtists(ctx, h.db, songs)
+
+	albums := []models.Album{}
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT DISTINCT al.id, al.artist_id, al.title, al.year, COALESCE(al.cover_path, ''), al.created_at, ar.id, ar.name
+		FROM song_genres sg
+		JOIN songs s ON s.id = sg.song_id
+		JOIN albums al ON al.id = s.album_id
+		LEFT JOIN artists ar ON ar.id = al.artist_id
+		WHERE sg.genre_id = ? AND `+acc.Filter("s")+`
+		ORDER BY al.title COLLATE NOCASE
+	`, id)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var al models.Album
+			var year, albumArtistID, artistID sql.NullInt64
+			var artistName sql.NullString
+			if err := rows.Scan(&al.ID, &albumArtistID, &al.Title, &year, &al.CoverPath, &al.CreatedAt, &artistID, &artistName); err != nil {
+				continue
+			}
+			if albumArtistID.Valid {
+				al.ArtistID = &albumArtistID.Int64
+			}
+			if year.Valid {
+				y := int(year.Int64)
+				al.Year = &y
+			}
+			if artistID.Valid {
+				al.Artist = &models.Artist{ID: artistID.Int64, Name: artistName.String}
+			}
+			albums = append(albums, al)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"id":         g.ID,
+		"name":       g.Name,
+		"song_count": g.SongCount,
+		"albums":     albums,
+		"songs":      songs,
+	})
+}
@@ -15,12 +15,16 @@ type Handler struct {
 	transcoder        *services.Transcoder
 	musicBrainz       *services.MusicBrainzService
 	listenBrainz      *services.ListenBrainzService
+	lastFM            *services.LastFMService
+	scrobbles         *services.ScrobbleService
 	radio             *services.RadioService
+	events            *services.EventBus
+	smartPlaylists    *services.SmartPlaylistRefresher
 	mediaRoot         string
 	radioDefaultLimit int
 }
 
-func New(db *sql.DB, dbPath string, auth *services.AuthService, scanner *services.ScannerService, search *services.SearchService, transcoder *services.Transcoder, mb *services.MusicBrainzService, lb *services.ListenBrainzService, radio *services.RadioService, mediaRoot string, radioDefaultLimit int) *Handler {
+func New(db *sql.DB, dbPath string, auth *services.AuthService, scanner *services.ScannerService, search *services.SearchService, transcoder *services.Transcoder, mb *services.MusicBrainzService, lb *services.ListenBrainzService, lastFM *services.LastFMService, scrobbles *services.ScrobbleService, radio *services.RadioService, events *services.EventBus, smartPlaylists *services.SmartPlaylistRefresher, mediaRoot string, radioDefaultLimit int) *Handler {
 	return &Handler{
 		db:                db,
 		dbPath:            dbPath,
@@ -30,7 +34,11 @@ func New(db *sql.DB, dbPath string, auth *services.AuthService, scanner *service
 		transcoder:        transcoder,
 		musicBrainz:       mb,
 		listenBrainz:      lb,
+		lastFM:            lastFM,
+		scrobbles:         scrobbles,
 		radio:             radio,
+		events:            events,
+		smartPlaylists:    smartPlaylists,
 		mediaRoot:         mediaRoot,
 		radioDefaultLimit: radioDefaultLimit,
 	}
@@ -46,6 +46,9 @@ func (h *Handler) RecordHistory(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "HISTORY_SAVE_FAILED"})
 	}
+	h.smartPlaylists.Schedule(user.ID)
+	// The timestamp marks the end of the play; scrobbles want its start
+	h.scrobble(c, req.SongID, ts.Add(-time.Duration(req.DurationListened)*time.Second), req.DurationListened)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
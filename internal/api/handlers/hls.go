@@ -2,29 +2,39 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
+	"github.com/Aunali321/korus/internal/services"
 	"github.com/Aunali321/korus/internal/services/hls"
 )
 
 type HLSHandler struct {
 	db      *sql.DB
 	hls     *hls.Service
+	lyrics  *services.LyricsService
+	artwork *services.ArtworkService
 	formats map[string][]int
 }
 
-func NewHLSHandler(db *sql.DB, hlsService *hls.Service) *HLSHandler {
+func NewHLSHandler(db *sql.DB, hlsService *hls.Service, lyrics *services.LyricsService, artwork *services.ArtworkService) *HLSHandler {
 	return &HLSHandler{
-		db:  db,
-		hls: hlsService,
+		db:      db,
+		hls:     hlsService,
+		lyrics:  lyrics,
+		artwork: artwork,
 		formats: map[string][]int{
 			"mp3":  {128, 192, 256, 320},
 			"aac":  {128, 192, 256},
@@ -44,6 +54,40 @@ type hlsTrackMeta struct {
 	SampleRate int
 	BitDepth   int
 	Channels   int
+	// Loudness in LUFS and linear peaks, when measured
+	TrackLoudness, TrackPeak sql.NullFloat64
+	AlbumLoudness, AlbumPeak sql.NullFloat64
+	// CueTrack is set for a track a cue sheet cut from Path between StartMs
+	// and EndMs (0 for the end of the file)
+	CueTrack       bool
+	StartMs, EndMs int
+}
+
+// gain returns the ReplayGain in dB for normalize mode "track" or "album",
+// lowered where needed so the song's peak doesn't clip. Album mode falls back
+// to the track gain until the album is measured; unmeasured songs get 0.
+func (m *hlsTrackMeta) gain(normalize string) float64 {
+	loudness, peak := m.TrackLoudness, m.TrackPeak
+	if normalize == "album" && m.AlbumLoudness.Valid {
+		loudness, peak = m.AlbumLoudness, m.AlbumPeak
+	}
+	if normalize == "" || !loudness.Valid {
+		return 0
+	}
+	gain := models.ReplayGainReference - loudness.Float64
+	if peak.Valid && peak.Float64 > 0 {
+		gain = math.Min(gain, -20*math.Log10(peak.Float64))
+	}
+	return gain
+}
+
+// parseNormalize reads the normalize query parameter: "", "track" or "album"
+func parseNormalize(c echo.Context) (string, error) {
+	normalize := c.QueryParam("normalize")
+	if normalize != "" && normalize != "track" && normalize != "album" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "normalize must be track or album", "code": "VALIDATION_ERROR"})
+	}
+	return normalize, nil
 }
 
 func (h *HLSHandler) getTrackMeta(c echo.Context, id int64) (*hlsTrackMeta, error) {
@@ -53,12 +97,15 @@ func (h *HLSHandler) getTrackMeta(c echo.Context, id int64) (*hlsTrackMeta, erro
 	var artistName sql.NullString
 
 	err := h.db.QueryRowContext(ctx, `
-		SELECT s.id, s.file_path, s.title, s.duration_ms, s.sample_rate, s.bit_depth, s.channels,
+		SELECT s.id, COALESCE(s.source_path, s.file_path), s.title, s.duration_ms, s.sample_rate, s.bit_depth, s.channels,
+		       s.track_loudness, s.track_peak, s.album_loudness, s.album_peak,
+		       s.source_path IS NOT NULL, COALESCE(s.cue_start_ms, 0), COALESCE(s.cue_end_ms, 0),
 		       (SELECT GROUP_CONCAT(a.name, ', ') FROM artists a 
 		        JOIN song_artists sa ON sa.artist_id = a.id 
 		        WHERE sa.song_id = s.id) as artist_name
-		FROM songs s WHERE s.id = ?
-	`, id).Scan(&meta.ID, &meta.Path, &meta.Title, &durationMs, &sampleRate, &bitDepth, &channels, &artistName)
+		FROM songs s WHERE s.id = ? AND `+access(c).Filter("s"), id).Scan(&meta.ID, &meta.Path, &meta.Title, &durationMs, &sampleRate, &bitDepth, &channels,
+		&meta.TrackLoudness, &meta.TrackPeak, &meta.AlbumLoudness, &meta.AlbumPeak,
+		&meta.CueTrack, &meta.StartMs, &meta.EndMs, &artistName)
 
 	if err != nil {
 		return nil, err
@@ -103,6 +150,7 @@ func (h *HLSHandler) validateFormat(format string, bitrate int) error {
 // @Param id path int true "Track ID"
 // @Param format query string false "Audio format" Enums(aac, mp3, opus, flac, alac) default(aac)
 // @Param bitrate query int false "Bitrate in kbps"
+// @Param normalize query string false "Apply ReplayGain while transcoding" Enums(track, album)
 // @Param token query string false "Auth token for player"
 // @Success 200 {string} string "HLS manifest"
 // @Failure 400 {object} map[string]string
@@ -127,6 +175,10 @@ func (h *HLSHandler) Manifest(c echo.Context) error {
 	if err := h.validateFormat(format, bitrate); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "INVALID_FORMAT"})
 	}
+	normalize, err := parseNormalize(c)
+	if err != nil {
+		return err
+	}
 
 	meta, err := h.getTrackMeta(c, id)
 	if err != nil {
@@ -150,6 +202,10 @@ func (h *HLSHandler) Manifest(c echo.Context) error {
 		SampleRate: meta.SampleRate,
 		BitDepth:   meta.BitDepth,
 		Channels:   meta.Channels,
+		Normalize:  normalize,
+		GainDB:     meta.gain(normalize),
+		StartMs:    meta.StartMs,
+		EndMs:      meta.EndMs,
 	}
 
 	manifest, err := h.hls.Generator.GenerateManifest(ctx, req, token)
@@ -164,6 +220,269 @@ func (h *HLSHandler) Manifest(c echo.Context) error {
 	return c.Blob(http.StatusOK, "application/vnd.apple.mpegurl", manifest)
 }
 
+// parseVariants reads a comma-separated list of format[:bitrate] variants,
+// such as "aac:128,aac:256,flac". An empty list means hls.DefaultVariants.
+func (h *HLSHandler) parseVariants(list string) ([]hls.Variant, error) {
+	if list == "" {
+		return hls.DefaultVariants, nil
+	}
+	var variants []hls.Variant
+	for _, item := range strings.Split(list, ",") {
+		format, rate, _ := strings.Cut(strings.TrimSpace(item), ":")
+		bitrate := 0
+		if rate != "" {
+			var err error
+			if bitrate, err = strconv.Atoi(rate); err != nil {
+				return nil, fmt.Errorf("invalid bitrate: %s", rate)
+			}
+		}
+		if err := h.validateFormat(format, bitrate); err != nil {
+			return nil, err
+		}
+		v := hls.Variant{Format: format, Bitrate: bitrate}
+		if !slices.Contains(variants, v) {
+			variants = append(variants, v)
+		}
+	}
+	return variants, nil
+}
+
+// MasterPlaylist godoc
+// @Summary Get HLS master playlist for a track
+// @Description Serves a master playlist offering the track in several formats and bitrates, so players can switch quality with the connection. Each variant links to its manifest.m3u8 and is transcoded only when a player picks it. Defaults to AAC 128 and 256 kbps and FLAC.
+// @Tags Streaming
+// @Produce application/vnd.apple.mpegurl
+// @Param id path int true "Track ID"
+// @Param variants query string false "Comma-separated format[:bitrate] variants in the order offered, e.g. aac:128,aac:256,flac"
+// @Param normalize query string false "Apply ReplayGain while transcoding" Enums(track, album)
+// @Param token query string false "Auth token for player"
+// @Success 200 {string} string "HLS master playlist"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /stream/{id}/master.m3u8 [get]
+// @Security BearerAuth
+func (h *HLSHandler) MasterPlaylist(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid track id", "code": "INVALID_ID"})
+	}
+
+	variants, err := h.parseVariants(c.QueryParam("variants"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "INVALID_FORMAT"})
+	}
+	normalize, err := parseNormalize(c)
+	if err != nil {
+		return err
+	}
+
+	meta, err := h.loadTrack(c, id)
+	if err != nil {
+		return err
+	}
+
+	playlist := hls.MasterPlaylist(variants, meta.SampleRate, meta.BitDepth, meta.Channels, normalize, c.QueryParam("token"))
+
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+
+	return c.Blob(http.StatusOK, "application/vnd.apple.mpegurl", playlist)
+}
+
+// Gapless godoc
+// @Summary Get gapless playback info for a track
+// @Description Returns the encoder delay and padding, in samples, around the track as encoded for a format, with an iTunSMPB-style value for AAC and MP3, so players can trim them between tracks. For HLS they are measured from the rendition's segments, transcoding it first if needed, and also signalled by an edit list in its init segment. For progressive streams and downloads (delivery=progressive) they are estimated from the encoder, which for MP3 is LAME rather than the AAC that HLS sends MP3 as.
+// @Tags Streaming
+// @Produce json
+// @Param id path int true "Track ID"
+// @Param format query string false "Audio format" Enums(aac, mp3, opus, flac, alac) default(aac)
+// @Param bitrate query int false "Bitrate in kbps"
+// @Param normalize query string false "ReplayGain applied while transcoding" Enums(track, album)
+// @Param delivery query string false "How the track is sent" Enums(hls, progressive) default(hls)
+// @Success 200 {object} hls.Gapless
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /stream/{id}/gapless [get]
+// @Security BearerAuth
+func (h *HLSHandler) Gapless(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid track id", "code": "INVALID_ID"})
+	}
+	format, bitrate, normalize, err := h.parseStreamFormat(c, "aac")
+	if err != nil {
+		return err
+	}
+	delivery := c.QueryParam("delivery")
+	if delivery != "" && delivery != "hls" && delivery != "progressive" {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "delivery must be hls or progressive", "code": "VALIDATION_ERROR"})
+	}
+
+	meta, err := h.loadTrack(c, id)
+	if err != nil {
+		return err
+	}
+
+	req := hls.SegmentRequest{
+		TrackID:    meta.ID,
+		SourcePath: meta.Path,
+		Format:     format,
+		Bitrate:    bitrate,
+		DurationMs: meta.DurationMs,
+		SampleRate: meta.SampleRate,
+		BitDepth:   meta.BitDepth,
+		Channels:   meta.Channels,
+		Normalize:  normalize,
+		GainDB:     meta.gain(normalize),
+		StartMs:    meta.StartMs,
+		EndMs:      meta.EndMs,
+	}
+	if delivery == "progressive" {
+		return c.JSON(http.StatusOK, req.TranscodeGapless())
+	}
+	gapless, err := h.hls.Generator.Gapless(c.Request().Context(), req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "segment generation failed", "code": "SEGMENT_FAILED"})
+	}
+	return c.JSON(http.StatusOK, gapless)
+}
+
+// AlbumStream godoc
+// @Summary Get an HLS stream of a whole album
+// @Description Serves one media playlist playing the album's tracks back to back, with an EXT-X-DATERANGE chapter of class "track" for each, carrying its song ID, title and artist. Tracks are separated by a discontinuity, so players may leave a short gap between them; each track's init segment carries an edit list trimming its encoder delay and padding. Tracks are transcoded as they're reached.
+// @Tags Streaming
+// @Produce application/vnd.apple.mpegurl
+// @Param id path int true "Album ID"
+// @Param format query string false "Audio format" Enums(aac, mp3, opus, flac, alac) default(aac)
+// @Param bitrate query int false "Bitrate in kbps"
+// @Param normalize query string false "Apply ReplayGain while transcoding" Enums(track, album)
+// @Param token query string false "Auth token for player"
+// @Success 200 {string} string "HLS manifest"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /albums/{id}/stream.m3u8 [get]
+// @Security BearerAuth
+func (h *HLSHandler) AlbumStream(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid album id", "code": "INVALID_ID"})
+	}
+	songs, err := db.GetSongsByAlbum(c.Request().Context(), h.db, access(c), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "database error", "code": "DB_ERROR"})
+	}
+	if len(songs) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "album not found", "code": "NOT_FOUND"})
+	}
+	return h.sendStream(c, songs)
+}
+
+// PlaylistStream godoc
+// @Summary Get an HLS stream of a whole playlist
+// @Description Like the album stream, for the playlist's songs in order
+// @Tags Streaming
+// @Produce application/vnd.apple.mpegurl
+// @Param id path int true "Playlist ID"
+// @Param format query string false "Audio format" Enums(aac, mp3, opus, flac, alac) default(aac)
+// @Param bitrate query int false "Bitrate in kbps"
+// @Param normalize query string false "Apply ReplayGain while transcoding" Enums(track, album)
+// @Param token query string false "Auth token for player"
+// @Success 200 {string} string "HLS manifest"
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /playlists/{id}/stream.m3u8 [get]
+// @Security BearerAuth
+func (h *HLSHandler) PlaylistStream(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid playlist id", "code": "INVALID_ID"})
+	}
+	ctx := c.Request().Context()
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	role, _, err := db.GetPlaylistRole(ctx, h.db, id, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
+	}
+	if !db.PlaylistRoleAtLeast(role, db.PlaylistViewer) {
+		return echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "forbidden", "code": "FORBIDDEN"})
+	}
+	songs, err := db.GetSongsByPlaylist(ctx, h.db, access(c), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "database error", "code": "DB_ERROR"})
+	}
+	return h.sendStream(c, songs)
+}
+
+// parseStreamFormat reads the format, defaulting to def, bitrate and
+// normalize query parameters of a transcoded stream
+func (h *HLSHandler) parseStreamFormat(c echo.Context, def string) (string, int, string, error) {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = def
+	}
+	bitrate, _ := strconv.Atoi(c.QueryParam("bitrate"))
+	if err := h.validateFormat(format, bitrate); err != nil {
+		return "", 0, "", echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "INVALID_FORMAT"})
+	}
+	normalize, err := parseNormalize(c)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return format, bitrate, normalize, nil
+}
+
+// sendStream serves songs as one media playlist. Songs whose files
+// are gone, or whose length isn't known, are left out.
+func (h *HLSHandler) sendStream(c echo.Context, songs []models.Song) error {
+	format, bitrate, normalize, err := h.parseStreamFormat(c, "aac")
+	if err != nil {
+		return err
+	}
+
+	tracks := make([]hls.StreamTrack, 0, len(songs))
+	for _, song := range songs {
+		meta, err := h.getTrackMeta(c, song.ID)
+		if err != nil || meta.DurationMs <= 0 {
+			continue
+		}
+		if _, err := os.Stat(meta.Path); err != nil {
+			continue
+		}
+		tracks = append(tracks, hls.StreamTrack{
+			Request: hls.SegmentRequest{
+				TrackID:    meta.ID,
+				SourcePath: meta.Path,
+				Format:     format,
+				Bitrate:    bitrate,
+				DurationMs: meta.DurationMs,
+				SampleRate: meta.SampleRate,
+				BitDepth:   meta.BitDepth,
+				Channels:   meta.Channels,
+				Normalize:  normalize,
+				GainDB:     meta.gain(normalize),
+				StartMs:    meta.StartMs,
+				EndMs:      meta.EndMs,
+			},
+			Title:  meta.Title,
+			Artist: meta.Artist,
+		})
+	}
+
+	// Served from /api/albums/:id and /api/playlists/:id, next to /api/stream
+	playlist := h.hls.Generator.StreamPlaylist(tracks, "../../stream/", c.QueryParam("token"))
+
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+
+	return c.Blob(http.StatusOK, "application/vnd.apple.mpegurl", playlist)
+}
+
 // InitSegment godoc
 // @Summary Get fMP4 init segment
 // @Description Serves the fMP4 initialization segment for HLS
@@ -172,6 +491,7 @@ func (h *HLSHandler) Manifest(c echo.Context) error {
 // @Param id path int true "Track ID"
 // @Param format query string false "Audio format" Enums(aac, mp3, opus, flac, alac) default(aac)
 // @Param bitrate query int false "Bitrate in kbps"
+// @Param normalize query string false "Apply ReplayGain while transcoding" Enums(track, album)
 // @Success 200 {file} binary "Init segment"
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
@@ -194,6 +514,10 @@ func (h *HLSHandler) InitSegment(c echo.Context) error {
 	if err := h.validateFormat(format, bitrate); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "INVALID_FORMAT"})
 	}
+	normalize, err := parseNormalize(c)
+	if err != nil {
+		return err
+	}
 
 	meta, err := h.getTrackMeta(c, id)
 	if err != nil {
@@ -213,6 +537,10 @@ func (h *HLSHandler) InitSegment(c echo.Context) error {
 		SampleRate: meta.SampleRate,
 		BitDepth:   meta.BitDepth,
 		Channels:   meta.Channels,
+		Normalize:  normalize,
+		GainDB:     meta.gain(normalize),
+		StartMs:    meta.StartMs,
+		EndMs:      meta.EndMs,
 	}
 
 	data, err := h.hls.Generator.GenerateInitSegment(ctx, req)
@@ -236,6 +564,7 @@ func (h *HLSHandler) InitSegment(c echo.Context) error {
 // @Param segment path string true "Segment number (e.g., 0.m4s)"
 // @Param format query string false "Audio format" Enums(aac, mp3, opus, flac, alac) default(aac)
 // @Param bitrate query int false "Bitrate in kbps"
+// @Param normalize query string false "Apply ReplayGain while transcoding" Enums(track, album)
 // @Success 200 {file} binary "Audio segment"
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
@@ -265,6 +594,10 @@ func (h *HLSHandler) Segment(c echo.Context) error {
 	if err := h.validateFormat(format, bitrate); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "INVALID_FORMAT"})
 	}
+	normalize, err := parseNormalize(c)
+	if err != nil {
+		return err
+	}
 
 	meta, err := h.getTrackMeta(c, id)
 	if err != nil {
@@ -290,6 +623,10 @@ func (h *HLSHandler) Segment(c echo.Context) error {
 		SampleRate: meta.SampleRate,
 		BitDepth:   meta.BitDepth,
 		Channels:   meta.Channels,
+		Normalize:  normalize,
+		GainDB:     meta.gain(normalize),
+		StartMs:    meta.StartMs,
+		EndMs:      meta.EndMs,
 	}
 
 	data, err := h.hls.Generator.GenerateSegment(ctx, req)
@@ -312,6 +649,7 @@ func (h *HLSHandler) Segment(c echo.Context) error {
 // @Param id path int true "Track ID"
 // @Param format query string false "Target format" Enums(mp3, aac, opus, flac, alac)
 // @Param bitrate query int false "Bitrate in kbps"
+// @Param normalize query string false "Apply ReplayGain while transcoding; requires format" Enums(track, album)
 // @Success 200 {file} binary "Audio file"
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
@@ -326,49 +664,206 @@ func (h *HLSHandler) Download(c echo.Context) error {
 
 	format := c.QueryParam("format")
 	bitrate, _ := strconv.Atoi(c.QueryParam("bitrate"))
-
-	meta, err := h.getTrackMeta(c, id)
+	normalize, err := parseNormalize(c)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "track not found", "code": "NOT_FOUND"})
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "database error", "code": "DB_ERROR"})
+		return err
+	}
+	if normalize != "" && format == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "normalize requires a format", "code": "VALIDATION_ERROR"})
 	}
 
-	if _, err := os.Stat(meta.Path); err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "audio file not found", "code": "FILE_NOT_FOUND"})
+	meta, err := h.loadTrack(c, id)
+	if err != nil {
+		return err
 	}
 
 	if format == "" {
-		filename := sanitizeFilename(meta.Title) + getExtension(meta.Path)
-		c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
-		return c.File(meta.Path)
+		return h.sendAttachment(c, meta)
 	}
 
 	if err := h.validateFormat(format, bitrate); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "INVALID_FORMAT"})
 	}
 
-	ctx := c.Request().Context()
-	data, err := h.hls.Generator.Transcode(ctx, meta.Path, format, bitrate)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "transcode failed", "code": "TRANSCODE_FAILED"})
-	}
-
 	ext := "." + format
 	if format == "alac" {
 		ext = ".m4a"
 	}
+	filename := sanitizeFilename(meta.Title) + ext
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	return h.sendTranscoded(c, meta, format, bitrate, normalize, 0)
+}
+
+// loadTrack resolves a track and checks that its source file is still on
+// disk, returning ready-to-send HTTP errors otherwise.
+func (h *HLSHandler) loadTrack(c echo.Context, id int64) (*hlsTrackMeta, error) {
+	meta, err := h.getTrackMeta(c, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "track not found", "code": "NOT_FOUND"})
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "database error", "code": "DB_ERROR"})
+	}
+
+	if _, err := os.Stat(meta.Path); err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "audio file not found", "code": "FILE_NOT_FOUND"})
+	}
+	return meta, nil
+}
+
+// sendOriginal serves the untouched source file. c.File goes through
+// http.ServeContent, so Range requests work. A cue track has no file of its
+// own and is cut losslessly from its source as FLAC.
+func (h *HLSHandler) sendOriginal(c echo.Context, meta *hlsTrackMeta) error {
+	if meta.CueTrack {
+		return h.sendTranscoded(c, meta, "flac", 0, "", 0)
+	}
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	return c.File(meta.Path)
+}
 
+// sendAttachment sends the original track as a download named after its
+// title.
+func (h *HLSHandler) sendAttachment(c echo.Context, meta *hlsTrackMeta) error {
+	ext := getExtension(meta.Path)
+	if meta.CueTrack {
+		ext = ".flac"
+	}
 	filename := sanitizeFilename(meta.Title) + ext
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if meta.CueTrack {
+		return h.sendTranscoded(c, meta, "flac", 0, "", 0)
+	}
+	return c.File(meta.Path)
+}
+
+// sendTranscoded sends the track transcoded to format, applying ReplayGain for
+// normalize mode "track" or "album" when set, and starting offsetMs into it. A
+// whole-track transcode is served from the cache once done, with its length
+// and Range support. Until then it is sent as ffmpeg produces it, whose length
+// isn't known up front, so without a Content-Length and ignoring Range;
+// players seek with an offset instead.
+func (h *HLSHandler) sendTranscoded(c echo.Context, meta *hlsTrackMeta, format string, bitrate int, normalize string, offsetMs int) error {
+	req := hls.SegmentRequest{
+		TrackID:    meta.ID,
+		SourcePath: meta.Path,
+		Format:     format,
+		Bitrate:    bitrate,
+		DurationMs: meta.DurationMs,
+		Normalize:  normalize,
+		GainDB:     meta.gain(normalize),
+		StartMs:    meta.StartMs,
+		EndMs:      meta.EndMs,
+		OffsetMs:   offsetMs,
+	}
 	contentType := getContentType(format)
+	res := c.Response()
+	res.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if offsetMs == 0 {
+		if path, ok := h.hls.Generator.CachedTranscode(req); ok {
+			if f, err := os.Open(path); err == nil {
+				defer f.Close()
+				if info, err := f.Stat(); err == nil {
+					res.Header().Set(echo.HeaderContentType, contentType)
+					http.ServeContent(res, c.Request(), "", info.ModTime(), f)
+					return nil
+				}
+			}
+		}
+	}
 
-	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
-	c.Response().Header().Set("Content-Type", contentType)
-	c.Response().Header().Set("Content-Length", strconv.Itoa(len(data)))
-	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set("Accept-Ranges", "none")
+	out := &progressiveWriter{res: res}
+	if err := h.hls.Generator.TranscodeStream(c.Request().Context(), req, out); err != nil {
+		if !res.Committed {
+			return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "transcode failed", "code": "TRANSCODE_FAILED"})
+		}
+		return nil
+	}
+	if !res.Committed {
+		res.WriteHeader(http.StatusOK)
+	}
+	return nil
+}
+
+// progressiveWriter sends a transcode to the client as it's produced. The
+// status and headers go out with the first bytes, so a transcode that fails
+// straight away can still be answered with an error.
+type progressiveWriter struct {
+	res *echo.Response
+}
+
+func (w *progressiveWriter) Write(p []byte) (int, error) {
+	if !w.res.Committed {
+		w.res.WriteHeader(http.StatusOK)
+	}
+	n, err := w.res.Write(p)
+	if err != nil {
+		return n, err
+	}
+	w.res.Flush()
+	return n, nil
+}
+
+// parseOffset reads where in the track to start, in seconds, from offset or
+// timeOffset as Subsonic clients send it
+func parseOffset(c echo.Context) (int, error) {
+	raw := c.QueryParam("offset")
+	if raw == "" {
+		raw = c.QueryParam("timeOffset")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	sec, err := strconv.ParseFloat(raw, 64)
+	if err != nil || !(sec >= 0) || math.IsInf(sec, 1) {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "offset must be a number of seconds", "code": "VALIDATION_ERROR"})
+	}
+	return int(sec * 1000), nil
+}
+
+// Progressive godoc
+// @Summary Stream a transcoded track progressively
+// @Description For players without HLS: sends the track transcoded as ffmpeg produces it, optionally starting offset seconds in, without a Content-Length and ignoring Range. Once a whole track has been transcoded it is cached, and later requests are served from the cache with a Content-Length and Range support.
+// @Tags Streaming
+// @Produce audio/*
+// @Param id path int true "Track ID"
+// @Param format query string false "Audio format" Enums(mp3, aac, opus, flac, alac) default(mp3)
+// @Param bitrate query int false "Bitrate in kbps"
+// @Param normalize query string false "Apply ReplayGain while transcoding" Enums(track, album)
+// @Param offset query number false "Start this many seconds into the track"
+// @Param timeOffset query number false "Same as offset"
+// @Param token query string false "Auth token for player"
+// @Success 200 {file} binary "Audio stream"
+// @Success 206 {file} binary "Partial audio stream, once cached"
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /stream/{id}/progressive [get]
+// @Security BearerAuth
+func (h *HLSHandler) Progressive(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid track id", "code": "INVALID_ID"})
+	}
+	format, bitrate, normalize, err := h.parseStreamFormat(c, "mp3")
+	if err != nil {
+		return err
+	}
+	offsetMs, err := parseOffset(c)
+	if err != nil {
+		return err
+	}
 
-	return c.Blob(http.StatusOK, contentType, data)
+	meta, err := h.loadTrack(c, id)
+	if err != nil {
+		return err
+	}
+	return h.sendTranscoded(c, meta, format, bitrate, normalize, offsetMs)
 }
 
 // StreamingOptions godoc
@@ -454,6 +949,7 @@ func getContentType(format string) string {
 // @Param id path int true "Track ID"
 // @Param format query string false "Target format" Enums(aac, mp3, opus, flac, alac)
 // @Param bitrate query string false "Bitrate in kbps"
+// @Param normalize query string false "Apply ReplayGain while transcoding; requires format" Enums(track, album)
 // @Param token query string false "Auth token for player"
 // @Success 200 {file} binary "Audio stream"
 // @Success 307 {string} string "Redirect to HLS manifest"
@@ -471,24 +967,21 @@ func (h *HLSHandler) Stream(c echo.Context) error {
 	format := c.QueryParam("format")
 	bitrate := c.QueryParam("bitrate")
 	token := c.QueryParam("token")
+	normalize, err := parseNormalize(c)
+	if err != nil {
+		return err
+	}
+	if normalize != "" && format == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "normalize requires a format", "code": "VALIDATION_ERROR"})
+	}
 
 	// If no format specified, serve original file directly for playback
 	if format == "" {
-		meta, err := h.getTrackMeta(c, id)
+		meta, err := h.loadTrack(c, id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "track not found", "code": "NOT_FOUND"})
-			}
-			return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "database error", "code": "DB_ERROR"})
-		}
-
-		if _, err := os.Stat(meta.Path); err != nil {
-			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "audio file not found", "code": "FILE_NOT_FOUND"})
+			return err
 		}
-
-		c.Response().Header().Set("Access-Control-Allow-Origin", "*")
-		c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-		return c.File(meta.Path)
+		return h.sendOriginal(c, meta)
 	}
 
 	// With format, redirect to HLS manifest
@@ -497,6 +990,9 @@ func (h *HLSHandler) Stream(c echo.Context) error {
 	if bitrate != "" {
 		params = append(params, "bitrate="+bitrate)
 	}
+	if normalize != "" {
+		params = append(params, "normalize="+normalize)
+	}
 	if token != "" {
 		params = append(params, "token="+token)
 	}
@@ -507,38 +1003,92 @@ func (h *HLSHandler) Stream(c echo.Context) error {
 
 // Artwork godoc
 // @Summary Get artwork for a track or album
-// @Description Returns cover artwork image
+// @Description Returns cover artwork image. With size and/or format, a thumbnail is served instead; thumbnails are rendered once and cached.
 // @Tags Streaming
 // @Produce image/*
 // @Param id path int true "Track or Album ID"
 // @Param type query string false "Type of artwork" Enums(track, album) default(track)
+// @Param size query int false "Thumbnail size (longest edge)" Enums(64, 256, 512, 1024)
+// @Param format query string false "Thumbnail format" Enums(jpeg, webp) default(jpeg)
 // @Success 200 {file} binary "Artwork image"
+// @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Router /artwork/{id} [get]
 func (h *HLSHandler) Artwork(c echo.Context) error {
-	ctx := c.Request().Context()
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
-	artworkType := c.QueryParam("type")
+	size, format, err := parseArtworkVariant(c)
+	if err != nil {
+		return err
+	}
+	return h.sendArtwork(c, id, c.QueryParam("type"), size, format)
+}
 
+// parseArtworkVariant reads the size and format query parameters. A size of
+// 0 asks for the original image.
+func parseArtworkVariant(c echo.Context) (int, string, error) {
+	size := 0
+	if v := c.QueryParam("size"); v != "" {
+		size, _ = strconv.Atoi(v)
+		if !slices.Contains(services.ArtworkSizes, size) {
+			return 0, "", echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "size must be 64, 256, 512 or 1024", "code": "VALIDATION_ERROR"})
+		}
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		return size, "jpeg", nil
+	}
+	if _, ok := services.ArtworkFormats[format]; !ok {
+		return 0, "", echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "format must be jpeg or webp", "code": "VALIDATION_ERROR"})
+	}
+	if size == 0 {
+		size = services.ArtworkSizes[len(services.ArtworkSizes)-1]
+	}
+	return size, format, nil
+}
+
+// sendImage serves src, or its cached size x size thumbnail in format when
+// size is set. key identifies src in the thumbnail cache.
+func (h *HLSHandler) sendImage(c echo.Context, src, key string, size int, format string) error {
+	if size > 0 {
+		variant, err := h.artwork.Variant(c.Request().Context(), src, key, size, format)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to render artwork", "code": "ARTWORK_FAILED"})
+		}
+		src = variant
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "artwork not found", "code": "NOT_FOUND"})
+	}
+	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	c.Response().Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	return c.File(src)
+}
+
+// sendArtwork serves the cover for an album (artworkType "album") or for the
+// album of a track, extracting embedded art from the file as a last resort.
+func (h *HLSHandler) sendArtwork(c echo.Context, id int64, artworkType string, size int, format string) error {
+	ctx := c.Request().Context()
+
+	var albumID int64
 	var cover string
 	var filePath string
 	var err error
 
 	if artworkType == "album" {
-		err = h.db.QueryRowContext(ctx, `SELECT cover_path FROM albums WHERE id = ?`, id).Scan(&cover)
+		albumID = id
+		err = h.db.QueryRowContext(ctx, `SELECT COALESCE(cover_path, '') FROM albums WHERE id = ?`, id).Scan(&cover)
 	} else {
 		err = h.db.QueryRowContext(ctx, `
-			SELECT a.cover_path, s.file_path FROM albums a 
+			SELECT a.id, COALESCE(a.cover_path, ''), COALESCE(s.source_path, s.file_path) FROM albums a 
 			JOIN songs s ON s.album_id = a.id 
 			WHERE s.id = ?
-		`, id).Scan(&cover, &filePath)
+		`, id).Scan(&albumID, &cover, &filePath)
 	}
 
 	if err == nil && cover != "" {
-		if info, statErr := os.Stat(cover); statErr == nil {
-			c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-			c.Response().Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
-			return c.File(cover)
+		if _, statErr := os.Stat(cover); statErr == nil {
+			return h.sendImage(c, cover, fmt.Sprintf("album-%d", albumID), size, format)
 		}
 	}
 
@@ -578,37 +1128,42 @@ func hlsExtractArtwork(audioPath string) (string, error) {
 
 // ArtistImage godoc
 // @Summary Get artist image
-// @Description Returns artist image
+// @Description Returns artist image, or a cached thumbnail of it with size and/or format
 // @Tags Library
 // @Produce image/*
 // @Param id path int true "Artist ID"
+// @Param size query int false "Thumbnail size (longest edge)" Enums(64, 256, 512, 1024)
+// @Param format query string false "Thumbnail format" Enums(jpeg, webp) default(jpeg)
 // @Success 200 {file} binary "Artist image"
+// @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Router /artists/{id}/image [get]
 func (h *HLSHandler) ArtistImage(c echo.Context) error {
-	ctx := c.Request().Context()
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	size, format, err := parseArtworkVariant(c)
+	if err != nil {
+		return err
+	}
+	return h.sendArtistImage(c, id, size, format)
+}
+
+func (h *HLSHandler) sendArtistImage(c echo.Context, id int64, size int, format string) error {
+	ctx := c.Request().Context()
 
 	var imagePath string
 	err := h.db.QueryRowContext(ctx, `SELECT image_path FROM artists WHERE id = ?`, id).Scan(&imagePath)
 	if err != nil || imagePath == "" {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "artist image not found", "code": "NOT_FOUND"})
 	}
-
-	info, err := os.Stat(imagePath)
-	if err != nil {
+	if _, err := os.Stat(imagePath); err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "artist image not found", "code": "NOT_FOUND"})
 	}
-
-	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
-	c.Response().Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
-
-	return c.File(imagePath)
+	return h.sendImage(c, imagePath, fmt.Sprintf("artist-%d", id), size, format)
 }
 
 // Lyrics godoc
 // @Summary Get lyrics for a track
-// @Description Returns lyrics and synced lyrics if available
+// @Description Returns lyrics and synced lyrics if available. Lyrics come from the file's tags, a sidecar .lrc or .txt file next to it or, when enabled, the lyrics provider; source says which.
 // @Tags Library
 // @Produce json
 // @Param id path int true "Track ID"
@@ -619,15 +1174,18 @@ func (h *HLSHandler) ArtistImage(c echo.Context) error {
 func (h *HLSHandler) Lyrics(c echo.Context) error {
 	ctx := c.Request().Context()
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
-	var lyrics, synced string
-	err := h.db.QueryRowContext(ctx, `SELECT lyrics, lyrics_synced FROM songs WHERE id = ?`, id).Scan(&lyrics, &synced)
-	if err != nil {
+	lyrics, err := h.lyrics.Get(ctx, access(c), id)
+	if errors.Is(err, sql.ErrNoRows) {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "lyrics not found", "code": "NOT_FOUND"})
 	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "DB_ERROR"})
+	}
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"lyrics": lyrics,
-		"synced": synced,
-		"source": "embedded",
+		"lyrics":       lyrics.Plain,
+		"synced":       lyrics.Synced,
+		"source":       lyrics.Source,
+		"instrumental": lyrics.Instrumental,
 	})
 }
 
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	_ "modernc.org/sqlite"
+
+	"github.com/Aunali321/korus/internal/models"
+	"github.com/Aunali321/korus/internal/services/hls"
+)
+
+// newTranscodeTestHandler returns an HLS handler over one song, whose ffmpeg
+// writes its own arguments out in place of audio
+func newTranscodeTestHandler(t *testing.T) *HLSHandler {
+	t.Helper()
+	dir := t.TempDir()
+
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\necho \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	audio := filepath.Join(dir, "song.flac")
+	if err := os.WriteFile(audio, []byte("fLaC"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := sql.Open("sqlite", filepath.Join(dir, "korus.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	_, err = database.Exec(`
+		CREATE TABLE songs (id INTEGER PRIMARY KEY, library_id INTEGER, file_path TEXT, source_path TEXT, title TEXT,
+			duration_ms INTEGER, sample_rate INTEGER, bit_depth INTEGER, channels INTEGER,
+			track_loudness REAL, track_peak REAL, album_loudness REAL, album_peak REAL,
+			cue_start_ms INTEGER, cue_end_ms INTEGER);
+		CREATE TABLE artists (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE song_artists (song_id INTEGER, artist_id INTEGER);
+		INSERT INTO songs (id, library_id, file_path, title, duration_ms, track_loudness, track_peak)
+			VALUES (1, 1, ?, 'Song', 180000, -8, 0.5);
+	`, audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := hls.NewService(hls.ServiceConfig{
+		CacheDir:    filepath.Join(dir, "cache"),
+		CacheSizeMB: 10,
+		FFmpegPath:  ffmpeg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHLSHandler(database, svc, nil, nil)
+}
+
+func TestDownloadNormalizeCachedSeparately(t *testing.T) {
+	h := newTranscodeTestHandler(t)
+	e := echo.New()
+
+	download := func(query string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/download/1?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		c.Set("user", models.User{ID: 1, Role: "admin"})
+		if err := h.Download(c); err != nil {
+			t.Fatalf("Download(%s): %v", query, err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Download(%s) status = %d", query, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	// Each is fetched twice, the second time from the cache
+	plain := download("format=mp3")
+	if got := download("format=mp3"); got != plain {
+		t.Errorf("cached plain download = %q, want %q", got, plain)
+	}
+	normalized := download("format=mp3&normalize=track")
+	if got := download("format=mp3&normalize=track"); got != normalized {
+		t.Errorf("cached normalized download = %q, want %q", got, normalized)
+	}
+
+	if normalized == plain {
+		t.Fatalf("normalized download is the plain one: %q", plain)
+	}
+	if strings.Contains(plain, "volume=") {
+		t.Errorf("plain download applied a gain: %q", plain)
+	}
+	if !strings.Contains(normalized, "volume=") {
+		t.Errorf("normalized download applied no gain: %q", normalized)
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Aunali321/korus/internal/services"
+)
+
+// lastFMError maps Last.fm service errors to HTTP errors
+func lastFMError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrLastFMNotLinked):
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": err.Error(), "code": "LASTFM_NOT_LINKED"})
+	case errors.Is(err, services.ErrLastFMToken):
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "LASTFM_INVALID_TOKEN"})
+	}
+	return echo.NewHTTPError(http.StatusBadGateway, map[string]string{"error": err.Error(), "code": "LASTFM_FAILED"})
+}
+
+func (h *Handler) lastFMEnabled() error {
+	if h.lastFM == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, map[string]string{"error": "last.fm disabled", "code": "LASTFM_DISABLED"})
+	}
+	return nil
+}
+
+// GetLastFM godoc
+// @Summary Get linked Last.fm account
+// @Tags LastFM
+// @Produce json
+// @Success 200 {object} models.LastFMAccount
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /lastfm [get]
+// @Security BearerAuth
+func (h *Handler) GetLastFM(c echo.Context) error {
+	if err := h.lastFMEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	account, err := h.lastFM.Account(c.Request().Context(), user.ID)
+	if err != nil {
+		return lastFMError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// UpdateLastFM godoc
+// @Summary Turn Last.fm scrobbling on or off
+// @Tags LastFM
+// @Accept json
+// @Produce json
+// @Param body body map[string]bool true "scrobble"
+// @Success 200 {object} models.LastFMAccount
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /lastfm [put]
+// @Security BearerAuth
+func (h *Handler) UpdateLastFM(c echo.Context) error {
+	if err := h.lastFMEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	var payload struct {
+		Scrobble bool `json:"scrobble"`
+	}
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	ctx := c.Request().Context()
+	if err := h.lastFM.SetScrobble(ctx, user.ID, payload.Scrobble); err != nil {
+		return lastFMError(err)
+	}
+	account, err := h.lastFM.Account(ctx, user.ID)
+	if err != nil {
+		return lastFMError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// UnlinkLastFM godoc
+// @Summary Unlink Last.fm account
+// @Tags LastFM
+// @Produce json
+// @Success 200 {object} map[string]bool
+// @Failure 503 {object} map[string]string
+// @Router /lastfm [delete]
+// @Security BearerAuth
+func (h *Handler) UnlinkLastFM(c echo.Context) error {
+	if err := h.lastFMEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	if err := h.lastFM.Unlink(c.Request().Context(), user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"success": true})
+}
+
+// LastFMAuth godoc
+// @Summary Start Last.fm authorization
+// @Description Returns the Last.fm page where the user grants access. With a callback, Last.fm redirects there with a token query parameter; without one, the token is returned here. Either way, send it to POST /lastfm/session once the user has granted access.
+// @Tags LastFM
+// @Produce json
+// @Param callback query string false "URL Last.fm redirects to after authorization"
+// @Success 200 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /lastfm/auth [get]
+// @Security BearerAuth
+func (h *Handler) LastFMAuth(c echo.Context) error {
+	if err := h.lastFMEnabled(); err != nil {
+		return err
+	}
+	authURL, token, err := h.lastFM.AuthURL(c.Request().Context(), c.QueryParam("callback"))
+	if err != nil {
+		return lastFMError(err)
+	}
+	res := map[string]string{"url": authURL}
+	if token != "" {
+		res["token"] = token
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// LastFMSession godoc
+// @Summary Link Last.fm account
+// @Description Exchanges a token the user authorized on Last.fm for a session and links the account
+// @Tags LastFM
+// @Accept json
+// @Produce json
+// @Param body body map[string]string true "token"
+// @Success 200 {object} models.LastFMAccount
+// @Failure 400 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /lastfm/session [post]
+// @Security BearerAuth
+func (h *Handler) LastFMSession(c echo.Context) error {
+	if err := h.lastFMEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	var payload struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	if err := c.Validate(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
+	}
+	ctx := c.Request().Context()
+	if err := h.lastFM.CreateSession(ctx, user.ID, payload.Token); err != nil {
+		return lastFMError(err)
+	}
+	account, err := h.lastFM.Account(ctx, user.ID)
+	if err != nil {
+		return lastFMError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// LastFMNowPlaying godoc
+// @Summary Update Last.fm now playing
+// @Description Tells Last.fm which song the user started playing
+// @Tags LastFM
+// @Accept json
+// @Produce json
+// @Param body body map[string]int64 true "song_id"
+// @Success 200 {object} map[string]bool
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /lastfm/now-playing [post]
+// @Security BearerAuth
+func (h *Handler) LastFMNowPlaying(c echo.Context) error {
+	if err := h.lastFMEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	var payload struct {
+		SongID int64 `json:"song_id" validate:"required"`
+	}
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	if err := c.Validate(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
+	}
+	if !h.songExists(c.Request().Context(), payload.SongID) {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "song not found", "code": "NOT_FOUND"})
+	}
+	if err := h.lastFM.UpdateNowPlaying(c.Request().Context(), user.ID, payload.SongID); err != nil {
+		return lastFMError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"submitted": true})
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
+)
+
+type libraryRequest struct {
+	Name                string `json:"name" validate:"required"`
+	RootPath            string `json:"root_path" validate:"required"`
+	ExcludePattern      string `json:"exclude_pattern"`
+	ScanIntervalMinutes int    `json:"scan_interval_minutes" validate:"min=0"`
+	AutoGrant           bool   `json:"auto_grant"`
+}
+
+type userLibrariesRequest struct {
+	LibraryIDs []int64 `json:"library_ids"`
+}
+
+// access returns the libraries the current user can read
+func access(c echo.Context) db.Access {
+	user, _ := currentUser(c)
+	return db.UserAccess(user)
+}
+
+// ListLibraries godoc
+// @Summary List libraries
+// @Description Returns the libraries the current user can read. Root paths are only shown to admins.
+// @Tags Library
+// @Produce json
+// @Success 200 {array} models.Library
+// @Router /libraries [get]
+// @Security BearerAuth
+func (h *Handler) ListLibraries(c echo.Context) error {
+	acc := access(c)
+	libraries, err := db.GetLibraries(c.Request().Context(), h.db, acc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to load libraries", "code": "DB_ERROR"})
+	}
+	if !acc.All {
+		for i := range libraries {
+			libraries[i].RootPath = ""
+			libraries[i].ExcludePattern = ""
+		}
+	}
+	return c.JSON(http.StatusOK, libraries)
+}
+
+// CreateLibrary godoc
+// @Summary Create library
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param body body libraryRequest true "library"
+// @Success 201 {object} models.Library
+// @Failure 400 {object} map[string]string
+// @Router /admin/libraries [post]
+// @Security BearerAuth
+func (h *Handler) CreateLibrary(c echo.Context) error {
+	lib, err := bindLibrary(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	id, err := db.CreateLibrary(ctx, h.db, lib)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "library name or root path already in use", "code": "CREATE_FAILED"})
+	}
+	h.scanner.Rewatch()
+	created, err := db.GetLibrary(ctx, h.db, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to load library", "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusCreated, created)
+}
+
+// UpdateLibrary godoc
+// @Summary Update library
+// @Description Changing the root path or exclude pattern takes effect on the next scan
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Library ID"
+// @Param body body libraryRequest true "library"
+// @Success 200 {object} models.Library
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /admin/libraries/{id} [put]
+// @Security BearerAuth
+func (h *Handler) UpdateLibrary(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	lib, err := bindLibrary(c)
+	if err != nil {
+		return err
+	}
+	lib.ID = id
+	ctx := c.Request().Context()
+	if err := db.UpdateLibrary(ctx, h.db, lib); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "library not found", "code": "NOT_FOUND"})
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "library name or root path already in use", "code": "UPDATE_FAILED"})
+	}
+	h.scanner.Rewatch()
+	updated, err := db.GetLibrary(ctx, h.db, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to load library", "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, updated)
+}
+
+// DeleteLibrary godoc
+// @Summary Delete library
+// @Description Removes the library and its songs and albums from the database. Files on disk are untouched.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "Library ID"
+// @Success 200 {object} map[string]bool
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /admin/libraries/{id} [delete]
+// @Security BearerAuth
+func (h *Handler) DeleteLibrary(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err := h.scanner.RemoveLibrary(c.Request().Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "library not found", "code": "NOT_FOUND"})
+		}
+		return echo.NewHTTPError(http.StatusConflict, map[string]string{"error": err.Error(), "code": "DELETE_FAILED"})
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"success": true})
+}
+
+// GetUserLibraries godoc
+// @Summary Get a user's libraries
+// @Tags Admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} userLibrariesRequest
+// @Router /admin/users/{id}/libraries [get]
+// @Security BearerAuth
+func (h *Handler) GetUserLibraries(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	ids, err := db.GetUserLibraryIDs(c.Request().Context(), h.db, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to load libraries", "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, userLibrariesRequest{LibraryIDs: ids})
+}
+
+// SetUserLibraries godoc
+// @Summary Set a user's libraries
+// @Description Replaces the libraries the user can read. Admins can always read every library.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param body body userLibrariesRequest true "library ids"
+// @Success 200 {object} userLibrariesRequest
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /admin/users/{id}/libraries [put]
+// @Security BearerAuth
+func (h *Handler) SetUserLibraries(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	var req userLibrariesRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	ctx := c.Request().Context()
+	var exists int
+	if err := h.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM users WHERE id = ?`, id).Scan(&exists); err != nil || exists == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "user not found", "code": "NOT_FOUND"})
+	}
+	for _, libID := range req.LibraryIDs {
+		if _, err := db.GetLibrary(ctx, h.db, libID); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "unknown library " + strconv.FormatInt(libID, 10), "code": "VALIDATION_ERROR"})
+		}
+	}
+	if err := db.SetUserLibraries(ctx, h.db, id, req.LibraryIDs); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to save libraries", "code": "UPDATE_FAILED"})
+	}
+	ids, _ := db.GetUserLibraryIDs(ctx, h.db, id)
+	return c.JSON(http.StatusOK, userLibrariesRequest{LibraryIDs: ids})
+}
+
+// bindLibrary reads and validates a library payload. The root path must be
+// an existing directory and the exclude pattern a valid regular expression.
+func bindLibrary(c echo.Context) (models.Library, error) {
+	var req libraryRequest
+	if err := c.Bind(&req); err != nil {
+		return models.Library{}, echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return models.Library{}, echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
+	}
+	root, err := filepath.Abs(req.RootPath)
+	if err != nil {
+		return models.Library{}, echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid root path", "code": "VALIDATION_ERROR"})
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return models.Library{}, echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "root path is not a directory", "code": "VALIDATION_ERROR"})
+	}
+	if req.ExcludePattern != "" {
+		if _, err := regexp.Compile(req.ExcludePattern); err != nil {
+			return models.Library{}, echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid exclude pattern", "code": "VALIDATION_ERROR"})
+		}
+	}
+	return models.Library{
+		Name:                req.Name,
+		RootPath:            root,
+		ExcludePattern:      req.ExcludePattern,
+		ScanIntervalMinutes: req.ScanIntervalMinutes,
+		AutoGrant:           req.AutoGrant,
+	}, nil
+}
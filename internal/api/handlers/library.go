@@ -23,9 +23,10 @@ import (
 func (h *Handler) Library(c echo.Context) error {
 	ctx := c.Request().Context()
 	limit := parseOptionalLimit(c)
-	artists, _ := h.fetchArtists(ctx, limit)
-	albums, _ := h.fetchAlbums(ctx, limit)
-	songs, _ := db.GetSongsRecent(ctx, h.db, limit)
+	acc := access(c)
+	artists, _ := h.fetchArtists(ctx, acc, limit)
+	albums, _ := h.fetchAlbums(ctx, acc, limit)
+	songs, _ := db.GetSongsRecent(ctx, h.db, acc, limit)
 	_ = db.PopulateSongArtists(ctx, h.db, songs)
 	return c.JSON(http.StatusOK, map[string]any{
 		"artists": artists,
@@ -46,9 +47,10 @@ func (h *Handler) Library(c echo.Context) error {
 func (h *Handler) Artist(c echo.Context) error {
 	ctx := c.Request().Context()
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	acc := access(c)
 	var a models.Artist
 	var mbid, bio, imagePath sql.NullString
-	err := h.db.QueryRowContext(ctx, `SELECT id, name, bio, image_path, mbid, created_at FROM artists WHERE id = ?`, id).
+	err := h.db.QueryRowContext(ctx, `SELECT id, name, bio, image_path, mbid, created_at FROM artists ar WHERE id = ? AND `+acc.ArtistFilter("ar"), id).
 		Scan(&a.ID, &a.Name, &bio, &imagePath, &mbid, &a.CreatedAt)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "artist not found", "code": "NOT_FOUND"})
@@ -62,8 +64,8 @@ func (h *Handler) Artist(c echo.Context) error {
 	if mbid.Valid {
 		a.MBID = &mbid.String
 	}
-	albums, _ := h.fetchAlbumsByArtist(ctx, id)
-	songs, _ := db.GetSongsByArtist(ctx, h.db, id)
+	albums, _ := h.fetchAlbumsByArtist(ctx, acc, id)
+	songs, _ := db.GetSongsByArtist(ctx, h.db, acc, id)
 	_ = db.PopulateSongArtists(ctx, h.db, songs)
 	return c.JSON(http.StatusOK, map[string]any{
 		"id":         a.ID,
@@ -78,6 +80,7 @@ func (h *Handler) Artist(c echo.Context) error {
 
 // Album godoc
 // @Summary Get album by id
+// @Description Returns the album with its songs in disc and track order, both as a flat list and grouped by disc.
 // @Tags Library
 // @Produce json
 // @Param id path int true "Album ID"
@@ -88,13 +91,14 @@ func (h *Handler) Artist(c echo.Context) error {
 func (h *Handler) Album(c echo.Context) error {
 	ctx := c.Request().Context()
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	acc := access(c)
 	var al models.Album
 	var mbid sql.NullString
 	var year sql.NullInt64
 	var artistID sql.NullInt64
 	err := h.db.QueryRowContext(ctx, `
-		SELECT id, artist_id, title, year, cover_path, mbid, created_at FROM albums WHERE id = ?
-	`, id).Scan(&al.ID, &artistID, &al.Title, &year, &al.CoverPath, &mbid, &al.CreatedAt)
+		SELECT id, artist_id, title, year, cover_path, COALESCE(cover_color, ''), COALESCE(cover_blurhash, ''), mbid, created_at
+		FROM albums al WHERE id = ? AND `+acc.Filter("al"), id).Scan(&al.ID, &artistID, &al.Title, &year, &al.CoverPath, &al.CoverColor, &al.CoverBlurhash, &mbid, &al.CreatedAt)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "album not found", "code": "NOT_FOUND"})
 	}
@@ -108,7 +112,7 @@ func (h *Handler) Album(c echo.Context) error {
 	if mbid.Valid {
 		al.MBID = &mbid.String
 	}
-	songs, _ := db.GetSongsByAlbum(ctx, h.db, id)
+	songs, _ := db.GetSongsByAlbum(ctx, h.db, acc, id)
 	_ = db.PopulateSongArtists(ctx, h.db, songs)
 	var artist *models.Artist
 	if al.ArtistID != nil {
@@ -116,13 +120,16 @@ func (h *Handler) Album(c echo.Context) error {
 		al.Artist = artist
 	}
 	return c.JSON(http.StatusOK, map[string]any{
-		"id":         al.ID,
-		"title":      al.Title,
-		"year":       al.Year,
-		"cover_path": al.CoverPath,
-		"mbid":       al.MBID,
-		"artist":     artist,
-		"songs":      songs,
+		"id":             al.ID,
+		"title":          al.Title,
+		"year":           al.Year,
+		"cover_path":     al.CoverPath,
+		"cover_color":    al.CoverColor,
+		"cover_blurhash": al.CoverBlurhash,
+		"mbid":           al.MBID,
+		"artist":         artist,
+		"songs":          songs,
+		"discs":          groupByDisc(songs),
 	})
 }
 
@@ -139,13 +146,15 @@ func (h *Handler) Song(c echo.Context) error {
 	ctx := c.Request().Context()
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	var s models.Song
-	var track sql.NullInt64
+	var track, disc, discTotal sql.NullInt64
 	var duration sql.NullInt64
 	var mbid sql.NullString
+	var trackLoudness, trackPeak, albumLoudness, albumPeak sql.NullFloat64
 	err := h.db.QueryRowContext(ctx, `
-		SELECT id, album_id, title, track_number, duration_ms / 1000, file_path, lyrics, lyrics_synced, mbid
-		FROM songs WHERE id = ?
-	`, id).Scan(&s.ID, &s.AlbumID, &s.Title, &track, &duration, &s.FilePath, &s.Lyrics, &s.LyricsSynced, &mbid)
+		SELECT id, album_id, title, track_number, disc_number, disc_total, COALESCE(disc_subtitle, ''), duration_ms / 1000, file_path, lyrics, lyrics_synced, mbid,
+		       track_loudness, track_peak, album_loudness, album_peak
+		FROM songs s WHERE id = ? AND `+access(c).Filter("s"), id).Scan(&s.ID, &s.AlbumID, &s.Title, &track, &disc, &discTotal, &s.DiscSubtitle, &duration, &s.FilePath, &s.Lyrics, &s.LyricsSynced, &mbid,
+		&trackLoudness, &trackPeak, &albumLoudness, &albumPeak)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "song not found", "code": "NOT_FOUND"})
 	}
@@ -153,6 +162,14 @@ func (h *Handler) Song(c echo.Context) error {
 		t := int(track.Int64)
 		s.TrackNumber = &t
 	}
+	if disc.Valid {
+		d := int(disc.Int64)
+		s.DiscNumber = &d
+	}
+	if discTotal.Valid {
+		d := int(discTotal.Int64)
+		s.DiscTotal = &d
+	}
 	if duration.Valid {
 		d := int(duration.Int64)
 		s.Duration = &d
@@ -160,6 +177,7 @@ func (h *Handler) Song(c echo.Context) error {
 	if mbid.Valid {
 		s.MBID = &mbid.String
 	}
+	s.ReplayGain = db.ReplayGain(trackLoudness, trackPeak, albumLoudness, albumPeak)
 	album, _ := h.fetchAlbum(ctx, s.AlbumID)
 	s.Album = album
 	// Populate all artists from song_artists
@@ -167,12 +185,38 @@ func (h *Handler) Song(c echo.Context) error {
 	if len(artists) > 0 {
 		s.Artists = artists
 	}
+	genres, _ := db.GetGenresForSong(ctx, h.db, s.ID)
+	if len(genres) > 0 {
+		s.Genres = genres
+	}
 	return c.JSON(http.StatusOK, s)
 }
 
 // helpers
-func (h *Handler) fetchArtists(ctx context.Context, limit int) ([]models.Artist, error) {
-	rows, err := h.db.QueryContext(ctx, `SELECT id, name, bio, image_path, mbid, created_at FROM artists ORDER BY created_at DESC LIMIT ?`, limit)
+
+// groupByDisc splits songs sorted by disc into discs. The first subtitle
+// found on a disc's songs names the disc.
+func groupByDisc(songs []models.Song) []models.Disc {
+	discs := []models.Disc{}
+	for _, s := range songs {
+		n := 1
+		if s.DiscNumber != nil {
+			n = *s.DiscNumber
+		}
+		if len(discs) == 0 || discs[len(discs)-1].Number != n {
+			discs = append(discs, models.Disc{Number: n})
+		}
+		d := &discs[len(discs)-1]
+		if d.Subtitle == "" {
+			d.Subtitle = s.DiscSubtitle
+		}
+		d.Songs = append(d.Songs, s)
+	}
+	return discs
+}
+
+func (h *Handler) fetchArtists(ctx context.Context, acc db.Access, limit int) ([]models.Artist, error) {
+	rows, err := h.db.QueryContext(ctx, `SELECT id, name, bio, image_path, mbid, created_at FROM artists ar WHERE `+acc.ArtistFilter("ar")+` ORDER BY created_at DESC LIMIT ?`, limit)
 	if err != nil {
 		return []models.Artist{}, err
 	}
@@ -197,12 +241,13 @@ func (h *Handler) fetchArtists(ctx context.Context, limit int) ([]models.Artist,
 	return res, nil
 }
 
-func (h *Handler) fetchAlbums(ctx context.Context, limit int) ([]models.Album, error) {
+func (h *Handler) fetchAlbums(ctx context.Context, acc db.Access, limit int) ([]models.Album, error) {
 	rows, err := h.db.QueryContext(ctx, `
-		SELECT al.id, al.artist_id, al.title, al.year, al.cover_path, al.mbid, al.created_at,
+		SELECT al.id, al.artist_id, al.title, al.year, al.cover_path, COALESCE(al.cover_color, ''), COALESCE(al.cover_blurhash, ''), al.mbid, al.created_at,
 		       ar.id, ar.name, ar.bio, ar.image_path, ar.mbid
 		FROM albums al
 		LEFT JOIN artists ar ON ar.id = al.artist_id
+		WHERE `+acc.Filter("al")+`
 		ORDER BY al.created_at DESC LIMIT ?`, limit)
 	if err != nil {
 		return []models.Album{}, err
@@ -216,7 +261,7 @@ func (h *Handler) fetchAlbums(ctx context.Context, limit int) ([]models.Album, e
 		var albumArtistID sql.NullInt64
 		var artistID sql.NullInt64
 		var artistName, artistBio, artistImagePath, artistMBID sql.NullString
-		if err := rows.Scan(&al.ID, &albumArtistID, &al.Title, &year, &al.CoverPath, &mbid, &al.CreatedAt,
+		if err := rows.Scan(&al.ID, &albumArtistID, &al.Title, &year, &al.CoverPath, &al.CoverColor, &al.CoverBlurhash, &mbid, &al.CreatedAt,
 			&artistID, &artistName, &artistBio, &artistImagePath, &artistMBID); err == nil {
 			if albumArtistID.Valid {
 				al.ArtistID = &albumArtistID.Int64
@@ -247,20 +292,21 @@ func (h *Handler) fetchAlbums(ctx context.Context, limit int) ([]models.Album, e
 	return res, nil
 }
 
-func (h *Handler) fetchAlbumsByArtist(ctx context.Context, artistID int64) ([]models.Album, error) {
+func (h *Handler) fetchAlbumsByArtist(ctx context.Context, acc db.Access, artistID int64) ([]models.Album, error) {
 	// An artist's albums = (a) albums whose album-level artist matches, AND
 	// (b) compilation/multi-artist albums (artist_id IS NULL or different)
 	// where the artist appears as a primary performer on at least one song.
 	// Without (b), the artist's detail page would be missing every
 	// compilation track they performed on.
 	rows, err := h.db.QueryContext(ctx, `
-		SELECT id, artist_id, title, year, cover_path, mbid, created_at FROM albums WHERE artist_id = ?
+		SELECT id, artist_id, title, year, cover_path, COALESCE(cover_color, ''), COALESCE(cover_blurhash, ''), mbid, created_at
+		FROM albums al WHERE artist_id = ? AND `+acc.Filter("al")+`
 		UNION
-		SELECT al.id, al.artist_id, al.title, al.year, al.cover_path, al.mbid, al.created_at
+		SELECT al.id, al.artist_id, al.title, al.year, al.cover_path, COALESCE(al.cover_color, ''), COALESCE(al.cover_blurhash, ''), al.mbid, al.created_at
 		FROM albums al
 		JOIN songs s ON s.album_id = al.id
 		JOIN song_artists sa ON sa.song_id = s.id AND sa.role = 'primary'
-		WHERE sa.artist_id = ? AND (al.artist_id IS NULL OR al.artist_id <> ?)
+		WHERE sa.artist_id = ? AND (al.artist_id IS NULL OR al.artist_id <> ?) AND `+acc.Filter("al")+`
 	`, artistID, artistID, artistID)
 	if err != nil {
 		return []models.Album{}, err
@@ -272,7 +318,7 @@ func (h *Handler) fetchAlbumsByArtist(ctx context.Context, artistID int64) ([]mo
 		var mbid sql.NullString
 		var year sql.NullInt64
 		var aid sql.NullInt64
-		if err := rows.Scan(&al.ID, &aid, &al.Title, &year, &al.CoverPath, &mbid, &al.CreatedAt); err == nil {
+		if err := rows.Scan(&al.ID, &aid, &al.Title, &year, &al.CoverPath, &al.CoverColor, &al.CoverBlurhash, &mbid, &al.CreatedAt); err == nil {
 			if aid.Valid {
 				al.ArtistID = &aid.Int64
 			}
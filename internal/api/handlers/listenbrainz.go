@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Aunali321/korus/internal/services"
+)
+
+type listenBrainzRequest struct {
+	Token         string `json:"token"`
+	SubmitListens *bool  `json:"submit_listens"`
+}
+
+// listenBrainzError maps ListenBrainz service errors to HTTP errors
+func listenBrainzError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrListenBrainzNotLinked):
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": err.Error(), "code": "LB_NOT_LINKED"})
+	case errors.Is(err, services.ErrListenBrainzToken):
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "LB_INVALID_TOKEN"})
+	case errors.Is(err, services.ErrListenBrainzImporting):
+		return echo.NewHTTPError(http.StatusConflict, map[string]string{"error": err.Error(), "code": "LB_IMPORT_RUNNING"})
+	}
+	return echo.NewHTTPError(http.StatusBadGateway, map[string]string{"error": err.Error(), "code": "LB_FAILED"})
+}
+
+func (h *Handler) listenBrainzEnabled() error {
+	if h.listenBrainz == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, map[string]string{"error": "listenbrainz disabled", "code": "LB_DISABLED"})
+	}
+	return nil
+}
+
+// GetListenBrainz godoc
+// @Summary Get linked ListenBrainz account
+// @Description Returns the linked account, whether listens are submitted and the state of the last history import
+// @Tags ListenBrainz
+// @Produce json
+// @Success 200 {object} models.ListenBrainzAccount
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /listenbrainz [get]
+// @Security BearerAuth
+func (h *Handler) GetListenBrainz(c echo.Context) error {
+	if err := h.listenBrainzEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	account, err := h.listenBrainz.Account(c.Request().Context(), user.ID)
+	if err != nil {
+		return listenBrainzError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// LinkListenBrainz godoc
+// @Summary Link ListenBrainz account
+// @Description Validates and stores the user's ListenBrainz token. Without a token only submit_listens is changed.
+// @Tags ListenBrainz
+// @Accept json
+// @Produce json
+// @Param body body listenBrainzRequest true "token and submit_listens (default true)"
+// @Success 200 {object} models.ListenBrainzAccount
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /listenbrainz [put]
+// @Security BearerAuth
+func (h *Handler) LinkListenBrainz(c echo.Context) error {
+	if err := h.listenBrainzEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	var req listenBrainzRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	ctx := c.Request().Context()
+	submit := true
+	if req.SubmitListens != nil {
+		submit = *req.SubmitListens
+	} else if req.Token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "token or submit_listens is required", "code": "VALIDATION_ERROR"})
+	}
+	if err := h.listenBrainz.Link(ctx, user.ID, req.Token, submit); err != nil {
+		return listenBrainzError(err)
+	}
+	account, err := h.listenBrainz.Account(ctx, user.ID)
+	if err != nil {
+		return listenBrainzError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// UnlinkListenBrainz godoc
+// @Summary Unlink ListenBrainz account
+// @Tags ListenBrainz
+// @Produce json
+// @Success 200 {object} map[string]bool
+// @Failure 503 {object} map[string]string
+// @Router /listenbrainz [delete]
+// @Security BearerAuth
+func (h *Handler) UnlinkListenBrainz(c echo.Context) error {
+	if err := h.listenBrainzEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	if err := h.listenBrainz.Unlink(c.Request().Context(), user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"success": true})
+}
+
+// ListenBrainzNowPlaying godoc
+// @Summary Submit now playing
+// @Description Tells ListenBrainz which song the user started playing
+// @Tags ListenBrainz
+// @Accept json
+// @Produce json
+// @Param body body map[string]int64 true "song_id"
+// @Success 200 {object} map[string]bool
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /listenbrainz/now-playing [post]
+// @Security BearerAuth
+func (h *Handler) ListenBrainzNowPlaying(c echo.Context) error {
+	if err := h.listenBrainzEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	var payload struct {
+		SongID int64 `json:"song_id" validate:"required"`
+	}
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	if err := c.Validate(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
+	}
+	if !h.songExists(c.Request().Context(), payload.SongID) {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "song not found", "code": "NOT_FOUND"})
+	}
+	if err := h.listenBrainz.SubmitPlayingNow(c.Request().Context(), user.ID, payload.SongID); err != nil {
+		return listenBrainzError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"submitted": true})
+}
+
+// ImportListenBrainz godoc
+// @Summary Import ListenBrainz history
+// @Description Starts importing the user's listens into play history in the background. Listens are matched to library songs by MusicBrainz recording ID, ISRC, then artist and title; unmatched ones are skipped. Later imports resume where the last one stopped. Poll GET /listenbrainz for progress.
+// @Tags ListenBrainz
+// @Produce json
+// @Success 202 {object} map[string]bool
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /listenbrainz/import [post]
+// @Security BearerAuth
+func (h *Handler) ImportListenBrainz(c echo.Context) error {
+	if err := h.listenBrainzEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	if err := h.listenBrainz.StartImport(user); err != nil {
+		return listenBrainzError(err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]bool{"started": true})
+}
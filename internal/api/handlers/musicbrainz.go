@@ -5,10 +5,13 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/Aunali321/korus/internal/services"
 )
 
 // SubmitListen godoc
 // @Summary Submit listen to ListenBrainz
+// @Description Queues a listen of the song, starting now, for the user's linked ListenBrainz account
 // @Tags MusicBrainz
 // @Accept json
 // @Produce json
@@ -17,10 +20,12 @@ import (
 // @Failure 404 {object} map[string]string
 // @Failure 503 {object} map[string]string
 // @Router /musicbrainz/submit-listen [post]
+// @Security BearerAuth
 func (h *Handler) SubmitListen(c echo.Context) error {
-	if h.listenBrainz == nil {
-		return echo.NewHTTPError(http.StatusServiceUnavailable, map[string]string{"error": "listenbrainz disabled", "code": "LB_DISABLED"})
+	if err := h.listenBrainzEnabled(); err != nil {
+		return err
 	}
+	user, _ := currentUser(c)
 	var payload struct {
 		SongID int64 `json:"song_id" validate:"required"`
 	}
@@ -30,11 +35,12 @@ func (h *Handler) SubmitListen(c echo.Context) error {
 	if err := c.Validate(&payload); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
 	}
-	var title string
-	if err := h.db.QueryRowContext(c.Request().Context(), `SELECT title FROM songs WHERE id = ?`, payload.SongID).Scan(&title); err != nil {
+	if !h.songExists(c.Request().Context(), payload.SongID) {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "song not found", "code": "NOT_FOUND"})
 	}
-	go h.listenBrainz.SubmitListen(c.Request().Context(), title, time.Now().Unix())
+	if err := h.scrobbles.Queue(c.Request().Context(), user.ID, payload.SongID, services.ScrobbleListenBrainz, time.Now()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "DB_ERROR"})
+	}
 	return c.JSON(http.StatusOK, map[string]bool{"submitted": true})
 }
 
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/Aunali321/korus/internal/models"
+	"github.com/Aunali321/korus/internal/services"
 	"github.com/labstack/echo/v4"
 )
 
@@ -112,5 +113,10 @@ func (h *Handler) SavePlayerState(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to save player state"})
 	}
 
+	if req.Queue == nil {
+		req.Queue = []int{}
+	}
+	h.events.Publish(services.Event{Type: services.EventPlayerState, Data: req, UserIDs: []int64{user.ID}})
+
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
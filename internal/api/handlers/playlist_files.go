@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/services"
+)
+
+var playlistContentTypes = map[string]string{
+	services.PlaylistM3U8: "audio/x-mpegurl; charset=utf-8",
+	services.PlaylistXSPF: "application/xspf+xml",
+	services.PlaylistJSPF: "application/json",
+}
+
+var unsafeFilename = regexp.MustCompile(`[^\w\- ]+`)
+
+// ExportPlaylist godoc
+// @Summary Export playlist
+// @Description Downloads the playlist as M3U8, XSPF or JSPF (ListenBrainz). Entries carry file paths, artist, title, album, duration and, when known, MusicBrainz recording IDs and ISRCs.
+// @Tags Playlists
+// @Produce octet-stream
+// @Param id path int true "Playlist ID"
+// @Param format query string false "m3u8 (default), xspf or jspf"
+// @Success 200 {file} binary
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /playlists/{id}/export [get]
+// @Security BearerAuth
+func (h *Handler) ExportPlaylist(c echo.Context) error {
+	user, _ := currentUser(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	format := services.PlaylistFormat(c.QueryParam("format"), ".m3u8")
+	if format == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "format must be m3u8, xspf or jspf", "code": "VALIDATION_ERROR"})
+	}
+	if _, _, err := h.playlistRole(c, id, db.PlaylistViewer); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	var name, owner string
+	if err := h.db.QueryRowContext(ctx, `SELECT p.name, u.username FROM playlists p JOIN users u ON u.id = p.user_id WHERE p.id = ?`, id).Scan(&name, &owner); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
+	}
+	entries, err := services.PlaylistFileEntries(ctx, h.db, db.UserAccess(user), id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "EXPORT_FAILED"})
+	}
+	var buf bytes.Buffer
+	if err := services.WritePlaylistFile(&buf, format, name, owner, entries); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "EXPORT_FAILED"})
+	}
+	filename := strings.TrimSpace(unsafeFilename.ReplaceAllString(name, ""))
+	if filename == "" {
+		filename = "playlist"
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+"."+format+`"`)
+	return c.Blob(http.StatusOK, playlistContentTypes[format], buf.Bytes())
+}
+
+// ImportPlaylist godoc
+// @Summary Import playlist
+// @Description Creates a playlist from an uploaded M3U/M3U8, XSPF or JSPF file. Entries are matched to library songs by MusicBrainz recording ID, ISRC, file path (also by trailing path components, for files exported elsewhere) and finally artist and title. The response lists the entries that matched nothing.
+// @Tags Playlists
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Playlist file"
+// @Param format formData string false "m3u8, xspf or jspf (default: from the file extension)"
+// @Param name formData string false "Playlist name (default: the file's title or name)"
+// @Param public formData bool false "Make the playlist public"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /playlists/import [post]
+// @Security BearerAuth
+func (h *Handler) ImportPlaylist(c echo.Context) error {
+	user, _ := currentUser(c)
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "no file provided", "code": "BAD_REQUEST"})
+	}
+	format := services.PlaylistFormat(c.FormValue("format"), file.Filename)
+	if format == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "format must be m3u8, xspf or jspf", "code": "VALIDATION_ERROR"})
+	}
+	src, err := file.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "failed to read file", "code": "BAD_REQUEST"})
+	}
+	defer src.Close()
+	title, entries, err := services.ParsePlaylistFile(format, src)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
+	}
+	if len(entries) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "playlist has no entries", "code": "VALIDATION_ERROR"})
+	}
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		name = title
+	}
+	if name == "" {
+		name = strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename))
+	}
+	public, _ := strconv.ParseBool(c.FormValue("public"))
+
+	ctx := c.Request().Context()
+	ids := services.MatchPlaylistEntries(ctx, h.db, db.UserAccess(user), entries)
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "IMPORT_FAILED"})
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `INSERT INTO playlists(user_id, name, description, public) VALUES(?, ?, '', ?)`, user.ID, name, public)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "IMPORT_FAILED"})
+	}
+	playlistID, _ := res.LastInsertId()
+	unmatched := []services.PlaylistImportMiss{}
+	matched, position := 0, 0
+	for i, songID := range ids {
+		if songID == 0 {
+			e := entries[i]
+			unmatched = append(unmatched, services.PlaylistImportMiss{Index: i + 1, Title: e.Title, Artist: e.Artist, Path: e.Path})
+			continue
+		}
+		matched++
+		position++
+		if _, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO playlist_songs(playlist_id, song_id, position, added_by, added_at) VALUES(?, ?, ?, ?, CURRENT_TIMESTAMP)
+		`, playlistID, songID, position, user.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "IMPORT_FAILED"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "IMPORT_FAILED"})
+	}
+	h.logActivity(c, playlistID, "imported", 0, file.Filename)
+	h.syncPlaylistFile(c, playlistID)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"id":        playlistID,
+		"name":      name,
+		"total":     len(entries),
+		"matched":   matched,
+		"unmatched": unmatched,
+	})
+}
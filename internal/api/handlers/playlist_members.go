@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/services"
+)
+
+type playlistMemberRequest struct {
+	Username string `json:"username" validate:"required"`
+	Role     string `json:"role" validate:"required,oneof=viewer editor"`
+}
+
+// ListPlaylistMembers godoc
+// @Summary List playlist members
+// @Description Returns the users a playlist is shared with and their roles
+// @Tags Playlists
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Success 200 {array} models.PlaylistMember
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /playlists/{id}/members [get]
+// @Security BearerAuth
+func (h *Handler) ListPlaylistMembers(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if _, _, err := h.playlistRole(c, id, db.PlaylistViewer); err != nil {
+		return err
+	}
+	members, err := db.GetPlaylistMembers(c.Request().Context(), h.db, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to load members", "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, members)
+}
+
+// AddPlaylistMember godoc
+// @Summary Share playlist
+// @Description Invites a user as viewer or editor, or changes their role. Only the owner can share a playlist.
+// @Tags Playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Param body body playlistMemberRequest true "member"
+// @Success 200 {array} models.PlaylistMember
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /playlists/{id}/members [post]
+// @Security BearerAuth
+func (h *Handler) AddPlaylistMember(c echo.Context) error {
+	user, _ := currentUser(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if _, _, err := h.playlistRole(c, id, db.PlaylistOwner); err != nil {
+		return err
+	}
+	var req playlistMemberRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	if err := c.Validate(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
+	}
+	ctx := c.Request().Context()
+	var memberID int64
+	var username string
+	if err := h.db.QueryRowContext(ctx, `SELECT id, username FROM users WHERE username = ?`, req.Username).Scan(&memberID, &username); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "user not found", "code": "NOT_FOUND"})
+	}
+	if memberID == user.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "the owner cannot be a member", "code": "VALIDATION_ERROR"})
+	}
+	if err := db.SetPlaylistMember(ctx, h.db, id, memberID, req.Role); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to share playlist", "code": "UPDATE_FAILED"})
+	}
+	h.logActivity(c, id, "member_added", 0, username+" ("+req.Role+")")
+	members, _ := db.GetPlaylistMembers(ctx, h.db, id)
+	return c.JSON(http.StatusOK, members)
+}
+
+// RemovePlaylistMember godoc
+// @Summary Unshare playlist
+// @Description Removes a member. The owner can remove anyone; members can remove themselves.
+// @Tags Playlists
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Param user_id path int true "User ID"
+// @Success 200 {object} map[string]bool
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /playlists/{id}/members/{user_id} [delete]
+// @Security BearerAuth
+func (h *Handler) RemovePlaylistMember(c echo.Context) error {
+	user, _ := currentUser(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	memberID, _ := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	min := db.PlaylistOwner
+	if memberID == user.ID {
+		min = db.PlaylistViewer
+	}
+	if _, _, err := h.playlistRole(c, id, min); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	var username string
+	_ = h.db.QueryRowContext(ctx, `SELECT username FROM users WHERE id = ?`, memberID).Scan(&username)
+	if err := db.RemovePlaylistMember(ctx, h.db, id, memberID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "member not found", "code": "NOT_FOUND"})
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to remove member", "code": "UPDATE_FAILED"})
+	}
+	h.logActivity(c, id, "member_removed", 0, username)
+	// Unless the playlist is public, the removed member no longer sees it
+	// and so missed that announcement
+	if role, _, _ := db.GetPlaylistRole(ctx, h.db, id, memberID); role == "" {
+		h.events.Publish(services.Event{Type: services.EventPlaylistUpdated, Data: services.PlaylistEvent{ID: id}, UserIDs: []int64{memberID}})
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"success": true})
+}
+
+// ListPlaylistActivity godoc
+// @Summary Playlist activity
+// @Description Returns who changed the playlist and how, newest first
+// @Tags Playlists
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Param limit query int false "max items (default 50, max 200)"
+// @Param offset query int false "offset"
+// @Success 200 {array} models.PlaylistActivity
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /playlists/{id}/activity [get]
+// @Security BearerAuth
+func (h *Handler) ListPlaylistActivity(c echo.Context) error {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if _, _, err := h.playlistRole(c, id, db.PlaylistViewer); err != nil {
+		return err
+	}
+	limit, offset := parseLimitOffset(c, 50, 200)
+	activity, err := db.GetPlaylistActivity(c.Request().Context(), h.db, id, limit, offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to load activity", "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, activity)
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,16 +16,129 @@ import (
 	"github.com/labstack/echo/v4"
 
 	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
+	"github.com/Aunali321/korus/internal/services"
 )
 
 type playlistRequest struct {
-	Name        string `json:"name" validate:"required"`
-	Description string `json:"description"`
-	Public      bool   `json:"public"`
+	Name        string             `json:"name" validate:"required"`
+	Description string             `json:"description"`
+	Public      bool               `json:"public"`
+	Rules       *models.SmartRules `json:"rules"`
+}
+
+// playlistUpdateRequest is playlistRequest for updates, where leaving rules
+// out keeps a smart playlist's rules and only an explicit null removes them
+type playlistUpdateRequest struct {
+	Name        string        `json:"name" validate:"required"`
+	Description string        `json:"description"`
+	Public      bool          `json:"public"`
+	Rules       optionalRules `json:"rules" swaggertype:"object"`
+}
+
+// optionalRules is a rules field that records whether it was present at all
+type optionalRules struct {
+	Set   bool
+	Rules *models.SmartRules
+}
+
+func (o *optionalRules) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Rules = nil
+		return nil
+	}
+	o.Rules = new(models.SmartRules)
+	return json.Unmarshal(data, o.Rules)
+}
+
+// encodeRules validates a smart playlist rule document and returns it as
+// stored in playlists.rules, or NULL for a regular playlist.
+func encodeRules(rules *models.SmartRules) (sql.NullString, error) {
+	if rules == nil {
+		return sql.NullString{}, nil
+	}
+	if err := db.ValidateSmartRules(*rules); err != nil {
+		return sql.NullString{}, echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid rules: " + err.Error(), "code": "VALIDATION_ERROR"})
+	}
+	raw, _ := json.Marshal(rules)
+	return sql.NullString{String: string(raw), Valid: true}, nil
+}
+
+// decodeRules parses a stored rule document, returning nil for regular playlists
+func decodeRules(raw sql.NullString) *models.SmartRules {
+	if !raw.Valid {
+		return nil
+	}
+	var rules models.SmartRules
+	if json.Unmarshal([]byte(raw.String), &rules) != nil {
+		return nil
+	}
+	return &rules
+}
+
+// playlistRole returns the current user's role on a playlist and whether it
+// is smart, failing unless the role grants at least min.
+func (h *Handler) playlistRole(c echo.Context, id int64, min string) (string, bool, error) {
+	user, _ := currentUser(c)
+	role, smart, err := db.GetPlaylistRole(c.Request().Context(), h.db, id, user.ID)
+	if err != nil {
+		return "", false, echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
+	}
+	if !db.PlaylistRoleAtLeast(role, min) {
+		return "", false, echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "forbidden", "code": "FORBIDDEN"})
+	}
+	return role, smart, nil
+}
+
+// editablePlaylist checks the current user may change a playlist's songs.
+// Songs of a smart playlist are rewritten from its rules, so they can't be.
+func (h *Handler) editablePlaylist(c echo.Context, id int64) error {
+	_, smart, err := h.playlistRole(c, id, db.PlaylistEditor)
+	if err != nil {
+		return err
+	}
+	if smart {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "songs of a smart playlist are managed by its rules", "code": "SMART_PLAYLIST"})
+	}
+	if err := h.scanner.CheckPlaylistFile(c.Request().Context(), id); err != nil {
+		if errors.Is(err, services.ErrPlaylistConflict) {
+			return echo.NewHTTPError(http.StatusConflict, map[string]string{"error": err.Error(), "code": "PLAYLIST_CONFLICT"})
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "DB_ERROR"})
+	}
+	return nil
+}
+
+// syncPlaylistFile writes a changed playlist back to its file or mirror. The
+// change is already saved, so failures are only logged.
+func (h *Handler) syncPlaylistFile(c echo.Context, id int64) {
+	if err := h.scanner.SyncPlaylistFile(c.Request().Context(), id); err != nil {
+		log.Printf("sync playlist %d to disk: %v", id, err)
+	}
+}
+
+// logActivity records a change in a playlist's activity log and announces it
+// to everyone who can see the playlist. Failures are not worth failing the
+// change itself over.
+func (h *Handler) logActivity(c echo.Context, playlistID int64, action string, songID int64, detail string) {
+	user, _ := currentUser(c)
+	_ = db.LogPlaylistActivity(c.Request().Context(), h.db, playlistID, user.ID, action, songID, detail)
+	eventType := services.EventPlaylistUpdated
+	if action == "created" || action == "imported" {
+		eventType = services.EventPlaylistCreated
+	}
+	h.publishPlaylist(eventType, playlistID)
+}
+
+// publishPlaylist announces a playlist change to the users who can see it
+func (h *Handler) publishPlaylist(eventType string, playlistID int64) {
+	h.events.Publish(services.Event{Type: eventType, Data: services.PlaylistEvent{ID: playlistID}, PlaylistID: playlistID})
 }
 
 // ListPlaylists godoc
 // @Summary List playlists
+// @Description Returns the user's own playlists, playlists shared with them and public ones, each with the user's role
 // @Tags Playlists
 // @Produce json
 // @Param limit query int false "max items (default 50, max 200)"
@@ -33,15 +150,16 @@ func (h *Handler) ListPlaylists(c echo.Context) error {
 	user, _ := currentUser(c)
 	limit, offset := parseLimitOffset(c, 50, 200)
 	rows, err := h.db.QueryContext(c.Request().Context(), `
-		SELECT p.id, p.user_id, p.name, p.description, p.cover_path, p.public, p.created_at, u.username,
+		SELECT p.id, p.user_id, p.name, p.description, p.cover_path, p.public, p.rules, p.created_at, u.username,
+		       `+db.PlaylistRoleColumn("p", user.ID)+` as role,
 		       (SELECT COUNT(*) FROM playlist_songs ps WHERE ps.playlist_id = p.id) as song_count,
 		       (SELECT ps2.song_id FROM playlist_songs ps2 WHERE ps2.playlist_id = p.id ORDER BY ps2.position LIMIT 1) as first_song_id
 		FROM playlists p
 		JOIN users u ON u.id = p.user_id
-		WHERE p.public = 1 OR p.user_id = ?
+		WHERE `+db.PlaylistVisible("p", user.ID)+`
 		ORDER BY p.created_at DESC
 		LIMIT ? OFFSET ?
-	`, user.ID, limit, offset)
+	`, limit, offset)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_LIST_FAILED"})
 	}
@@ -52,11 +170,12 @@ func (h *Handler) ListPlaylists(c echo.Context) error {
 		var name, desc string
 		var coverPath *string
 		var pub bool
+		var rules sql.NullString
 		var created string
-		var owner string
+		var owner, role string
 		var songCount int
 		var firstSongID *int64
-		if err := rows.Scan(&id, &uid, &name, &desc, &coverPath, &pub, &created, &owner, &songCount, &firstSongID); err == nil {
+		if err := rows.Scan(&id, &uid, &name, &desc, &coverPath, &pub, &rules, &created, &owner, &role, &songCount, &firstSongID); err == nil {
 			item := map[string]any{
 				"id":          id,
 				"user_id":     uid,
@@ -66,6 +185,8 @@ func (h *Handler) ListPlaylists(c echo.Context) error {
 				"created_at":  created,
 				"owner":       map[string]any{"id": uid, "username": owner},
 				"song_count":  songCount,
+				"smart":       rules.Valid,
+				"role":        role,
 			}
 			if coverPath != nil && *coverPath != "" {
 				item["cover_path"] = *coverPath
@@ -80,6 +201,7 @@ func (h *Handler) ListPlaylists(c echo.Context) error {
 
 // CreatePlaylist godoc
 // @Summary Create playlist
+// @Description Passing a rules document creates a smart playlist whose songs are re-evaluated after each scan and play
 // @Tags Playlists
 // @Accept json
 // @Produce json
@@ -96,19 +218,30 @@ func (h *Handler) CreatePlaylist(c echo.Context) error {
 	if err := c.Validate(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
 	}
+	rules, err := encodeRules(req.Rules)
+	if err != nil {
+		return err
+	}
 	res, err := h.db.ExecContext(c.Request().Context(), `
-		INSERT INTO playlists(user_id, name, description, public) VALUES(?, ?, ?, ?)
-	`, user.ID, req.Name, req.Description, req.Public)
+		INSERT INTO playlists(user_id, name, description, public, rules) VALUES(?, ?, ?, ?, ?)
+	`, user.ID, req.Name, req.Description, req.Public, rules)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_CREATE_FAILED"})
 	}
 	id, _ := res.LastInsertId()
+	if err := db.RefreshSmartPlaylist(c.Request().Context(), h.db, id); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_CREATE_FAILED"})
+	}
+	h.logActivity(c, id, "created", 0, req.Name)
+	h.syncPlaylistFile(c, id)
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"id":          id,
 		"user_id":     user.ID,
 		"name":        req.Name,
 		"description": req.Description,
 		"public":      req.Public,
+		"smart":       rules.Valid,
+		"rules":       req.Rules,
 	})
 }
 
@@ -129,20 +262,23 @@ func (h *Handler) GetPlaylist(c echo.Context) error {
 	var ownerID int64
 	var coverPath *string
 	var pub bool
+	var rules sql.NullString
 	err := h.db.QueryRowContext(c.Request().Context(), `
-		SELECT p.id, p.user_id, p.name, p.description, p.cover_path, p.public, u.username
+		SELECT p.id, p.user_id, p.name, p.description, p.cover_path, p.public, p.rules, u.username
 		FROM playlists p
 		JOIN users u ON u.id = p.user_id
 		WHERE p.id = ?
-	`, id).Scan(&id, &ownerID, &name, &desc, &coverPath, &pub, &ownerUsername)
+	`, id).Scan(&id, &ownerID, &name, &desc, &coverPath, &pub, &rules, &ownerUsername)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
 	}
-	if !pub && ownerID != user.ID {
-		return echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "forbidden", "code": "FORBIDDEN"})
+	role, _, err := h.playlistRole(c, id, db.PlaylistViewer)
+	if err != nil {
+		return err
 	}
-	songs, _ := db.GetSongsByPlaylist(c.Request().Context(), h.db, id)
+	songs, _ := db.GetSongsByPlaylist(c.Request().Context(), h.db, db.UserAccess(user), id)
 	_ = db.PopulateSongArtists(c.Request().Context(), h.db, songs)
+	entries, _ := db.GetPlaylistEntries(c.Request().Context(), h.db, db.UserAccess(user), id)
 
 	result := map[string]any{
 		"id":          id,
@@ -151,7 +287,13 @@ func (h *Handler) GetPlaylist(c echo.Context) error {
 		"description": desc,
 		"public":      pub,
 		"songs":       songs,
+		"entries":     entries,
 		"owner":       map[string]interface{}{"id": ownerID, "username": ownerUsername},
+		"smart":       rules.Valid,
+		"role":        role,
+	}
+	if rules.Valid {
+		result["rules"] = decodeRules(rules)
 	}
 
 	if coverPath != nil && *coverPath != "" {
@@ -165,45 +307,67 @@ func (h *Handler) GetPlaylist(c echo.Context) error {
 
 // UpdatePlaylist godoc
 // @Summary Update playlist
+// @Description Owners and editors can rename a playlist. Only the owner can change public and rules; omitting rules keeps them, and "rules": null turns a smart playlist into a regular one that keeps its current songs
 // @Tags Playlists
 // @Accept json
 // @Produce json
 // @Param id path int true "Playlist ID"
-// @Param body body playlistRequest true "playlist"
+// @Param body body playlistUpdateRequest true "playlist"
 // @Success 200 {object} map[string]interface{}
 // @Failure 404 {object} map[string]string
 // @Failure 403 {object} map[string]string
 // @Router /playlists/{id} [put]
 // @Security BearerAuth
 func (h *Handler) UpdatePlaylist(c echo.Context) error {
-	user, _ := currentUser(c)
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
-	var owner int64
-	if err := h.db.QueryRowContext(c.Request().Context(), `SELECT user_id FROM playlists WHERE id = ?`, id).Scan(&owner); err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
-	}
-	if owner != user.ID {
-		return echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "forbidden", "code": "FORBIDDEN"})
+	role, _, err := h.playlistRole(c, id, db.PlaylistEditor)
+	if err != nil {
+		return err
 	}
-	var req playlistRequest
+	var req playlistUpdateRequest
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
 	}
 	if err := c.Validate(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": err.Error(), "code": "VALIDATION_ERROR"})
 	}
-	_, err := h.db.ExecContext(c.Request().Context(), `
-		UPDATE playlists SET name = ?, description = ?, public = ? WHERE id = ?
-	`, req.Name, req.Description, req.Public, id)
-	if err != nil {
+	ctx := c.Request().Context()
+	if role == db.PlaylistOwner {
+		_, err = h.db.ExecContext(ctx, `UPDATE playlists SET name = ?, description = ?, public = ? WHERE id = ?`, req.Name, req.Description, req.Public, id)
+		if err == nil && req.Rules.Set {
+			var rules sql.NullString
+			if rules, err = encodeRules(req.Rules.Rules); err != nil {
+				return err
+			}
+			_, err = h.db.ExecContext(ctx, `UPDATE playlists SET rules = ? WHERE id = ?`, rules, id)
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_UPDATE_FAILED"})
+		}
+	} else {
+		_, err = h.db.ExecContext(ctx, `UPDATE playlists SET name = ?, description = ? WHERE id = ?`, req.Name, req.Description, id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_UPDATE_FAILED"})
+		}
+	}
+	if err := db.RefreshSmartPlaylist(ctx, h.db, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_UPDATE_FAILED"})
 	}
+	h.logActivity(c, id, "updated", 0, req.Name)
+	h.syncPlaylistFile(c, id)
+
+	var owner int64
+	var pub bool
+	var rules sql.NullString
+	_ = h.db.QueryRowContext(ctx, `SELECT user_id, public, rules FROM playlists WHERE id = ?`, id).Scan(&owner, &pub, &rules)
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"id":          id,
-		"user_id":     user.ID,
+		"user_id":     owner,
 		"name":        req.Name,
 		"description": req.Description,
-		"public":      req.Public,
+		"public":      pub,
+		"smart":       rules.Valid,
+		"rules":       decodeRules(rules),
 	})
 }
 
@@ -269,6 +433,7 @@ func (h *Handler) UploadPlaylistCover(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": "failed to update playlist", "code": "INTERNAL_ERROR"})
 	}
+	h.publishPlaylist(services.EventPlaylistUpdated, id)
 
 	return c.JSON(http.StatusOK, map[string]string{"cover_path": destPath})
 }
@@ -315,15 +480,28 @@ func (h *Handler) DeletePlaylist(c echo.Context) error {
 	user, _ := currentUser(c)
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	var owner int64
-	if err := h.db.QueryRowContext(c.Request().Context(), `SELECT user_id FROM playlists WHERE id = ?`, id).Scan(&owner); err != nil {
+	var public bool
+	if err := h.db.QueryRowContext(c.Request().Context(), `SELECT user_id, public FROM playlists WHERE id = ?`, id).Scan(&owner, &public); err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
 	}
 	if owner != user.ID {
 		return echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "forbidden", "code": "FORBIDDEN"})
 	}
+	// Once it's gone nobody can see the playlist, so its audience is
+	// collected first: everyone for a public playlist, else owner and members
+	var audience []int64
+	if !public {
+		audience = []int64{owner}
+		members, _ := db.GetPlaylistMembers(c.Request().Context(), h.db, id)
+		for _, m := range members {
+			audience = append(audience, m.UserID)
+		}
+	}
+	h.scanner.RemovePlaylistMirror(c.Request().Context(), id)
 	if _, err := h.db.ExecContext(c.Request().Context(), `DELETE FROM playlists WHERE id = ?`, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_DELETE_FAILED"})
 	}
+	h.events.Publish(services.Event{Type: services.EventPlaylistDeleted, Data: services.PlaylistEvent{ID: id}, UserIDs: audience})
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
@@ -337,17 +515,13 @@ func (h *Handler) DeletePlaylist(c echo.Context) error {
 // @Success 200 {object} map[string]bool
 // @Failure 404 {object} map[string]string
 // @Failure 403 {object} map[string]string
+// @Failure 400 {object} map[string]string
 // @Router /playlists/{id}/songs [post]
 // @Security BearerAuth
 func (h *Handler) AddPlaylistSong(c echo.Context) error {
-	user, _ := currentUser(c)
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
-	var owner int64
-	if err := h.db.QueryRowContext(c.Request().Context(), `SELECT user_id FROM playlists WHERE id = ?`, id).Scan(&owner); err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
-	}
-	if owner != user.ID {
-		return echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "forbidden", "code": "FORBIDDEN"})
+	if err := h.editablePlaylist(c, id); err != nil {
+		return err
 	}
 	var payload struct {
 		SongID   int64 `json:"song_id" validate:"required"`
@@ -365,11 +539,14 @@ func (h *Handler) AddPlaylistSong(c echo.Context) error {
 	if payload.Position == 0 {
 		payload.Position = int(time.Now().Unix())
 	}
+	user, _ := currentUser(c)
 	if _, err := h.db.ExecContext(c.Request().Context(), `
-		INSERT OR REPLACE INTO playlist_songs(playlist_id, song_id, position) VALUES(?, ?, ?)
-	`, id, payload.SongID, payload.Position); err != nil {
+		INSERT OR REPLACE INTO playlist_songs(playlist_id, song_id, position, added_by, added_at) VALUES(?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, id, payload.SongID, payload.Position, user.ID); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_ADD_FAILED"})
 	}
+	h.logActivity(c, id, "song_added", payload.SongID, h.songTitle(c, payload.SongID))
+	h.syncPlaylistFile(c, id)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
@@ -382,22 +559,23 @@ func (h *Handler) AddPlaylistSong(c echo.Context) error {
 // @Success 200 {object} map[string]bool
 // @Failure 404 {object} map[string]string
 // @Failure 403 {object} map[string]string
+// @Failure 400 {object} map[string]string
 // @Router /playlists/{id}/songs/{song_id} [delete]
 // @Security BearerAuth
 func (h *Handler) DeletePlaylistSong(c echo.Context) error {
-	user, _ := currentUser(c)
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
 	songID, _ := strconv.ParseInt(c.Param("song_id"), 10, 64)
-	var owner int64
-	if err := h.db.QueryRowContext(c.Request().Context(), `SELECT user_id FROM playlists WHERE id = ?`, id).Scan(&owner); err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
-	}
-	if owner != user.ID {
-		return echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "forbidden", "code": "FORBIDDEN"})
+	if err := h.editablePlaylist(c, id); err != nil {
+		return err
 	}
-	if _, err := h.db.ExecContext(c.Request().Context(), `DELETE FROM playlist_songs WHERE playlist_id = ? AND song_id = ?`, id, songID); err != nil {
+	res, err := h.db.ExecContext(c.Request().Context(), `DELETE FROM playlist_songs WHERE playlist_id = ? AND song_id = ?`, id, songID)
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "PLAYLIST_REMOVE_FAILED"})
 	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		h.logActivity(c, id, "song_removed", songID, h.songTitle(c, songID))
+		h.syncPlaylistFile(c, id)
+	}
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
 
@@ -411,17 +589,13 @@ func (h *Handler) DeletePlaylistSong(c echo.Context) error {
 // @Success 200 {object} map[string]bool
 // @Failure 404 {object} map[string]string
 // @Failure 403 {object} map[string]string
+// @Failure 400 {object} map[string]string
 // @Router /playlists/{id}/reorder [put]
 // @Security BearerAuth
 func (h *Handler) ReorderPlaylistSongs(c echo.Context) error {
-	user, _ := currentUser(c)
 	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
-	var owner int64
-	if err := h.db.QueryRowContext(c.Request().Context(), `SELECT user_id FROM playlists WHERE id = ?`, id).Scan(&owner); err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "playlist not found", "code": "NOT_FOUND"})
-	}
-	if owner != user.ID {
-		return echo.NewHTTPError(http.StatusForbidden, map[string]string{"error": "forbidden", "code": "FORBIDDEN"})
+	if err := h.editablePlaylist(c, id); err != nil {
+		return err
 	}
 	var payload struct {
 		SongIDs []int64 `json:"song_ids" validate:"required"`
@@ -451,5 +625,14 @@ func (h *Handler) ReorderPlaylistSongs(c echo.Context) error {
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "REORDER_FAILED"})
 	}
+	h.logActivity(c, id, "reordered", 0, "")
+	h.syncPlaylistFile(c, id)
 	return c.JSON(http.StatusOK, map[string]bool{"success": true})
 }
+
+// songTitle returns a song's title for the activity log
+func (h *Handler) songTitle(c echo.Context, songID int64) string {
+	var title string
+	_ = h.db.QueryRowContext(c.Request().Context(), `SELECT title FROM songs WHERE id = ?`, songID).Scan(&title)
+	return title
+}
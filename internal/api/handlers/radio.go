@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"net/http"
 
+	"github.com/Aunali321/korus/internal/db"
 	"github.com/Aunali321/korus/internal/models"
 	"github.com/Aunali321/korus/internal/services"
 	"github.com/labstack/echo/v4"
@@ -38,6 +39,7 @@ func (h *Handler) Radio(c echo.Context) error {
 	mode := c.QueryParam("mode")
 
 	ctx := c.Request().Context()
+	acc := access(c)
 
 	// Try LLM-based recommendations if available
 	if h.radio != nil {
@@ -47,7 +49,7 @@ func (h *Handler) Radio(c echo.Context) error {
 		}
 		ids, err := h.radio.GetRecommendations(ctx, songID, limit, radioMode)
 		if err == nil && len(ids) > 0 {
-			return h.getSongsByIDs(c, ids)
+			return h.getSongsByIDs(c, acc, ids)
 		}
 		// Fall through to metadata-based if LLM fails
 	}
@@ -68,16 +70,17 @@ func (h *Handler) Radio(c echo.Context) error {
 		       s.album_id, al.year
 		FROM songs s
 		JOIN albums al ON s.album_id = al.id
-		WHERE s.id = ?
-	`, songID).Scan(&artistID, &albumID, &year)
+		WHERE s.id = ? AND `+acc.Filter("s"), songID).Scan(&artistID, &albumID, &year)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "song not found", "code": "NOT_FOUND"})
 	}
 
-	return h.radioByMetadata(c, songID, artistID, albumID, year, limit)
+	return h.radioByMetadata(c, acc, songID, artistID, albumID, year, limit)
 }
 
-func (h *Handler) getSongsByIDs(c echo.Context, ids []int64) error {
+// getSongsByIDs returns the given songs in order, leaving out any that are
+// not readable with acc.
+func (h *Handler) getSongsByIDs(c echo.Context, acc db.Access, ids []int64) error {
 	ctx := c.Request().Context()
 
 	songs := make([]models.Song, 0, len(ids))
@@ -99,8 +102,7 @@ func (h *Handler) getSongsByIDs(c echo.Context, ids []int64) error {
 			JOIN albums al ON s.album_id = al.id
 			LEFT JOIN song_artists sa ON sa.song_id = s.id AND sa.role = 'primary'
 			LEFT JOIN artists ar ON ar.id = sa.artist_id
-			WHERE s.id = ?
-		`, id).Scan(
+			WHERE s.id = ? AND `+acc.Filter("s"), id).Scan(
 			&s.ID, &s.AlbumID, &s.Title, &trackNum, &duration,
 			&s.FilePath, &lyrics, &lyricsSynced, &mbid,
 			&artistID, &artistName, &al.ID, &al.Title, &year, &coverPath,
@@ -145,7 +147,7 @@ func (h *Handler) getSongsByIDs(c echo.Context, ids []int64) error {
 	return c.JSON(http.StatusOK, map[string]any{"songs": songs})
 }
 
-func (h *Handler) radioByMetadata(c echo.Context, seedID int64, artistID sql.NullInt64, albumID int64, year sql.NullInt64, limit int) error {
+func (h *Handler) radioByMetadata(c echo.Context, acc db.Access, seedID int64, artistID sql.NullInt64, albumID int64, year sql.NullInt64, limit int) error {
 	ctx := c.Request().Context()
 
 	// Score on song-level artist match so compilation tracks can match seeds
@@ -168,7 +170,7 @@ func (h *Handler) radioByMetadata(c echo.Context, seedID int64, artistID sql.Nul
 			WHERE song_id = s.id AND role = 'primary'
 			ORDER BY position LIMIT 1
 		)
-		WHERE s.id != ?
+		WHERE s.id != ? AND ` + acc.Filter("s") + `
 		ORDER BY score DESC, RANDOM()
 		LIMIT ?
 	`
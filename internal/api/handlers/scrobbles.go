@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// scrobble queues a finished play for ListenBrainz and Last.fm. The play is
+// already recorded, so failures are only logged.
+func (h *Handler) scrobble(c echo.Context, songID int64, startedAt time.Time, listened int) {
+	if h.scrobbles == nil {
+		return
+	}
+	user, _ := currentUser(c)
+	if err := h.scrobbles.Enqueue(c.Request().Context(), user.ID, songID, startedAt, listened); err != nil {
+		log.Printf("queue scrobble for user %d: %v", user.ID, err)
+	}
+}
+
+func (h *Handler) scrobblesEnabled() error {
+	if h.scrobbles == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, map[string]string{"error": "scrobbling disabled", "code": "SCROBBLING_DISABLED"})
+	}
+	return nil
+}
+
+// ListScrobbles godoc
+// @Summary List queued scrobbles
+// @Description Returns the user's listens still waiting to be submitted to ListenBrainz or Last.fm, and those that failed for good
+// @Tags History
+// @Produce json
+// @Success 200 {array} models.Scrobble
+// @Failure 503 {object} map[string]string
+// @Router /scrobbles [get]
+// @Security BearerAuth
+func (h *Handler) ListScrobbles(c echo.Context) error {
+	if err := h.scrobblesEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	scrobbles, err := h.scrobbles.Outbox(c.Request().Context(), user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, scrobbles)
+}
+
+// ReplayScrobbles godoc
+// @Summary Replay scrobbles
+// @Description Retries the given queued or failed scrobbles right away, or all of the user's when no IDs are given
+// @Tags History
+// @Accept json
+// @Produce json
+// @Param body body map[string][]int64 false "ids"
+// @Success 200 {object} map[string]int64
+// @Failure 503 {object} map[string]string
+// @Router /scrobbles/replay [post]
+// @Security BearerAuth
+func (h *Handler) ReplayScrobbles(c echo.Context) error {
+	if err := h.scrobblesEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	var payload struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, map[string]string{"error": "invalid payload", "code": "BAD_REQUEST"})
+	}
+	n, err := h.scrobbles.Replay(c.Request().Context(), user.ID, payload.IDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, map[string]int64{"replayed": n})
+}
+
+// DeleteScrobble godoc
+// @Summary Discard scrobble
+// @Description Removes a queued or failed scrobble without submitting it
+// @Tags History
+// @Produce json
+// @Param id path int true "Scrobble ID"
+// @Success 200 {object} map[string]bool
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /scrobbles/{id} [delete]
+// @Security BearerAuth
+func (h *Handler) DeleteScrobble(c echo.Context) error {
+	if err := h.scrobblesEnabled(); err != nil {
+		return err
+	}
+	user, _ := currentUser(c)
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err := h.scrobbles.Discard(c.Request().Context(), user.ID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "scrobble not found", "code": "NOT_FOUND"})
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "DB_ERROR"})
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"success": true})
+}
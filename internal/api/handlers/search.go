@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 )
@@ -11,6 +12,7 @@ import (
 // @Tags Search
 // @Produce json
 // @Param q query string true "query"
+// @Param genre query int false "only return songs in this genre"
 // @Param limit query int false "max items (default 25, max 200)"
 // @Param offset query int false "offset"
 // @Success 200 {object} map[string]interface{}
@@ -18,8 +20,9 @@ import (
 // @Security BearerAuth
 func (h *Handler) Search(c echo.Context) error {
 	q := c.QueryParam("q")
+	genreID, _ := strconv.ParseInt(c.QueryParam("genre"), 10, 64)
 	limit, offset := parseLimitOffset(c, 25, 200)
-	res, err := h.search.Search(c.Request().Context(), q, limit, offset)
+	res, err := h.search.Search(c.Request().Context(), access(c), q, genreID, limit, offset)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, map[string]string{"error": err.Error(), "code": "SEARCH_FAILED"})
 	}
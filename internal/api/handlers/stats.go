@@ -26,11 +26,12 @@ func (h *Handler) Stats(c echo.Context) error {
 	ctx := c.Request().Context()
 
 	overview := h.overview(ctx, user.ID, start, end)
+	acc := db.UserAccess(user)
 
-	topSongs := h.rankSongs(ctx, user.ID, start, end, 10)
-	topArtists := h.rankArtists(ctx, user.ID, start, end, 10)
-	topAlbums := h.rankAlbums(ctx, user.ID, start, end, 10)
-	topGenres := h.rankGenres(ctx, user.ID, start, end, 5)
+	topSongs := h.rankSongs(ctx, acc, user.ID, start, end, 10)
+	topArtists := h.rankArtists(ctx, acc, user.ID, start, end, 10)
+	topAlbums := h.rankAlbums(ctx, acc, user.ID, start, end, 10)
+	topGenres := h.rankGenres(ctx, acc, user.ID, start, end, 5)
 	patterns := h.listeningPatterns(ctx, user.ID, start, end)
 	discovery := h.discoveryStats(ctx, user.ID, start, end, overview)
 
@@ -63,6 +64,7 @@ func (h *Handler) Wrapped(c echo.Context) error {
 	start, end := resolvePeriod(c.QueryParam("period"))
 	ctx := c.Request().Context()
 	overview := h.overview(ctx, user.ID, start, end)
+	acc := db.UserAccess(user)
 
 	startStr := start.Format(time.RFC3339)
 	endStr := end.Format(time.RFC3339)
@@ -82,7 +84,7 @@ func (h *Handler) Wrapped(c echo.Context) error {
 			WHERE song_id = s.id AND role = 'primary'
 			ORDER BY position LIMIT 1
 		)
-		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ?
+		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ? AND `+acc.Filter("s")+`
 		GROUP BY s.id
 		ORDER BY plays DESC
 		LIMIT 5
@@ -115,9 +117,10 @@ func (h *Handler) Wrapped(c echo.Context) error {
 	artistRows, err := h.db.QueryContext(ctx, `
 		SELECT ar.id, ar.name, COUNT(*) as plays
 		FROM play_history ph
-		JOIN song_artists sa ON sa.song_id = ph.song_id AND sa.role = 'primary'
+		JOIN songs s ON s.id = ph.song_id
+		JOIN song_artists sa ON sa.song_id = s.id AND sa.role = 'primary'
 		JOIN artists ar ON ar.id = sa.artist_id
-		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ?
+		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ? AND `+acc.Filter("s")+`
 		GROUP BY ar.id
 		ORDER BY plays DESC
 		LIMIT 5
@@ -147,7 +150,7 @@ func (h *Handler) Wrapped(c echo.Context) error {
 		JOIN songs s ON s.id = ph.song_id
 		JOIN albums al ON al.id = s.album_id
 		LEFT JOIN artists ar ON ar.id = al.artist_id
-		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ?
+		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ? AND `+acc.Filter("s")+`
 		GROUP BY al.id
 		ORDER BY plays DESC
 		LIMIT 5
@@ -174,6 +177,8 @@ func (h *Handler) Wrapped(c echo.Context) error {
 		}
 	}
 
+	topGenres := h.rankGenres(ctx, acc, user.ID, start, end, 5)
+
 	totalTime, _ := overview["total_time"].(int64)
 	totalMinutes := totalTime / 60
 	totalPlays, _ := overview["total_plays"].(int64)
@@ -197,6 +202,7 @@ func (h *Handler) Wrapped(c echo.Context) error {
 		"top_songs":         topSongs,
 		"top_artists":       topArtists,
 		"top_albums":        topAlbums,
+		"top_genres":        topGenres,
 		"total_minutes":     totalMinutes,
 		"total_plays":       totalPlays,
 		"days_listened":     daysListened,
@@ -236,11 +242,12 @@ func (h *Handler) Insights(c echo.Context) error {
 func (h *Handler) Home(c echo.Context) error {
 	user, _ := currentUser(c)
 	ctx := c.Request().Context()
-	recent, _ := db.GetSongsByRecentPlays(ctx, h.db, user.ID, 27)
+	acc := db.UserAccess(user)
+	recent, _ := db.GetSongsByRecentPlays(ctx, h.db, acc, user.ID, 27)
 	_ = db.PopulateSongArtists(ctx, h.db, recent)
-	recommended, _ := db.GetSongsByTopPlayed(ctx, h.db, user.ID, 5)
+	recommended, _ := db.GetSongsByTopPlayed(ctx, h.db, acc, user.ID, 5)
 	_ = db.PopulateSongArtists(ctx, h.db, recommended)
-	newAdditions, _ := h.fetchAlbums(ctx, 10)
+	newAdditions, _ := h.fetchAlbums(ctx, acc, 10)
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"recent_plays":    recent,
 		"recommendations": recommended,
@@ -275,7 +282,7 @@ func resolvePeriod(period string) (time.Time, time.Time) {
 	}
 }
 
-func (h *Handler) rankSongs(ctx context.Context, userID int64, start, end time.Time, limit int) []map[string]interface{} {
+func (h *Handler) rankSongs(ctx context.Context, acc db.Access, userID int64, start, end time.Time, limit int) []map[string]interface{} {
 	// Song's primary artist comes from song_artists (per-song truth), not
 	// albums.artist_id. Inner subselect picks the lowest-position primary so
 	// a song with multiple primary rows (rare) contributes one row per play.
@@ -290,7 +297,7 @@ func (h *Handler) rankSongs(ctx context.Context, userID int64, start, end time.T
 			WHERE song_id = s.id AND role = 'primary'
 			ORDER BY position LIMIT 1
 		)
-		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ?
+		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ? AND `+acc.Filter("s")+`
 		GROUP BY s.id, s.title, s.album_id, s.duration_ms, ar.id, ar.name
 		ORDER BY plays DESC
 		LIMIT ?
@@ -374,14 +381,14 @@ func (h *Handler) recentPlays(ctx context.Context, userID int64, limit int) ([]m
 	return res, nil
 }
 
-func (h *Handler) rankArtists(ctx context.Context, userID int64, start, end time.Time, limit int) []map[string]interface{} {
+func (h *Handler) rankArtists(ctx context.Context, acc db.Access, userID int64, start, end time.Time, limit int) []map[string]interface{} {
 	rows, err := h.db.QueryContext(ctx, `
 		SELECT a.id, a.name, a.image_path, COUNT(*) as plays, COALESCE(SUM(ph.duration_listened),0) as total_time, COUNT(DISTINCT s.id) as songs
 		FROM play_history ph
 		JOIN songs s ON s.id = ph.song_id
 		JOIN song_artists sa ON sa.song_id = s.id AND sa.role = 'primary'
 		JOIN artists a ON a.id = sa.artist_id
-		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ?
+		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ? AND `+acc.Filter("s")+`
 		GROUP BY a.id, a.name, a.image_path
 		ORDER BY plays DESC
 		LIMIT ?
@@ -412,7 +419,7 @@ func (h *Handler) rankArtists(ctx context.Context, userID int64, start, end time
 	return res
 }
 
-func (h *Handler) rankAlbums(ctx context.Context, userID int64, start, end time.Time, limit int) []map[string]interface{} {
+func (h *Handler) rankAlbums(ctx context.Context, acc db.Access, userID int64, start, end time.Time, limit int) []map[string]interface{} {
 	// LEFT JOIN so compilations (artist_id IS NULL) still appear in top albums.
 	rows, err := h.db.QueryContext(ctx, `
 		SELECT al.id, al.title, al.artist_id, ar.id, ar.name,
@@ -421,7 +428,7 @@ func (h *Handler) rankAlbums(ctx context.Context, userID int64, start, end time.
 		JOIN songs s ON s.id = ph.song_id
 		JOIN albums al ON al.id = s.album_id
 		LEFT JOIN artists ar ON ar.id = al.artist_id
-		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ?
+		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ? AND `+acc.Filter("s")+`
 		GROUP BY al.id, al.title, al.artist_id, ar.id, ar.name
 		ORDER BY plays DESC
 		LIMIT ?
@@ -463,9 +470,50 @@ func (h *Handler) rankAlbums(ctx context.Context, userID int64, start, end time.
 	return res
 }
 
-func (h *Handler) rankGenres(ctx context.Context, userID int64, start, end time.Time, limit int) []map[string]interface{} {
-	// Genres not modeled; return empty placeholder
-	return []map[string]interface{}{}
+// rankGenres ranks genres by plays in the period. A play of a song with
+// several genres counts toward each of them; percentage is relative to all
+// plays of readable songs in the period, so it can add up to more than 100.
+func (h *Handler) rankGenres(ctx context.Context, acc db.Access, userID int64, start, end time.Time, limit int) []map[string]interface{} {
+	startStr, endStr := start.Format(time.RFC3339), end.Format(time.RFC3339)
+	var totalPlays int64
+	_ = h.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM play_history ph
+		JOIN songs s ON s.id = ph.song_id
+		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ? AND `+acc.Filter("s")+`
+	`, userID, startStr, endStr).Scan(&totalPlays)
+
+	res := []map[string]interface{}{}
+	if totalPlays == 0 {
+		return res
+	}
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT g.id, g.name, COUNT(*) as plays, COALESCE(SUM(ph.duration_listened),0) as total_time
+		FROM play_history ph
+		JOIN songs s ON s.id = ph.song_id
+		JOIN song_genres sg ON sg.song_id = ph.song_id
+		JOIN genres g ON g.id = sg.genre_id
+		WHERE ph.user_id = ? AND ph.played_at BETWEEN ? AND ? AND `+acc.Filter("s")+`
+		GROUP BY g.id, g.name
+		ORDER BY plays DESC
+		LIMIT ?
+	`, userID, startStr, endStr, limit)
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, plays, totalTime int64
+		var name string
+		if err := rows.Scan(&id, &name, &plays, &totalTime); err == nil {
+			res = append(res, map[string]interface{}{
+				"genre":      map[string]interface{}{"id": id, "name": name},
+				"play_count": plays,
+				"total_time": totalTime,
+				"percentage": float64(plays) * 100 / float64(totalPlays),
+			})
+		}
+	}
+	return res
 }
 
 func (h *Handler) listeningPatterns(ctx context.Context, userID int64, start, end time.Time) map[string][]map[string]interface{} {
@@ -0,0 +1,1033 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
+	"github.com/Aunali321/korus/internal/services"
+)
+
+const (
+	subsonicAPIVersion = "1.16.1"
+	subsonicServerType = "korus"
+	subsonicServerVer  = "0.1"
+	subsonicXMLNS      = "http://subsonic.org/restapi"
+)
+
+// Subsonic error codes, see http://www.subsonic.org/pages/api.jsp.
+const (
+	subsonicErrGeneric        = 0
+	subsonicErrMissingParam   = 10
+	subsonicErrBadCredentials = 40
+	subsonicErrNotFound       = 70
+)
+
+// SubsonicHandler serves the Subsonic/OpenSubsonic API under /rest so that
+// third-party clients (Symfonium, DSub, ...) can talk to Korus. It reads the
+// same tables as the native API and reuses HLSHandler for media delivery.
+type SubsonicHandler struct {
+	db  *sql.DB
+	h   *Handler
+	hls *HLSHandler
+}
+
+func NewSubsonicHandler(h *Handler, hlsHandler *HLSHandler) *SubsonicHandler {
+	return &SubsonicHandler{
+		db:  h.db,
+		h:   h,
+		hls: hlsHandler,
+	}
+}
+
+// Auth authenticates Subsonic requests from the u/p or u/t/s parameters and
+// stores the user in the context like middleware.Auth does.
+func (s *SubsonicHandler) Auth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		username := c.FormValue("u")
+		password := c.FormValue("p")
+		token := c.FormValue("t")
+		salt := c.FormValue("s")
+		if username == "" || (password == "" && (token == "" || salt == "")) {
+			return s.fail(c, subsonicErrMissingParam, "required parameter is missing")
+		}
+		if strings.HasPrefix(password, "enc:") {
+			decoded, err := hex.DecodeString(strings.TrimPrefix(password, "enc:"))
+			if err != nil {
+				return s.fail(c, subsonicErrBadCredentials, "wrong username or password")
+			}
+			password = string(decoded)
+		}
+		user, err := s.h.auth.AuthenticateSubsonic(c.Request().Context(), username, password, token, salt)
+		if err != nil {
+			return s.fail(c, subsonicErrBadCredentials, "wrong username or password")
+		}
+		c.Set("user", user)
+		return next(c)
+	}
+}
+
+func (s *SubsonicHandler) newResponse() *subsonicResponse {
+	return &subsonicResponse{
+		Status:        "ok",
+		Version:       subsonicAPIVersion,
+		Type:          subsonicServerType,
+		ServerVersion: subsonicServerVer,
+		OpenSubsonic:  true,
+	}
+}
+
+func (s *SubsonicHandler) send(c echo.Context, resp *subsonicResponse) error {
+	if c.FormValue("f") == "json" {
+		return c.JSON(http.StatusOK, map[string]*subsonicResponse{"subsonic-response": resp})
+	}
+	resp.Xmlns = subsonicXMLNS
+	return c.XML(http.StatusOK, resp)
+}
+
+// fail reports an error the Subsonic way: HTTP 200 with status="failed".
+func (s *SubsonicHandler) fail(c echo.Context, code int, message string) error {
+	resp := s.newResponse()
+	resp.Status = "failed"
+	resp.Error = &subsonicError{Code: code, Message: message}
+	return s.send(c, resp)
+}
+
+func (s *SubsonicHandler) user(c echo.Context) models.User {
+	user, _ := currentUser(c)
+	return user
+}
+
+// Ping implements ping.
+func (s *SubsonicHandler) Ping(c echo.Context) error {
+	return s.send(c, s.newResponse())
+}
+
+// GetLicense implements getLicense. Korus has no licensing, so it is always valid.
+func (s *SubsonicHandler) GetLicense(c echo.Context) error {
+	resp := s.newResponse()
+	resp.License = &subsonicLicense{Valid: true}
+	return s.send(c, resp)
+}
+
+// GetOpenSubsonicExtensions implements getOpenSubsonicExtensions. The spec
+// requires it to be reachable without authentication.
+func (s *SubsonicHandler) GetOpenSubsonicExtensions(c echo.Context) error {
+	resp := s.newResponse()
+	resp.OpenSubsonicExtensions = []subsonicExtension{
+		{Name: "formPost", Versions: []int{1}},
+		{Name: "songLyrics", Versions: []int{1}},
+	}
+	return s.send(c, resp)
+}
+
+// GetMusicFolders implements getMusicFolders with the libraries the user can read.
+func (s *SubsonicHandler) GetMusicFolders(c echo.Context) error {
+	libraries, err := db.GetLibraries(c.Request().Context(), s.db, db.UserAccess(s.user(c)))
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load music folders")
+	}
+	folders := &subsonicMusicFolders{MusicFolder: []subsonicMusicFolder{}}
+	for _, l := range libraries {
+		folders.MusicFolder = append(folders.MusicFolder, subsonicMusicFolder{ID: l.ID, Name: l.Name})
+	}
+	resp := s.newResponse()
+	resp.MusicFolders = folders
+	return s.send(c, resp)
+}
+
+// GetGenres implements getGenres.
+func (s *SubsonicHandler) GetGenres(c echo.Context) error {
+	rows, err := s.db.QueryContext(c.Request().Context(), `
+		SELECT g.name, COUNT(DISTINCT sg.song_id), COUNT(DISTINCT s.album_id)
+		FROM genres g
+		JOIN song_genres sg ON sg.genre_id = g.id
+		JOIN songs s ON s.id = sg.song_id
+		WHERE `+db.UserAccess(s.user(c)).Filter("s")+`
+		GROUP BY g.id
+		ORDER BY g.name COLLATE NOCASE
+	`)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load genres")
+	}
+	defer rows.Close()
+
+	genres := &subsonicGenres{Genre: []subsonicGenre{}}
+	for rows.Next() {
+		var g subsonicGenre
+		if err := rows.Scan(&g.Value, &g.SongCount, &g.AlbumCount); err != nil {
+			return s.fail(c, subsonicErrGeneric, "failed to load genres")
+		}
+		genres.Genre = append(genres.Genre, g)
+	}
+	resp := s.newResponse()
+	resp.Genres = genres
+	return s.send(c, resp)
+}
+
+// GetArtists implements getArtists, grouping album artists by initial.
+func (s *SubsonicHandler) GetArtists(c echo.Context) error {
+	ctx := c.Request().Context()
+	artists, err := s.queryArtists(ctx, s.user(c), `HAVING COUNT(DISTINCT al.id) > 0 ORDER BY ar.name COLLATE NOCASE`)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load artists")
+	}
+
+	index := []subsonicIndex{}
+	for _, a := range artists {
+		key := subsonicIndexKey(a.Name)
+		if n := len(index); n == 0 || index[n-1].Name != key {
+			index = append(index, subsonicIndex{Name: key})
+		}
+		index[len(index)-1].Artist = append(index[len(index)-1].Artist, a)
+	}
+
+	resp := s.newResponse()
+	resp.Artists = &subsonicArtists{IgnoredArticles: "The A An", Index: index}
+	return s.send(c, resp)
+}
+
+// GetArtist implements getArtist.
+func (s *SubsonicHandler) GetArtist(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, ok := subsonicID(c.FormValue("id"), "ar-")
+	if !ok {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	user := s.user(c)
+
+	artists, err := s.queryArtists(ctx, user, `HAVING ar.id = ?`, id)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load artist")
+	}
+	if len(artists) == 0 {
+		return s.fail(c, subsonicErrNotFound, "artist not found")
+	}
+	albums, err := s.queryAlbums(ctx, user, `
+		WHERE al.artist_id = ? OR al.id IN (
+			SELECT s.album_id FROM songs s
+			JOIN song_artists sa ON sa.song_id = s.id AND sa.role = 'primary'
+			WHERE sa.artist_id = ?
+		)
+		ORDER BY al.year, al.title COLLATE NOCASE`, id, id)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load albums")
+	}
+
+	artist := artists[0]
+	artist.AlbumCount = len(albums)
+	resp := s.newResponse()
+	resp.Artist = &subsonicArtistWithAlbums{subsonicArtist: artist, Album: albums}
+	return s.send(c, resp)
+}
+
+// GetAlbum implements getAlbum.
+func (s *SubsonicHandler) GetAlbum(c echo.Context) error {
+	ctx := c.Request().Context()
+	id, ok := subsonicID(c.FormValue("id"), "al-")
+	if !ok {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	user := s.user(c)
+
+	albums, err := s.queryAlbums(ctx, user, `WHERE al.id = ?`, id)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load album")
+	}
+	if len(albums) == 0 {
+		return s.fail(c, subsonicErrNotFound, "album not found")
+	}
+	songs, err := s.querySongs(ctx, user, `WHERE s.album_id = ? ORDER BY COALESCE(s.disc_number, 1), s.track_number, s.title COLLATE NOCASE`, id)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load songs")
+	}
+	discTitles, err := s.discTitles(ctx, id)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load songs")
+	}
+
+	resp := s.newResponse()
+	resp.Album = &subsonicAlbumWithSongs{subsonicAlbum: albums[0], DiscTitles: discTitles, Song: songs}
+	return s.send(c, resp)
+}
+
+// discTitles returns the subtitles of an album's discs
+func (s *SubsonicHandler) discTitles(ctx context.Context, albumID int64) ([]subsonicDiscTitle, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT COALESCE(disc_number, 1), MIN(disc_subtitle) FROM songs
+		WHERE album_id = ? AND COALESCE(disc_subtitle, '') != ''
+		GROUP BY COALESCE(disc_number, 1) ORDER BY 1
+	`, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var titles []subsonicDiscTitle
+	for rows.Next() {
+		var t subsonicDiscTitle
+		if err := rows.Scan(&t.Disc, &t.Title); err != nil {
+			return nil, err
+		}
+		titles = append(titles, t)
+	}
+	return titles, rows.Err()
+}
+
+// GetSong implements getSong.
+func (s *SubsonicHandler) GetSong(c echo.Context) error {
+	id, ok := subsonicID(c.FormValue("id"), "")
+	if !ok {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	songs, err := s.querySongs(c.Request().Context(), s.user(c), `WHERE s.id = ?`, id)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load song")
+	}
+	if len(songs) == 0 {
+		return s.fail(c, subsonicErrNotFound, "song not found")
+	}
+	resp := s.newResponse()
+	resp.Song = &songs[0]
+	return s.send(c, resp)
+}
+
+// GetAlbumList2 implements getAlbumList2.
+func (s *SubsonicHandler) GetAlbumList2(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := s.user(c)
+	size := subsonicInt(c.FormValue("size"), 10, 500)
+	offset := subsonicInt(c.FormValue("offset"), 0, -1)
+
+	var tail string
+	args := []any{}
+	switch c.FormValue("type") {
+	case "random":
+		tail = `ORDER BY RANDOM()`
+	case "newest":
+		tail = `ORDER BY al.created_at DESC, al.id DESC`
+	case "alphabeticalByName":
+		tail = `ORDER BY al.title COLLATE NOCASE`
+	case "alphabeticalByArtist":
+		tail = `ORDER BY ar.name COLLATE NOCASE, al.title COLLATE NOCASE`
+	case "frequent":
+		tail = `WHERE al.id IN (SELECT s.album_id FROM play_history ph JOIN songs s ON s.id = ph.song_id WHERE ph.user_id = ?)
+			ORDER BY (SELECT COUNT(*) FROM play_history ph JOIN songs s ON s.id = ph.song_id WHERE ph.user_id = ? AND s.album_id = al.id) DESC`
+		args = append(args, user.ID, user.ID)
+	case "recent":
+		tail = `WHERE al.id IN (SELECT s.album_id FROM play_history ph JOIN songs s ON s.id = ph.song_id WHERE ph.user_id = ?)
+			ORDER BY (SELECT MAX(ph.played_at) FROM play_history ph JOIN songs s ON s.id = ph.song_id WHERE ph.user_id = ? AND s.album_id = al.id) DESC`
+		args = append(args, user.ID, user.ID)
+	case "starred":
+		tail = `WHERE fa.created_at IS NOT NULL ORDER BY fa.created_at DESC`
+	case "byYear":
+		from := subsonicInt(c.FormValue("fromYear"), 0, -1)
+		to := subsonicInt(c.FormValue("toYear"), 9999, -1)
+		if from <= to {
+			tail = `WHERE al.year BETWEEN ? AND ? ORDER BY al.year, al.title COLLATE NOCASE`
+			args = append(args, from, to)
+		} else {
+			tail = `WHERE al.year BETWEEN ? AND ? ORDER BY al.year DESC, al.title COLLATE NOCASE`
+			args = append(args, to, from)
+		}
+	case "byGenre":
+		tail = `WHERE al.id IN (
+				SELECT s.album_id FROM songs s
+				JOIN song_genres sg ON sg.song_id = s.id
+				JOIN genres g ON g.id = sg.genre_id
+				WHERE g.name = ?
+			)
+			ORDER BY al.title COLLATE NOCASE`
+		args = append(args, c.FormValue("genre"))
+	case "":
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: type")
+	default:
+		return s.fail(c, subsonicErrGeneric, "unknown list type")
+	}
+
+	albums, err := s.queryAlbums(ctx, user, tail+` LIMIT ? OFFSET ?`, append(args, size, offset)...)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load albums")
+	}
+	resp := s.newResponse()
+	resp.AlbumList2 = &subsonicAlbumList{Album: albums}
+	return s.send(c, resp)
+}
+
+// Search3 implements search3. An empty query lists everything, which is how
+// clients such as Symfonium sync the whole library.
+func (s *SubsonicHandler) Search3(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := s.user(c)
+	query := strings.Trim(strings.TrimSpace(c.FormValue("query")), `"`)
+	pattern := "%" + query + "%"
+
+	artistCount := subsonicInt(c.FormValue("artistCount"), 20, 500)
+	albumCount := subsonicInt(c.FormValue("albumCount"), 20, 500)
+	songCount := subsonicInt(c.FormValue("songCount"), 20, 500)
+
+	result := &subsonicSearchResult{}
+	var err error
+	if artistCount > 0 {
+		result.Artist, err = s.queryArtists(ctx, user, `HAVING ar.name LIKE ? ORDER BY ar.name COLLATE NOCASE LIMIT ? OFFSET ?`,
+			pattern, artistCount, subsonicInt(c.FormValue("artistOffset"), 0, -1))
+		if err != nil {
+			return s.fail(c, subsonicErrGeneric, "search failed")
+		}
+	}
+	if albumCount > 0 {
+		result.Album, err = s.queryAlbums(ctx, user, `WHERE al.title LIKE ? ORDER BY al.title COLLATE NOCASE LIMIT ? OFFSET ?`,
+			pattern, albumCount, subsonicInt(c.FormValue("albumOffset"), 0, -1))
+		if err != nil {
+			return s.fail(c, subsonicErrGeneric, "search failed")
+		}
+	}
+	if songCount > 0 {
+		result.Song, err = s.querySongs(ctx, user, `WHERE s.title LIKE ? ORDER BY s.id LIMIT ? OFFSET ?`,
+			pattern, songCount, subsonicInt(c.FormValue("songOffset"), 0, -1))
+		if err != nil {
+			return s.fail(c, subsonicErrGeneric, "search failed")
+		}
+	}
+
+	resp := s.newResponse()
+	resp.SearchResult3 = result
+	return s.send(c, resp)
+}
+
+// Stream implements stream. Without format or maxBitRate the original file
+// is served with Range support; otherwise the track is transcoded from
+// timeOffset seconds in.
+func (s *SubsonicHandler) Stream(c echo.Context) error {
+	id, ok := subsonicID(c.FormValue("id"), "")
+	if !ok {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	meta, err := s.hls.loadTrack(c, id)
+	if err != nil {
+		return s.fail(c, subsonicErrNotFound, "song not found")
+	}
+
+	format := c.FormValue("format")
+	maxBitRate := subsonicInt(c.FormValue("maxBitRate"), 0, -1)
+	if format == "raw" || (format == "" && maxBitRate == 0) {
+		return s.hls.sendOriginal(c, meta)
+	}
+	if format == "" {
+		format = "mp3"
+	}
+	bitrates, ok := s.hls.formats[format]
+	if !ok {
+		return s.hls.sendOriginal(c, meta)
+	}
+	return s.hls.sendTranscoded(c, meta, format, subsonicBitrate(bitrates, maxBitRate), "", subsonicInt(c.FormValue("timeOffset"), 0, -1)*1000)
+}
+
+// Download implements download, always serving the original file.
+func (s *SubsonicHandler) Download(c echo.Context) error {
+	id, ok := subsonicID(c.FormValue("id"), "")
+	if !ok {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	meta, err := s.hls.loadTrack(c, id)
+	if err != nil {
+		return s.fail(c, subsonicErrNotFound, "song not found")
+	}
+	return s.hls.sendAttachment(c, meta)
+}
+
+// GetCoverArt implements getCoverArt. Cover ids are prefixed by kind:
+// "al-" album, "ar-" artist, "pl-" playlist; bare ids are songs.
+func (s *SubsonicHandler) GetCoverArt(c echo.Context) error {
+	raw := c.FormValue("id")
+	if raw == "" {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	// Clients ask for any size; serve the smallest thumbnail at least that big
+	size := 0
+	if requested, _ := strconv.Atoi(c.FormValue("size")); requested > 0 {
+		for _, sz := range services.ArtworkSizes {
+			size = sz
+			if sz >= requested {
+				break
+			}
+		}
+	}
+	switch {
+	case strings.HasPrefix(raw, "al-"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(raw, "al-"), 10, 64)
+		return s.hls.sendArtwork(c, id, "album", size, "jpeg")
+	case strings.HasPrefix(raw, "ar-"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(raw, "ar-"), 10, 64)
+		return s.hls.sendArtistImage(c, id, size, "jpeg")
+	case strings.HasPrefix(raw, "pl-"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(raw, "pl-"), 10, 64)
+		var coverPath sql.NullString
+		err := s.db.QueryRowContext(c.Request().Context(), `SELECT p.cover_path FROM playlists p WHERE p.id = ? AND `+db.PlaylistVisible("p", s.user(c).ID), id).Scan(&coverPath)
+		if err != nil || coverPath.String == "" {
+			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "no cover", "code": "NOT_FOUND"})
+		}
+		if _, err := os.Stat(coverPath.String); err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, map[string]string{"error": "cover file not found", "code": "NOT_FOUND"})
+		}
+		return c.File(coverPath.String)
+	default:
+		id, _ := strconv.ParseInt(raw, 10, 64)
+		return s.hls.sendArtwork(c, id, "track", size, "jpeg")
+	}
+}
+
+// GetPlaylists implements getPlaylists: the user's own playlists plus shared and public ones.
+func (s *SubsonicHandler) GetPlaylists(c echo.Context) error {
+	user := s.user(c)
+	playlists, err := s.queryPlaylists(c.Request().Context(), `WHERE `+db.PlaylistVisible("p", user.ID)+` ORDER BY p.name COLLATE NOCASE`)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load playlists")
+	}
+	resp := s.newResponse()
+	resp.Playlists = &subsonicPlaylists{Playlist: playlists}
+	return s.send(c, resp)
+}
+
+// GetPlaylist implements getPlaylist.
+func (s *SubsonicHandler) GetPlaylist(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := s.user(c)
+	id, ok := subsonicID(c.FormValue("id"), "pl-")
+	if !ok {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	playlists, err := s.queryPlaylists(ctx, `WHERE p.id = ? AND `+db.PlaylistVisible("p", user.ID), id)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load playlist")
+	}
+	if len(playlists) == 0 {
+		return s.fail(c, subsonicErrNotFound, "playlist not found")
+	}
+	songs, err := s.querySongs(ctx, user, `
+		JOIN playlist_songs ps ON ps.song_id = s.id
+		WHERE ps.playlist_id = ?
+		ORDER BY ps.position`, id)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load playlist songs")
+	}
+	resp := s.newResponse()
+	resp.Playlist = &subsonicPlaylistEntries{subsonicPlaylist: playlists[0], Entry: songs}
+	return s.send(c, resp)
+}
+
+// Star implements star for any mix of id (songs), albumId and artistId.
+func (s *SubsonicHandler) Star(c echo.Context) error {
+	return s.setStarred(c, true)
+}
+
+// Unstar implements unstar.
+func (s *SubsonicHandler) Unstar(c echo.Context) error {
+	return s.setStarred(c, false)
+}
+
+// setStarred stars or unstars the given items. Only items in the user's
+// libraries can be starred; unstarring is always allowed, so favorites left
+// from a library the user lost access to can still be cleared.
+func (s *SubsonicHandler) setStarred(c echo.Context, starred bool) error {
+	ctx := c.Request().Context()
+	user := s.user(c)
+	acc := db.UserAccess(user)
+
+	targets := []struct {
+		param, prefix, table, column, kind, readable string
+	}{
+		{"id", "", "favorites_songs", "song_id", "song", `SELECT 1 FROM songs s WHERE s.id = ? AND ` + acc.Filter("s")},
+		{"albumId", "al-", "favorites_albums", "album_id", "album", `SELECT 1 FROM albums al WHERE al.id = ? AND ` + acc.Filter("al")},
+		{"artistId", "ar-", "follows_artists", "artist_id", "artist", `SELECT 1 FROM artists ar WHERE ar.id = ? AND ` + acc.ArtistFilter("ar")},
+	}
+	eventType := services.EventFavoriteRemoved
+	if starred {
+		eventType = services.EventFavoriteAdded
+	}
+
+	touched := false
+	for _, t := range targets {
+		for _, raw := range subsonicParams(c, t.param) {
+			id, ok := subsonicID(raw, t.prefix)
+			if !ok {
+				return s.fail(c, subsonicErrNotFound, "invalid id: "+raw)
+			}
+			var query string
+			if starred {
+				var found int
+				if err := s.db.QueryRowContext(ctx, t.readable, id).Scan(&found); err != nil {
+					return s.fail(c, subsonicErrNotFound, "item not found: "+raw)
+				}
+				query = fmt.Sprintf(`INSERT OR IGNORE INTO %s (user_id, %s) VALUES (?, ?)`, t.table, t.column)
+			} else {
+				query = fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND %s = ?`, t.table, t.column)
+			}
+			if _, err := s.db.ExecContext(ctx, query, user.ID, id); err != nil {
+				return s.fail(c, subsonicErrNotFound, "item not found: "+raw)
+			}
+			s.h.publishFavorite(user.ID, eventType, t.kind, id)
+			touched = true
+		}
+	}
+	if !touched {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	return s.send(c, s.newResponse())
+}
+
+// GetStarred2 implements getStarred2.
+func (s *SubsonicHandler) GetStarred2(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := s.user(c)
+
+	artists, err := s.queryArtists(ctx, user, `HAVING fa.created_at IS NOT NULL ORDER BY ar.name COLLATE NOCASE`)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load starred artists")
+	}
+	albums, err := s.queryAlbums(ctx, user, `WHERE fa.created_at IS NOT NULL ORDER BY fa.created_at DESC`)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load starred albums")
+	}
+	songs, err := s.querySongs(ctx, user, `WHERE fs.created_at IS NOT NULL ORDER BY fs.created_at DESC`)
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load starred songs")
+	}
+
+	resp := s.newResponse()
+	resp.Starred2 = &subsonicStarred{Artist: artists, Album: albums, Song: songs}
+	return s.send(c, resp)
+}
+
+// Scrobble implements scrobble. Submissions are recorded in play_history and
+// forwarded to ListenBrainz and Last.fm along with "now playing"
+// notifications.
+func (s *SubsonicHandler) Scrobble(c echo.Context) error {
+	ctx := c.Request().Context()
+	user := s.user(c)
+	readable := `FROM songs s WHERE s.id = ? AND ` + db.UserAccess(user).Filter("s")
+	ids := subsonicParams(c, "id")
+	if len(ids) == 0 {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	if c.FormValue("submission") == "false" {
+		if id, ok := subsonicID(ids[0], ""); ok {
+			var found int
+			if err := s.db.QueryRowContext(ctx, `SELECT 1 `+readable, id).Scan(&found); err != nil {
+				return s.fail(c, subsonicErrNotFound, "song not found: "+ids[0])
+			}
+			if s.h.listenBrainz != nil {
+				if err := s.h.listenBrainz.SubmitPlayingNow(ctx, user.ID, id); err != nil {
+					log.Printf("listenbrainz now playing for user %d: %v", user.ID, err)
+				}
+			}
+			if s.h.lastFM != nil {
+				if err := s.h.lastFM.UpdateNowPlaying(ctx, user.ID, id); err != nil {
+					log.Printf("last.fm now playing for user %d: %v", user.ID, err)
+				}
+			}
+		}
+		return s.send(c, s.newResponse())
+	}
+
+	times := subsonicParams(c, "time")
+	for i, raw := range ids {
+		id, ok := subsonicID(raw, "")
+		if !ok {
+			return s.fail(c, subsonicErrNotFound, "song not found: "+raw)
+		}
+		var durationMs sql.NullInt64
+		if err := s.db.QueryRowContext(ctx, `SELECT s.duration_ms `+readable, id).Scan(&durationMs); err != nil {
+			return s.fail(c, subsonicErrNotFound, "song not found: "+raw)
+		}
+		playedAt := time.Now()
+		if i < len(times) {
+			if ms, err := strconv.ParseInt(times[i], 10, 64); err == nil && ms > 0 {
+				playedAt = time.UnixMilli(ms)
+			}
+		}
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO play_history (user_id, song_id, played_at, duration_listened, completion_rate, source)
+			VALUES (?, ?, ?, ?, 1, 'subsonic')
+		`, user.ID, id, playedAt.UTC().Format(time.RFC3339), durationMs.Int64/1000)
+		if err != nil {
+			return s.fail(c, subsonicErrGeneric, "failed to record scrobble")
+		}
+		s.h.scrobble(c, id, playedAt, int(durationMs.Int64/1000))
+	}
+	s.h.smartPlaylists.Schedule(user.ID)
+	return s.send(c, s.newResponse())
+}
+
+// GetLyricsBySongID implements the OpenSubsonic getLyricsBySongId endpoint.
+func (s *SubsonicHandler) GetLyricsBySongID(c echo.Context) error {
+	id, ok := subsonicID(c.FormValue("id"), "")
+	if !ok {
+		return s.fail(c, subsonicErrMissingParam, "required parameter is missing: id")
+	}
+	ctx := c.Request().Context()
+	lyrics, err := s.hls.lyrics.Get(ctx, db.UserAccess(s.user(c)), id)
+	if err == sql.ErrNoRows {
+		return s.fail(c, subsonicErrNotFound, "song not found")
+	}
+	if err != nil {
+		return s.fail(c, subsonicErrGeneric, "failed to load lyrics")
+	}
+	var title string
+	var artist sql.NullString
+	_ = s.db.QueryRowContext(ctx, `
+		SELECT s.title,
+		       (SELECT GROUP_CONCAT(a.name, ', ') FROM artists a
+		        JOIN song_artists sa ON sa.artist_id = a.id
+		        WHERE sa.song_id = s.id)
+		FROM songs s WHERE s.id = ?`, id).Scan(&title, &artist)
+
+	list := &subsonicLyricsList{StructuredLyrics: []subsonicStructuredLyrics{}}
+	base := subsonicStructuredLyrics{DisplayArtist: artist.String, DisplayTitle: title, Lang: "xxx"}
+	if lines := parseLRC(lyrics.Synced); len(lines) > 0 {
+		entry := base
+		entry.Synced = true
+		entry.Line = lines
+		list.StructuredLyrics = append(list.StructuredLyrics, entry)
+	}
+	if text := strings.TrimSpace(lyrics.Plain); text != "" {
+		entry := base
+		for _, line := range strings.Split(text, "\n") {
+			entry.Line = append(entry.Line, subsonicLyricLine{Value: strings.TrimRight(line, "\r")})
+		}
+		list.StructuredLyrics = append(list.StructuredLyrics, entry)
+	}
+
+	resp := s.newResponse()
+	resp.LyricsList = list
+	return s.send(c, resp)
+}
+
+var lrcTimestamp = regexp.MustCompile(`\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+
+// parseLRC turns LRC text into timed lines. Lines carrying several
+// timestamps are repeated once per timestamp; metadata tags are dropped.
+func parseLRC(text string) []subsonicLyricLine {
+	var lines []subsonicLyricLine
+	for _, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimSpace(raw)
+		matches := lrcTimestamp.FindAllStringSubmatchIndex(raw, -1)
+		if len(matches) == 0 || matches[0][0] != 0 {
+			continue
+		}
+		value := strings.TrimSpace(raw[matches[len(matches)-1][1]:])
+		for _, m := range matches {
+			minutes, _ := strconv.ParseInt(raw[m[2]:m[3]], 10, 64)
+			seconds, _ := strconv.ParseInt(raw[m[4]:m[5]], 10, 64)
+			var millis int64
+			if m[6] >= 0 {
+				frac := raw[m[6]:m[7]]
+				millis, _ = strconv.ParseInt(frac, 10, 64)
+				for i := len(frac); i < 3; i++ {
+					millis *= 10
+				}
+			}
+			start := (minutes*60+seconds)*1000 + millis
+			lines = append(lines, subsonicLyricLine{Start: &start, Value: value})
+		}
+	}
+	return lines
+}
+
+// The query bases below select from subqueries limited to the user's
+// libraries, so callers' tails can keep starting with WHERE, JOIN or HAVING.
+
+func subsonicArtistQuery(access db.Access) string {
+	return `
+	SELECT ar.id, ar.name, ar.image_path, COUNT(DISTINCT al.id), fa.created_at
+	FROM (SELECT * FROM artists WHERE ` + access.ArtistFilter("artists") + `) ar
+	LEFT JOIN albums al ON al.artist_id = ar.id AND ` + access.Filter("al") + `
+	LEFT JOIN follows_artists fa ON fa.artist_id = ar.id AND fa.user_id = ?
+	GROUP BY ar.id
+`
+}
+
+func (s *SubsonicHandler) queryArtists(ctx context.Context, user models.User, tail string, args ...any) ([]subsonicArtist, error) {
+	rows, err := s.db.QueryContext(ctx, subsonicArtistQuery(db.UserAccess(user))+tail, append([]any{user.ID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artists := []subsonicArtist{}
+	for rows.Next() {
+		var id int64
+		var a subsonicArtist
+		var image, starred sql.NullString
+		if err := rows.Scan(&id, &a.Name, &image, &a.AlbumCount, &starred); err != nil {
+			return nil, err
+		}
+		a.ID = strconv.FormatInt(id, 10)
+		if image.String != "" {
+			a.CoverArt = "ar-" + a.ID
+		}
+		a.Starred = subsonicTime(starred.String)
+		artists = append(artists, a)
+	}
+	return artists, rows.Err()
+}
+
+func subsonicAlbumQuery(access db.Access) string {
+	return `
+	SELECT al.id, al.title, al.year, al.created_at, al.artist_id, COALESCE(ar.name, 'Various Artists'),
+	       (SELECT COUNT(*) FROM songs WHERE album_id = al.id),
+	       (SELECT COALESCE(SUM(duration_ms), 0) FROM songs WHERE album_id = al.id),
+	       fa.created_at
+	FROM (SELECT * FROM albums WHERE ` + access.Filter("albums") + `) al
+	LEFT JOIN artists ar ON ar.id = al.artist_id
+	LEFT JOIN favorites_albums fa ON fa.album_id = al.id AND fa.user_id = ?
+`
+}
+
+func (s *SubsonicHandler) queryAlbums(ctx context.Context, user models.User, tail string, args ...any) ([]subsonicAlbum, error) {
+	rows, err := s.db.QueryContext(ctx, subsonicAlbumQuery(db.UserAccess(user))+tail, append([]any{user.ID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []subsonicAlbum{}
+	for rows.Next() {
+		var id, durationMs int64
+		var year, artistID sql.NullInt64
+		var created, starred sql.NullString
+		var a subsonicAlbum
+		if err := rows.Scan(&id, &a.Name, &year, &created, &artistID, &a.Artist, &a.SongCount, &durationMs, &starred); err != nil {
+			return nil, err
+		}
+		a.ID = "al-" + strconv.FormatInt(id, 10)
+		a.CoverArt = a.ID
+		if artistID.Valid {
+			a.ArtistID = "ar-" + strconv.FormatInt(artistID.Int64, 10)
+		}
+		a.Year = int(year.Int64)
+		a.Duration = int(durationMs / 1000)
+		a.Created = subsonicTime(created.String)
+		a.Starred = subsonicTime(starred.String)
+		albums = append(albums, a)
+	}
+	return albums, rows.Err()
+}
+
+func subsonicSongQuery(access db.Access) string {
+	return `
+	SELECT s.id, s.album_id, s.title, s.track_number, s.disc_number, s.duration_ms, s.file_path, s.source_path IS NOT NULL,
+	       s.sample_rate, s.bit_depth, s.channels, al.title, al.year,
+	       COALESCE(sar.id, alar.id), COALESCE(sar.name, alar.name, 'Unknown Artist'),
+	       fs.created_at
+	FROM (SELECT * FROM songs WHERE ` + access.Filter("songs") + `) s
+	JOIN albums al ON al.id = s.album_id
+	LEFT JOIN artists alar ON alar.id = al.artist_id
+	LEFT JOIN artists sar ON sar.id = (
+		SELECT artist_id FROM song_artists
+		WHERE song_id = s.id AND role = 'primary'
+		ORDER BY position LIMIT 1
+	)
+	LEFT JOIN favorites_songs fs ON fs.song_id = s.id AND fs.user_id = ?
+`
+}
+
+func (s *SubsonicHandler) querySongs(ctx context.Context, user models.User, tail string, args ...any) ([]subsonicChild, error) {
+	rows, err := s.db.QueryContext(ctx, subsonicSongQuery(db.UserAccess(user))+tail, append([]any{user.ID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs := []subsonicChild{}
+	for rows.Next() {
+		var id, albumID int64
+		var track, disc, durationMs, sampleRate, bitDepth, channels, year, artistID sql.NullInt64
+		var path string
+		var cueTrack bool
+		var starred sql.NullString
+		song := subsonicChild{Type: "music", MediaType: "song"}
+		if err := rows.Scan(&id, &albumID, &song.Title, &track, &disc, &durationMs, &path, &cueTrack,
+			&sampleRate, &bitDepth, &channels, &song.Album, &year,
+			&artistID, &song.Artist, &starred); err != nil {
+			return nil, err
+		}
+		song.ID = strconv.FormatInt(id, 10)
+		song.AlbumID = "al-" + strconv.FormatInt(albumID, 10)
+		song.Parent = song.AlbumID
+		song.CoverArt = song.AlbumID
+		if artistID.Valid {
+			song.ArtistID = "ar-" + strconv.FormatInt(artistID.Int64, 10)
+		}
+		song.Track = int(track.Int64)
+		song.DiscNumber = int(disc.Int64)
+		song.Year = int(year.Int64)
+		song.Duration = int(durationMs.Int64 / 1000)
+		song.SamplingRate = int(sampleRate.Int64)
+		song.BitDepth = int(bitDepth.Int64)
+		song.ChannelCount = int(channels.Int64)
+		song.Suffix = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		song.Path = filepath.Base(path)
+		if cueTrack {
+			// Served as a FLAC cut from the album's file
+			song.Suffix = "flac"
+			song.Path = sanitizeFilename(song.Title) + ".flac"
+		}
+		song.ContentType = subsonicContentType(song.Suffix)
+		song.Starred = subsonicTime(starred.String)
+		songs = append(songs, song)
+	}
+	return songs, rows.Err()
+}
+
+func (s *SubsonicHandler) queryPlaylists(ctx context.Context, tail string, args ...any) ([]subsonicPlaylist, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.name, p.description, p.public, p.cover_path, p.created_at, u.username,
+		       (SELECT COUNT(*) FROM playlist_songs WHERE playlist_id = p.id),
+		       (SELECT COALESCE(SUM(s.duration_ms), 0) FROM playlist_songs ps JOIN songs s ON s.id = ps.song_id WHERE ps.playlist_id = p.id)
+		FROM playlists p
+		JOIN users u ON u.id = p.user_id
+	`+tail, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []subsonicPlaylist{}
+	for rows.Next() {
+		var id, durationMs int64
+		var description, cover, created sql.NullString
+		var p subsonicPlaylist
+		if err := rows.Scan(&id, &p.Name, &description, &p.Public, &cover, &created, &p.Owner, &p.SongCount, &durationMs); err != nil {
+			return nil, err
+		}
+		p.ID = strconv.FormatInt(id, 10)
+		p.Comment = description.String
+		p.Duration = int(durationMs / 1000)
+		p.Created = subsonicTime(created.String)
+		p.Changed = p.Created
+		if cover.String != "" {
+			p.CoverArt = "pl-" + p.ID
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+// subsonicID parses an id that may carry the given kind prefix.
+func subsonicID(raw, prefix string) (int64, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(raw, prefix), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// subsonicParams returns every value of a repeatable parameter from the
+// query string or a form-encoded body.
+func subsonicParams(c echo.Context, name string) []string {
+	if err := c.Request().ParseForm(); err != nil {
+		return nil
+	}
+	return c.Request().Form[name]
+}
+
+// subsonicInt parses a non-negative integer parameter, falling back to def
+// and clamping to max when max >= 0.
+func subsonicInt(raw string, def, max int) int {
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		n = def
+	}
+	if max >= 0 && n > max {
+		n = max
+	}
+	return n
+}
+
+// subsonicBitrate picks the highest supported bitrate not above maxBitRate,
+// or the lowest one when maxBitRate is below all of them. Zero means the
+// format default.
+func subsonicBitrate(supported []int, maxBitRate int) int {
+	if maxBitRate == 0 || len(supported) == 0 {
+		return 0
+	}
+	best := supported[0]
+	for _, b := range supported {
+		if b <= maxBitRate && b > best {
+			best = b
+		}
+	}
+	return best
+}
+
+func subsonicIndexKey(name string) string {
+	lower := strings.ToLower(name)
+	for _, article := range []string{"the ", "a ", "an "} {
+		if strings.HasPrefix(lower, article) && len(name) > len(article) {
+			name = name[len(article):]
+			break
+		}
+	}
+	for _, r := range name {
+		if unicode.IsLetter(r) {
+			return strings.ToUpper(string(r))
+		}
+		break
+	}
+	return "#"
+}
+
+func subsonicContentType(suffix string) string {
+	switch suffix {
+	case "mp3":
+		return "audio/mpeg"
+	case "flac":
+		return "audio/flac"
+	case "m4a", "mp4", "aac":
+		return "audio/mp4"
+	case "ogg", "oga", "opus":
+		return "audio/ogg"
+	case "wav":
+		return "audio/wav"
+	case "aif", "aiff", "aifc":
+		return "audio/aiff"
+	case "wv":
+		return "audio/x-wavpack"
+	case "ape":
+		return "audio/x-ape"
+	case "tak":
+		return "audio/x-tak"
+	case "dsf", "dff":
+		return "audio/x-dsd"
+	case "mka":
+		return "audio/x-matroska"
+	case "wma":
+		return "audio/x-ms-wma"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// subsonicTime normalises a SQLite timestamp to RFC 3339; empty stays empty.
+func subsonicTime(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05Z"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return raw
+}
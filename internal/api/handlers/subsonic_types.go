@@ -0,0 +1,194 @@
+package handlers
+
+import "encoding/xml"
+
+// Wire types for the Subsonic API. Every type carries both xml and json tags
+// because clients pick the encoding per request with the f parameter.
+
+type subsonicResponse struct {
+	XMLName       xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns         string   `xml:"xmlns,attr,omitempty" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error                  *subsonicError            `xml:"error,omitempty" json:"error,omitempty"`
+	License                *subsonicLicense          `xml:"license,omitempty" json:"license,omitempty"`
+	OpenSubsonicExtensions []subsonicExtension       `xml:"openSubsonicExtensions,omitempty" json:"openSubsonicExtensions,omitempty"`
+	MusicFolders           *subsonicMusicFolders     `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Genres                 *subsonicGenres           `xml:"genres,omitempty" json:"genres,omitempty"`
+	Artists                *subsonicArtists          `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist                 *subsonicArtistWithAlbums `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album                  *subsonicAlbumWithSongs   `xml:"album,omitempty" json:"album,omitempty"`
+	AlbumList2             *subsonicAlbumList        `xml:"albumList2,omitempty" json:"albumList2,omitempty"`
+	Song                   *subsonicChild            `xml:"song,omitempty" json:"song,omitempty"`
+	SearchResult3          *subsonicSearchResult     `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Playlists              *subsonicPlaylists        `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist               *subsonicPlaylistEntries  `xml:"playlist,omitempty" json:"playlist,omitempty"`
+	Starred2               *subsonicStarred          `xml:"starred2,omitempty" json:"starred2,omitempty"`
+	LyricsList             *subsonicLyricsList       `xml:"lyricsList,omitempty" json:"lyricsList,omitempty"`
+}
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicExtension struct {
+	Name     string `xml:"name,attr" json:"name"`
+	Versions []int  `xml:"versions" json:"versions"`
+}
+
+type subsonicMusicFolders struct {
+	MusicFolder []subsonicMusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type subsonicMusicFolder struct {
+	ID   int64  `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicGenres struct {
+	Genre []subsonicGenre `xml:"genre" json:"genre"`
+}
+
+type subsonicGenre struct {
+	SongCount  int    `xml:"songCount,attr" json:"songCount"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+	Value      string `xml:",chardata" json:"value"`
+}
+
+type subsonicArtists struct {
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []subsonicIndex `xml:"index" json:"index"`
+}
+
+type subsonicIndex struct {
+	Name   string           `xml:"name,attr" json:"name"`
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+type subsonicArtist struct {
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	CoverArt   string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+	Starred    string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+type subsonicArtistWithAlbums struct {
+	subsonicArtist
+	Album []subsonicAlbum `xml:"album" json:"album"`
+}
+
+type subsonicAlbum struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Artist    string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	ArtistID  string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Year      int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Created   string `xml:"created,attr" json:"created"`
+	Starred   string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+type subsonicAlbumWithSongs struct {
+	subsonicAlbum
+	DiscTitles []subsonicDiscTitle `xml:"discTitles" json:"discTitles,omitempty"`
+	Song       []subsonicChild     `xml:"song" json:"song"`
+}
+
+// subsonicDiscTitle is OpenSubsonic's subtitle of one disc of an album
+type subsonicDiscTitle struct {
+	Disc  int    `xml:"disc,attr" json:"disc"`
+	Title string `xml:"title,attr" json:"title"`
+}
+
+type subsonicAlbumList struct {
+	Album []subsonicAlbum `xml:"album" json:"album"`
+}
+
+type subsonicChild struct {
+	ID           string `xml:"id,attr" json:"id"`
+	Parent       string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir        bool   `xml:"isDir,attr" json:"isDir"`
+	Title        string `xml:"title,attr" json:"title"`
+	Album        string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist       string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track        int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	DiscNumber   int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	Year         int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	CoverArt     string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size         int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType  string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix       string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration     int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	Path         string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	AlbumID      string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID     string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type         string `xml:"type,attr" json:"type"`
+	MediaType    string `xml:"mediaType,attr" json:"mediaType"`
+	Starred      string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	BitDepth     int    `xml:"bitDepth,attr,omitempty" json:"bitDepth,omitempty"`
+	SamplingRate int    `xml:"samplingRate,attr,omitempty" json:"samplingRate,omitempty"`
+	ChannelCount int    `xml:"channelCount,attr,omitempty" json:"channelCount,omitempty"`
+}
+
+type subsonicSearchResult struct {
+	Artist []subsonicArtist `xml:"artist" json:"artist,omitempty"`
+	Album  []subsonicAlbum  `xml:"album" json:"album,omitempty"`
+	Song   []subsonicChild  `xml:"song" json:"song,omitempty"`
+}
+
+type subsonicPlaylists struct {
+	Playlist []subsonicPlaylist `xml:"playlist" json:"playlist"`
+}
+
+type subsonicPlaylist struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Comment   string `xml:"comment,attr,omitempty" json:"comment,omitempty"`
+	Owner     string `xml:"owner,attr" json:"owner"`
+	Public    bool   `xml:"public,attr" json:"public"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Created   string `xml:"created,attr" json:"created"`
+	Changed   string `xml:"changed,attr" json:"changed"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+}
+
+type subsonicPlaylistEntries struct {
+	subsonicPlaylist
+	Entry []subsonicChild `xml:"entry" json:"entry"`
+}
+
+type subsonicStarred struct {
+	Artist []subsonicArtist `xml:"artist" json:"artist,omitempty"`
+	Album  []subsonicAlbum  `xml:"album" json:"album,omitempty"`
+	Song   []subsonicChild  `xml:"song" json:"song,omitempty"`
+}
+
+type subsonicLyricsList struct {
+	StructuredLyrics []subsonicStructuredLyrics `xml:"structuredLyrics" json:"structuredLyrics"`
+}
+
+type subsonicStructuredLyrics struct {
+	DisplayArtist string              `xml:"displayArtist,attr,omitempty" json:"displayArtist,omitempty"`
+	DisplayTitle  string              `xml:"displayTitle,attr,omitempty" json:"displayTitle,omitempty"`
+	Lang          string              `xml:"lang,attr" json:"lang"`
+	Synced        bool                `xml:"synced,attr" json:"synced"`
+	Line          []subsonicLyricLine `xml:"line" json:"line"`
+}
+
+type subsonicLyricLine struct {
+	Start *int64 `xml:"start,attr,omitempty" json:"start,omitempty"`
+	Value string `xml:",chardata" json:"value"`
+}
@@ -27,7 +27,13 @@ type Deps struct {
 	Transcoder        *services.Transcoder
 	MusicBrainz       *services.MusicBrainzService
 	ListenBrainz      *services.ListenBrainzService
+	LastFM            *services.LastFMService
+	Scrobbles         *services.ScrobbleService
+	Lyrics            *services.LyricsService
+	Artwork           *services.ArtworkService
 	Radio             *services.RadioService
+	Events            *services.EventBus
+	SmartPlaylists    *services.SmartPlaylistRefresher
 	HLS               *hls.Service
 	MediaRoot         string
 	AuthRate          int
@@ -52,8 +58,8 @@ func New(deps Deps) *echo.Echo {
 	e.Use(echomw.Recover())
 	e.Use(echomw.CORS())
 
-	h := handlers.New(deps.DB, deps.DBPath, deps.Auth, deps.Scanner, deps.Search, deps.Transcoder, deps.MusicBrainz, deps.ListenBrainz, deps.Radio, deps.MediaRoot, deps.RadioDefaultLimit)
-	hlsHandler := handlers.NewHLSHandler(deps.DB, deps.HLS)
+	h := handlers.New(deps.DB, deps.DBPath, deps.Auth, deps.Scanner, deps.Search, deps.Transcoder, deps.MusicBrainz, deps.ListenBrainz, deps.LastFM, deps.Scrobbles, deps.Radio, deps.Events, deps.SmartPlaylists, deps.MediaRoot, deps.RadioDefaultLimit)
+	hlsHandler := handlers.NewHLSHandler(deps.DB, deps.HLS, deps.Lyrics, deps.Artwork)
 
 	api := e.Group("/api")
 	api.GET("/health", h.Health)
@@ -75,20 +81,30 @@ func New(deps Deps) *echo.Echo {
 	authGroup.POST("/logout", h.Logout, middleware.Auth(deps.Auth))
 	authGroup.GET("/me", h.Me, middleware.Auth(deps.Auth))
 	authGroup.POST("/onboarded", h.CompleteOnboarding, middleware.Auth(deps.Auth))
+	authGroup.POST("/subsonic", h.CreateSubsonicPassword, middleware.Auth(deps.Auth))
+	authGroup.DELETE("/subsonic", h.RevokeSubsonicPassword, middleware.Auth(deps.Auth))
 
 	api.GET("/library", h.Library, middleware.Auth(deps.Auth))
 	api.GET("/artists/:id", h.Artist, middleware.Auth(deps.Auth))
 	api.GET("/albums/:id", h.Album, middleware.Auth(deps.Auth))
 	api.GET("/songs/:id", h.Song, middleware.Auth(deps.Auth))
 	api.GET("/search", h.Search, middleware.Auth(deps.Auth))
+	api.GET("/genres", h.ListGenres, middleware.Auth(deps.Auth))
+	api.GET("/genres/:id", h.Genre, middleware.Auth(deps.Auth))
+	api.GET("/libraries", h.ListLibraries, middleware.Auth(deps.Auth))
 
 	// HLS streaming endpoints
 	api.GET("/stream/:id", hlsHandler.Stream, middleware.Auth(deps.Auth))
 	api.GET("/stream/:id/manifest.m3u8", hlsHandler.Manifest, middleware.Auth(deps.Auth))
+	api.GET("/stream/:id/master.m3u8", hlsHandler.MasterPlaylist, middleware.Auth(deps.Auth))
+	api.GET("/stream/:id/gapless", hlsHandler.Gapless, middleware.Auth(deps.Auth))
+	api.GET("/stream/:id/progressive", hlsHandler.Progressive, middleware.Auth(deps.Auth))
 	api.GET("/stream/:id/init.mp4", hlsHandler.InitSegment, middleware.Auth(deps.Auth))
 	api.GET("/stream/:id/:segment", hlsHandler.Segment, middleware.Auth(deps.Auth))
 	api.GET("/streaming/options", hlsHandler.StreamingOptions, middleware.Auth(deps.Auth))
 	api.GET("/download/:id", hlsHandler.Download, middleware.Auth(deps.Auth))
+	api.GET("/albums/:id/stream.m3u8", hlsHandler.AlbumStream, middleware.Auth(deps.Auth))
+	api.GET("/playlists/:id/stream.m3u8", hlsHandler.PlaylistStream, middleware.Auth(deps.Auth))
 
 	api.GET("/artwork/:id", hlsHandler.Artwork)
 	api.GET("/artist-image/:id", hlsHandler.ArtistImage)
@@ -96,12 +112,18 @@ func New(deps Deps) *echo.Echo {
 
 	api.GET("/playlists", h.ListPlaylists, middleware.Auth(deps.Auth))
 	api.POST("/playlists", h.CreatePlaylist, middleware.Auth(deps.Auth))
+	api.POST("/playlists/import", h.ImportPlaylist, middleware.Auth(deps.Auth))
 	api.GET("/playlists/:id", h.GetPlaylist, middleware.Auth(deps.Auth))
 	api.PUT("/playlists/:id", h.UpdatePlaylist, middleware.Auth(deps.Auth))
 	api.DELETE("/playlists/:id", h.DeletePlaylist, middleware.Auth(deps.Auth))
 	api.POST("/playlists/:id/songs", h.AddPlaylistSong, middleware.Auth(deps.Auth))
 	api.DELETE("/playlists/:id/songs/:song_id", h.DeletePlaylistSong, middleware.Auth(deps.Auth))
 	api.PUT("/playlists/:id/reorder", h.ReorderPlaylistSongs, middleware.Auth(deps.Auth))
+	api.GET("/playlists/:id/members", h.ListPlaylistMembers, middleware.Auth(deps.Auth))
+	api.POST("/playlists/:id/members", h.AddPlaylistMember, middleware.Auth(deps.Auth))
+	api.DELETE("/playlists/:id/members/:user_id", h.RemovePlaylistMember, middleware.Auth(deps.Auth))
+	api.GET("/playlists/:id/activity", h.ListPlaylistActivity, middleware.Auth(deps.Auth))
+	api.GET("/playlists/:id/export", h.ExportPlaylist, middleware.Auth(deps.Auth))
 	api.POST("/playlists/:id/cover", h.UploadPlaylistCover, middleware.Auth(deps.Auth))
 	api.GET("/playlists/:id/cover", h.GetPlaylistCover)
 
@@ -115,6 +137,9 @@ func New(deps Deps) *echo.Echo {
 
 	api.POST("/history", h.RecordHistory, middleware.Auth(deps.Auth))
 	api.GET("/history", h.ListHistory, middleware.Auth(deps.Auth))
+	api.GET("/scrobbles", h.ListScrobbles, middleware.Auth(deps.Auth))
+	api.POST("/scrobbles/replay", h.ReplayScrobbles, middleware.Auth(deps.Auth))
+	api.DELETE("/scrobbles/:id", h.DeleteScrobble, middleware.Auth(deps.Auth))
 
 	api.GET("/stats", h.Stats, middleware.Auth(deps.Auth))
 	api.GET("/stats/wrapped", h.Wrapped, middleware.Auth(deps.Auth))
@@ -130,7 +155,12 @@ func New(deps Deps) *echo.Echo {
 	api.POST("/player/state", h.SavePlayerState, middleware.Auth(deps.Auth))
 
 	api.POST("/scan", h.StartScan, middleware.Auth(deps.Auth))
+	api.DELETE("/scan", h.CancelScan, middleware.Auth(deps.Auth), middleware.AdminOnly)
 	api.GET("/scan/status", h.ScanStatus, middleware.Auth(deps.Auth))
+	api.GET("/scan/history", h.ScanHistory, middleware.Auth(deps.Auth), middleware.AdminOnly)
+	api.GET("/scan/:id/errors", h.ScanErrors, middleware.Auth(deps.Auth), middleware.AdminOnly)
+
+	api.GET("/events", h.Events, middleware.Auth(deps.Auth))
 
 	admin := api.Group("/admin", middleware.Auth(deps.Auth), middleware.AdminOnly)
 	admin.GET("/system", h.SystemInfo)
@@ -140,10 +170,51 @@ func New(deps Deps) *echo.Echo {
 	admin.PUT("/settings", h.UpdateAppSettings)
 	admin.GET("/database/backup", h.BackupDatabase)
 	admin.POST("/database/restore", h.RestoreDatabase)
+	admin.POST("/libraries", h.CreateLibrary)
+	admin.PUT("/libraries/:id", h.UpdateLibrary)
+	admin.DELETE("/libraries/:id", h.DeleteLibrary)
+	admin.GET("/users/:id/libraries", h.GetUserLibraries)
+	admin.PUT("/users/:id/libraries", h.SetUserLibraries)
 
+	api.GET("/listenbrainz", h.GetListenBrainz, middleware.Auth(deps.Auth))
+	api.PUT("/listenbrainz", h.LinkListenBrainz, middleware.Auth(deps.Auth))
+	api.DELETE("/listenbrainz", h.UnlinkListenBrainz, middleware.Auth(deps.Auth))
+	api.POST("/listenbrainz/now-playing", h.ListenBrainzNowPlaying, middleware.Auth(deps.Auth))
+	api.POST("/listenbrainz/import", h.ImportListenBrainz, middleware.Auth(deps.Auth))
+	api.GET("/lastfm", h.GetLastFM, middleware.Auth(deps.Auth))
+	api.PUT("/lastfm", h.UpdateLastFM, middleware.Auth(deps.Auth))
+	api.DELETE("/lastfm", h.UnlinkLastFM, middleware.Auth(deps.Auth))
+	api.GET("/lastfm/auth", h.LastFMAuth, middleware.Auth(deps.Auth))
+	api.POST("/lastfm/session", h.LastFMSession, middleware.Auth(deps.Auth))
+	api.POST("/lastfm/now-playing", h.LastFMNowPlaying, middleware.Auth(deps.Auth))
 	api.POST("/musicbrainz/submit-listen", h.SubmitListen, middleware.Auth(deps.Auth))
 	api.GET("/musicbrainz/recommendations", h.Recommendations, middleware.Auth(deps.Auth))
 
+	// Subsonic/OpenSubsonic API for third-party clients
+	sh := handlers.NewSubsonicHandler(h, hlsHandler)
+	rest := e.Group("/rest")
+	subsonicRoute(rest, "getOpenSubsonicExtensions", sh.GetOpenSubsonicExtensions)
+	subsonicRoute(rest, "ping", sh.Ping, sh.Auth)
+	subsonicRoute(rest, "getLicense", sh.GetLicense, sh.Auth)
+	subsonicRoute(rest, "getMusicFolders", sh.GetMusicFolders, sh.Auth)
+	subsonicRoute(rest, "getGenres", sh.GetGenres, sh.Auth)
+	subsonicRoute(rest, "getArtists", sh.GetArtists, sh.Auth)
+	subsonicRoute(rest, "getArtist", sh.GetArtist, sh.Auth)
+	subsonicRoute(rest, "getAlbum", sh.GetAlbum, sh.Auth)
+	subsonicRoute(rest, "getSong", sh.GetSong, sh.Auth)
+	subsonicRoute(rest, "getAlbumList2", sh.GetAlbumList2, sh.Auth)
+	subsonicRoute(rest, "search3", sh.Search3, sh.Auth)
+	subsonicRoute(rest, "stream", sh.Stream, sh.Auth)
+	subsonicRoute(rest, "download", sh.Download, sh.Auth)
+	subsonicRoute(rest, "getCoverArt", sh.GetCoverArt, sh.Auth)
+	subsonicRoute(rest, "getPlaylists", sh.GetPlaylists, sh.Auth)
+	subsonicRoute(rest, "getPlaylist", sh.GetPlaylist, sh.Auth)
+	subsonicRoute(rest, "star", sh.Star, sh.Auth)
+	subsonicRoute(rest, "unstar", sh.Unstar, sh.Auth)
+	subsonicRoute(rest, "getStarred2", sh.GetStarred2, sh.Auth)
+	subsonicRoute(rest, "scrobble", sh.Scrobble, sh.Auth)
+	subsonicRoute(rest, "getLyricsBySongId", sh.GetLyricsBySongID, sh.Auth)
+
 	// SPA fallback: serve static files, fall back to index.html for client-side routing
 	if deps.WebDistPath != "" {
 		e.Use(spaMiddleware(deps.WebDistPath))
@@ -157,8 +228,8 @@ func spaMiddleware(distPath string) echo.MiddlewareFunc {
 		return func(c echo.Context) error {
 			path := c.Request().URL.Path
 
-			// Skip API and swagger routes
-			if strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/swagger") {
+			// Skip API, Subsonic and swagger routes
+			if strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/rest") || strings.HasPrefix(path, "/swagger") {
 				return next(c)
 			}
 
@@ -174,6 +245,14 @@ func spaMiddleware(distPath string) echo.MiddlewareFunc {
 	}
 }
 
+// subsonicRoute registers a Subsonic endpoint under its bare name and the
+// legacy ".view" suffix, for both GET and form POST.
+func subsonicRoute(g *echo.Group, name string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) {
+	methods := []string{http.MethodGet, http.MethodPost}
+	g.Match(methods, "/"+name, h, m...)
+	g.Match(methods, "/"+name+".view", h, m...)
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,18 +18,28 @@ type Config struct {
 	RefreshTTL            time.Duration
 	FFmpegPath            string
 	FFprobePath           string
-	ListenBrainzToken     string
-	ListenBrainzUser      string
+	ListenBrainzMinPct    int
+	ListenBrainzMinSecs   int
 	MusicBrainzAgent      string
+	LastFMAPIKey          string
+	LastFMAPISecret       string
 	EnableListenBrainz    bool
 	EnableMusicBrainz     bool
+	EnableLyricsProvider  bool
+	LyricsProviderURL     string
 	RateLimitAuthCount    int
 	RateLimitAuthWindow   time.Duration
 	ScanWatch             bool
 	ScanExcludePattern    string
 	ScanEmbeddedCover     bool
+	ScanCoverPriority     []string
+	ScanArtistImages      []string
 	ScanWorkers           int
 	ScanAutoPlaylists     bool
+	ScanContentHash       bool
+	ScanPlaylistSync      bool
+	ScanLoudness          bool
+	PlaylistMirrorDir     string
 	CoverCachePath        string
 	RadioLLMEnabled       bool
 	RadioLLMAPIKey        string
@@ -54,18 +65,28 @@ func FromEnv() (Config, error) {
 		RefreshTTL:            durationEnv("REFRESH_TTL", 30*24*time.Hour),
 		FFmpegPath:            getenv("FFMPEG_PATH", "ffmpeg"),
 		FFprobePath:           getenv("FFPROBE_PATH", "ffprobe"),
-		ListenBrainzToken:     getenv("LISTENBRAINZ_TOKEN", ""),
-		ListenBrainzUser:      getenv("LISTENBRAINZ_USER", ""),
+		ListenBrainzMinPct:    intEnv("LISTENBRAINZ_MIN_PERCENT", 50),
+		ListenBrainzMinSecs:   intEnv("LISTENBRAINZ_MIN_SECONDS", 240),
+		LastFMAPIKey:          getenv("LASTFM_API_KEY", ""),
+		LastFMAPISecret:       getenv("LASTFM_API_SECRET", ""),
 		MusicBrainzAgent:      getenv("MUSICBRAINZ_AGENT", "Korus/0.1 (https://github.com/Aunali321/korus)"),
 		EnableListenBrainz:    boolEnv("ENABLE_LISTENBRAINZ", false),
 		EnableMusicBrainz:     boolEnv("ENABLE_MUSICBRAINZ", false),
+		EnableLyricsProvider:  boolEnv("ENABLE_LYRICS_PROVIDER", false),
+		LyricsProviderURL:     getenv("LYRICS_PROVIDER_URL", "https://lrclib.net"),
 		RateLimitAuthCount:    intEnv("RATE_LIMIT_AUTH_COUNT", 10),
 		RateLimitAuthWindow:   durationEnv("RATE_LIMIT_AUTH_WINDOW", time.Minute),
 		ScanWatch:             boolEnv("SCAN_WATCH", false),
 		ScanExcludePattern:    getenv("SCAN_EXCLUDE_PATTERN", ""),
 		ScanEmbeddedCover:     boolEnv("SCAN_EMBEDDED_COVER", true),
+		ScanCoverPriority:     listEnv("SCAN_COVER_PRIORITY", "cover,folder,front,embedded"),
+		ScanArtistImages:      listEnv("SCAN_ARTIST_IMAGES", "artist"),
 		ScanWorkers:           intEnv("SCAN_WORKERS", 8),
 		ScanAutoPlaylists:     boolEnv("SCAN_AUTO_PLAYLISTS", true),
+		ScanContentHash:       boolEnv("SCAN_CONTENT_HASH", false),
+		ScanPlaylistSync:      boolEnv("SCAN_PLAYLIST_SYNC", false),
+		ScanLoudness:          boolEnv("SCAN_LOUDNESS", false),
+		PlaylistMirrorDir:     getenv("PLAYLIST_MIRROR_DIR", ""),
 		CoverCachePath:        getenv("COVER_CACHE_PATH", "./cache/covers"),
 		RadioLLMEnabled:       boolEnv("RADIO_LLM_ENABLED", false),
 		RadioLLMAPIKey:        getenv("OPENROUTER_API_KEY", ""),
@@ -92,6 +113,17 @@ func getenv(key, def string) string {
 	return def
 }
 
+// listEnv splits a comma-separated variable, dropping empty entries
+func listEnv(key, def string) []string {
+	var list []string
+	for _, v := range strings.Split(getenv(key, def), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func durationEnv(key string, def time.Duration) time.Duration {
 	if v := os.Getenv(key); v != "" {
 		if d, err := time.ParseDuration(v); err == nil {
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Aunali321/korus/internal/models"
+)
+
+// Access describes which libraries a user can read. Admins read every
+// library; other users only those granted to them in user_libraries.
+type Access struct {
+	UserID int64
+	All    bool
+}
+
+// UserAccess returns the library access of a user
+func UserAccess(user models.User) Access {
+	return Access{UserID: user.ID, All: user.Role == "admin"}
+}
+
+// Filter returns a SQL condition limiting the songs or albums aliased as
+// alias to readable libraries. The user ID is inlined; it is an integer, so
+// this is safe and keeps callers' positional arguments unchanged.
+func (a Access) Filter(alias string) string {
+	if a.All {
+		return "1 = 1"
+	}
+	return fmt.Sprintf("%s.library_id IN (SELECT library_id FROM user_libraries WHERE user_id = %d)", alias, a.UserID)
+}
+
+// ArtistFilter limits the artists aliased as alias to those with an album or
+// song in a readable library.
+func (a Access) ArtistFilter(alias string) string {
+	if a.All {
+		return "1 = 1"
+	}
+	return fmt.Sprintf(`(%[1]s.id IN (SELECT artist_id FROM albums WHERE %[2]s)
+		OR %[1]s.id IN (SELECT sa.artist_id FROM song_artists sa JOIN songs ON songs.id = sa.song_id WHERE %[3]s))`,
+		alias, a.Filter("albums"), a.Filter("songs"))
+}
+
+const libraryColumns = `l.id, l.name, l.root_path, l.exclude_pattern, l.scan_interval_minutes, l.auto_grant, l.last_scanned_at, l.created_at,
+	(SELECT COUNT(*) FROM songs WHERE library_id = l.id)`
+
+func scanLibrary(row interface{ Scan(...any) error }) (models.Library, error) {
+	var l models.Library
+	var lastScanned sql.NullTime
+	err := row.Scan(&l.ID, &l.Name, &l.RootPath, &l.ExcludePattern, &l.ScanIntervalMinutes, &l.AutoGrant, &lastScanned, &l.CreatedAt, &l.SongCount)
+	if lastScanned.Valid {
+		l.LastScannedAt = &lastScanned.Time
+	}
+	return l, err
+}
+
+// GetLibraries returns the libraries readable with access, ordered by name
+func GetLibraries(ctx context.Context, db *sql.DB, access Access) ([]models.Library, error) {
+	where := "1 = 1"
+	if !access.All {
+		where = fmt.Sprintf("l.id IN (SELECT library_id FROM user_libraries WHERE user_id = %d)", access.UserID)
+	}
+	rows, err := db.QueryContext(ctx, `SELECT `+libraryColumns+` FROM libraries l WHERE `+where+` ORDER BY l.name COLLATE NOCASE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	libraries := []models.Library{}
+	for rows.Next() {
+		l, err := scanLibrary(rows)
+		if err != nil {
+			continue
+		}
+		libraries = append(libraries, l)
+	}
+	return libraries, rows.Err()
+}
+
+// GetLibrary returns a single library by id
+func GetLibrary(ctx context.Context, db *sql.DB, id int64) (models.Library, error) {
+	return scanLibrary(db.QueryRowContext(ctx, `SELECT `+libraryColumns+` FROM libraries l WHERE l.id = ?`, id))
+}
+
+// CreateLibrary inserts a library and returns its id. Libraries with
+// AutoGrant set are also granted to every existing user.
+func CreateLibrary(ctx context.Context, db *sql.DB, l models.Library) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO libraries (name, root_path, exclude_pattern, scan_interval_minutes, auto_grant)
+		VALUES (?, ?, ?, ?, ?)
+	`, l.Name, l.RootPath, l.ExcludePattern, l.ScanIntervalMinutes, l.AutoGrant)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if l.AutoGrant {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO user_libraries (user_id, library_id) SELECT id, ? FROM users`, id); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// UpdateLibrary saves a library's settings
+func UpdateLibrary(ctx context.Context, db *sql.DB, l models.Library) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE libraries SET name = ?, root_path = ?, exclude_pattern = ?, scan_interval_minutes = ?, auto_grant = ?
+		WHERE id = ?
+	`, l.Name, l.RootPath, l.ExcludePattern, l.ScanIntervalMinutes, l.AutoGrant, l.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteLibrary removes a library. Its songs, albums and grants go with it.
+func DeleteLibrary(ctx context.Context, db *sql.DB, id int64) error {
+	_, _ = db.ExecContext(ctx, `DELETE FROM songs_fts WHERE rowid IN (SELECT id FROM songs WHERE library_id = ?)`, id)
+	res, err := db.ExecContext(ctx, `DELETE FROM libraries WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetUserLibraryIDs returns the ids of the libraries granted to a user
+func GetUserLibraryIDs(ctx context.Context, db *sql.DB, userID int64) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, `SELECT library_id FROM user_libraries WHERE user_id = ? ORDER BY library_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// SetUserLibraries replaces the libraries granted to a user
+func SetUserLibraries(ctx context.Context, db *sql.DB, userID int64, libraryIDs []int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_libraries WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, id := range libraryIDs {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO user_libraries (user_id, library_id) VALUES (?, ?)`, userID, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SeedDefaultLibrary creates a "Music" library for MEDIA_ROOT the first time
+// the server starts without any libraries. Songs and albums scanned before
+// libraries existed are moved into it, and every existing user is granted it
+// so upgrading does not hide anyone's music.
+func SeedDefaultLibrary(ctx context.Context, db *sql.DB, root, exclude string) error {
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(1) FROM libraries`).Scan(&count); err != nil {
+		return fmt.Errorf("count libraries: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO libraries (name, root_path, exclude_pattern, auto_grant)
+		VALUES ('Music', ?, ?, 1)
+	`, root, exclude)
+	if err != nil {
+		return fmt.Errorf("insert library: %w", err)
+	}
+	id, _ := res.LastInsertId()
+	for _, q := range []string{
+		`UPDATE albums SET library_id = ? WHERE library_id IS NULL`,
+		`UPDATE songs SET library_id = ? WHERE library_id IS NULL`,
+		`INSERT INTO user_libraries (user_id, library_id) SELECT id, ? FROM users`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
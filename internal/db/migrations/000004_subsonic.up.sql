@@ -0,0 +1,5 @@
+-- Subsonic clients authenticate with md5(password + salt), which can't be
+-- checked against a bcrypt hash. Each user can generate a dedicated app
+-- password for Subsonic clients; it is stored as-is so token auth works,
+-- and is never the same secret as the account login password.
+ALTER TABLE users ADD COLUMN subsonic_password TEXT;
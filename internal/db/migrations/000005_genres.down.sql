@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_song_genres_genre;
+DROP TABLE IF EXISTS song_genres;
+DROP TABLE IF EXISTS genres;
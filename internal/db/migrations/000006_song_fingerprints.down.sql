@@ -0,0 +1,3 @@
+ALTER TABLE songs DROP COLUMN content_hash;
+ALTER TABLE songs DROP COLUMN file_size;
+ALTER TABLE songs DROP COLUMN file_mtime;
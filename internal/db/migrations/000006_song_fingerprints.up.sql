@@ -0,0 +1,6 @@
+-- File fingerprints let rescans skip files that haven't changed since they
+-- were last ingested. file_mtime is in Unix nanoseconds. content_hash is a
+-- SHA-256 of the file and only filled when SCAN_CONTENT_HASH is enabled.
+ALTER TABLE songs ADD COLUMN file_mtime INTEGER;
+ALTER TABLE songs ADD COLUMN file_size INTEGER;
+ALTER TABLE songs ADD COLUMN content_hash TEXT;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_user_libraries_library;
+DROP INDEX IF EXISTS idx_songs_library;
+DROP INDEX IF EXISTS idx_albums_library;
+ALTER TABLE songs DROP COLUMN library_id;
+ALTER TABLE albums DROP COLUMN library_id;
+DROP TABLE IF EXISTS user_libraries;
+DROP TABLE IF EXISTS libraries;
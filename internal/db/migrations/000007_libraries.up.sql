@@ -0,0 +1,31 @@
+-- Libraries are named media roots, each with its own exclude pattern and
+-- scan schedule. scan_interval_minutes = 0 means the library is only scanned
+-- on demand. auto_grant libraries are granted to newly registered users.
+CREATE TABLE IF NOT EXISTS libraries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    root_path TEXT NOT NULL UNIQUE,
+    exclude_pattern TEXT NOT NULL DEFAULT '',
+    scan_interval_minutes INTEGER NOT NULL DEFAULT 0,
+    auto_grant INTEGER NOT NULL DEFAULT 0,
+    last_scanned_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Libraries a non-admin user may read. Admins can read every library.
+CREATE TABLE IF NOT EXISTS user_libraries (
+    user_id INTEGER NOT NULL,
+    library_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, library_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (library_id) REFERENCES libraries(id) ON DELETE CASCADE
+);
+
+-- Rows scanned before libraries existed are assigned to the default library
+-- when it is seeded at startup.
+ALTER TABLE albums ADD COLUMN library_id INTEGER REFERENCES libraries(id) ON DELETE CASCADE;
+ALTER TABLE songs ADD COLUMN library_id INTEGER REFERENCES libraries(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_albums_library ON albums(library_id);
+CREATE INDEX IF NOT EXISTS idx_songs_library ON songs(library_id);
+CREATE INDEX IF NOT EXISTS idx_user_libraries_library ON user_libraries(library_id);
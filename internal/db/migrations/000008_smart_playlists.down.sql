@@ -0,0 +1,2 @@
+ALTER TABLE songs DROP COLUMN added_at;
+ALTER TABLE playlists DROP COLUMN rules;
@@ -0,0 +1,8 @@
+-- Smart playlists store their rule document as JSON. Their playlist_songs
+-- rows are rewritten whenever the rules are re-evaluated.
+ALTER TABLE playlists ADD COLUMN rules TEXT;
+
+-- When a song first entered the library, for "added" rules. Existing songs
+-- take their album's creation time.
+ALTER TABLE songs ADD COLUMN added_at TIMESTAMP;
+UPDATE songs SET added_at = (SELECT created_at FROM albums WHERE albums.id = songs.album_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_playlist_activity_playlist;
+DROP INDEX IF EXISTS idx_playlist_members_user;
+DROP TABLE IF EXISTS playlist_activity;
+ALTER TABLE playlist_songs DROP COLUMN added_at;
+ALTER TABLE playlist_songs DROP COLUMN added_by;
+DROP TABLE IF EXISTS playlist_members;
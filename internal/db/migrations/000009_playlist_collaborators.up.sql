@@ -0,0 +1,38 @@
+-- Users a playlist is shared with. Viewers can read a private playlist;
+-- editors can also rename it and add, remove and reorder its songs.
+CREATE TABLE IF NOT EXISTS playlist_members (
+    playlist_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'editor')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (playlist_id, user_id),
+    FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Who added each song and when. Before sharing existed only the owner could
+-- add songs by hand, so existing rows of manual playlists are theirs.
+ALTER TABLE playlist_songs ADD COLUMN added_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE playlist_songs ADD COLUMN added_at TIMESTAMP;
+UPDATE playlist_songs SET
+    added_at = (SELECT created_at FROM playlists WHERE playlists.id = playlist_songs.playlist_id),
+    added_by = (SELECT user_id FROM playlists WHERE playlists.id = playlist_songs.playlist_id
+                AND source_path IS NULL AND rules IS NULL);
+
+-- Changes made to a playlist, newest last. detail holds a readable
+-- description of the subject (song title, member name) at the time.
+CREATE TABLE IF NOT EXISTS playlist_activity (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    playlist_id INTEGER NOT NULL,
+    user_id INTEGER,
+    action TEXT NOT NULL,
+    song_id INTEGER,
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_playlist_members_user ON playlist_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_playlist_activity_playlist ON playlist_activity(playlist_id, id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_songs_mbid;
+DROP INDEX IF EXISTS idx_songs_isrc;
+ALTER TABLE songs DROP COLUMN isrc;
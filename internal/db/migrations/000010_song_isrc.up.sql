@@ -0,0 +1,7 @@
+-- ISRCs read from tags, for matching imported playlists
+ALTER TABLE songs ADD COLUMN isrc TEXT;
+CREATE INDEX IF NOT EXISTS idx_songs_isrc ON songs(isrc);
+CREATE INDEX IF NOT EXISTS idx_songs_mbid ON songs(mbid);
+
+-- Forget fingerprints so the next scan reads the ISRCs of existing songs
+UPDATE songs SET file_mtime = NULL, content_hash = NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE playlists DROP COLUMN mirror_path;
+ALTER TABLE playlists DROP COLUMN source_mtime;
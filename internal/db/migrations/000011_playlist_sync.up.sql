@@ -0,0 +1,5 @@
+-- source_mtime is the mtime (Unix nanoseconds) of an imported playlist's
+-- file when Korus last read or wrote it, for detecting outside edits.
+-- mirror_path is where a user-created playlist was last mirrored to.
+ALTER TABLE playlists ADD COLUMN source_mtime INTEGER;
+ALTER TABLE playlists ADD COLUMN mirror_path TEXT;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS listenbrainz_accounts;
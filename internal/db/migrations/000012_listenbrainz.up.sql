@@ -0,0 +1,14 @@
+-- Per-user ListenBrainz accounts, replacing the single global token
+CREATE TABLE IF NOT EXISTS listenbrainz_accounts (
+    user_id INTEGER PRIMARY KEY,
+    token TEXT NOT NULL,
+    username TEXT NOT NULL,
+    submit_listens INTEGER NOT NULL DEFAULT 1,
+    -- listened_at of the newest imported listen; imports resume from here
+    import_cursor INTEGER NOT NULL DEFAULT 0,
+    last_import_at TIMESTAMP,
+    last_import_count INTEGER NOT NULL DEFAULT 0,
+    last_import_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+-- Per-user Last.fm sessions, obtained through the Last.fm auth flow
+CREATE TABLE IF NOT EXISTS lastfm_accounts (
+    user_id INTEGER PRIMARY KEY,
+    session_key TEXT NOT NULL,
+    username TEXT NOT NULL,
+    scrobble INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,19 @@
+-- Listens waiting to be submitted, one row per listen and destination.
+-- Rows are deleted once submitted; 'failed' rows wait for a manual replay.
+CREATE TABLE IF NOT EXISTS scrobble_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    song_id INTEGER NOT NULL,
+    destination TEXT NOT NULL CHECK (destination IN ('listenbrainz', 'lastfm')),
+    listened_at INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (song_id) REFERENCES songs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_scrobble_outbox_due ON scrobble_outbox(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_scrobble_outbox_user ON scrobble_outbox(user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE songs DROP COLUMN lyrics_fetched_at;
+ALTER TABLE songs DROP COLUMN lyrics_mtime;
+ALTER TABLE songs DROP COLUMN lyrics_source;
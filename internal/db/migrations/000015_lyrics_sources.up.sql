@@ -0,0 +1,9 @@
+-- Where a song's lyrics came from: embedded tags, a sidecar .lrc/.txt file
+-- or a lyrics provider (by name). lyrics_mtime fingerprints the sidecar
+-- files; lyrics_fetched_at records the last provider lookup, including ones
+-- that found nothing.
+ALTER TABLE songs ADD COLUMN lyrics_source TEXT;
+ALTER TABLE songs ADD COLUMN lyrics_mtime INTEGER;
+ALTER TABLE songs ADD COLUMN lyrics_fetched_at TIMESTAMP;
+UPDATE songs SET lyrics_source = 'embedded'
+WHERE COALESCE(lyrics, '') != '' OR COALESCE(lyrics_synced, '') != '';
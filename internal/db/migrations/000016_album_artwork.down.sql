@@ -0,0 +1,4 @@
+ALTER TABLE albums DROP COLUMN cover_blurhash;
+ALTER TABLE albums DROP COLUMN cover_color;
+ALTER TABLE albums DROP COLUMN cover_mtime;
+ALTER TABLE albums DROP COLUMN cover_source;
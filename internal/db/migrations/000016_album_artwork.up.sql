@@ -0,0 +1,8 @@
+-- Where an album's cached cover came from (an image file, or "embedded:"
+-- and the audio file) and that file's mtime, so rescans only re-render
+-- covers whose source changed. The dominant color and blurhash are UI
+-- placeholders.
+ALTER TABLE albums ADD COLUMN cover_source TEXT;
+ALTER TABLE albums ADD COLUMN cover_mtime INTEGER;
+ALTER TABLE albums ADD COLUMN cover_color TEXT;
+ALTER TABLE albums ADD COLUMN cover_blurhash TEXT;
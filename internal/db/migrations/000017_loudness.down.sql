@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_songs_loudness_pending;
+ALTER TABLE songs DROP COLUMN loudness_source;
+ALTER TABLE songs DROP COLUMN album_peak;
+ALTER TABLE songs DROP COLUMN album_loudness;
+ALTER TABLE songs DROP COLUMN track_peak;
+ALTER TABLE songs DROP COLUMN track_loudness;
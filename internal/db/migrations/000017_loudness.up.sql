@@ -0,0 +1,11 @@
+-- Integrated loudness in LUFS and sample peak as linear amplitude, per song
+-- and for the song's album. loudness_source is 'tags' when read from
+-- REPLAYGAIN_* / R128_* tags, 'ebur128' when measured, or 'failed'; songs
+-- without one are waiting for the loudness analysis.
+ALTER TABLE songs ADD COLUMN track_loudness REAL;
+ALTER TABLE songs ADD COLUMN track_peak REAL;
+ALTER TABLE songs ADD COLUMN album_loudness REAL;
+ALTER TABLE songs ADD COLUMN album_peak REAL;
+ALTER TABLE songs ADD COLUMN loudness_source TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_songs_loudness_pending ON songs(album_id) WHERE loudness_source IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_songs_album_disc_track;
+ALTER TABLE songs DROP COLUMN disc_subtitle;
+ALTER TABLE songs DROP COLUMN disc_total;
+ALTER TABLE songs DROP COLUMN disc_number;
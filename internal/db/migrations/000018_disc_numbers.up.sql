@@ -0,0 +1,10 @@
+-- Disc position within multi-disc albums. disc_subtitle is the disc's own
+-- title (DISCSUBTITLE / SETSUBTITLE tags), e.g. "Live at Wembley".
+ALTER TABLE songs ADD COLUMN disc_number INTEGER;
+ALTER TABLE songs ADD COLUMN disc_total INTEGER;
+ALTER TABLE songs ADD COLUMN disc_subtitle TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_songs_album_disc_track ON songs(album_id, disc_number, track_number);
+
+-- Forget fingerprints so the next scan re-reads the tags of existing songs
+UPDATE songs SET file_mtime = NULL, content_hash = NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_artists_mbid;
+DROP INDEX IF EXISTS idx_albums_mbid;
+ALTER TABLE albums DROP COLUMN release_group_mbid;
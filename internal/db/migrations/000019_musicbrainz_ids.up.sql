@@ -0,0 +1,10 @@
+-- MusicBrainz IDs from tags identify albums (release MBID in albums.mbid)
+-- and artists before their titles and names do.
+ALTER TABLE albums ADD COLUMN release_group_mbid TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_albums_mbid ON albums(mbid);
+CREATE INDEX IF NOT EXISTS idx_artists_mbid ON artists(mbid);
+
+-- Forget fingerprints so the next scan reads the IDs of existing songs and
+-- splits releases that were merged by title
+UPDATE songs SET file_mtime = NULL, content_hash = NULL;
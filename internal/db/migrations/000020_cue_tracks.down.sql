@@ -0,0 +1,6 @@
+DELETE FROM songs WHERE source_path IS NOT NULL;
+DROP INDEX IF EXISTS idx_songs_source_path;
+ALTER TABLE songs DROP COLUMN cue_mtime;
+ALTER TABLE songs DROP COLUMN cue_end_ms;
+ALTER TABLE songs DROP COLUMN cue_start_ms;
+ALTER TABLE songs DROP COLUMN source_path;
@@ -0,0 +1,16 @@
+-- Tracks of a single-file album split by a cue sheet. file_path is then a
+-- virtual "<file>#NN" path and source_path the audio file the track is cut
+-- from, between cue_start_ms and cue_end_ms (NULL for the last track, which
+-- runs to the end of the file). cue_mtime is the cue sheet's mtime, so
+-- editing the sheet triggers a rescan.
+ALTER TABLE songs ADD COLUMN source_path TEXT;
+ALTER TABLE songs ADD COLUMN cue_start_ms INTEGER;
+ALTER TABLE songs ADD COLUMN cue_end_ms INTEGER;
+ALTER TABLE songs ADD COLUMN cue_mtime INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_songs_source_path ON songs(source_path) WHERE source_path IS NOT NULL;
+
+-- Embedded cue sheets have no mtime of their own, so forget every file's
+-- fingerprint for the next scan to re-read files that carry one. Both the
+-- mtime and hash go, since a matching hash would otherwise count as unchanged.
+UPDATE songs SET file_mtime = NULL, content_hash = NULL;
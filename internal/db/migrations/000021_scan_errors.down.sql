@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_scan_errors_scan;
+DROP TABLE IF EXISTS scan_errors;
+ALTER TABLE scan_status DROP COLUMN failed;
+ALTER TABLE scan_status DROP COLUMN removed;
+ALTER TABLE scan_status DROP COLUMN updated;
+ALTER TABLE scan_status DROP COLUMN added;
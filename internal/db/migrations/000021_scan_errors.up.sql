@@ -0,0 +1,18 @@
+-- Per-scan summary counts of audio files, and the files a scan failed to
+-- read along with why (see services.ScanError* for the reasons).
+ALTER TABLE scan_status ADD COLUMN added INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scan_status ADD COLUMN updated INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scan_status ADD COLUMN removed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE scan_status ADD COLUMN failed INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS scan_errors (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    scan_id INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (scan_id) REFERENCES scan_status(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_scan_errors_scan ON scan_errors(scan_id, path);
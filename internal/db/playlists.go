@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Aunali321/korus/internal/models"
+)
+
+// Playlist roles. Owners can do everything; editors can rename a playlist
+// and change its songs; viewers can only read it.
+const (
+	PlaylistOwner  = "owner"
+	PlaylistEditor = "editor"
+	PlaylistViewer = "viewer"
+)
+
+var playlistRank = map[string]int{PlaylistViewer: 1, PlaylistEditor: 2, PlaylistOwner: 3}
+
+// PlaylistRoleAtLeast reports whether role grants at least the rights of min
+func PlaylistRoleAtLeast(role, min string) bool {
+	return playlistRank[role] >= playlistRank[min]
+}
+
+// PlaylistVisible returns a SQL condition limiting the playlists aliased as
+// alias to those userID owns, is a member of, or that are public.
+func PlaylistVisible(alias string, userID int64) string {
+	return fmt.Sprintf("(%[1]s.public = 1 OR %[1]s.user_id = %[2]d OR %[1]s.id IN (SELECT playlist_id FROM playlist_members WHERE user_id = %[2]d))", alias, userID)
+}
+
+// PlaylistRoleColumn returns a SQL expression for userID's role on the
+// playlist aliased as alias. Non-members of public playlists are viewers.
+func PlaylistRoleColumn(alias string, userID int64) string {
+	return fmt.Sprintf(`CASE WHEN %[1]s.user_id = %[2]d THEN 'owner'
+		ELSE COALESCE((SELECT role FROM playlist_members WHERE playlist_id = %[1]s.id AND user_id = %[2]d), 'viewer') END`, alias, userID)
+}
+
+// GetPlaylistRole returns userID's role on a playlist, or "" when they cannot
+// read it, and whether the playlist is smart. It returns sql.ErrNoRows when
+// the playlist does not exist.
+func GetPlaylistRole(ctx context.Context, db *sql.DB, playlistID, userID int64) (string, bool, error) {
+	var role string
+	var smart bool
+	err := db.QueryRowContext(ctx, `
+		SELECT CASE WHEN `+PlaylistVisible("p", userID)+` THEN `+PlaylistRoleColumn("p", userID)+` ELSE '' END,
+		       p.rules IS NOT NULL
+		FROM playlists p WHERE p.id = ?
+	`, playlistID).Scan(&role, &smart)
+	return role, smart, err
+}
+
+// GetPlaylistMembers returns the users a playlist is shared with
+func GetPlaylistMembers(ctx context.Context, db *sql.DB, playlistID int64) ([]models.PlaylistMember, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT pm.user_id, u.username, pm.role, pm.created_at
+		FROM playlist_members pm
+		JOIN users u ON u.id = pm.user_id
+		WHERE pm.playlist_id = ?
+		ORDER BY u.username COLLATE NOCASE
+	`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []models.PlaylistMember{}
+	for rows.Next() {
+		var m models.PlaylistMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetPlaylistMember shares a playlist with a user, or changes their role
+func SetPlaylistMember(ctx context.Context, db *sql.DB, playlistID, userID int64, role string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO playlist_members(playlist_id, user_id, role) VALUES (?, ?, ?)
+		ON CONFLICT(playlist_id, user_id) DO UPDATE SET role = excluded.role
+	`, playlistID, userID, role)
+	return err
+}
+
+// RemovePlaylistMember stops sharing a playlist with a user. It returns
+// sql.ErrNoRows when the user was not a member.
+func RemovePlaylistMember(ctx context.Context, db *sql.DB, playlistID, userID int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM playlist_members WHERE playlist_id = ? AND user_id = ?`, playlistID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetPlaylistEntries returns who added each readable song of a playlist, in
+// playlist order
+func GetPlaylistEntries(ctx context.Context, db *sql.DB, access Access, playlistID int64) ([]models.PlaylistEntry, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT ps.song_id, ps.added_by, u.username, ps.added_at
+		FROM playlist_songs ps
+		JOIN songs s ON s.id = ps.song_id
+		LEFT JOIN users u ON u.id = ps.added_by
+		WHERE ps.playlist_id = ? AND `+access.Filter("s")+`
+		ORDER BY ps.position
+	`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []models.PlaylistEntry{}
+	for rows.Next() {
+		var e models.PlaylistEntry
+		var addedBy sql.NullInt64
+		var username sql.NullString
+		var addedAt sql.NullTime
+		if err := rows.Scan(&e.SongID, &addedBy, &username, &addedAt); err != nil {
+			return nil, err
+		}
+		if addedBy.Valid {
+			e.AddedBy = &addedBy.Int64
+			e.AddedByUsername = username.String
+		}
+		if addedAt.Valid {
+			e.AddedAt = &addedAt.Time
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// LogPlaylistActivity appends to a playlist's activity log. songID 0 means
+// the action is not about a song.
+func LogPlaylistActivity(ctx context.Context, db *sql.DB, playlistID, userID int64, action string, songID int64, detail string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO playlist_activity(playlist_id, user_id, action, song_id, detail) VALUES (?, ?, ?, NULLIF(?, 0), ?)
+	`, playlistID, userID, action, songID, detail)
+	return err
+}
+
+// GetPlaylistActivity returns a playlist's activity log, newest first
+func GetPlaylistActivity(ctx context.Context, db *sql.DB, playlistID int64, limit, offset int) ([]models.PlaylistActivity, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT pa.id, pa.user_id, u.username, pa.action, pa.song_id, pa.detail, pa.created_at
+		FROM playlist_activity pa
+		LEFT JOIN users u ON u.id = pa.user_id
+		WHERE pa.playlist_id = ?
+		ORDER BY pa.id DESC
+		LIMIT ? OFFSET ?
+	`, playlistID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	activity := []models.PlaylistActivity{}
+	for rows.Next() {
+		var a models.PlaylistActivity
+		var userID, songID sql.NullInt64
+		var username sql.NullString
+		if err := rows.Scan(&a.ID, &userID, &username, &a.Action, &songID, &a.Detail, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			a.UserID = &userID.Int64
+			a.Username = username.String
+		}
+		if songID.Valid {
+			a.SongID = &songID.Int64
+		}
+		activity = append(activity, a)
+	}
+	return activity, rows.Err()
+}
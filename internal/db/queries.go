@@ -9,8 +9,8 @@ import (
 
 // SongColumns is the standard SELECT columns for songs with artist and album
 // Note: duration_ms is converted to seconds for API compatibility
-const SongColumns = `s.id, s.album_id, s.title, s.track_number, s.duration_ms / 1000 as duration, s.file_path,
-	ar.id, ar.name, al.id, al.title`
+const SongColumns = `s.id, s.album_id, s.title, s.track_number, s.disc_number, s.disc_total, COALESCE(s.disc_subtitle, ''), s.duration_ms / 1000 as duration, s.file_path,
+	ar.id, ar.name, al.id, al.title, s.track_loudness, s.track_peak, s.album_loudness, s.album_peak`
 
 // SongJoins is the standard JOIN clause to get artist and album
 const SongJoins = `LEFT JOIN albums al ON al.id = s.album_id
@@ -19,13 +19,15 @@ const SongJoins = `LEFT JOIN albums al ON al.id = s.album_id
 // ScanSong scans a row into a models.Song with optional artist and album
 func ScanSong(row interface{ Scan(...any) error }) (models.Song, error) {
 	var song models.Song
-	var track, duration sql.NullInt64
+	var track, disc, discTotal, duration sql.NullInt64
 	var artistID, albumID sql.NullInt64
 	var artistName, albumTitle sql.NullString
+	var trackLoudness, trackPeak, albumLoudness, albumPeak sql.NullFloat64
 
 	err := row.Scan(
-		&song.ID, &song.AlbumID, &song.Title, &track, &duration, &song.FilePath,
+		&song.ID, &song.AlbumID, &song.Title, &track, &disc, &discTotal, &song.DiscSubtitle, &duration, &song.FilePath,
 		&artistID, &artistName, &albumID, &albumTitle,
+		&trackLoudness, &trackPeak, &albumLoudness, &albumPeak,
 	)
 	if err != nil {
 		return song, err
@@ -35,6 +37,14 @@ func ScanSong(row interface{ Scan(...any) error }) (models.Song, error) {
 		t := int(track.Int64)
 		song.TrackNumber = &t
 	}
+	if disc.Valid {
+		d := int(disc.Int64)
+		song.DiscNumber = &d
+	}
+	if discTotal.Valid {
+		d := int(discTotal.Int64)
+		song.DiscTotal = &d
+	}
 	if duration.Valid {
 		d := int(duration.Int64)
 		song.Duration = &d
@@ -42,18 +52,39 @@ func ScanSong(row interface{ Scan(...any) error }) (models.Song, error) {
 	if albumID.Valid {
 		song.Album = &models.Album{ID: albumID.Int64, Title: albumTitle.String}
 	}
+	song.ReplayGain = ReplayGain(trackLoudness, trackPeak, albumLoudness, albumPeak)
 
 	return song, nil
 }
 
-// GetSongsByPlaylist returns all songs in a playlist with artist and album info
-func GetSongsByPlaylist(ctx context.Context, db *sql.DB, playlistID int64) ([]models.Song, error) {
+// ReplayGain converts a song's stored loudness and peaks into ReplayGain
+// values, or nil when the song hasn't been measured yet.
+func ReplayGain(trackLoudness, trackPeak, albumLoudness, albumPeak sql.NullFloat64) *models.ReplayGain {
+	if !trackLoudness.Valid {
+		return nil
+	}
+	rg := &models.ReplayGain{TrackGain: models.ReplayGainReference - trackLoudness.Float64}
+	if trackPeak.Valid {
+		rg.TrackPeak = &trackPeak.Float64
+	}
+	if albumLoudness.Valid {
+		gain := models.ReplayGainReference - albumLoudness.Float64
+		rg.AlbumGain = &gain
+	}
+	if albumPeak.Valid {
+		rg.AlbumPeak = &albumPeak.Float64
+	}
+	return rg
+}
+
+// GetSongsByPlaylist returns the readable songs in a playlist with artist and album info
+func GetSongsByPlaylist(ctx context.Context, db *sql.DB, access Access, playlistID int64) ([]models.Song, error) {
 	rows, err := db.QueryContext(ctx, `
 		SELECT `+SongColumns+`
 		FROM playlist_songs ps
 		JOIN songs s ON s.id = ps.song_id
 		`+SongJoins+`
-		WHERE ps.playlist_id = ?
+		WHERE ps.playlist_id = ? AND `+access.Filter("s")+`
 		ORDER BY ps.position
 	`, playlistID)
 	if err != nil {
@@ -64,13 +95,13 @@ func GetSongsByPlaylist(ctx context.Context, db *sql.DB, playlistID int64) ([]mo
 }
 
 // GetSongsByAlbum returns all songs in an album with artist info
-func GetSongsByAlbum(ctx context.Context, db *sql.DB, albumID int64) ([]models.Song, error) {
+func GetSongsByAlbum(ctx context.Context, db *sql.DB, access Access, albumID int64) ([]models.Song, error) {
 	rows, err := db.QueryContext(ctx, `
 		SELECT `+SongColumns+`
 		FROM songs s
 		`+SongJoins+`
-		WHERE s.album_id = ?
-		ORDER BY s.track_number
+		WHERE s.album_id = ? AND `+access.Filter("s")+`
+		ORDER BY COALESCE(s.disc_number, 1), s.track_number
 	`, albumID)
 	if err != nil {
 		return nil, err
@@ -80,14 +111,14 @@ func GetSongsByAlbum(ctx context.Context, db *sql.DB, albumID int64) ([]models.S
 }
 
 // GetSongsByArtist returns all songs by an artist (via album or song_artists)
-func GetSongsByArtist(ctx context.Context, db *sql.DB, artistID int64) ([]models.Song, error) {
+func GetSongsByArtist(ctx context.Context, db *sql.DB, access Access, artistID int64) ([]models.Song, error) {
 	// Get songs where artist is either album artist or in song_artists
 	rows, err := db.QueryContext(ctx, `
 		SELECT DISTINCT `+SongColumns+`
 		FROM songs s
 		`+SongJoins+`
 		LEFT JOIN song_artists sa ON sa.song_id = s.id
-		WHERE al.artist_id = ? OR sa.artist_id = ?
+		WHERE (al.artist_id = ? OR sa.artist_id = ?) AND `+access.Filter("s")+`
 		ORDER BY s.id
 	`, artistID, artistID)
 	if err != nil {
@@ -98,11 +129,12 @@ func GetSongsByArtist(ctx context.Context, db *sql.DB, artistID int64) ([]models
 }
 
 // GetSongsRecent returns the most recent songs with artist and album info
-func GetSongsRecent(ctx context.Context, db *sql.DB, limit int) ([]models.Song, error) {
+func GetSongsRecent(ctx context.Context, db *sql.DB, access Access, limit int) ([]models.Song, error) {
 	rows, err := db.QueryContext(ctx, `
 		SELECT `+SongColumns+`
 		FROM songs s
 		`+SongJoins+`
+		WHERE `+access.Filter("s")+`
 		ORDER BY s.id DESC
 		LIMIT ?
 	`, limit)
@@ -113,14 +145,14 @@ func GetSongsRecent(ctx context.Context, db *sql.DB, limit int) ([]models.Song,
 	return scanSongs(rows)
 }
 
-// GetSongsByFavorites returns favorite songs for a user
-func GetSongsByFavorites(ctx context.Context, db *sql.DB, userID int64) ([]models.Song, error) {
+// GetSongsByFavorites returns a user's favorite songs that are still readable
+func GetSongsByFavorites(ctx context.Context, db *sql.DB, access Access, userID int64) ([]models.Song, error) {
 	rows, err := db.QueryContext(ctx, `
 		SELECT `+SongColumns+`
 		FROM favorites_songs f
 		JOIN songs s ON s.id = f.song_id
 		`+SongJoins+`
-		WHERE f.user_id = ?
+		WHERE f.user_id = ? AND `+access.Filter("s")+`
 		ORDER BY f.created_at DESC
 	`, userID)
 	if err != nil {
@@ -130,6 +162,24 @@ func GetSongsByFavorites(ctx context.Context, db *sql.DB, userID int64) ([]model
 	return scanSongs(rows)
 }
 
+// GetSongsByGenre returns a page of songs tagged with a genre
+func GetSongsByGenre(ctx context.Context, db *sql.DB, access Access, genreID int64, limit, offset int) ([]models.Song, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+SongColumns+`
+		FROM song_genres sg
+		JOIN songs s ON s.id = sg.song_id
+		`+SongJoins+`
+		WHERE sg.genre_id = ? AND `+access.Filter("s")+`
+		ORDER BY al.title, COALESCE(s.disc_number, 1), s.track_number
+		LIMIT ? OFFSET ?
+	`, genreID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSongs(rows)
+}
+
 func scanSongs(rows *sql.Rows) ([]models.Song, error) {
 	var songs []models.Song
 	for rows.Next() {
@@ -143,13 +193,13 @@ func scanSongs(rows *sql.Rows) ([]models.Song, error) {
 }
 
 // GetSongsByRecentPlays returns recently played songs for a user with full song data
-func GetSongsByRecentPlays(ctx context.Context, db *sql.DB, userID int64, limit int) ([]models.Song, error) {
+func GetSongsByRecentPlays(ctx context.Context, db *sql.DB, access Access, userID int64, limit int) ([]models.Song, error) {
 	rows, err := db.QueryContext(ctx, `
 		SELECT `+SongColumns+`
 		FROM play_history ph
 		JOIN songs s ON s.id = ph.song_id
 		`+SongJoins+`
-		WHERE ph.user_id = ?
+		WHERE ph.user_id = ? AND `+access.Filter("s")+`
 		GROUP BY s.id
 		ORDER BY MAX(ph.played_at) DESC
 		LIMIT ?
@@ -162,13 +212,13 @@ func GetSongsByRecentPlays(ctx context.Context, db *sql.DB, userID int64, limit
 }
 
 // GetSongsByTopPlayed returns top played songs for a user with full song data
-func GetSongsByTopPlayed(ctx context.Context, db *sql.DB, userID int64, limit int) ([]models.Song, error) {
+func GetSongsByTopPlayed(ctx context.Context, db *sql.DB, access Access, userID int64, limit int) ([]models.Song, error) {
 	rows, err := db.QueryContext(ctx, `
 		SELECT `+SongColumns+`
 		FROM play_history ph
 		JOIN songs s ON s.id = ph.song_id
 		`+SongJoins+`
-		WHERE ph.user_id = ?
+		WHERE ph.user_id = ? AND `+access.Filter("s")+`
 		GROUP BY s.id
 		ORDER BY COUNT(*) DESC
 		LIMIT ?
@@ -228,3 +278,50 @@ func PopulateSongArtists(ctx context.Context, db *sql.DB, songs []models.Song) e
 	}
 	return nil
 }
+
+// GetGenres returns the genres of readable songs with their song counts,
+// most used first
+func GetGenres(ctx context.Context, db *sql.DB, access Access) ([]models.Genre, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT g.id, g.name, COUNT(s.id)
+		FROM genres g
+		JOIN song_genres sg ON sg.genre_id = g.id
+		JOIN songs s ON s.id = sg.song_id
+		WHERE `+access.Filter("s")+`
+		GROUP BY g.id
+		ORDER BY COUNT(s.id) DESC, g.name COLLATE NOCASE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanGenres(rows)
+}
+
+// GetGenresForSong returns the genres a song is tagged with
+func GetGenresForSong(ctx context.Context, db *sql.DB, songID int64) ([]models.Genre, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT g.id, g.name, (SELECT COUNT(*) FROM song_genres WHERE genre_id = g.id)
+		FROM song_genres sg
+		JOIN genres g ON g.id = sg.genre_id
+		WHERE sg.song_id = ?
+		ORDER BY g.name COLLATE NOCASE
+	`, songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanGenres(rows)
+}
+
+func scanGenres(rows *sql.Rows) ([]models.Genre, error) {
+	genres := []models.Genre{}
+	for rows.Next() {
+		var g models.Genre
+		if err := rows.Scan(&g.ID, &g.Name, &g.SongCount); err != nil {
+			continue
+		}
+		genres = append(genres, g)
+	}
+	return genres, rows.Err()
+}
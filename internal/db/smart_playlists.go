@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aunali321/korus/internal/models"
+)
+
+type smartKind int
+
+const (
+	smartText smartKind = iota
+	smartNumber
+	smartDate
+	smartBool
+)
+
+// smartField maps a rule field to a SQL expression over songs s and albums
+// al. {user} is replaced with the playlist owner's user ID.
+type smartField struct {
+	kind smartKind
+	expr string
+}
+
+var smartFields = map[string]smartField{
+	"artist": {smartText, `(SELECT GROUP_CONCAT(a.name, char(31)) FROM artists a
+		WHERE a.id = al.artist_id OR a.id IN (SELECT artist_id FROM song_artists WHERE song_id = s.id))`},
+	"album":       {smartText, "al.title"},
+	"title":       {smartText, "s.title"},
+	"year":        {smartNumber, "al.year"},
+	"duration":    {smartNumber, "(s.duration_ms / 1000)"},
+	"sample_rate": {smartNumber, "s.sample_rate"},
+	"bit_depth":   {smartNumber, "s.bit_depth"},
+	"play_count":  {smartNumber, "(SELECT COUNT(*) FROM play_history WHERE song_id = s.id AND user_id = {user})"},
+	"last_played": {smartDate, "(SELECT MAX(played_at) FROM play_history WHERE song_id = s.id AND user_id = {user})"},
+	"added":       {smartDate, "s.added_at"},
+	"favorited":   {smartBool, "EXISTS (SELECT 1 FROM favorites_songs WHERE song_id = s.id AND user_id = {user})"},
+}
+
+var smartSorts = map[string]string{
+	"title":       "s.title COLLATE NOCASE",
+	"album":       "al.title COLLATE NOCASE",
+	"year":        "al.year",
+	"duration":    "s.duration_ms",
+	"play_count":  "(SELECT COUNT(*) FROM play_history WHERE song_id = s.id AND user_id = {user})",
+	"last_played": "(SELECT MAX(julianday(played_at)) FROM play_history WHERE song_id = s.id AND user_id = {user})",
+	"added":       "s.added_at",
+	"random":      "RANDOM()",
+}
+
+// ValidateSmartRules reports whether a rule document can be evaluated
+func ValidateSmartRules(rules models.SmartRules) error {
+	_, _, _, err := compileSmartRules(rules, 0)
+	return err
+}
+
+// compileSmartRules turns a rule document into a WHERE condition, an ORDER BY
+// clause and the condition's arguments.
+func compileSmartRules(rules models.SmartRules, userID int64) (string, string, []any, error) {
+	join := " AND "
+	switch rules.Match {
+	case "", "all":
+	case "any":
+		join = " OR "
+	default:
+		return "", "", nil, fmt.Errorf("match must be all or any")
+	}
+	if rules.Limit < 0 {
+		return "", "", nil, fmt.Errorf("limit must not be negative")
+	}
+
+	var conds []string
+	var args []any
+	for i, rule := range rules.Rules {
+		field, ok := smartFields[rule.Field]
+		if !ok {
+			return "", "", nil, fmt.Errorf("rule %d: unknown field %q", i+1, rule.Field)
+		}
+		cond, condArgs, err := compileSmartRule(field, rule)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("rule %d (%s): %w", i+1, rule.Field, err)
+		}
+		conds = append(conds, strings.ReplaceAll(cond, "{user}", strconv.FormatInt(userID, 10)))
+		args = append(args, condArgs...)
+	}
+	where := "1 = 1"
+	if len(conds) > 0 {
+		where = "(" + strings.Join(conds, join) + ")"
+	}
+
+	order := "al.title COLLATE NOCASE, COALESCE(s.disc_number, 1), s.track_number"
+	if rules.Sort != "" {
+		expr, ok := smartSorts[rules.Sort]
+		if !ok {
+			return "", "", nil, fmt.Errorf("unknown sort %q", rules.Sort)
+		}
+		order = strings.ReplaceAll(expr, "{user}", strconv.FormatInt(userID, 10))
+	}
+	switch rules.Order {
+	case "", "asc":
+	case "desc":
+		order += " DESC"
+	default:
+		return "", "", nil, fmt.Errorf("order must be asc or desc")
+	}
+	return where, order + ", s.id", args, nil
+}
+
+func compileSmartRule(field smartField, rule models.SmartRule) (string, []any, error) {
+	expr := field.expr
+	switch field.kind {
+	case smartText:
+		v, ok := rule.Value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("value must be a string")
+		}
+		// Artist names are joined with the unit separator, so wrapping the
+		// value in separators makes "is" match any one of the names.
+		exact := "%\x1f" + EscapeLike(v) + "\x1f%"
+		switch rule.Operator {
+		case "is":
+			return "(char(31) || " + expr + " || char(31)) LIKE ? ESCAPE '\\'", []any{exact}, nil
+		case "is_not":
+			return "(char(31) || COALESCE(" + expr + ", '') || char(31)) NOT LIKE ? ESCAPE '\\'", []any{exact}, nil
+		case "contains":
+			return expr + " LIKE ? ESCAPE '\\'", []any{"%" + EscapeLike(v) + "%"}, nil
+		case "not_contains":
+			return "COALESCE(" + expr + ", '') NOT LIKE ? ESCAPE '\\'", []any{"%" + EscapeLike(v) + "%"}, nil
+		}
+	case smartNumber:
+		if rule.Operator == "between" {
+			bounds, ok := rule.Value.([]any)
+			if !ok || len(bounds) != 2 {
+				return "", nil, fmt.Errorf("between takes a [min, max] array")
+			}
+			lo, ok1 := bounds[0].(float64)
+			hi, ok2 := bounds[1].(float64)
+			if !ok1 || !ok2 {
+				return "", nil, fmt.Errorf("between bounds must be numbers")
+			}
+			return expr + " BETWEEN ? AND ?", []any{lo, hi}, nil
+		}
+		v, ok := rule.Value.(float64)
+		if !ok {
+			return "", nil, fmt.Errorf("value must be a number")
+		}
+		switch rule.Operator {
+		case "is":
+			return expr + " = ?", []any{v}, nil
+		case "is_not":
+			return expr + " != ?", []any{v}, nil
+		case "gt":
+			return expr + " > ?", []any{v}, nil
+		case "lt":
+			return expr + " < ?", []any{v}, nil
+		}
+	case smartDate:
+		switch rule.Operator {
+		case "in_last", "not_in_last":
+			days, ok := rule.Value.(float64)
+			if !ok || days <= 0 {
+				return "", nil, fmt.Errorf("value must be a positive number of days")
+			}
+			since := fmt.Sprintf("-%d days", int(days))
+			if rule.Operator == "in_last" {
+				return "julianday(" + expr + ") >= julianday('now', ?)", []any{since}, nil
+			}
+			return "COALESCE(julianday(" + expr + ") < julianday('now', ?), 1)", []any{since}, nil
+		case "before", "after":
+			v, _ := rule.Value.(string)
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				return "", nil, fmt.Errorf("value must be a YYYY-MM-DD date")
+			}
+			if rule.Operator == "before" {
+				return "julianday(" + expr + ") < julianday(?)", []any{v}, nil
+			}
+			return "julianday(" + expr + ") >= julianday(?, '+1 day')", []any{v}, nil
+		}
+	case smartBool:
+		v, ok := rule.Value.(bool)
+		if !ok {
+			return "", nil, fmt.Errorf("value must be true or false")
+		}
+		if rule.Operator == "is" {
+			if v {
+				return expr, nil, nil
+			}
+			return "NOT " + expr, nil, nil
+		}
+	}
+	return "", nil, fmt.Errorf("unsupported operator %q", rule.Operator)
+}
+
+// EscapeLike escapes LIKE wildcards in s, for patterns using ESCAPE '\'
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// RefreshSmartPlaylist re-evaluates a smart playlist's rules against the
+// songs its owner can read and rewrites its playlist_songs rows. Playlists
+// without rules are left untouched.
+func RefreshSmartPlaylist(ctx context.Context, db *sql.DB, playlistID int64) error {
+	var raw sql.NullString
+	var user models.User
+	err := db.QueryRowContext(ctx, `
+		SELECT p.rules, u.id, u.role FROM playlists p JOIN users u ON u.id = p.user_id WHERE p.id = ?
+	`, playlistID).Scan(&raw, &user.ID, &user.Role)
+	if err != nil || !raw.Valid {
+		return err
+	}
+	var rules models.SmartRules
+	if err := json.Unmarshal([]byte(raw.String), &rules); err != nil {
+		return fmt.Errorf("playlist %d rules: %w", playlistID, err)
+	}
+	where, order, args, err := compileSmartRules(rules, user.ID)
+	if err != nil {
+		return fmt.Errorf("playlist %d rules: %w", playlistID, err)
+	}
+	query := `SELECT s.id FROM songs s JOIN albums al ON al.id = s.album_id
+		WHERE ` + UserAccess(user).Filter("s") + ` AND ` + where + `
+		ORDER BY ` + order
+	if rules.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", rules.Limit)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM playlist_songs WHERE playlist_id = ?`, playlistID); err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO playlist_songs(playlist_id, song_id, position, added_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)`, playlistID, id, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// RefreshSmartPlaylists re-evaluates every smart playlist owned by userID,
+// or all smart playlists when userID is 0. It keeps going past failures and
+// returns the first error.
+func RefreshSmartPlaylists(ctx context.Context, db *sql.DB, userID int64) error {
+	rows, err := db.QueryContext(ctx, `SELECT id FROM playlists WHERE rules IS NOT NULL AND (? = 0 OR user_id = ?)`, userID, userID)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	var firstErr error
+	for _, id := range ids {
+		if err := RefreshSmartPlaylist(ctx, db, id); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Aunali321/korus/internal/models"
+)
+
+func TestCompileSmartRules(t *testing.T) {
+	const defaultOrder = "al.title COLLATE NOCASE, COALESCE(s.disc_number, 1), s.track_number, s.id"
+
+	tests := []struct {
+		name      string
+		rules     string // JSON, as the API receives it
+		wantWhere string
+		wantOrder string
+		wantArgs  []any
+		wantErr   string
+	}{
+		{
+			name:      "no rules",
+			rules:     `{"rules": []}`,
+			wantWhere: "1 = 1",
+			wantOrder: defaultOrder,
+		},
+		{
+			name:      "text contains, escaping wildcards",
+			rules:     `{"rules": [{"field": "title", "operator": "contains", "value": "100%_done"}]}`,
+			wantWhere: `(s.title LIKE ? ESCAPE '\')`,
+			wantOrder: defaultOrder,
+			wantArgs:  []any{`%100\%\_done%`},
+		},
+		{
+			name:      "text is matches one of the joined names",
+			rules:     `{"rules": [{"field": "album", "operator": "is", "value": "Blue"}]}`,
+			wantWhere: `((char(31) || al.title || char(31)) LIKE ? ESCAPE '\')`,
+			wantOrder: defaultOrder,
+			wantArgs:  []any{"%\x1fBlue\x1f%"},
+		},
+		{
+			name:      "numbers joined with any",
+			rules:     `{"match": "any", "rules": [{"field": "year", "operator": "gt", "value": 1990}, {"field": "bit_depth", "operator": "is", "value": 24}]}`,
+			wantWhere: "(al.year > ? OR s.bit_depth = ?)",
+			wantOrder: defaultOrder,
+			wantArgs:  []any{1990.0, 24.0},
+		},
+		{
+			name:      "between",
+			rules:     `{"rules": [{"field": "duration", "operator": "between", "value": [60, 300]}]}`,
+			wantWhere: "((s.duration_ms / 1000) BETWEEN ? AND ?)",
+			wantOrder: defaultOrder,
+			wantArgs:  []any{60.0, 300.0},
+		},
+		{
+			name:      "in the last days, with the owner substituted",
+			rules:     `{"rules": [{"field": "last_played", "operator": "in_last", "value": 30}]}`,
+			wantWhere: "(julianday((SELECT MAX(played_at) FROM play_history WHERE song_id = s.id AND user_id = 7)) >= julianday('now', ?))",
+			wantOrder: defaultOrder,
+			wantArgs:  []any{"-30 days"},
+		},
+		{
+			name:      "after a date",
+			rules:     `{"rules": [{"field": "added", "operator": "after", "value": "2024-01-31"}]}`,
+			wantWhere: "(julianday(s.added_at) >= julianday(?, '+1 day'))",
+			wantOrder: defaultOrder,
+			wantArgs:  []any{"2024-01-31"},
+		},
+		{
+			name:      "not favorited, sorted by play count descending",
+			rules:     `{"rules": [{"field": "favorited", "operator": "is", "value": false}], "sort": "play_count", "order": "desc"}`,
+			wantWhere: "(NOT EXISTS (SELECT 1 FROM favorites_songs WHERE song_id = s.id AND user_id = 7))",
+			wantOrder: "(SELECT COUNT(*) FROM play_history WHERE song_id = s.id AND user_id = 7) DESC, s.id",
+		},
+		{name: "unknown match", rules: `{"match": "some", "rules": []}`, wantErr: "match must be all or any"},
+		{name: "negative limit", rules: `{"rules": [], "limit": -1}`, wantErr: "limit must not be negative"},
+		{name: "unknown field", rules: `{"rules": [{"field": "mood", "operator": "is", "value": "happy"}]}`, wantErr: `rule 1: unknown field "mood"`},
+		{name: "unsupported operator", rules: `{"rules": [{"field": "title", "operator": "gt", "value": "a"}]}`, wantErr: `unsupported operator "gt"`},
+		{name: "number given as a string", rules: `{"rules": [{"field": "year", "operator": "is", "value": "1990"}]}`, wantErr: "value must be a number"},
+		{name: "between without two bounds", rules: `{"rules": [{"field": "year", "operator": "between", "value": [1990]}]}`, wantErr: "between takes a [min, max] array"},
+		{name: "zero days", rules: `{"rules": [{"field": "added", "operator": "in_last", "value": 0}]}`, wantErr: "positive number of days"},
+		{name: "bad date", rules: `{"rules": [{"field": "added", "operator": "before", "value": "31/01/2024"}]}`, wantErr: "YYYY-MM-DD"},
+		{name: "unknown sort", rules: `{"rules": [], "sort": "mood"}`, wantErr: `unknown sort "mood"`},
+		{name: "unknown order", rules: `{"rules": [], "order": "up"}`, wantErr: "order must be asc or desc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules models.SmartRules
+			if err := json.Unmarshal([]byte(tt.rules), &rules); err != nil {
+				t.Fatalf("unmarshal rules: %v", err)
+			}
+			where, order, args, err := compileSmartRules(rules, 7)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("compileSmartRules() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileSmartRules: %v", err)
+			}
+			if where != tt.wantWhere {
+				t.Errorf("where = %s, want %s", where, tt.wantWhere)
+			}
+			if order != tt.wantOrder {
+				t.Errorf("order = %s, want %s", order, tt.wantOrder)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"50%", `50\%`},
+		{"a_b", `a\_b`},
+		{`back\slash`, `back\\slash`},
+	}
+
+	for _, tt := range tests {
+		if got := EscapeLike(tt.in); got != tt.want {
+			t.Errorf("EscapeLike(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -12,3 +12,17 @@ type PlayHistory struct {
 	Source           string    `json:"source,omitempty"`
 	Song             *Song     `json:"song,omitempty"`
 }
+
+// Scrobble is a listen waiting in the outbox to be submitted to ListenBrainz
+// or Last.fm, or one that failed for good.
+type Scrobble struct {
+	ID            int64      `json:"id"`
+	SongID        int64      `json:"song_id"`
+	Title         string     `json:"title"`
+	Destination   string     `json:"destination"`
+	ListenedAt    time.Time  `json:"listened_at"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
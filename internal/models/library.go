@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type Library struct {
+	ID                  int64      `json:"id"`
+	Name                string     `json:"name"`
+	RootPath            string     `json:"root_path,omitempty"`
+	ExcludePattern      string     `json:"exclude_pattern,omitempty"`
+	ScanIntervalMinutes int        `json:"scan_interval_minutes"`
+	AutoGrant           bool       `json:"auto_grant"`
+	LastScannedAt       *time.Time `json:"last_scanned_at,omitempty"`
+	SongCount           int        `json:"song_count"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
@@ -13,26 +13,61 @@ type Artist struct {
 }
 
 type Album struct {
-	ID        int64     `json:"id"`
-	ArtistID  *int64    `json:"artist_id"`
-	Title     string    `json:"title"`
-	Year      *int      `json:"year,omitempty"`
-	CoverPath string    `json:"cover_path,omitempty"`
-	MBID      *string   `json:"mbid,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	Artist    *Artist   `json:"artist,omitempty"`
+	ID            int64     `json:"id"`
+	ArtistID      *int64    `json:"artist_id"`
+	Title         string    `json:"title"`
+	Year          *int      `json:"year,omitempty"`
+	CoverPath     string    `json:"cover_path,omitempty"`
+	CoverColor    string    `json:"cover_color,omitempty"`
+	CoverBlurhash string    `json:"cover_blurhash,omitempty"`
+	MBID          *string   `json:"mbid,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Artist        *Artist   `json:"artist,omitempty"`
+}
+
+type Genre struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	SongCount int    `json:"song_count"`
 }
 
 type Song struct {
-	ID           int64    `json:"id"`
-	AlbumID      int64    `json:"album_id"`
-	Title        string   `json:"title"`
-	TrackNumber  *int     `json:"track_number,omitempty"`
-	Duration     *int     `json:"duration,omitempty"`
-	FilePath     string   `json:"file_path"`
-	Lyrics       string   `json:"lyrics,omitempty"`
-	LyricsSynced string   `json:"lyrics_synced,omitempty"`
-	MBID         *string  `json:"mbid,omitempty"`
-	Album        *Album   `json:"album,omitempty"`
-	Artists      []Artist `json:"artists,omitempty"`
+	ID           int64       `json:"id"`
+	AlbumID      int64       `json:"album_id"`
+	Title        string      `json:"title"`
+	TrackNumber  *int        `json:"track_number,omitempty"`
+	DiscNumber   *int        `json:"disc_number,omitempty"`
+	DiscTotal    *int        `json:"disc_total,omitempty"`
+	DiscSubtitle string      `json:"disc_subtitle,omitempty"`
+	Duration     *int        `json:"duration,omitempty"`
+	FilePath     string      `json:"file_path"`
+	Lyrics       string      `json:"lyrics,omitempty"`
+	LyricsSynced string      `json:"lyrics_synced,omitempty"`
+	MBID         *string     `json:"mbid,omitempty"`
+	ReplayGain   *ReplayGain `json:"replay_gain,omitempty"`
+	Album        *Album      `json:"album,omitempty"`
+	Artists      []Artist    `json:"artists,omitempty"`
+	Genres       []Genre     `json:"genres,omitempty"`
+}
+
+// Disc is one disc of an album with its songs in track order. Songs
+// without a disc number are on disc 1.
+type Disc struct {
+	Number   int    `json:"number"`
+	Subtitle string `json:"subtitle,omitempty"`
+	Songs    []Song `json:"songs"`
+}
+
+// ReplayGainReference is the loudness in LUFS that ReplayGain 2.0 gains
+// bring songs to
+const ReplayGainReference = -18.0
+
+// ReplayGain holds a song's gains in dB relative to ReplayGainReference and
+// its sample peaks as linear amplitude (1.0 is full scale). Album values are
+// missing until the whole album has been measured.
+type ReplayGain struct {
+	TrackGain float64  `json:"track_gain"`
+	TrackPeak *float64 `json:"track_peak,omitempty"`
+	AlbumGain *float64 `json:"album_gain,omitempty"`
+	AlbumPeak *float64 `json:"album_peak,omitempty"`
 }
@@ -13,3 +13,51 @@ type Playlist struct {
 	SongCount   int       `json:"song_count,omitempty"`
 	Songs       []Song    `json:"songs,omitempty"`
 }
+
+// PlaylistMember is a user a playlist is shared with, as viewer or editor
+type PlaylistMember struct {
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PlaylistEntry records who added a song to a playlist and when. AddedBy is
+// nil for songs added by imports, smart rules or since-deleted users.
+type PlaylistEntry struct {
+	SongID          int64      `json:"song_id"`
+	AddedBy         *int64     `json:"added_by,omitempty"`
+	AddedByUsername string     `json:"added_by_username,omitempty"`
+	AddedAt         *time.Time `json:"added_at,omitempty"`
+}
+
+// PlaylistActivity is one change in a playlist's activity log
+type PlaylistActivity struct {
+	ID        int64     `json:"id"`
+	UserID    *int64    `json:"user_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Action    string    `json:"action"`
+	SongID    *int64    `json:"song_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SmartRules is the rule document of a smart playlist. Songs matching all
+// (or, with Match "any", at least one) of the rules are materialized into
+// playlist_songs, sorted by Sort and capped at Limit (0 means no limit).
+type SmartRules struct {
+	Match string      `json:"match,omitempty"`
+	Rules []SmartRule `json:"rules"`
+	Sort  string      `json:"sort,omitempty"`
+	Order string      `json:"order,omitempty"`
+	Limit int         `json:"limit,omitempty"`
+}
+
+// SmartRule compares one song field with Value. Value is a string, number,
+// boolean or, for "between", a two-element array; date rules take a number
+// of days for in_last/not_in_last and a YYYY-MM-DD date for before/after.
+type SmartRule struct {
+	Field    string `json:"field"`
+	Operator string `json:"operator"`
+	Value    any    `json:"value"`
+}
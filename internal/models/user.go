@@ -17,3 +17,21 @@ type Session struct {
 	UserID    int64
 	ExpiresAt time.Time
 }
+
+// ListenBrainzAccount is a user's linked ListenBrainz account. The token is
+// never returned.
+type ListenBrainzAccount struct {
+	Username        string     `json:"username"`
+	SubmitListens   bool       `json:"submit_listens"`
+	Importing       bool       `json:"importing"`
+	LastImportAt    *time.Time `json:"last_import_at,omitempty"`
+	LastImportCount int        `json:"last_import_count"`
+	LastImportError string     `json:"last_import_error,omitempty"`
+}
+
+// LastFMAccount is a user's linked Last.fm account. The session key is never
+// returned.
+type LastFMAccount struct {
+	Username string `json:"username"`
+	Scrobble bool   `json:"scrobble"`
+}
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ArtworkSizes are the thumbnail edge lengths ArtworkService generates
+var ArtworkSizes = []int{64, 256, 512, 1024}
+
+// ArtworkFormats maps the thumbnail formats ArtworkService generates to
+// their file extension.
+var ArtworkFormats = map[string]string{"jpeg": ".jpg", "webp": ".webp"}
+
+// coverMaxSize is the edge length album covers are cached at, and so the
+// largest thumbnail that adds detail.
+const coverMaxSize = 1024
+
+// ArtworkService renders and caches resized copies of album covers and
+// artist images.
+type ArtworkService struct {
+	cacheDir   string
+	ffmpegPath string
+}
+
+func NewArtworkService(cacheDir, ffmpegPath string) *ArtworkService {
+	return &ArtworkService{cacheDir: cacheDir, ffmpegPath: ffmpegPath}
+}
+
+// Variant returns the path of src scaled to fit size x size in format,
+// rendering it on first use and again whenever src is newer than the cached
+// copy. key names the image in the cache, e.g. "album-12".
+func (a *ArtworkService) Variant(ctx context.Context, src, key string, size int, format string) (string, error) {
+	ext, ok := ArtworkFormats[format]
+	if !ok {
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	out := filepath.Join(a.cacheDir, fmt.Sprintf("%s-%d%s", key, size, ext))
+	if cached, err := os.Stat(out); err == nil && !cached.ModTime().Before(info.ModTime()) {
+		return out, nil
+	}
+	if err := os.MkdirAll(a.cacheDir, 0o755); err != nil {
+		return "", err
+	}
+	if err := renderImage(ctx, a.ffmpegPath, src, out, size, format); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// renderImage scales src down to fit size x size, never up, and writes it to
+// out in format. The file is written next to out and renamed into place so
+// concurrent readers never see a partial image.
+func renderImage(ctx context.Context, ffmpegPath, src, out string, size int, format string) error {
+	tmp := out + ".tmp" + ArtworkFormats[format]
+	args := []string{
+		"-y", "-i", src,
+		"-an",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':'min(%d,ih)':force_original_aspect_ratio=decrease", size, size),
+		"-frames:v", "1",
+	}
+	if format == "webp" {
+		args = append(args, "-c:v", "libwebp", "-quality", "80")
+	} else {
+		args = append(args, "-q:v", "2")
+	}
+	args = append(args, tmp)
+	if err := exec.CommandContext(ctx, ffmpegPath, args...).Run(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if info, err := os.Stat(tmp); err != nil || info.Size() == 0 {
+		_ = os.Remove(tmp)
+		return errors.New("no image rendered")
+	}
+	return os.Rename(tmp, out)
+}
+
+// imagePalette returns the dominant color of an image as #rrggbb and its
+// blurhash, for placeholders shown while the image loads.
+func imagePalette(path string) (string, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", "", err
+	}
+	pixels, w, h := samplePixels(img, 32)
+	if len(pixels) == 0 {
+		return "", "", errors.New("empty image")
+	}
+	return dominantColor(pixels), blurhash(pixels, w, h, 4, 3), nil
+}
+
+// samplePixels reads img on a grid of at most n x n points as 8-bit RGB
+func samplePixels(img image.Image, n int) ([][3]uint8, int, int) {
+	b := img.Bounds()
+	w, h := min(n, b.Dx()), min(n, b.Dy())
+	pixels := make([][3]uint8, 0, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h).RGBA()
+			pixels = append(pixels, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8)})
+		}
+	}
+	return pixels, w, h
+}
+
+// dominantColor buckets pixels into 16 levels per channel and averages the
+// fullest bucket.
+func dominantColor(pixels [][3]uint8) string {
+	type bucket struct{ n, r, g, b int }
+	buckets := map[int]*bucket{}
+	var best *bucket
+	for _, p := range pixels {
+		key := int(p[0]>>4)<<8 | int(p[1]>>4)<<4 | int(p[2]>>4)
+		bk := buckets[key]
+		if bk == nil {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.n++
+		bk.r += int(p[0])
+		bk.g += int(p[1])
+		bk.b += int(p[2])
+		if best == nil || bk.n > best.n {
+			best = bk
+		}
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.n, best.g/best.n, best.b/best.n)
+}
+
+const blurhashChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// blurhash encodes a w x h image with nx x ny components, following
+// https://github.com/woltapp/blurhash
+func blurhash(pixels [][3]uint8, w, h, nx, ny int) string {
+	factors := make([][3]float64, 0, nx*ny)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := norm * math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					p := pixels[y*w+x]
+					for c := range f {
+						f[c] += basis * srgbToLinear(p[c])
+					}
+				}
+			}
+			for c := range f {
+				f[c] /= float64(w * h)
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(encode83((nx-1)+(ny-1)*9, 1))
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, f := range factors[1:] {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantised+1) / 166
+		sb.WriteString(encode83(quantised, 1))
+	} else {
+		sb.WriteString(encode83(0, 1))
+	}
+	dc := factors[0]
+	sb.WriteString(encode83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+	for _, f := range factors[1:] {
+		var q [3]int
+		for c, v := range f {
+			q[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		sb.WriteString(encode83(q[0]*19*19+q[1]*19+q[2], 2))
+	}
+	return sb.String()
+}
+
+func encode83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = blurhashChars[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// coverEmbedded in the cover priority stands for art embedded in the audio
+// files; every other entry is an image file name without extension.
+const coverEmbedded = "embedded"
+
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+
+// folderImages returns the image files in dir by lowercased name without
+// extension, so "Cover.JPG" is found as "cover".
+func folderImages(dir string) map[string]string {
+	images := map[string]string{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return images
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.Type().IsRegular() && imageExts[strings.ToLower(ext)] {
+			name := strings.ToLower(strings.TrimSuffix(e.Name(), ext))
+			if _, ok := images[name]; !ok {
+				images[name] = filepath.Join(dir, e.Name())
+			}
+		}
+	}
+	return images
+}
+
+func isImageFile(path string) bool {
+	return imageExts[strings.ToLower(filepath.Ext(path))]
+}
+
+func lowerAll(names []string) []string {
+	res := make([]string, 0, len(names))
+	for _, n := range names {
+		if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// updateAlbumCover picks the album's cover from the images in the song's
+// folder and the song's embedded art, in cover priority order, and caches it
+// with its dominant color and blurhash. The cover is only re-rendered when
+// its source is new or was modified.
+func (s *ScannerService) updateAlbumCover(ctx context.Context, path string, albumID int64) {
+	var source, cover string
+	var sourceMtime int64
+	_ = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(cover_source, ''), COALESCE(cover_mtime, 0), COALESCE(cover_path, '') FROM albums WHERE id = ?
+	`, albumID).Scan(&source, &sourceMtime, &cover)
+	cached := false
+	if cover != "" {
+		_, err := os.Stat(cover)
+		cached = err == nil
+	}
+
+	images := folderImages(filepath.Dir(path))
+	for _, name := range s.coverPriority {
+		src, key := images[name], images[name]
+		if name == coverEmbedded {
+			if !s.scanEmbedded {
+				continue
+			}
+			// Any of the album's songs' art will do
+			if cached && strings.HasPrefix(source, coverEmbedded+":") && source != coverEmbedded+":"+path {
+				return
+			}
+			src, key = path, coverEmbedded+":"+path
+		}
+		if src == "" {
+			continue
+		}
+		info, err := os.Stat(src)
+		if err != nil {
+			continue
+		}
+		if cached && source == key && sourceMtime == info.ModTime().UnixNano() {
+			return
+		}
+		coverPath, err := s.extractCover(ctx, src, albumID)
+		if err != nil {
+			// Most likely a song without embedded art
+			continue
+		}
+		color, hash, err := imagePalette(coverPath)
+		if err != nil {
+			log.Printf("cover palette for album %d: %v", albumID, err)
+		}
+		_, _ = s.db.ExecContext(ctx, `
+			UPDATE albums SET cover_path = ?, cover_source = ?, cover_mtime = ?, cover_color = NULLIF(?, ''), cover_blurhash = NULLIF(?, '')
+			WHERE id = ?
+		`, coverPath, key, info.ModTime().UnixNano(), color, hash, albumID)
+		return
+	}
+}
+
+// updateArtistImage sets the album artist's image from an artist image file
+// (e.g. artist.jpg) in the artist's folder, the parent of the album folder,
+// or in the album folder itself for flat layouts. Images found on disk take
+// precedence over downloaded ones.
+func (s *ScannerService) updateArtistImage(ctx context.Context, path string, libraryID, artistID int64) {
+	if len(s.artistImages) == 0 {
+		return
+	}
+	var root string
+	_ = s.db.QueryRowContext(ctx, `SELECT root_path FROM libraries WHERE id = ?`, libraryID).Scan(&root)
+	albumDir := filepath.Dir(path)
+	var dirs []string
+	// The library root is shared by every artist, so it's nobody's folder
+	if parent := filepath.Dir(albumDir); root != "" && pathWithin(root, parent) && filepath.Clean(parent) != filepath.Clean(root) {
+		dirs = append(dirs, parent)
+	}
+	dirs = append(dirs, albumDir)
+	for _, dir := range dirs {
+		images := folderImages(dir)
+		for _, name := range s.artistImages {
+			if img := images[name]; img != "" {
+				_, _ = s.db.ExecContext(ctx, `UPDATE artists SET image_path = ? WHERE id = ? AND COALESCE(image_path, '') != ?`, img, artistID, img)
+				return
+			}
+		}
+	}
+}
+
+// refreshArtwork re-evaluates the cover and artist image of the library's
+// albums, or only of albums with songs below one of dirs when dirs isn't
+// empty. Songs only update their album's art when they are re-read, so this
+// picks up images added, replaced or removed next to files that didn't
+// change, and fills in covers cached before their colors were recorded.
+func (s *ScannerService) refreshArtwork(ctx context.Context, libraryID int64, dirs []string) {
+	query := `
+		SELECT s.album_id, COALESCE(a.artist_id, 0), MIN(COALESCE(s.source_path, s.file_path))
+		FROM songs s JOIN albums a ON a.id = s.album_id
+		WHERE s.library_id = ?`
+	args := []any{libraryID}
+	if len(dirs) > 0 {
+		var conds []string
+		for _, dir := range dirs {
+			cond, condArgs := underDir("COALESCE(s.source_path, s.file_path)", dir)
+			conds = append(conds, cond)
+			args = append(args, condArgs...)
+		}
+		query += ` AND (` + strings.Join(conds, ` OR `) + `)`
+	}
+	rows, err := s.db.QueryContext(ctx, query+` GROUP BY s.album_id`, args...)
+	if err != nil {
+		log.Printf("scan: artwork: %v", err)
+		return
+	}
+	type albumArt struct {
+		albumID, artistID int64
+		path              string
+	}
+	var albums []albumArt
+	for rows.Next() {
+		var a albumArt
+		if rows.Scan(&a.albumID, &a.artistID, &a.path) == nil {
+			albums = append(albums, a)
+		}
+	}
+	rows.Close()
+
+	for _, a := range albums {
+		if ctx.Err() != nil {
+			return
+		}
+		s.updateAlbumCover(ctx, a.path, a.albumID)
+		if a.artistID != 0 {
+			s.updateArtistImage(ctx, a.path, libraryID, a.artistID)
+		}
+	}
+}
@@ -2,11 +2,15 @@ package services
 
 import (
 	"context"
+	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -48,6 +52,11 @@ func (s *AuthService) Register(ctx context.Context, username, email, password st
 		return models.User{}, Tokens{}, fmt.Errorf("insert user: %w", err)
 	}
 	id, _ := res.LastInsertId()
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_libraries (user_id, library_id) SELECT ?, id FROM libraries WHERE auto_grant = 1
+	`, id); err != nil {
+		return models.User{}, Tokens{}, fmt.Errorf("grant libraries: %w", err)
+	}
 	user := models.User{
 		ID:           id,
 		Username:     username,
@@ -232,3 +241,53 @@ func hashToken(token string) string {
 	sum := sha256.Sum256([]byte(token))
 	return fmt.Sprintf("%x", sum[:])
 }
+
+// CreateSubsonicPassword generates a new app password for Subsonic clients,
+// replacing any previous one. The plain value is returned once.
+func (s *AuthService) CreateSubsonicPassword(ctx context.Context, userID int64) (string, error) {
+	secret := make([]byte, 12)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("subsonic password: %w", err)
+	}
+	password := fmt.Sprintf("%x", secret)
+	if _, err := s.db.ExecContext(ctx, `UPDATE users SET subsonic_password = ? WHERE id = ?`, password, userID); err != nil {
+		return "", fmt.Errorf("store subsonic password: %w", err)
+	}
+	return password, nil
+}
+
+func (s *AuthService) RevokeSubsonicPassword(ctx context.Context, userID int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET subsonic_password = NULL WHERE id = ?`, userID)
+	return err
+}
+
+// AuthenticateSubsonic validates Subsonic credentials against the user's app
+// password, with token auth (t + s) or plain password auth (p). The account
+// password isn't accepted: /rest isn't rate limited like /api/auth, and the
+// random app password can't be guessed the way a chosen one can.
+func (s *AuthService) AuthenticateSubsonic(ctx context.Context, username, password, token, salt string) (models.User, error) {
+	var user models.User
+	var appPassword sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, username, password_hash, email, role, onboarded, created_at, subsonic_password
+		FROM users
+		WHERE username = ?
+	`, username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.Role, &user.Onboarded, &user.CreatedAt, &appPassword)
+	if err != nil {
+		return models.User{}, errors.New("invalid credentials")
+	}
+	if !appPassword.Valid || appPassword.String == "" {
+		return models.User{}, errors.New("no subsonic password set")
+	}
+	if token != "" {
+		sum := md5.Sum([]byte(appPassword.String + salt))
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(token))) != 1 {
+			return models.User{}, errors.New("invalid credentials")
+		}
+		return user, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(appPassword.String), []byte(password)) != 1 {
+		return models.User{}, errors.New("invalid credentials")
+	}
+	return user, nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dhowden/tag"
+)
+
+// cueSheet is the part of a cue sheet describing one audio file
+type cueSheet struct {
+	title     string
+	performer string
+	genre     string
+	tracks    []cueTrack
+}
+
+// cueTrack is one track of a cue sheet. endMs is 0 for the last track,
+// which runs to the end of the file.
+type cueTrack struct {
+	number    int
+	title     string
+	performer string
+	isrc      string
+	startMs   int
+	endMs     int
+}
+
+// cueSong is a cue track being ingested as a song of its own
+type cueSong struct {
+	cueTrack
+	sheet *cueSheet
+	audio audioMetadata
+}
+
+// cueTrackPath is the file_path of a cue track. It only has to be unique;
+// the audio is read from the song's source_path.
+func cueTrackPath(path string, number int) string {
+	return fmt.Sprintf("%s#%02d", path, number)
+}
+
+func isCueFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".cue"
+}
+
+// findCueSheet returns the cue sheet next to an audio file, named like it
+// ("Album.cue") or after it ("Album.flac.cue"), or "" when there is none.
+func findCueSheet(path string) string {
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, candidate := range []string{stem + ".cue", stem + ".CUE", path + ".cue", path + ".CUE"} {
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// cueSheetMtime returns the mtime of an audio file's cue sheet in Unix
+// nanoseconds, or 0 when there is none.
+func cueSheetMtime(path string) int64 {
+	if cue := findCueSheet(path); cue != "" {
+		if info, err := os.Stat(cue); err == nil {
+			return info.ModTime().UnixNano()
+		}
+	}
+	return 0
+}
+
+// readCueSheet returns the cue sheet for an audio file, from a sidecar file
+// or else from an embedded CUESHEET tag. Files without one, or whose sheet
+// lists fewer than two tracks, get an empty sheet and are ingested whole.
+func readCueSheet(path string, meta tag.Metadata) cueSheet {
+	if cue := findCueSheet(path); cue != "" {
+		if b, err := os.ReadFile(cue); err == nil {
+			return parseCueSheet(decodeCueText(b), filepath.Base(path))
+		}
+	}
+	if embedded := textTags(meta)["cuesheet"]; embedded != "" {
+		// The FILE line of an embedded sheet names the original rip
+		return parseCueSheet(embedded, "")
+	}
+	return cueSheet{}
+}
+
+// decodeCueText returns a cue sheet's text. Sheets written by older rippers
+// are often Latin-1 rather than UTF-8.
+func decodeCueText(b []byte) string {
+	text := strings.TrimPrefix(string(b), "\ufeff")
+	if utf8.ValidString(text) {
+		return text
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// parseCueSheet reads the tracks of the FILE named audioBase, compared
+// case-insensitively. A sheet with a single FILE is used whatever that file
+// is called, since rips are often renamed after their sheet was written.
+func parseCueSheet(text, audioBase string) cueSheet {
+	var sheet cueSheet
+	type cueFile struct {
+		name   string
+		tracks []cueTrack
+	}
+	var files []cueFile
+	var track *cueTrack
+	endTrack := func() {
+		if track != nil && track.startMs >= 0 && len(files) > 0 {
+			f := &files[len(files)-1]
+			f.tracks = append(f.tracks, *track)
+		}
+		track = nil
+	}
+
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for sc.Scan() {
+		args := cueArgs(sc.Text())
+		if len(args) == 0 {
+			continue
+		}
+		switch strings.ToUpper(args[0]) {
+		case "FILE":
+			endTrack()
+			if len(args) > 1 {
+				files = append(files, cueFile{name: args[1]})
+			}
+		case "TRACK":
+			endTrack()
+			if len(args) > 2 && strings.EqualFold(args[2], "AUDIO") {
+				n, _ := strconv.Atoi(args[1])
+				track = &cueTrack{number: n, startMs: -1}
+			}
+		case "TITLE":
+			if len(args) > 1 {
+				if track != nil {
+					track.title = args[1]
+				} else if len(files) == 0 {
+					sheet.title = args[1]
+				}
+			}
+		case "PERFORMER":
+			if len(args) > 1 {
+				if track != nil {
+					track.performer = args[1]
+				} else if len(files) == 0 {
+					sheet.performer = args[1]
+				}
+			}
+		case "ISRC":
+			if track != nil && len(args) > 1 {
+				track.isrc = args[1]
+			}
+		case "INDEX":
+			if track != nil && len(args) > 2 && args[1] == "01" {
+				if ms, ok := parseCueTime(args[2]); ok {
+					track.startMs = ms
+				}
+			}
+		case "REM":
+			if len(args) > 2 && strings.EqualFold(args[1], "GENRE") && track == nil {
+				sheet.genre = args[2]
+			}
+		}
+	}
+	endTrack()
+
+	for _, f := range files {
+		if audioBase == "" || len(files) == 1 || strings.EqualFold(filepath.Base(f.name), audioBase) {
+			sheet.tracks = f.tracks
+			break
+		}
+	}
+	if len(sheet.tracks) < 2 {
+		return cueSheet{}
+	}
+	for i := range sheet.tracks {
+		t := &sheet.tracks[i]
+		if t.title == "" {
+			t.title = fmt.Sprintf("Track %02d", t.number)
+		}
+		if i+1 < len(sheet.tracks) {
+			t.endMs = sheet.tracks[i+1].startMs
+		}
+	}
+	return sheet
+}
+
+// cueArgs splits a cue sheet line into its command and arguments, keeping
+// quoted arguments whole.
+func cueArgs(line string) []string {
+	var args []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				args = append(args, line[1:])
+				break
+			}
+			args = append(args, line[1:end+1])
+			line = strings.TrimSpace(line[end+2:])
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			args = append(args, line)
+			break
+		}
+		args = append(args, line[:end])
+		line = strings.TrimSpace(line[end:])
+	}
+	return args
+}
+
+// parseCueTime converts an mm:ss:ff index, with 75 frames per second, to
+// milliseconds.
+func parseCueTime(s string) (int, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	var v [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		v[i] = n
+	}
+	return (v[0]*60+v[1])*1000 + v[2]*1000/75, true
+}
+
+// cueFileSongs returns the audio files a cue sheet describes: those it is
+// named after and those its FILE lines name.
+func cueFileSongs(path string) []string {
+	dir := filepath.Dir(path)
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var files []string
+	seen := map[string]bool{}
+	add := func(f string) {
+		if !seen[f] && isAudioFile(f) {
+			if info, err := os.Stat(f); err == nil && info.Mode().IsRegular() {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	add(filepath.Join(dir, stem))
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())) == stem {
+			add(filepath.Join(dir, e.Name()))
+		}
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		sc := bufio.NewScanner(strings.NewReader(decodeCueText(b)))
+		for sc.Scan() {
+			if args := cueArgs(sc.Text()); len(args) > 1 && strings.EqualFold(args[0], "FILE") {
+				add(filepath.Join(dir, filepath.Base(args[1])))
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCueSheet(t *testing.T) {
+	const album = `REM GENRE "Progressive Rock"
+PERFORMER "The Band"
+TITLE "The Album"
+FILE "The Album.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "Opening"
+    ISRC GBAYE0000001
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Second Song"
+    PERFORMER "The Band feat. Guest"
+    INDEX 00 03:58:00
+    INDEX 01 04:00:37
+  TRACK 03 AUDIO
+    INDEX 01 09:30:74
+`
+	albumTracks := []cueTrack{
+		{number: 1, title: "Opening", isrc: "GBAYE0000001", startMs: 0, endMs: 240493},
+		{number: 2, title: "Second Song", performer: "The Band feat. Guest", startMs: 240493, endMs: 570986},
+		{number: 3, title: "Track 03", startMs: 570986},
+	}
+
+	const twoFiles = `FILE "Disc 1.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "One"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Two"
+    INDEX 01 01:00:00
+FILE "Disc 2.flac" WAVE
+  TRACK 03 AUDIO
+    TITLE "Three"
+    INDEX 01 00:00:00
+  TRACK 04 AUDIO
+    TITLE "Four"
+    INDEX 01 02:00:00
+`
+
+	tests := []struct {
+		name      string
+		text      string
+		audioBase string
+		want      cueSheet
+	}{
+		{
+			name:      "sidecar",
+			text:      album,
+			audioBase: "The Album.flac",
+			want:      cueSheet{title: "The Album", performer: "The Band", genre: "Progressive Rock", tracks: albumTracks},
+		},
+		{
+			name:      "single file renamed since ripping",
+			text:      album,
+			audioBase: "renamed.flac",
+			want:      cueSheet{title: "The Album", performer: "The Band", genre: "Progressive Rock", tracks: albumTracks},
+		},
+		{
+			name: "embedded",
+			text: album,
+			want: cueSheet{title: "The Album", performer: "The Band", genre: "Progressive Rock", tracks: albumTracks},
+		},
+		{
+			name:      "second of several files, matched case-insensitively",
+			text:      twoFiles,
+			audioBase: "disc 2.FLAC",
+			want: cueSheet{tracks: []cueTrack{
+				{number: 3, title: "Three", startMs: 0, endMs: 120000},
+				{number: 4, title: "Four", startMs: 120000},
+			}},
+		},
+		{
+			name:      "file not in the sheet",
+			text:      twoFiles,
+			audioBase: "Disc 3.flac",
+			want:      cueSheet{},
+		},
+		{
+			name: "single track",
+			text: "FILE \"a.flac\" WAVE\n  TRACK 01 AUDIO\n    INDEX 01 00:00:00\n",
+			want: cueSheet{},
+		},
+		{
+			name: "data tracks and tracks without INDEX 01 skipped",
+			text: "FILE \"a.bin\" BINARY\n  TRACK 01 MODE1/2352\n    INDEX 01 00:00:00\n  TRACK 02 AUDIO\n    INDEX 01 00:10:00\n  TRACK 03 AUDIO\n    INDEX 00 00:20:00\n  TRACK 04 AUDIO\n    INDEX 01 00:30:00\n",
+			want: cueSheet{tracks: []cueTrack{
+				{number: 2, title: "Track 02", startMs: 10000, endMs: 30000},
+				{number: 4, title: "Track 04", startMs: 30000},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCueSheet(tt.text, tt.audioBase); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCueSheet() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCueTime(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   int
+		wantOK bool
+	}{
+		{"00:00:00", 0, true},
+		{"04:00:37", 240493, true},
+		{"74:59:74", 4499986, true},
+		{"1:2:3", 62040, true},
+		{"00:00", 0, false},
+		{"00:-1:00", 0, false},
+		{"aa:00:00", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseCueTime(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseCueTime(%q) = %d, %v, want %d, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCueArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`  TRACK 01 AUDIO`, []string{"TRACK", "01", "AUDIO"}},
+		{`TITLE "Song, With Spaces"`, []string{"TITLE", "Song, With Spaces"}},
+		{"FILE\t\"a b.flac\"\tWAVE", []string{"FILE", "a b.flac", "WAVE"}},
+		{`TITLE "unterminated`, []string{"TITLE", "unterminated"}},
+		{`TITLE ""`, []string{"TITLE", ""}},
+		{"   ", nil},
+	}
+
+	for _, tt := range tests {
+		if got := cueArgs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("cueArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
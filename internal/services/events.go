@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
+)
+
+// Event types published on the EventBus
+const (
+	EventScanStatus      = "scan.status"      // Data is a ScanStatus; only admins see its current file
+	EventSongsAdded      = "songs.added"      // Data is a SongsEvent
+	EventSongsRemoved    = "songs.removed"    // Data is a SongsEvent
+	EventPlaylistCreated = "playlist.created" // Data is a PlaylistEvent
+	EventPlaylistUpdated = "playlist.updated"
+	EventPlaylistDeleted = "playlist.deleted"
+	EventFavoriteAdded   = "favorite.added" // Data is a FavoriteEvent
+	EventFavoriteRemoved = "favorite.removed"
+	EventPlayerState     = "player_state.updated" // Data is the saved player state
+)
+
+// eventBuffer is how far a subscriber may fall behind before events to it
+// are dropped
+const eventBuffer = 64
+
+// Event is something that changed in the library. Its audience is every
+// user when none of UserIDs, LibraryID, PlaylistID and Admins is set;
+// otherwise it is the listed users, the users who can read the library, those
+// who can see the playlist and, with Admins, admins. Users outside the
+// audience are sent Redacted in place of Data when it is set, and nothing
+// otherwise.
+type Event struct {
+	Type       string  `json:"type"`
+	Data       any     `json:"data"`
+	UserIDs    []int64 `json:"-"`
+	LibraryID  int64   `json:"-"`
+	PlaylistID int64   `json:"-"`
+	Admins     bool    `json:"-"`
+	Redacted   any     `json:"-"`
+}
+
+// SongsEvent lists songs added to or removed from a library
+type SongsEvent struct {
+	LibraryID int64   `json:"library_id"`
+	SongIDs   []int64 `json:"song_ids"`
+}
+
+// PlaylistEvent names a playlist that was created, edited or deleted
+type PlaylistEvent struct {
+	ID int64 `json:"id"`
+}
+
+// FavoriteEvent is a song, album or artist a user starred or unstarred
+type FavoriteEvent struct {
+	Kind string `json:"kind"`
+	ID   int64  `json:"id"`
+}
+
+// EventBus fans events out to the /api/events streams in this process.
+// Publishing never blocks: a subscriber that isn't keeping up misses events
+// and is expected to refetch what it shows.
+type EventBus struct {
+	db     *sql.DB
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+func NewEventBus(db *sql.DB) *EventBus {
+	return &EventBus{db: db, subs: map[chan Event]struct{}{}}
+}
+
+// Publish sends ev to every subscriber. Publishing on a nil bus does
+// nothing, so services built without one needn't check.
+func (b *EventBus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on
+// and a function that ends the subscription. The channel is closed when the
+// bus is.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	b.mu.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subs[ch] = struct{}{}
+	}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Close closes every subscriber's channel, ending the event streams so the
+// server can shut down.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		close(ch)
+		delete(b.subs, ch)
+	}
+}
+
+// Payload returns the data user is sent for ev, and false when user isn't
+// sent ev at all. Library and playlist access is checked when the event is
+// delivered, so grants and shares made while a stream is open apply to it.
+func (b *EventBus) Payload(ctx context.Context, ev Event, user models.User) (any, bool) {
+	if b.inAudience(ctx, ev, user) {
+		return ev.Data, true
+	}
+	return ev.Redacted, ev.Redacted != nil
+}
+
+func (b *EventBus) inAudience(ctx context.Context, ev Event, user models.User) bool {
+	if len(ev.UserIDs) == 0 && ev.LibraryID == 0 && ev.PlaylistID == 0 && !ev.Admins {
+		return true
+	}
+	if slices.Contains(ev.UserIDs, user.ID) {
+		return true
+	}
+	if (ev.Admins || ev.LibraryID != 0) && db.UserAccess(user).All {
+		return true
+	}
+	if ev.LibraryID != 0 {
+		var ok int
+		if b.db.QueryRowContext(ctx, `SELECT 1 FROM user_libraries WHERE user_id = ? AND library_id = ?`, user.ID, ev.LibraryID).Scan(&ok) == nil {
+			return true
+		}
+	}
+	if ev.PlaylistID != 0 {
+		if role, _, err := db.GetPlaylistRole(ctx, b.db, ev.PlaylistID, user.ID); err == nil && role != "" {
+			return true
+		}
+	}
+	return false
+}
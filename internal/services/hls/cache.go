@@ -9,10 +9,14 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// tmpSuffix marks cache files still being written
+const tmpSuffix = ".tmp"
+
 type CacheEntry struct {
 	Path       string
 	Size       int64
@@ -60,6 +64,12 @@ func (c *Cache) loadExisting() error {
 			return err
 		}
 
+		// Left behind by a write the process didn't live to finish
+		if strings.HasSuffix(path, tmpSuffix) {
+			os.Remove(path)
+			return nil
+		}
+
 		info, err := d.Info()
 		if err != nil {
 			return nil
@@ -104,6 +114,20 @@ func (c *Cache) SegmentKey(trackID int64, format string, bitrate int, segmentNum
 	return hex.EncodeToString(hash[:16])
 }
 
+// TranscodeKey names a whole track transcoded to a standalone file
+func (c *Cache) TranscodeKey(trackID int64, format string, bitrate int) string {
+	data := fmt.Sprintf("%d:%s:%d:transcode", trackID, format, bitrate)
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:16])
+}
+
+// GaplessKey names the measured delay and padding of an HLS rendition
+func (c *Cache) GaplessKey(trackID int64, format string, bitrate int) string {
+	data := fmt.Sprintf("%d:%s:%d:gapless", trackID, format, bitrate)
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:16])
+}
+
 func (c *Cache) Get(key string) ([]byte, bool) {
 	c.mu.RLock()
 	entry, exists := c.entries[key]
@@ -153,13 +177,26 @@ func (c *Cache) GetPath(key string) (string, bool) {
 	return entry.Path, true
 }
 
+// Put stores data under key. The file is written under a temporary name and
+// renamed into place, so an interrupted write never leaves a truncated entry.
 func (c *Cache) Put(key string, data []byte, ext string) error {
 	path := filepath.Join(c.dir, key+ext)
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	tmp := path + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write cache file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
 		return fmt.Errorf("write cache file: %w", err)
 	}
 
+	c.add(key, path, int64(len(data)))
+	return nil
+}
+
+func (c *Cache) add(key string, path string, size int64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -169,15 +206,72 @@ func (c *Cache) Put(key string, data []byte, ext string) error {
 
 	c.entries[key] = &CacheEntry{
 		Path:       path,
-		Size:       int64(len(data)),
+		Size:       size,
 		AccessTime: time.Now(),
 		CreateTime: time.Now(),
 	}
-	c.currentSize += int64(len(data))
+	c.currentSize += size
+}
+
+// CacheFile is an entry being written a piece at a time. It goes to a
+// temporary file of its own, so concurrent writers of a key don't collide,
+// and Commit renames it into place.
+type CacheFile struct {
+	cache *Cache
+	key   string
+	path  string
+	file  *os.File
+	size  int64
+	err   error
+}
+
+// Create starts writing the entry for key
+func (c *Cache) Create(key string, ext string) (*CacheFile, error) {
+	f, err := os.CreateTemp(c.dir, key+"-*"+ext+tmpSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("create cache file: %w", err)
+	}
+	return &CacheFile{cache: c, key: key, path: filepath.Join(c.dir, key+ext), file: f}, nil
+}
+
+// Write appends p to the entry. It never fails, so a cache that can't be
+// written doesn't interrupt whatever is being copied into it alongside; the
+// first error is returned by Commit instead.
+func (f *CacheFile) Write(p []byte) (int, error) {
+	if f.err == nil {
+		n, err := f.file.Write(p)
+		f.size += int64(n)
+		f.err = err
+	}
+	return len(p), nil
+}
 
+// Commit finishes the entry and makes it visible under its key
+func (f *CacheFile) Commit() error {
+	tmp := f.file.Name()
+	if err := f.file.Chmod(0644); err != nil && f.err == nil {
+		f.err = err
+	}
+	if err := f.file.Close(); err != nil && f.err == nil {
+		f.err = err
+	}
+	if f.err == nil {
+		f.err = os.Rename(tmp, f.path)
+	}
+	if f.err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write cache file: %w", f.err)
+	}
+	f.cache.add(f.key, f.path, f.size)
 	return nil
 }
 
+// Abort discards the entry
+func (f *CacheFile) Abort() {
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
 func (c *Cache) PutFile(key string, srcPath string, ext string) error {
 	data, err := os.ReadFile(srcPath)
 	if err != nil {
@@ -0,0 +1,73 @@
+package hls
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCacheFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		commit bool
+		want   string
+	}{
+		{name: "committed", writes: []string{"ID3", "frames"}, commit: true, want: "ID3frames"},
+		{name: "committed empty", commit: true, want: ""},
+		{name: "aborted", writes: []string{"ID3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cache, err := NewCache(CacheConfig{Dir: dir, MaxSizeMB: 1})
+			if err != nil {
+				t.Fatalf("NewCache: %v", err)
+			}
+
+			f, err := cache.Create("key", ".mp3")
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			for _, w := range tt.writes {
+				if n, err := f.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if _, ok := cache.GetPath("key"); ok {
+				t.Fatalf("entry visible before it was committed")
+			}
+
+			if tt.commit {
+				if err := f.Commit(); err != nil {
+					t.Fatalf("Commit: %v", err)
+				}
+			} else {
+				f.Abort()
+			}
+
+			data, ok := cache.Get("key")
+			if ok != tt.commit {
+				t.Fatalf("Get found entry = %v, want %v", ok, tt.commit)
+			}
+			if ok && string(data) != tt.want {
+				t.Errorf("Get = %q, want %q", data, tt.want)
+			}
+
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("ReadDir: %v", err)
+			}
+			wantFiles := 0
+			if tt.commit {
+				wantFiles = 1
+			}
+			if len(files) != wantFiles {
+				t.Errorf("cache dir holds %d files, want %d", len(files), wantFiles)
+			}
+			if tt.commit && files[0].Name() != "key.mp3" {
+				t.Errorf("cache file = %s, want key.mp3", files[0].Name())
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package hls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Gapless describes the samples an encoder adds around a track: priming
+// before the audio and padding filling out its last frame. Players trim both
+// to join tracks without a gap.
+type Gapless struct {
+	SampleRate   int   `json:"sample_rate"`
+	EncoderDelay int   `json:"encoder_delay"`
+	Padding      int   `json:"padding"`
+	Samples      int64 `json:"samples"` // audio samples, excluding delay and padding
+	// ITunSMPB is the same for AAC and MP3 in the form iTunes tags it
+	ITunSMPB string `json:"itunsmpb,omitempty"`
+	// Measured is set when the values were read from the encoded output,
+	// rather than assumed from the encoder
+	Measured bool `json:"measured"`
+}
+
+// encoderPriming returns the priming samples and frame size of the encoder
+// ffmpeg uses for format, in HLS segments or, when standalone is set, in a
+// whole-track transcode. Segments carry MP3 as AAC (see encoderArgs), which
+// primes 1024 samples; a standalone MP3 is LAME's, whose 576 samples of
+// priming are followed by the 529-sample delay of every MP3 decoder. libopus
+// primes 312 samples at 48 kHz; FLAC and ALAC add none.
+func encoderPriming(format string, standalone bool) (delay, frame int) {
+	switch format {
+	case "flac", "alac":
+		return 0, 0
+	case "opus":
+		return 312, 960
+	case "mp3":
+		if standalone {
+			return 576 + 529, 1152
+		}
+	}
+	return 1024, 1024
+}
+
+// newGapless fills in the iTunSMPB value for the formats iTunes tags
+func newGapless(format string, rate, delay, padding int, samples int64, measured bool) Gapless {
+	g := Gapless{SampleRate: rate, EncoderDelay: delay, Padding: padding, Samples: samples, Measured: measured}
+	if format != "opus" && format != "flac" && format != "alac" {
+		g.ITunSMPB = fmt.Sprintf(" 00000000 %08X %08X %016X 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000",
+			g.EncoderDelay, g.Padding, g.Samples)
+	}
+	return g
+}
+
+// estimateGapless works out the delay and padding of req from its encoder's
+// priming and frame size, taking the decoded audio to fill whole frames
+func (r SegmentRequest) estimateGapless(standalone bool) Gapless {
+	rate := r.SampleRate
+	if rate <= 0 {
+		rate = 44100
+	}
+	delay, frame := encoderPriming(r.Format, standalone)
+	if r.Format == "opus" {
+		rate = 48000
+	}
+	samples := int64(r.DurationMs-r.OffsetMs) * int64(rate) / 1000
+	if samples < 0 {
+		samples = 0
+	}
+	padding := 0
+	if frame > 0 {
+		padding = int((int64(frame) - (int64(delay)+samples)%int64(frame)) % int64(frame))
+	}
+	return newGapless(r.Format, rate, delay, padding, samples, false)
+}
+
+// TranscodeGapless estimates the delay and padding of a whole-track
+// transcode of req, as sent by progressive streams and downloads. These are
+// standalone files ffmpeg writes to a pipe, so they can't be measured the
+// way HLS segments are.
+func (r SegmentRequest) TranscodeGapless() Gapless {
+	return r.estimateGapless(true)
+}
+
+// measureGapless reads the delay and padding of an HLS rendition from what
+// ffmpeg produced: the delay from the edit list ffmpeg wrote into init, or
+// the encoder's priming when it wrote none, and the padding from how far
+// total, the length of all the segments' samples in the track's timescale,
+// runs past the delay and the track. It returns init with an edit list
+// trimming both, which players honour to play the rendition gaplessly.
+func measureGapless(req SegmentRequest, init []byte, total int64) (Gapless, []byte, error) {
+	mdhd, ok := mp4Find(init, "moov", "trak", "mdia", "mdhd")
+	if !ok {
+		return Gapless{}, nil, errors.New("init segment has no mdhd")
+	}
+	rate, ok := mp4Timescale(init, mdhd)
+	if !ok || rate == 0 {
+		return Gapless{}, nil, errors.New("init segment has no timescale")
+	}
+	primed, frame := encoderPriming(req.Format, false)
+	delay, ok := mp4EditStart(init)
+	if !ok {
+		delay = int64(primed)
+	}
+	if total < delay {
+		return Gapless{}, nil, fmt.Errorf("segments hold %d samples, fewer than the %d of delay", total, delay)
+	}
+
+	samples := total - delay
+	if frame > 0 {
+		// Lossy encoders pad their last frame, which only the track's
+		// length tells apart from audio
+		samples = min(samples, int64(req.DurationMs)*int64(rate)/1000)
+	}
+	patched, err := mp4WithEditList(init, delay, samples)
+	if err != nil {
+		return Gapless{}, nil, err
+	}
+	return newGapless(req.Format, int(rate), int(delay), int(total-delay-samples), samples, true), patched, nil
+}
+
+// Gapless returns the delay and padding of req's HLS rendition, as measured
+// when its segments were generated, generating them first if needed
+func (g *Generator) Gapless(ctx context.Context, req SegmentRequest) (Gapless, error) {
+	key := g.cache.GaplessKey(req.TrackID, req.variant(), req.Bitrate)
+	var gapless Gapless
+	if data, ok := g.cache.Get(key); ok && json.Unmarshal(data, &gapless) == nil {
+		return gapless, nil
+	}
+	if err := g.GenerateAllSegments(ctx, req); err != nil {
+		return Gapless{}, err
+	}
+	if data, ok := g.cache.Get(key); ok && json.Unmarshal(data, &gapless) == nil {
+		return gapless, nil
+	}
+	return Gapless{}, errors.New("gapless info not found after generation")
+}
@@ -0,0 +1,266 @@
+package hls
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// mp4TestBox builds a box of typ around the concatenated payloads
+func mp4TestBox(typ string, payloads ...[]byte) []byte {
+	var body []byte
+	for _, p := range payloads {
+		body = append(body, p...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func u32s(vs ...uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// testInit builds an fMP4 init segment with the given movie and track
+// timescales and default sample duration. edts, when set, goes after tkhd.
+func testInit(movieScale, mediaScale, defaultDuration uint32, edts []byte) []byte {
+	trak := [][]byte{mp4TestBox("tkhd", make([]byte, 84))}
+	if edts != nil {
+		trak = append(trak, edts)
+	}
+	trak = append(trak, mp4TestBox("mdia",
+		mp4TestBox("mdhd", u32s(0, 0, 0, mediaScale, 0, 0)),
+		mp4TestBox("hdlr", make([]byte, 25)),
+	))
+	return append(mp4TestBox("ftyp", []byte("iso5"), u32s(512)), mp4TestBox("moov",
+		mp4TestBox("mvhd", u32s(0, 0, 0, movieScale, 0), make([]byte, 80)),
+		mp4TestBox("trak", trak...),
+		mp4TestBox("mvex", mp4TestBox("trex", u32s(0, 1, 1, defaultDuration, 0, 0))),
+	)...)
+}
+
+// testElst builds an edts box with version 0 entries of segment duration and
+// media time
+func testElst(entries ...[2]int32) []byte {
+	body := u32s(0, uint32(len(entries)))
+	for _, e := range entries {
+		body = append(body, u32s(uint32(e[0]), uint32(e[1]), 0x00010000)...)
+	}
+	return mp4TestBox("edts", mp4TestBox("elst", body))
+}
+
+// testSegment builds a media segment of one fragment whose trun holds count
+// samples, with a duration of their own when durations is set
+func testSegment(tfhdDuration uint32, count uint32, durations []uint32) []byte {
+	tfhd := u32s(0, 1)
+	if tfhdDuration > 0 {
+		tfhd = u32s(0x08, 1, tfhdDuration)
+	}
+	trun := u32s(0x01, count, 0)
+	if durations != nil {
+		trun = u32s(0x301, count, 0)
+		for _, d := range durations {
+			trun = append(trun, u32s(d, 100)...)
+		}
+	}
+	return append(mp4TestBox("moof",
+		mp4TestBox("mfhd", u32s(0, 1)),
+		mp4TestBox("traf", mp4TestBox("tfhd", tfhd), mp4TestBox("trun", trun)),
+	), mp4TestBox("mdat", make([]byte, 16))...)
+}
+
+func TestMP4FragmentDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		seg  []byte
+		def  uint32
+		want int64
+	}{
+		{name: "trex default", seg: testSegment(0, 45, nil), def: 1024, want: 45 * 1024},
+		{name: "tfhd default", seg: testSegment(960, 10, nil), def: 1024, want: 9600},
+		{name: "per-sample durations", seg: testSegment(960, 3, []uint32{4096, 4096, 1234}), def: 1024, want: 9426},
+		{name: "no fragments", seg: mp4TestBox("styp", []byte("msdh")), def: 1024, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mp4FragmentDuration(tt.seg, tt.def)
+			if err != nil {
+				t.Fatalf("mp4FragmentDuration: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("mp4FragmentDuration() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := mp4FragmentDuration(testSegment(0, 3, []uint32{1})[:60], 1024); err == nil {
+		t.Errorf("truncated segment: want an error")
+	}
+	if got := mp4DefaultDuration(testInit(1000, 44100, 1024, nil)); got != 1024 {
+		t.Errorf("mp4DefaultDuration() = %d, want 1024", got)
+	}
+}
+
+func TestMeasureGapless(t *testing.T) {
+	tests := []struct {
+		name         string
+		req          SegmentRequest
+		init         []byte
+		total        int64
+		want         Gapless
+		wantDuration uint64 // of the edit, in the movie timescale
+	}{
+		{
+			name:         "aac without an edit list",
+			req:          SegmentRequest{Format: "aac", DurationMs: 1000},
+			init:         testInit(1000, 44100, 1024, nil),
+			total:        45 * 1024,
+			want:         Gapless{SampleRate: 44100, EncoderDelay: 1024, Padding: 956, Samples: 44100},
+			wantDuration: 1000,
+		},
+		{
+			name:         "mp3 is sent as aac",
+			req:          SegmentRequest{Format: "mp3", DurationMs: 1000},
+			init:         testInit(1000, 48000, 1024, nil),
+			total:        48 * 1024,
+			want:         Gapless{SampleRate: 48000, EncoderDelay: 1024, Padding: 128, Samples: 48000},
+			wantDuration: 1000,
+		},
+		{
+			name:         "delay read from ffmpeg's edit list, past an empty edit",
+			req:          SegmentRequest{Format: "aac", DurationMs: 1000},
+			init:         testInit(1000, 44100, 1024, testElst([2]int32{10, -1}, [2]int32{0, 2048})),
+			total:        46 * 1024,
+			want:         Gapless{SampleRate: 44100, EncoderDelay: 2048, Padding: 956, Samples: 44100},
+			wantDuration: 1000,
+		},
+		{
+			name:         "opus at 48 kHz",
+			req:          SegmentRequest{Format: "opus", DurationMs: 500},
+			init:         testInit(48000, 48000, 960, testElst([2]int32{0, 312})),
+			total:        26 * 960,
+			want:         Gapless{SampleRate: 48000, EncoderDelay: 312, Padding: 648, Samples: 24000},
+			wantDuration: 24000,
+		},
+		{
+			name:         "flac length comes from the segments",
+			req:          SegmentRequest{Format: "flac", DurationMs: 1000},
+			init:         testInit(1000, 44100, 4096, nil),
+			total:        44123,
+			want:         Gapless{SampleRate: 44100, Samples: 44123},
+			wantDuration: 1000,
+		},
+		{
+			name:         "segments shorter than the track",
+			req:          SegmentRequest{Format: "aac", DurationMs: 2000},
+			init:         testInit(1000, 44100, 1024, nil),
+			total:        45 * 1024,
+			want:         Gapless{SampleRate: 44100, EncoderDelay: 1024, Samples: 45056},
+			wantDuration: 1021,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, patched, err := measureGapless(tt.req, tt.init, tt.total)
+			if err != nil {
+				t.Fatalf("measureGapless: %v", err)
+			}
+			if !got.Measured {
+				t.Errorf("Measured = false, want true")
+			}
+			if got.SampleRate != tt.want.SampleRate || got.EncoderDelay != tt.want.EncoderDelay || got.Padding != tt.want.Padding || got.Samples != tt.want.Samples {
+				t.Errorf("measureGapless() = %+v, want %+v", got, tt.want)
+			}
+
+			// The patched init parses, with exactly one edit list holding
+			// one edit
+			if _, err := mp4Boxes(patched, 0, len(patched)); err != nil {
+				t.Fatalf("patched init: %v", err)
+			}
+			trak, _ := mp4Find(patched, "moov", "trak")
+			children, err := mp4Boxes(patched, trak.body, trak.end)
+			if err != nil {
+				t.Fatalf("patched trak: %v", err)
+			}
+			if len(children) != 3 || children[0].typ != "tkhd" || children[1].typ != "edts" {
+				t.Fatalf("patched trak holds %v, want tkhd, edts, mdia", children)
+			}
+			if _, ok := mp4Find(patched, "moov", "mvex", "trex"); !ok {
+				t.Errorf("patched init lost its trex")
+			}
+			elst, _ := mp4Find(patched, "moov", "trak", "edts", "elst")
+			if count := binary.BigEndian.Uint32(patched[elst.body+4:]); count != 1 {
+				t.Fatalf("edit list holds %d edits, want 1", count)
+			}
+			if d := binary.BigEndian.Uint64(patched[elst.body+8:]); d != tt.wantDuration {
+				t.Errorf("edit duration = %d, want %d", d, tt.wantDuration)
+			}
+			if start, ok := mp4EditStart(patched); !ok || start != int64(tt.want.EncoderDelay) {
+				t.Errorf("edit start = %d, %v, want %d", start, ok, tt.want.EncoderDelay)
+			}
+		})
+	}
+
+	if _, _, err := measureGapless(SegmentRequest{Format: "aac", DurationMs: 1000}, testInit(1000, 44100, 1024, nil), 512); err == nil {
+		t.Errorf("segments shorter than the delay: want an error")
+	}
+	if _, _, err := measureGapless(SegmentRequest{Format: "aac"}, mp4TestBox("ftyp", []byte("iso5")), 1024); err == nil {
+		t.Errorf("init without moov: want an error")
+	}
+}
+
+func TestTranscodeGapless(t *testing.T) {
+	tests := []struct {
+		name string
+		req  SegmentRequest
+		want Gapless
+		smpb bool
+	}{
+		{
+			name: "mp3 is encoded with lame",
+			req:  SegmentRequest{Format: "mp3", DurationMs: 1000, SampleRate: 44100},
+			want: Gapless{SampleRate: 44100, EncoderDelay: 1105, Padding: 875, Samples: 44100},
+			smpb: true,
+		},
+		{
+			name: "aac",
+			req:  SegmentRequest{Format: "aac", DurationMs: 1000, SampleRate: 44100},
+			want: Gapless{SampleRate: 44100, EncoderDelay: 1024, Padding: 956, Samples: 44100},
+			smpb: true,
+		},
+		{
+			name: "opus resamples to 48 kHz",
+			req:  SegmentRequest{Format: "opus", DurationMs: 1000, SampleRate: 44100},
+			want: Gapless{SampleRate: 48000, EncoderDelay: 312, Padding: 648, Samples: 48000},
+		},
+		{
+			name: "flac adds nothing",
+			req:  SegmentRequest{Format: "flac", DurationMs: 1000, SampleRate: 96000},
+			want: Gapless{SampleRate: 96000, Samples: 96000},
+		},
+		{
+			name: "from an offset",
+			req:  SegmentRequest{Format: "aac", DurationMs: 1500, OffsetMs: 500},
+			want: Gapless{SampleRate: 44100, EncoderDelay: 1024, Padding: 956, Samples: 44100},
+			smpb: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.req.TranscodeGapless()
+			if got.SampleRate != tt.want.SampleRate || got.EncoderDelay != tt.want.EncoderDelay || got.Padding != tt.want.Padding || got.Samples != tt.want.Samples {
+				t.Errorf("TranscodeGapless() = %+v, want %+v", got, tt.want)
+			}
+			if got.Measured {
+				t.Errorf("Measured = true, want false")
+			}
+			if (got.ITunSMPB != "") != tt.smpb {
+				t.Errorf("ITunSMPB = %q, want one: %v", got.ITunSMPB, tt.smpb)
+			}
+		})
+	}
+}
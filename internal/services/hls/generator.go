@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
@@ -23,6 +25,8 @@ type Generator struct {
 	cache           *Cache
 	generating      map[string]*sync.Mutex
 	genMu           sync.Mutex
+	// running counts ffmpeg processes, so shutdown can wait for them
+	running sync.WaitGroup
 }
 
 type GeneratorConfig struct {
@@ -50,6 +54,61 @@ type SegmentRequest struct {
 	SampleRate int
 	BitDepth   int
 	Channels   int
+	// Normalize is "track" or "album" when GainDB, the ReplayGain for that
+	// mode, is applied while transcoding
+	Normalize string
+	GainDB    float64
+	// StartMs and EndMs cut a cue track out of SourcePath; EndMs is 0 when
+	// the track runs to the end of the file
+	StartMs int
+	EndMs   int
+	// OffsetMs starts a whole-track transcode that far into the track
+	OffsetMs int
+}
+
+// inputArgs opens the request's source, seeking to the cue track in it and
+// then by OffsetMs
+func (r SegmentRequest) inputArgs() []string {
+	var args []string
+	if seek := r.StartMs + r.OffsetMs; seek > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", float64(seek)/1000))
+	}
+	if r.EndMs > 0 {
+		args = append(args, "-to", fmt.Sprintf("%.3f", float64(r.EndMs)/1000))
+	}
+	return append(args, "-i", r.SourcePath)
+}
+
+// variant names the rendition in cache keys. Normalized renditions include
+// their gain, so they're regenerated when a song's loudness is remeasured.
+func (r SegmentRequest) variant() string {
+	if r.Normalize == "" {
+		return r.Format
+	}
+	return fmt.Sprintf("%s+%s%.2f", r.Format, r.Normalize, r.GainDB)
+}
+
+// run runs an ffmpeg command, counting it as running until it exits
+func (g *Generator) run(cmd *exec.Cmd) error {
+	g.running.Add(1)
+	defer g.running.Done()
+	return cmd.Run()
+}
+
+// Wait waits for running ffmpeg processes to exit, or for ctx to be done.
+// They are killed when the contexts of the requests that started them end.
+func (g *Generator) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (g *Generator) getGenerationLock(key string) *sync.Mutex {
@@ -66,16 +125,19 @@ func (g *Generator) getGenerationLock(key string) *sync.Mutex {
 }
 
 func (g *Generator) trackKey(req SegmentRequest) string {
-	return fmt.Sprintf("%d:%s:%d", req.TrackID, req.Format, req.Bitrate)
+	return fmt.Sprintf("%d:%s:%d", req.TrackID, req.variant(), req.Bitrate)
 }
 
 // GenerateAllSegments generates all segments for a track at once using ffmpeg's HLS muxer
 func (g *Generator) GenerateAllSegments(ctx context.Context, req SegmentRequest) error {
 	trackKey := g.trackKey(req)
-	cacheKey := g.cache.InitKey(req.TrackID, req.Format, req.Bitrate)
+	cacheKey := g.cache.InitKey(req.TrackID, req.variant(), req.Bitrate)
+	gaplessKey := g.cache.GaplessKey(req.TrackID, req.variant(), req.Bitrate)
 
-	// Check if already generated
-	if g.cache.Has(cacheKey) {
+	// Check if already generated. Renditions cached before gapless info
+	// was recorded are generated again, for their init segment to get an
+	// edit list.
+	if g.cache.Has(cacheKey) && g.cache.Has(gaplessKey) {
 		return nil
 	}
 
@@ -85,7 +147,7 @@ func (g *Generator) GenerateAllSegments(ctx context.Context, req SegmentRequest)
 	defer mu.Unlock()
 
 	// Double-check after acquiring lock
-	if g.cache.Has(cacheKey) {
+	if g.cache.Has(cacheKey) && g.cache.Has(gaplessKey) {
 		return nil
 	}
 
@@ -101,10 +163,7 @@ func (g *Generator) GenerateAllSegments(ctx context.Context, req SegmentRequest)
 	playlistPath := filepath.Join(tmpDir, "playlist.m3u8")
 	segmentPattern := filepath.Join(tmpDir, "segment%d.m4s")
 
-	args := []string{
-		"-i", req.SourcePath,
-		"-vn",
-	}
+	args := append(req.inputArgs(), "-vn")
 
 	args = append(args, g.codecArgs(req)...)
 
@@ -123,7 +182,7 @@ func (g *Generator) GenerateAllSegments(ctx context.Context, req SegmentRequest)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := g.run(cmd); err != nil {
 		slog.Error("HLS generation failed",
 			"track_id", req.TrackID,
 			"format", req.Format,
@@ -138,22 +197,11 @@ func (g *Generator) GenerateAllSegments(ctx context.Context, req SegmentRequest)
 	if err != nil {
 		return fmt.Errorf("read playlist: %w", err)
 	}
-
-	// Cache the ffmpeg-generated manifest (we'll transform URLs later)
-	manifestKey := g.cache.ManifestKey(req.TrackID, req.Format, req.Bitrate)
-	if err := g.cache.Put(manifestKey, playlistData, ".m3u8"); err != nil {
-		slog.Warn("failed to cache manifest", "error", err)
-	}
-
-	// Read and cache init segment
 	initPath := filepath.Join(tmpDir, "init.mp4")
 	initData, err := os.ReadFile(initPath)
 	if err != nil {
 		return fmt.Errorf("read init segment: %w", err)
 	}
-	if err := g.cache.Put(cacheKey, initData, ".mp4"); err != nil {
-		slog.Warn("failed to cache init segment", "error", err)
-	}
 
 	// Read and cache all segments
 	files, err := filepath.Glob(filepath.Join(tmpDir, "segment*.m4s"))
@@ -161,6 +209,8 @@ func (g *Generator) GenerateAllSegments(ctx context.Context, req SegmentRequest)
 		return fmt.Errorf("glob segments: %w", err)
 	}
 
+	defaultDuration := mp4DefaultDuration(initData)
+	var total int64
 	for _, segPath := range files {
 		// Extract segment number from filename
 		base := filepath.Base(segPath)
@@ -176,12 +226,45 @@ func (g *Generator) GenerateAllSegments(ctx context.Context, req SegmentRequest)
 			slog.Warn("failed to read segment", "segment", segNum, "error", err)
 			continue
 		}
-		segKey := g.cache.SegmentKey(req.TrackID, req.Format, req.Bitrate, segNum)
+		if d, err := mp4FragmentDuration(segData, defaultDuration); err == nil {
+			total += d
+		} else {
+			slog.Warn("failed to read segment duration", "segment", segNum, "error", err)
+		}
+		segKey := g.cache.SegmentKey(req.TrackID, req.variant(), req.Bitrate, segNum)
 		if err := g.cache.Put(segKey, segData, ".m4s"); err != nil {
 			slog.Warn("failed to cache segment", "segment", segNum, "error", err)
 		}
 	}
 
+	// Cache the ffmpeg-generated manifest (we'll transform URLs later)
+	manifestKey := g.cache.ManifestKey(req.TrackID, req.variant(), req.Bitrate)
+	if err := g.cache.Put(manifestKey, playlistData, ".m3u8"); err != nil {
+		slog.Warn("failed to cache manifest", "error", err)
+	}
+
+	// Trim the encoder's priming and padding with an edit list in the init
+	// segment. If the output can't be measured, the estimate is recorded
+	// instead so the rendition isn't generated again for it.
+	gapless, patched, err := measureGapless(req, initData, total)
+	if err != nil {
+		slog.Warn("failed to measure gapless info", "track_id", req.TrackID, "format", req.Format, "error", err)
+		gapless = req.estimateGapless(false)
+	} else {
+		initData = patched
+	}
+	if data, err := json.Marshal(gapless); err == nil {
+		if err := g.cache.Put(gaplessKey, data, ".json"); err != nil {
+			slog.Warn("failed to cache gapless info", "error", err)
+		}
+	}
+
+	// The init segment marks the track as generated, so it goes last: a
+	// generation cut short by shutdown is redone rather than half served
+	if err := g.cache.Put(cacheKey, initData, ".mp4"); err != nil {
+		slog.Warn("failed to cache init segment", "error", err)
+	}
+
 	slog.Info("HLS segments generated",
 		"track_id", req.TrackID,
 		"format", req.Format,
@@ -193,7 +276,7 @@ func (g *Generator) GenerateAllSegments(ctx context.Context, req SegmentRequest)
 }
 
 func (g *Generator) GenerateInitSegment(ctx context.Context, req SegmentRequest) ([]byte, error) {
-	cacheKey := g.cache.InitKey(req.TrackID, req.Format, req.Bitrate)
+	cacheKey := g.cache.InitKey(req.TrackID, req.variant(), req.Bitrate)
 
 	if data, ok := g.cache.Get(cacheKey); ok {
 		slog.Debug("init segment cache hit", "track_id", req.TrackID, "format", req.Format)
@@ -214,7 +297,7 @@ func (g *Generator) GenerateInitSegment(ctx context.Context, req SegmentRequest)
 }
 
 func (g *Generator) GenerateSegment(ctx context.Context, req SegmentRequest) ([]byte, error) {
-	cacheKey := g.cache.SegmentKey(req.TrackID, req.Format, req.Bitrate, req.SegmentNum)
+	cacheKey := g.cache.SegmentKey(req.TrackID, req.variant(), req.Bitrate, req.SegmentNum)
 
 	if data, ok := g.cache.Get(cacheKey); ok {
 		slog.Debug("segment cache hit", "track_id", req.TrackID, "segment", req.SegmentNum)
@@ -235,28 +318,24 @@ func (g *Generator) GenerateSegment(ctx context.Context, req SegmentRequest) ([]
 }
 
 func (g *Generator) codecArgs(req SegmentRequest) []string {
+	if req.GainDB != 0 {
+		return append([]string{"-af", fmt.Sprintf("volume=%.2fdB", req.GainDB)}, g.encoderArgs(req)...)
+	}
+	return g.encoderArgs(req)
+}
+
+func (g *Generator) encoderArgs(req SegmentRequest) []string {
+	bitrate := fmt.Sprintf("%dk", lossyBitrate(req.Format, req.Bitrate))
 	switch req.Format {
 	case "mp3":
-		bitrate := req.Bitrate
-		if bitrate == 0 {
-			bitrate = 320
-		}
 		// MP3 in fMP4 isn't well supported, use AAC instead
-		return []string{"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", bitrate)}
+		return []string{"-c:a", "aac", "-b:a", bitrate}
 
 	case "aac":
-		bitrate := req.Bitrate
-		if bitrate == 0 {
-			bitrate = 256
-		}
-		return []string{"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", bitrate)}
+		return []string{"-c:a", "aac", "-b:a", bitrate}
 
 	case "opus":
-		bitrate := req.Bitrate
-		if bitrate == 0 {
-			bitrate = 256
-		}
-		return []string{"-c:a", "libopus", "-b:a", fmt.Sprintf("%dk", bitrate)}
+		return []string{"-c:a", "libopus", "-b:a", bitrate}
 
 	case "flac":
 		return []string{"-strict", "-2", "-c:a", "flac"}
@@ -270,7 +349,7 @@ func (g *Generator) codecArgs(req SegmentRequest) []string {
 }
 
 func (g *Generator) GenerateManifest(ctx context.Context, req SegmentRequest, token string) ([]byte, error) {
-	manifestKey := g.cache.ManifestKey(req.TrackID, req.Format, req.Bitrate)
+	manifestKey := g.cache.ManifestKey(req.TrackID, req.variant(), req.Bitrate)
 
 	// Check if we have cached manifest
 	if data, ok := g.cache.Get(manifestKey); ok {
@@ -296,7 +375,7 @@ func (g *Generator) transformManifest(data []byte, req SegmentRequest, token str
 	var sb strings.Builder
 	scanner := bufio.NewScanner(bytes.NewReader(data))
 
-	params := buildTransformParams(req.Format, req.Bitrate, token)
+	params := buildTransformParams(req.Format, req.Bitrate, req.Normalize, token)
 	segmentRegex := regexp.MustCompile(`^segment(\d+)\.m4s$`)
 
 	for scanner.Scan() {
@@ -320,7 +399,7 @@ func (g *Generator) transformManifest(data []byte, req SegmentRequest, token str
 	return []byte(sb.String())
 }
 
-func buildTransformParams(format string, bitrate int, token string) string {
+func buildTransformParams(format string, bitrate int, normalize string, token string) string {
 	var params []string
 
 	if format != "" {
@@ -329,6 +408,9 @@ func buildTransformParams(format string, bitrate int, token string) string {
 	if bitrate > 0 {
 		params = append(params, fmt.Sprintf("bitrate=%d", bitrate))
 	}
+	if normalize != "" {
+		params = append(params, fmt.Sprintf("normalize=%s", normalize))
+	}
 	if token != "" {
 		params = append(params, fmt.Sprintf("token=%s", token))
 	}
@@ -340,15 +422,21 @@ func buildTransformParams(format string, bitrate int, token string) string {
 	return "?" + strings.Join(params, "&")
 }
 
-func (g *Generator) Transcode(ctx context.Context, sourcePath string, format string, bitrate int) ([]byte, error) {
-	args := []string{
-		"-i", sourcePath,
-		"-vn",
-	}
+// transcodeArgs writes req to stdout as a standalone file rather than fMP4
+// segments, so MP3 can stay MP3
+func (g *Generator) transcodeArgs(req SegmentRequest) []string {
+	args := append(req.inputArgs(), "-vn")
 
-	args = append(args, g.codecArgs(SegmentRequest{Format: format, Bitrate: bitrate})...)
+	if req.Format == "mp3" {
+		if req.GainDB != 0 {
+			args = append(args, "-af", fmt.Sprintf("volume=%.2fdB", req.GainDB))
+		}
+		args = append(args, "-c:a", "libmp3lame", "-b:a", fmt.Sprintf("%dk", lossyBitrate(req.Format, req.Bitrate)))
+	} else {
+		args = append(args, g.codecArgs(req)...)
+	}
 
-	switch format {
+	switch req.Format {
 	case "mp3":
 		args = append(args, "-f", "mp3")
 	case "aac":
@@ -358,33 +446,86 @@ func (g *Generator) Transcode(ctx context.Context, sourcePath string, format str
 	case "flac":
 		args = append(args, "-f", "flac")
 	case "alac":
-		args = append(args, "-f", "ipod")
+		// A pipe can't be seeked back to write the index at the end, so
+		// write it up front and fragment the audio
+		args = append(args, "-movflags", "+empty_moov", "-frag_duration", "1000000", "-f", "ipod")
 	default:
 		args = append(args, "-f", "mp3")
 	}
 
-	args = append(args, "-")
+	return append(args, "-")
+}
 
-	cmd := exec.CommandContext(ctx, g.ffmpegPath, args...)
+// transcodeExt is the extension of a cached whole-track transcode
+func transcodeExt(format string) string {
+	switch format {
+	case "aac":
+		return ".aac"
+	case "alac":
+		return ".m4a"
+	case "opus", "flac":
+		return "." + format
+	default:
+		return ".mp3"
+	}
+}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
+// Transcode converts a whole song to req.Format, adjusting its volume by
+// req.GainDB when non-zero.
+func (g *Generator) Transcode(ctx context.Context, req SegmentRequest) ([]byte, error) {
+	var out bytes.Buffer
+	if err := g.TranscodeStream(ctx, req, &out); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// TranscodeStream writes req, transcoded, to w as ffmpeg produces it. A
+// transcode of the whole track, from no offset, is written to the cache
+// alongside and kept once it completes; CachedTranscode returns it from then
+// on.
+func (g *Generator) TranscodeStream(ctx context.Context, req SegmentRequest, w io.Writer) error {
+	cmd := exec.CommandContext(ctx, g.ffmpegPath, g.transcodeArgs(req)...)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("transcode failed: %w, stderr: %s", err, stderr.String())
+	var cached *CacheFile
+	if req.OffsetMs == 0 {
+		key := g.cache.TranscodeKey(req.TrackID, req.variant(), req.Bitrate)
+		var err error
+		if cached, err = g.cache.Create(key, transcodeExt(req.Format)); err != nil {
+			slog.Warn("failed to cache transcode", "track_id", req.TrackID, "error", err)
+		} else {
+			cmd.Stdout = io.MultiWriter(cached, w)
+		}
 	}
 
-	return stdout.Bytes(), nil
-}
+	if err := g.run(cmd); err != nil {
+		if cached != nil {
+			cached.Abort()
+		}
+		return fmt.Errorf("transcode failed: %w, stderr: %s", err, stderr.String())
+	}
 
-func (g *Generator) TranscodeToFile(ctx context.Context, sourcePath string, format string, bitrate int, outputPath string) error {
-	args := []string{
-		"-i", sourcePath,
-		"-vn",
+	if cached != nil {
+		if err := cached.Commit(); err != nil {
+			slog.Warn("failed to cache transcode", "track_id", req.TrackID, "error", err)
+		}
 	}
+	return nil
+}
 
-	args = append(args, g.codecArgs(SegmentRequest{Format: format, Bitrate: bitrate})...)
+// CachedTranscode returns the file of a completed whole-track transcode
+func (g *Generator) CachedTranscode(req SegmentRequest) (string, bool) {
+	return g.cache.GetPath(g.cache.TranscodeKey(req.TrackID, req.variant(), req.Bitrate))
+}
+
+func (g *Generator) TranscodeToFile(ctx context.Context, req SegmentRequest, outputPath string) error {
+	args := append(req.inputArgs(), "-vn")
+
+	args = append(args, g.codecArgs(req)...)
 	args = append(args, "-y", outputPath)
 
 	cmd := exec.CommandContext(ctx, g.ffmpegPath, args...)
@@ -392,7 +533,7 @@ func (g *Generator) TranscodeToFile(ctx context.Context, sourcePath string, form
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := g.run(cmd); err != nil {
 		return fmt.Errorf("transcode failed: %w, stderr: %s", err, stderr.String())
 	}
 
@@ -0,0 +1,90 @@
+package hls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Variant is one rendition offered by a master playlist
+type Variant struct {
+	Format  string
+	Bitrate int // kbps; 0 for lossless formats or the encoder's default
+}
+
+// DefaultVariants are offered when the client doesn't pick its own: AAC at
+// two bitrates for constrained connections, and lossless FLAC.
+var DefaultVariants = []Variant{{"aac", 128}, {"aac", 256}, {"flac", 0}}
+
+// lossyBitrate returns the bitrate in kbps format is encoded at when the
+// request doesn't set one. Lossless formats return 0.
+func lossyBitrate(format string, bitrate int) int {
+	if bitrate != 0 {
+		return bitrate
+	}
+	switch format {
+	case "mp3":
+		return 320
+	case "flac", "alac":
+		return 0
+	default:
+		return 256
+	}
+}
+
+// Codec returns the RFC 6381 codec of the variant's fMP4 segments. MP3 is
+// sent as AAC, see encoderArgs.
+func (v Variant) Codec() string {
+	switch v.Format {
+	case "flac":
+		return "fLaC"
+	case "alac":
+		return "alac"
+	case "opus":
+		return "Opus"
+	default:
+		return "mp4a.40.2"
+	}
+}
+
+// Bandwidth returns the variant's peak and average bits per second. Lossy
+// variants allow 10% over the nominal bitrate for VBR and fMP4 overhead.
+// Lossless ones are bounded by the source's PCM rate and have no average,
+// since it depends on how well the audio compresses.
+func (v Variant) Bandwidth(sampleRate, bitDepth, channels int) (peak, average int) {
+	if kbps := lossyBitrate(v.Format, v.Bitrate); kbps > 0 {
+		return kbps * 1100, kbps * 1000
+	}
+	if sampleRate <= 0 {
+		sampleRate = 44100
+	}
+	if bitDepth <= 0 {
+		bitDepth = 16
+	}
+	if channels <= 0 {
+		channels = 2
+	}
+	return sampleRate * bitDepth * channels, 0
+}
+
+// MasterPlaylist lists variants of a track, in the order given, as links to
+// their media playlists next to it. Nothing is transcoded until a player
+// picks a variant and fetches its manifest.m3u8. The sample rate, bit depth
+// and channels are the source's, used to size lossless variants.
+func MasterPlaylist(variants []Variant, sampleRate, bitDepth, channels int, normalize, token string) []byte {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:7\n")
+	sb.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for _, v := range variants {
+		peak, average := v.Bandwidth(sampleRate, bitDepth, channels)
+		sb.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d", peak))
+		if average > 0 {
+			sb.WriteString(fmt.Sprintf(",AVERAGE-BANDWIDTH=%d", average))
+		}
+		sb.WriteString(fmt.Sprintf(",CODECS=\"%s\"\n", v.Codec()))
+		sb.WriteString("manifest.m3u8" + buildTransformParams(v.Format, v.Bitrate, normalize, token) + "\n")
+	}
+
+	return []byte(sb.String())
+}
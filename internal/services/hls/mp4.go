@@ -0,0 +1,292 @@
+package hls
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// mp4Box is a box found in an MP4 buffer. start is where its header begins,
+// body where its payload does and end where it stops, all offsets into the
+// buffer it was read from.
+type mp4Box struct {
+	typ   string
+	start int
+	body  int
+	end   int
+}
+
+var errBadBox = errors.New("malformed mp4 box")
+
+// mp4Boxes lists the boxes between off and end of b
+func mp4Boxes(b []byte, off, end int) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for off < end {
+		if end-off < 8 {
+			return nil, errBadBox
+		}
+		size := int(binary.BigEndian.Uint32(b[off:]))
+		header := 8
+		switch size {
+		case 0:
+			size = end - off
+		case 1:
+			if end-off < 16 {
+				return nil, errBadBox
+			}
+			size = int(binary.BigEndian.Uint64(b[off+8:]))
+			header = 16
+		}
+		if size < header || size > end-off {
+			return nil, errBadBox
+		}
+		boxes = append(boxes, mp4Box{typ: string(b[off+4 : off+8]), start: off, body: off + header, end: off + size})
+		off += size
+	}
+	return boxes, nil
+}
+
+// mp4Find follows path from the top level of b, taking the first box of
+// each type
+func mp4Find(b []byte, path ...string) (mp4Box, bool) {
+	box := mp4Box{end: len(b)}
+	for _, typ := range path {
+		children, err := mp4Boxes(b, box.body, box.end)
+		if err != nil {
+			return mp4Box{}, false
+		}
+		found := false
+		for _, c := range children {
+			if c.typ == typ {
+				box, found = c, true
+				break
+			}
+		}
+		if !found {
+			return mp4Box{}, false
+		}
+	}
+	return box, true
+}
+
+// mp4Timescale reads the timescale of an mvhd or mdhd box
+func mp4Timescale(b []byte, box mp4Box) (uint32, bool) {
+	off := box.body + 12
+	if box.body < box.end && b[box.body] == 1 {
+		off = box.body + 20
+	}
+	if off+4 > box.end {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b[off:]), true
+}
+
+// mp4EditStart returns the media time the first track's edit list starts
+// playing from, in the track's timescale: the encoder delay it skips. An
+// empty edit, which delays playback rather than skipping media, is passed
+// over.
+func mp4EditStart(init []byte) (int64, bool) {
+	elst, ok := mp4Find(init, "moov", "trak", "edts", "elst")
+	if !ok || elst.end-elst.body < 8 {
+		return 0, false
+	}
+	version := init[elst.body]
+	count := int(binary.BigEndian.Uint32(init[elst.body+4:]))
+	entry := 12
+	if version == 1 {
+		entry = 20
+	}
+	off := elst.body + 8
+	for i := 0; i < count && off+entry <= elst.end; i++ {
+		var mediaTime int64
+		if version == 1 {
+			mediaTime = int64(binary.BigEndian.Uint64(init[off+8:]))
+		} else {
+			mediaTime = int64(int32(binary.BigEndian.Uint32(init[off+4:])))
+		}
+		if mediaTime >= 0 {
+			return mediaTime, true
+		}
+		off += entry
+	}
+	return 0, false
+}
+
+// mp4WithEditList returns init with the first track's edit list replaced by
+// one playing samples of media from delay on, both in the track's timescale,
+// which is how MP4 signals encoder priming and padding for gapless playback.
+// Only moov and trak change size; an init segment holds no sample offsets.
+func mp4WithEditList(init []byte, delay, samples int64) ([]byte, error) {
+	moov, ok := mp4Find(init, "moov")
+	if !ok {
+		return nil, errors.New("init segment has no moov")
+	}
+	mvhd, ok := mp4Find(init, "moov", "mvhd")
+	if !ok {
+		return nil, errors.New("init segment has no mvhd")
+	}
+	trak, ok := mp4Find(init, "moov", "trak")
+	if !ok {
+		return nil, errors.New("init segment has no trak")
+	}
+	mdhd, ok := mp4Find(init, "moov", "trak", "mdia", "mdhd")
+	if !ok {
+		return nil, errors.New("init segment has no mdhd")
+	}
+	movieScale, ok1 := mp4Timescale(init, mvhd)
+	mediaScale, ok2 := mp4Timescale(init, mdhd)
+	if !ok1 || !ok2 || movieScale == 0 || mediaScale == 0 {
+		return nil, errors.New("init segment has no timescale")
+	}
+	if moov.body-moov.start != 8 || trak.body-trak.start != 8 {
+		return nil, errors.New("64-bit moov or trak sizes are not supported")
+	}
+
+	// The edit's duration is in the movie's timescale, its start in the
+	// track's
+	duration := uint64(samples) * uint64(movieScale) / uint64(mediaScale)
+	edts := make([]byte, 8+8+8+20)
+	binary.BigEndian.PutUint32(edts[0:], uint32(len(edts)))
+	copy(edts[4:], "edts")
+	binary.BigEndian.PutUint32(edts[8:], uint32(len(edts)-8))
+	copy(edts[12:], "elst")
+	edts[16] = 1 // version 1, 64-bit duration and media time
+	binary.BigEndian.PutUint32(edts[20:], 1)
+	binary.BigEndian.PutUint64(edts[24:], duration)
+	binary.BigEndian.PutUint64(edts[32:], uint64(delay))
+	binary.BigEndian.PutUint32(edts[40:], 0x00010000) // rate 1.0
+
+	// Goes where the old edit list was, or else right after tkhd
+	at, cut := trak.body, trak.body
+	children, err := mp4Boxes(init, trak.body, trak.end)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range children {
+		if c.typ == "edts" {
+			at, cut = c.start, c.end
+			break
+		}
+		if c.typ == "tkhd" {
+			at, cut = c.end, c.end
+		}
+	}
+
+	out := make([]byte, 0, len(init)+len(edts))
+	out = append(out, init[:at]...)
+	out = append(out, edts...)
+	out = append(out, init[cut:]...)
+	grow := len(edts) - (cut - at)
+	binary.BigEndian.PutUint32(out[moov.start:], uint32(moov.end-moov.start+grow))
+	binary.BigEndian.PutUint32(out[trak.start:], uint32(trak.end-trak.start+grow))
+	return out, nil
+}
+
+// mp4DefaultDuration reads the default sample duration fragments fall back
+// to from an init segment's trex box
+func mp4DefaultDuration(init []byte) uint32 {
+	trex, ok := mp4Find(init, "moov", "mvex", "trex")
+	if !ok || trex.end-trex.body < 16 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(init[trex.body+12:])
+}
+
+// mp4FragmentDuration adds up the durations of the samples in a media
+// segment's fragments, in the track's timescale
+func mp4FragmentDuration(seg []byte, defaultDuration uint32) (int64, error) {
+	boxes, err := mp4Boxes(seg, 0, len(seg))
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, moof := range boxes {
+		if moof.typ != "moof" {
+			continue
+		}
+		trafs, err := mp4Boxes(seg, moof.body, moof.end)
+		if err != nil {
+			return 0, err
+		}
+		for _, traf := range trafs {
+			if traf.typ != "traf" {
+				continue
+			}
+			children, err := mp4Boxes(seg, traf.body, traf.end)
+			if err != nil {
+				return 0, err
+			}
+			sampleDuration := defaultDuration
+			for _, c := range children {
+				switch c.typ {
+				case "tfhd":
+					sampleDuration = tfhdDuration(seg[c.body:c.end], sampleDuration)
+				case "trun":
+					d, err := trunDuration(seg[c.body:c.end], sampleDuration)
+					if err != nil {
+						return 0, err
+					}
+					total += d
+				}
+			}
+		}
+	}
+	return total, nil
+}
+
+// tfhdDuration returns the default sample duration a tfhd box sets, or def
+// when it sets none
+func tfhdDuration(b []byte, def uint32) uint32 {
+	if len(b) < 8 {
+		return def
+	}
+	flags := binary.BigEndian.Uint32(b) & 0xffffff
+	if flags&0x08 == 0 {
+		return def
+	}
+	off := 8
+	if flags&0x01 != 0 {
+		off += 8 // base data offset
+	}
+	if flags&0x02 != 0 {
+		off += 4 // sample description index
+	}
+	if off+4 > len(b) {
+		return def
+	}
+	return binary.BigEndian.Uint32(b[off:])
+}
+
+// trunDuration adds up the sample durations of a trun box, using def for
+// samples without one of their own
+func trunDuration(b []byte, def uint32) (int64, error) {
+	if len(b) < 8 {
+		return 0, errBadBox
+	}
+	flags := binary.BigEndian.Uint32(b) & 0xffffff
+	count := int64(binary.BigEndian.Uint32(b[4:]))
+	if flags&0x100 == 0 {
+		return count * int64(def), nil
+	}
+	off := 8
+	if flags&0x01 != 0 {
+		off += 4 // data offset
+	}
+	if flags&0x04 != 0 {
+		off += 4 // first sample flags
+	}
+	stride := 0
+	for _, bit := range []uint32{0x100, 0x200, 0x400, 0x800} {
+		if flags&bit != 0 {
+			stride += 4
+		}
+	}
+	if int64(len(b)-off) < count*int64(stride) {
+		return 0, errBadBox
+	}
+	var total int64
+	for i := int64(0); i < count; i++ {
+		total += int64(binary.BigEndian.Uint32(b[off:]))
+		off += stride
+	}
+	return total, nil
+}
@@ -10,6 +10,8 @@ type Service struct {
 	Generator *Generator
 	Cleaner   *Cleaner
 	Config    ServiceConfig
+
+	cleanerDone chan struct{}
 }
 
 type ServiceConfig struct {
@@ -61,11 +63,25 @@ func NewService(cfg ServiceConfig) (*Service, error) {
 }
 
 func (s *Service) Start(ctx context.Context) {
-	go s.Cleaner.Start(ctx)
+	s.cleanerDone = make(chan struct{})
+	go func() {
+		defer close(s.cleanerDone)
+		s.Cleaner.Start(ctx)
+	}()
 }
 
-func (s *Service) Stop() {
+// Shutdown stops the cleaner and waits, until ctx is done, for it and for
+// ffmpeg processes still generating segments to exit.
+func (s *Service) Shutdown(ctx context.Context) error {
 	s.Cleaner.Stop()
+	if s.cleanerDone != nil {
+		select {
+		case <-s.cleanerDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.Generator.Wait(ctx)
 }
 
 func (s *Service) SegmentDuration() int {
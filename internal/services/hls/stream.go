@@ -0,0 +1,116 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamEpoch is the program date of the start of a continuous stream.
+// EXT-X-DATERANGE needs dates, so chapters are placed at offsets from it.
+var streamEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// StreamTrack is one track of a continuous stream
+type StreamTrack struct {
+	Request SegmentRequest
+	Title   string
+	Artist  string
+}
+
+// StreamPlaylist builds one media playlist playing tracks back to back, as
+// for an album or playlist. Each track keeps its own init segment and
+// segments, fetched from prefix plus its ID, so they are generated and cached
+// as for the track alone, and an EXT-X-DATERANGE of class "track" marks each
+// as a chapter. A discontinuity separates tracks, since their timestamps and
+// init segments start over, so players may not join them seamlessly; each
+// init segment's edit list trims its track's priming and padding for those
+// that can.
+func (g *Generator) StreamPlaylist(tracks []StreamTrack, prefix, token string) []byte {
+	durations := make([][]float64, len(tracks))
+	target := g.segmentDuration
+	for i, t := range tracks {
+		durations[i] = g.segmentDurations(t.Request)
+		for _, d := range durations[i] {
+			target = max(target, int(math.Ceil(d)))
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:7\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", target))
+	sb.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	sb.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+
+	var offset float64
+	for i, t := range tracks {
+		req := t.Request
+		var length float64
+		for _, d := range durations[i] {
+			length += d
+		}
+		start := streamEpoch.Add(time.Duration(offset * float64(time.Second))).Format("2006-01-02T15:04:05.000Z")
+		title, artist := quotedString(t.Title), quotedString(t.Artist)
+
+		if i > 0 {
+			sb.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		sb.WriteString(fmt.Sprintf("#EXT-X-PROGRAM-DATE-TIME:%s\n", start))
+		sb.WriteString(fmt.Sprintf("#EXT-X-DATERANGE:ID=\"track-%d\",CLASS=\"track\",START-DATE=\"%s\",DURATION=%.3f,X-SONG-ID=\"%d\",X-TITLE=\"%s\",X-ARTIST=\"%s\"\n",
+			i+1, start, length, req.TrackID, title, artist))
+
+		base := fmt.Sprintf("%s%d/", prefix, req.TrackID)
+		params := buildTransformParams(req.Format, req.Bitrate, req.Normalize, token)
+		sb.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"%sinit.mp4%s\"\n", base, params))
+		for n, d := range durations[i] {
+			if n == 0 {
+				sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,%s - %s\n", d, artist, title))
+			} else {
+				sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n", d))
+			}
+			sb.WriteString(fmt.Sprintf("%s%d.m4s%s\n", base, n, params))
+		}
+		offset += length
+	}
+
+	sb.WriteString("#EXT-X-ENDLIST\n")
+	return []byte(sb.String())
+}
+
+// segmentDurations returns the length of each of a track's segments, read
+// from its manifest once it has been generated. Until then they're estimated
+// from the track's duration, as ffmpeg cuts segments every segmentDuration
+// seconds, so a stream can be listed without transcoding all of it first.
+func (g *Generator) segmentDurations(req SegmentRequest) []float64 {
+	if data, ok := g.cache.Get(g.cache.ManifestKey(req.TrackID, req.variant(), req.Bitrate)); ok {
+		var durations []float64
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if v, ok := strings.CutPrefix(scanner.Text(), "#EXTINF:"); ok {
+				v, _, _ = strings.Cut(v, ",")
+				if d, err := strconv.ParseFloat(v, 64); err == nil {
+					durations = append(durations, d)
+				}
+			}
+		}
+		if len(durations) > 0 {
+			return durations
+		}
+	}
+
+	durations := make([]float64, CalculateSegmentCount(req.DurationMs, g.segmentDuration))
+	for i := range durations {
+		durations[i] = CalculateSegmentDuration(req.DurationMs, i, g.segmentDuration)
+	}
+	return durations
+}
+
+// quotedString makes s safe inside a quoted playlist attribute, which can't
+// hold double quotes or line breaks
+func quotedString(s string) string {
+	return strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
+)
+
+var (
+	// ErrLastFMToken is returned when a Last.fm auth token is invalid or was
+	// not authorized by the user
+	ErrLastFMToken = errors.New("invalid or unauthorized Last.fm token")
+	// ErrLastFMNotLinked is returned when a user has no Last.fm account
+	ErrLastFMNotLinked = errors.New("no Last.fm account linked")
+)
+
+// Last.fm error codes
+const (
+	lastFMInvalidToken    = 4
+	lastFMOperationFailed = 8
+	lastFMInvalidSession  = 9
+	lastFMOffline         = 11
+	lastFMUnauthorized    = 14
+	lastFMTokenExpired    = 15
+	lastFMTemporary       = 16
+	lastFMRateLimited     = 29
+)
+
+// LastFMService scrobbles to Last.fm with per-user session keys
+type LastFMService struct {
+	db      *sql.DB
+	client  *http.Client
+	apiKey  string
+	secret  string
+	baseURL string
+	authURL string
+}
+
+func NewLastFMService(conn *sql.DB, apiKey, secret string) *LastFMService {
+	return &LastFMService{
+		db:      conn,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		apiKey:  apiKey,
+		secret:  secret,
+		baseURL: "https://ws.audioscrobbler.com/2.0/",
+		authURL: "https://www.last.fm/api/auth/",
+	}
+}
+
+type lastFMError struct {
+	Code    int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e lastFMError) Error() string {
+	return fmt.Sprintf("last.fm error %d: %s", e.Code, e.Message)
+}
+
+// Qualifies applies Last.fm's scrobble rule: the track is longer than 30
+// seconds and half of it, or 4 minutes, was heard.
+func (f *LastFMService) Qualifies(durationMs int64, listened int) bool {
+	if durationMs > 0 && durationMs < 30000 {
+		return false
+	}
+	return listened >= 240 || (durationMs > 0 && int64(listened)*2000 >= durationMs)
+}
+
+// AuthURL returns the page where the user grants Korus access. With a
+// callback, Last.fm redirects there with a token query parameter. Without
+// one, a token is fetched first and returned alongside the URL; either way
+// the token is then exchanged with CreateSession.
+func (f *LastFMService) AuthURL(ctx context.Context, callback string) (string, string, error) {
+	q := url.Values{"api_key": {f.apiKey}}
+	if callback != "" {
+		q.Set("cb", callback)
+		return f.authURL + "?" + q.Encode(), "", nil
+	}
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := f.call(ctx, http.MethodGet, url.Values{"method": {"auth.getToken"}}, &res); err != nil {
+		return "", "", err
+	}
+	q.Set("token", res.Token)
+	return f.authURL + "?" + q.Encode(), res.Token, nil
+}
+
+// CreateSession exchanges an authorized token for a session key and links
+// the Last.fm account to the user.
+func (f *LastFMService) CreateSession(ctx context.Context, userID int64, token string) error {
+	var res struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}
+	err := f.call(ctx, http.MethodGet, url.Values{"method": {"auth.getSession"}, "token": {token}}, &res)
+	var lfErr lastFMError
+	if errors.As(err, &lfErr) && (lfErr.Code == lastFMInvalidToken || lfErr.Code == lastFMUnauthorized || lfErr.Code == lastFMTokenExpired) {
+		return ErrLastFMToken
+	}
+	if err != nil {
+		return err
+	}
+	_, err = f.db.ExecContext(ctx, `
+		INSERT INTO lastfm_accounts(user_id, session_key, username) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET session_key = excluded.session_key, username = excluded.username
+	`, userID, res.Session.Key, res.Session.Name)
+	return err
+}
+
+// Account returns a user's linked account, or ErrLastFMNotLinked
+func (f *LastFMService) Account(ctx context.Context, userID int64) (models.LastFMAccount, error) {
+	var a models.LastFMAccount
+	err := f.db.QueryRowContext(ctx, `SELECT username, scrobble FROM lastfm_accounts WHERE user_id = ?`, userID).Scan(&a.Username, &a.Scrobble)
+	if err == sql.ErrNoRows {
+		return a, ErrLastFMNotLinked
+	}
+	return a, err
+}
+
+// SetScrobble turns scrobbling on or off for a linked account
+func (f *LastFMService) SetScrobble(ctx context.Context, userID int64, scrobble bool) error {
+	res, err := f.db.ExecContext(ctx, `UPDATE lastfm_accounts SET scrobble = ? WHERE user_id = ?`, scrobble, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrLastFMNotLinked
+	}
+	return nil
+}
+
+// Unlink forgets a user's Last.fm session
+func (f *LastFMService) Unlink(ctx context.Context, userID int64) error {
+	_, err := f.db.ExecContext(ctx, `DELETE FROM lastfm_accounts WHERE user_id = ?`, userID)
+	return err
+}
+
+// Scrobble submits a play that started at startedAt. Users without a linked
+// account, or who turned scrobbling off, are skipped.
+func (f *LastFMService) Scrobble(ctx context.Context, userID, songID int64, startedAt time.Time) error {
+	return f.submit(ctx, userID, songID, "track.scrobble", startedAt)
+}
+
+// UpdateNowPlaying tells Last.fm what the user is listening to
+func (f *LastFMService) UpdateNowPlaying(ctx context.Context, userID, songID int64) error {
+	return f.submit(ctx, userID, songID, "track.updateNowPlaying", time.Time{})
+}
+
+func (f *LastFMService) submit(ctx context.Context, userID, songID int64, method string, startedAt time.Time) error {
+	var sessionKey string
+	err := f.db.QueryRowContext(ctx, `SELECT session_key FROM lastfm_accounts WHERE user_id = ? AND scrobble = 1`, userID).Scan(&sessionKey)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	params, err := f.trackParams(ctx, songID)
+	if err != nil {
+		return err
+	}
+	params.Set("method", method)
+	params.Set("sk", sessionKey)
+	if !startedAt.IsZero() {
+		params.Set("timestamp", strconv.FormatInt(startedAt.Unix(), 10))
+		params.Set("chosenByUser", "1")
+	}
+	err = f.call(ctx, http.MethodPost, params, nil)
+	var lfErr lastFMError
+	if errors.As(err, &lfErr) && lfErr.Code == lastFMInvalidSession {
+		// The user revoked access on last.fm; the session can't be used again
+		_ = f.Unlink(ctx, userID)
+	}
+	return err
+}
+
+// trackParams returns the Last.fm track parameters of a song. Last.fm keys
+// tracks on a single artist, so only the song's primary artist is sent.
+func (f *LastFMService) trackParams(ctx context.Context, songID int64) (url.Values, error) {
+	var title string
+	var album, albumArtist, artist, mbid sql.NullString
+	var durationMs, track sql.NullInt64
+	err := f.db.QueryRowContext(ctx, `
+		SELECT s.title, al.title, ar.name, s.mbid, s.duration_ms, s.track_number,
+		       (SELECT a.name FROM song_artists sa JOIN artists a ON a.id = sa.artist_id
+		        WHERE sa.song_id = s.id ORDER BY sa.position LIMIT 1)
+		FROM songs s
+		`+db.SongJoins+`
+		WHERE s.id = ?
+	`, songID).Scan(&title, &album, &albumArtist, &mbid, &durationMs, &track, &artist)
+	if err != nil {
+		return nil, err
+	}
+	if !artist.Valid {
+		artist = albumArtist
+	}
+	params := url.Values{"artist": {artist.String}, "track": {title}}
+	if album.String != "" {
+		params.Set("album", album.String)
+	}
+	if albumArtist.String != "" && albumArtist.String != artist.String {
+		params.Set("albumArtist", albumArtist.String)
+	}
+	if mbid.String != "" {
+		params.Set("mbid", strings.ToLower(mbid.String))
+	}
+	if durationMs.Int64 > 0 {
+		params.Set("duration", strconv.FormatInt(durationMs.Int64/1000, 10))
+	}
+	if track.Int64 > 0 {
+		params.Set("trackNumber", strconv.FormatInt(track.Int64, 10))
+	}
+	return params, nil
+}
+
+// sign adds the api_key and api_sig parameters. The signature is the MD5 of
+// every parameter name and value in name order, followed by the secret.
+func (f *LastFMService) sign(params url.Values) {
+	params.Set("api_key", f.apiKey)
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "format" && k != "callback" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteString(params.Get(k))
+	}
+	b.WriteString(f.secret)
+	sum := md5.Sum([]byte(b.String()))
+	params.Set("api_sig", hex.EncodeToString(sum[:]))
+}
+
+// call sends a signed request to the Last.fm API
+func (f *LastFMService) call(ctx context.Context, method string, params url.Values, out any) error {
+	f.sign(params)
+	params.Set("format", "json")
+	var req *http.Request
+	var err error
+	if method == http.MethodPost {
+		req, err = http.NewRequestWithContext(ctx, method, f.baseURL, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, f.baseURL+"?"+params.Encode(), nil)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return &statusError{service: "last.fm", code: resp.StatusCode}
+	}
+	var lfErr lastFMError
+	if json.Unmarshal(body, &lfErr) == nil && lfErr.Code != 0 {
+		if lfErr.Code == lastFMRateLimited {
+			return &RateLimitError{RetryAfter: 5 * time.Minute}
+		}
+		return lfErr
+	}
+	if resp.StatusCode >= 300 {
+		return &statusError{service: "last.fm", code: resp.StatusCode}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
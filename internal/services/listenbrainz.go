@@ -0,0 +1,443 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
+)
+
+var (
+	// ErrListenBrainzToken is returned when ListenBrainz rejects a user token
+	ErrListenBrainzToken = errors.New("invalid ListenBrainz token")
+	// ErrListenBrainzNotLinked is returned when a user has no ListenBrainz account
+	ErrListenBrainzNotLinked = errors.New("no ListenBrainz account linked")
+	// ErrListenBrainzImporting is returned when an import is already running
+	ErrListenBrainzImporting = errors.New("a ListenBrainz import is already running")
+)
+
+// ListenBrainzService submits listens with each user's own token and imports
+// their ListenBrainz history into play_history.
+type ListenBrainzService struct {
+	db         *sql.DB
+	client     *http.Client
+	baseURL    string
+	minPercent int
+	minSeconds int
+
+	mu        sync.Mutex
+	importing map[int64]bool
+}
+
+// NewListenBrainzService creates the service. A play counts as a listen once
+// minPercent of the song or minSeconds have been heard, whichever is first.
+func NewListenBrainzService(conn *sql.DB, minPercent, minSeconds int) *ListenBrainzService {
+	return &ListenBrainzService{
+		db:         conn,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		baseURL:    "https://api.listenbrainz.org/1",
+		minPercent: minPercent,
+		minSeconds: minSeconds,
+		importing:  make(map[int64]bool),
+	}
+}
+
+type lbSubmission struct {
+	ListenType string     `json:"listen_type"`
+	Payload    []lbListen `json:"payload"`
+}
+
+type lbListen struct {
+	ListenedAt    int64           `json:"listened_at,omitempty"`
+	TrackMetadata lbTrackMetadata `json:"track_metadata"`
+}
+
+type lbTrackMetadata struct {
+	ArtistName     string           `json:"artist_name"`
+	TrackName      string           `json:"track_name"`
+	ReleaseName    string           `json:"release_name,omitempty"`
+	AdditionalInfo lbAdditionalInfo `json:"additional_info"`
+	// Set by ListenBrainz on listens it returns
+	MBIDMapping *struct {
+		RecordingMBID string `json:"recording_mbid"`
+	} `json:"mbid_mapping,omitempty"`
+}
+
+type lbAdditionalInfo struct {
+	RecordingMBID    string   `json:"recording_mbid,omitempty"`
+	ISRC             string   `json:"isrc,omitempty"`
+	DurationMs       int64    `json:"duration_ms,omitempty"`
+	Duration         int64    `json:"duration,omitempty"`
+	TrackNumber      int      `json:"tracknumber,omitempty"`
+	ArtistNames      []string `json:"artist_names,omitempty"`
+	MediaPlayer      string   `json:"media_player,omitempty"`
+	SubmissionClient string   `json:"submission_client,omitempty"`
+}
+
+type lbListensResponse struct {
+	Payload struct {
+		Listens []lbListen `json:"listens"`
+	} `json:"payload"`
+}
+
+// Qualifies reports whether listening for listened seconds to a song of
+// durationMs counts as a listen.
+func (l *ListenBrainzService) Qualifies(durationMs int64, listened int) bool {
+	if listened >= l.minSeconds {
+		return true
+	}
+	return durationMs > 0 && int64(listened)*1000*100 >= durationMs*int64(l.minPercent)
+}
+
+// Account returns a user's linked account, or ErrListenBrainzNotLinked
+func (l *ListenBrainzService) Account(ctx context.Context, userID int64) (models.ListenBrainzAccount, error) {
+	var a models.ListenBrainzAccount
+	var lastImport sql.NullTime
+	var lastError sql.NullString
+	err := l.db.QueryRowContext(ctx, `
+		SELECT username, submit_listens, last_import_at, last_import_count, last_import_error
+		FROM listenbrainz_accounts WHERE user_id = ?
+	`, userID).Scan(&a.Username, &a.SubmitListens, &lastImport, &a.LastImportCount, &lastError)
+	if err == sql.ErrNoRows {
+		return a, ErrListenBrainzNotLinked
+	}
+	if err != nil {
+		return a, err
+	}
+	if lastImport.Valid {
+		a.LastImportAt = &lastImport.Time
+	}
+	a.LastImportError = lastError.String
+	l.mu.Lock()
+	a.Importing = l.importing[userID]
+	l.mu.Unlock()
+	return a, nil
+}
+
+// Link validates token with ListenBrainz and stores it for the user. An
+// empty token keeps the linked one and only changes submitListens.
+func (l *ListenBrainzService) Link(ctx context.Context, userID int64, token string, submitListens bool) error {
+	if token == "" {
+		res, err := l.db.ExecContext(ctx, `UPDATE listenbrainz_accounts SET submit_listens = ? WHERE user_id = ?`, submitListens, userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrListenBrainzNotLinked
+		}
+		return nil
+	}
+	var valid struct {
+		Valid    bool   `json:"valid"`
+		UserName string `json:"user_name"`
+	}
+	if err := l.call(ctx, http.MethodGet, "/validate-token", token, nil, &valid); err != nil {
+		return err
+	}
+	if !valid.Valid || valid.UserName == "" {
+		return ErrListenBrainzToken
+	}
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO listenbrainz_accounts(user_id, token, username, submit_listens) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			token = excluded.token,
+			submit_listens = excluded.submit_listens,
+			import_cursor = CASE WHEN username = excluded.username THEN import_cursor ELSE 0 END,
+			username = excluded.username
+	`, userID, token, valid.UserName, submitListens)
+	return err
+}
+
+// Unlink forgets a user's ListenBrainz account
+func (l *ListenBrainzService) Unlink(ctx context.Context, userID int64) error {
+	_, err := l.db.ExecContext(ctx, `DELETE FROM listenbrainz_accounts WHERE user_id = ?`, userID)
+	return err
+}
+
+// SubmitListen submits a play that started at listenedAt. Users without a
+// linked account, or who turned submission off, are skipped.
+func (l *ListenBrainzService) SubmitListen(ctx context.Context, userID, songID int64, listenedAt time.Time) error {
+	return l.submit(ctx, userID, songID, "single", listenedAt.Unix())
+}
+
+// SubmitPlayingNow tells ListenBrainz what the user is listening to
+func (l *ListenBrainzService) SubmitPlayingNow(ctx context.Context, userID, songID int64) error {
+	return l.submit(ctx, userID, songID, "playing_now", 0)
+}
+
+func (l *ListenBrainzService) submit(ctx context.Context, userID, songID int64, listenType string, listenedAt int64) error {
+	var token string
+	err := l.db.QueryRowContext(ctx, `SELECT token FROM listenbrainz_accounts WHERE user_id = ? AND submit_listens = 1`, userID).Scan(&token)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	meta, err := l.trackMetadata(ctx, songID)
+	if err != nil {
+		return err
+	}
+	body := lbSubmission{ListenType: listenType, Payload: []lbListen{{ListenedAt: listenedAt, TrackMetadata: meta}}}
+	return l.call(ctx, http.MethodPost, "/submit-listens", token, body, nil)
+}
+
+// trackMetadata builds a listen's metadata from the song, album and artist rows
+func (l *ListenBrainzService) trackMetadata(ctx context.Context, songID int64) (lbTrackMetadata, error) {
+	var m lbTrackMetadata
+	var album, albumArtist, artists sql.NullString
+	var mbid, isrc sql.NullString
+	var durationMs, track sql.NullInt64
+	err := l.db.QueryRowContext(ctx, `
+		SELECT s.title, al.title, ar.name, s.mbid, s.isrc, s.duration_ms, s.track_number,
+		       (SELECT GROUP_CONCAT(name, char(31)) FROM (
+		            SELECT a.name FROM song_artists sa JOIN artists a ON a.id = sa.artist_id
+		            WHERE sa.song_id = s.id ORDER BY sa.position))
+		FROM songs s
+		`+db.SongJoins+`
+		WHERE s.id = ?
+	`, songID).Scan(&m.TrackName, &album, &albumArtist, &mbid, &isrc, &durationMs, &track, &artists)
+	if err != nil {
+		return m, err
+	}
+	names := strings.Split(artists.String, "\x1f")
+	if !artists.Valid || artists.String == "" {
+		names = []string{albumArtist.String}
+	}
+	m.ArtistName = strings.Join(names, ", ")
+	m.ReleaseName = album.String
+	m.AdditionalInfo = lbAdditionalInfo{
+		RecordingMBID:    strings.ToLower(mbid.String),
+		ISRC:             strings.ToUpper(isrc.String),
+		DurationMs:       durationMs.Int64,
+		TrackNumber:      int(track.Int64),
+		MediaPlayer:      "Korus",
+		SubmissionClient: "Korus",
+	}
+	if len(names) > 1 {
+		m.AdditionalInfo.ArtistNames = names
+	}
+	return m, nil
+}
+
+// StartImport imports the user's ListenBrainz history in the background.
+// Listens already imported, or recorded by Korus itself, are skipped.
+func (l *ListenBrainzService) StartImport(user models.User) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.importing[user.ID] {
+		return ErrListenBrainzImporting
+	}
+	var linked int
+	_ = l.db.QueryRow(`SELECT COUNT(*) FROM listenbrainz_accounts WHERE user_id = ?`, user.ID).Scan(&linked)
+	if linked == 0 {
+		return ErrListenBrainzNotLinked
+	}
+	l.importing[user.ID] = true
+	go func() {
+		ctx := context.Background()
+		count, err := l.importListens(ctx, user)
+		var message any
+		if err != nil {
+			log.Printf("listenbrainz import for user %d: %v", user.ID, err)
+			message = err.Error()
+		}
+		if count > 0 {
+			if err := db.RefreshSmartPlaylists(ctx, l.db, user.ID); err != nil {
+				log.Printf("smart playlists for user %d: %v", user.ID, err)
+			}
+		}
+		_, _ = l.db.ExecContext(ctx, `
+			UPDATE listenbrainz_accounts SET last_import_at = CURRENT_TIMESTAMP, last_import_count = ?, last_import_error = ?
+			WHERE user_id = ?
+		`, count, message, user.ID)
+		l.mu.Lock()
+		delete(l.importing, user.ID)
+		l.mu.Unlock()
+	}()
+	return nil
+}
+
+func (l *ListenBrainzService) importListens(ctx context.Context, user models.User) (int, error) {
+	var token, username string
+	var cursor int64
+	if err := l.db.QueryRowContext(ctx, `SELECT token, username, import_cursor FROM listenbrainz_accounts WHERE user_id = ?`, user.ID).Scan(&token, &username, &cursor); err != nil {
+		return 0, err
+	}
+	access := db.UserAccess(user)
+	// Listens repeat the same tracks; match each one only once
+	matched := map[string]int64{}
+	newest, imported := cursor, 0
+	maxTs := time.Now().Unix() + 1
+	for {
+		var page lbListensResponse
+		q := url.Values{"count": {"1000"}, "max_ts": {strconv.FormatInt(maxTs, 10)}}
+		if err := l.callWaiting(ctx, http.MethodGet, "/user/"+url.PathEscape(username)+"/listens?"+q.Encode(), token, &page); err != nil {
+			return imported, err
+		}
+		listens := page.Payload.Listens
+		var fresh []lbListen
+		for _, li := range listens {
+			if li.ListenedAt > cursor {
+				fresh = append(fresh, li)
+			}
+		}
+		n, err := l.storeListens(ctx, user.ID, access, fresh, matched)
+		imported += n
+		if err != nil {
+			return imported, err
+		}
+		for _, li := range fresh {
+			newest = max(newest, li.ListenedAt)
+		}
+		// Pages are newest first, so a short page or one reaching the
+		// cursor is the last one
+		if len(listens) == 0 || len(fresh) < len(listens) || listens[len(listens)-1].ListenedAt >= maxTs {
+			break
+		}
+		maxTs = listens[len(listens)-1].ListenedAt
+	}
+	_, err := l.db.ExecContext(ctx, `UPDATE listenbrainz_accounts SET import_cursor = ? WHERE user_id = ?`, newest, user.ID)
+	return imported, err
+}
+
+// storeListens matches listens to songs and records them in play_history.
+// A listen within a minute of a play of the same song is a duplicate.
+func (l *ListenBrainzService) storeListens(ctx context.Context, userID int64, access db.Access, listens []lbListen, matched map[string]int64) (int, error) {
+	keys := make([]string, len(listens))
+	var entries []PlaylistFileEntry
+	var pending []string
+	for i, li := range listens {
+		e := listenEntry(li.TrackMetadata)
+		keys[i] = strings.Join([]string{e.MBID, e.ISRC, e.Artist, e.Title, e.Album}, "\x1f")
+		if _, ok := matched[keys[i]]; !ok {
+			matched[keys[i]] = 0
+			entries = append(entries, e)
+			pending = append(pending, keys[i])
+		}
+	}
+	for i, id := range MatchPlaylistEntries(ctx, l.db, access, entries) {
+		matched[pending[i]] = id
+	}
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	count := 0
+	for i, li := range listens {
+		songID := matched[keys[i]]
+		if songID == 0 {
+			continue
+		}
+		var exists int
+		if err := tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM play_history
+			WHERE user_id = ? AND song_id = ? AND ABS(CAST(strftime('%s', played_at) AS INTEGER) - ?) < 60
+		`, userID, songID, li.ListenedAt).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if exists > 0 {
+			continue
+		}
+		info := li.TrackMetadata.AdditionalInfo
+		listened := info.DurationMs / 1000
+		if listened == 0 {
+			listened = info.Duration
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO play_history(user_id, song_id, played_at, duration_listened, completion_rate, source)
+			VALUES (?, ?, ?, ?, 1, 'listenbrainz')
+		`, userID, songID, time.Unix(li.ListenedAt, 0).UTC().Format(time.RFC3339), listened); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, tx.Commit()
+}
+
+// listenEntry converts a listen's metadata for MatchPlaylistEntries
+func listenEntry(m lbTrackMetadata) PlaylistFileEntry {
+	e := PlaylistFileEntry{
+		Title:  m.TrackName,
+		Artist: m.ArtistName,
+		Album:  m.ReleaseName,
+		MBID:   m.AdditionalInfo.RecordingMBID,
+		ISRC:   m.AdditionalInfo.ISRC,
+	}
+	if e.MBID == "" && m.MBIDMapping != nil {
+		e.MBID = m.MBIDMapping.RecordingMBID
+	}
+	if m.AdditionalInfo.DurationMs > 0 {
+		e.Duration = int(m.AdditionalInfo.DurationMs / 1000)
+	} else {
+		e.Duration = int(m.AdditionalInfo.Duration)
+	}
+	return e
+}
+
+// callWaiting is call for long-running work, waiting out rate limits
+func (l *ListenBrainzService) callWaiting(ctx context.Context, method, path, token string, out any) error {
+	for attempt := 0; ; attempt++ {
+		err := l.call(ctx, method, path, token, nil, out)
+		var limited *RateLimitError
+		if !errors.As(err, &limited) || attempt == 5 {
+			return err
+		}
+		select {
+		case <-time.After(limited.RetryAfter):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// call sends a request to the ListenBrainz API
+func (l *ListenBrainzService) call(ctx context.Context, method, path, token string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, l.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Reset-In"))
+		return &RateLimitError{RetryAfter: time.Duration(max(wait, 1)) * time.Second}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrListenBrainzToken
+	}
+	if resp.StatusCode >= 300 {
+		return &statusError{service: "listenbrainz", code: resp.StatusCode}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
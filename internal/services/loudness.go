@@ -0,0 +1,262 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhowden/tag"
+
+	"github.com/Aunali321/korus/internal/models"
+)
+
+// Loudness sources stored in songs.loudness_source
+const (
+	LoudnessTags    = "tags"
+	LoudnessEBUR128 = "ebur128"
+	LoudnessFailed  = "failed"
+)
+
+// loudnessBatch is how many songs LoudnessService measures per pass
+const loudnessBatch = 20
+
+// r128Reference is the loudness Opus R128_*_GAIN tags are relative to
+const r128Reference = -23.0
+
+// LoudnessService measures the integrated loudness and peak of songs whose
+// files carry no ReplayGain tags with ffmpeg's ebur128 filter, and derives
+// album values once every song of an album is known.
+type LoudnessService struct {
+	db         *sql.DB
+	ffmpegPath string
+}
+
+func NewLoudnessService(db *sql.DB, ffmpegPath string) *LoudnessService {
+	return &LoudnessService{db: db, ffmpegPath: ffmpegPath}
+}
+
+// Run analyses pending songs until ctx is done, picking up songs added by
+// scans every few minutes.
+func (l *LoudnessService) Run(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		for l.analyse(ctx) == loudnessBatch {
+			// A full batch means there may be more pending
+		}
+		l.fillAlbums(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// analyse measures one batch of pending songs and returns how many it tried
+func (l *LoudnessService) analyse(ctx context.Context) int {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT id, COALESCE(source_path, file_path), COALESCE(cue_start_ms, 0), COALESCE(cue_end_ms, 0)
+		FROM songs WHERE loudness_source IS NULL ORDER BY album_id, id LIMIT ?
+	`, loudnessBatch)
+	if err != nil {
+		log.Printf("loudness analysis: %v", err)
+		return 0
+	}
+	type pending struct {
+		id             int64
+		path           string
+		startMs, endMs int
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.path, &p.startMs, &p.endMs); err == nil {
+			batch = append(batch, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range batch {
+		loudness, peak, err := l.measure(ctx, p.path, p.startMs, p.endMs)
+		if ctx.Err() != nil {
+			return 0
+		}
+		if err != nil {
+			log.Printf("loudness of %s: %v", p.path, err)
+			_, _ = l.db.ExecContext(ctx, `UPDATE songs SET loudness_source = ? WHERE id = ?`, LoudnessFailed, p.id)
+			continue
+		}
+		_, _ = l.db.ExecContext(ctx, `
+			UPDATE songs SET track_loudness = ?, track_peak = ?, loudness_source = ? WHERE id = ? AND loudness_source IS NULL
+		`, loudness, peak, LoudnessEBUR128, p.id)
+	}
+	return len(batch)
+}
+
+// measure returns the integrated loudness in LUFS and sample peak as linear
+// amplitude of a file, or of the part between startMs and endMs for a cue
+// track (endMs 0 meaning the end of the file).
+func (l *LoudnessService) measure(ctx context.Context, path string, startMs, endMs int) (float64, float64, error) {
+	args := []string{"-nostats", "-hide_banner"}
+	if startMs > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", float64(startMs)/1000))
+	}
+	if endMs > 0 {
+		args = append(args, "-to", fmt.Sprintf("%.3f", float64(endMs)/1000))
+	}
+	args = append(args, "-i", path, "-vn", "-af", "ebur128=peak=sample", "-f", "null", "-")
+	cmd := exec.CommandContext(ctx, l.ffmpegPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, 0, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return parseEBUR128(stderr.String())
+}
+
+// parseEBUR128 reads the integrated loudness and sample peak from the summary
+// the ebur128 filter logs when it finishes.
+func parseEBUR128(output string) (float64, float64, error) {
+	i := strings.LastIndex(output, "Summary:")
+	if i < 0 {
+		return 0, 0, errors.New("no ebur128 summary")
+	}
+	loudness, peak := math.NaN(), math.NaN()
+	sc := bufio.NewScanner(strings.NewReader(output[i:]))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "I:":
+			loudness = v
+		case "Peak:":
+			peak = math.Pow(10, v/20)
+		}
+	}
+	// Silence measures as -70 LUFS, the gating floor, or -inf
+	if math.IsNaN(loudness) || math.IsInf(loudness, 0) || loudness <= -70 {
+		return 0, 0, errors.New("no integrated loudness")
+	}
+	if math.IsNaN(peak) {
+		peak = 1
+	}
+	return loudness, peak, nil
+}
+
+// fillAlbums sets the album loudness and peak of measured songs in albums
+// with no songs pending analysis: the duration-weighted energy mean of the
+// songs' loudness and their highest peak.
+func (l *LoudnessService) fillAlbums(ctx context.Context) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT DISTINCT album_id FROM songs s
+		WHERE loudness_source = ? AND album_loudness IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM songs p WHERE p.album_id = s.album_id AND p.loudness_source IS NULL)
+	`, LoudnessEBUR128)
+	if err != nil {
+		log.Printf("album loudness: %v", err)
+		return
+	}
+	var albums []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			albums = append(albums, id)
+		}
+	}
+	rows.Close()
+
+	for _, albumID := range albums {
+		rows, err := l.db.QueryContext(ctx, `
+			SELECT track_loudness, COALESCE(track_peak, 1), COALESCE(duration_ms, 0) FROM songs
+			WHERE album_id = ? AND track_loudness IS NOT NULL
+		`, albumID)
+		if err != nil {
+			continue
+		}
+		var energy, total, peak float64
+		for rows.Next() {
+			var loudness, p float64
+			var durationMs int64
+			if rows.Scan(&loudness, &p, &durationMs) != nil {
+				continue
+			}
+			weight := math.Max(float64(durationMs), 1)
+			energy += weight * math.Pow(10, loudness/10)
+			total += weight
+			peak = math.Max(peak, p)
+		}
+		rows.Close()
+		if total == 0 {
+			continue
+		}
+		_, _ = l.db.ExecContext(ctx, `
+			UPDATE songs SET album_loudness = ?, album_peak = ? WHERE album_id = ? AND loudness_source = ?
+		`, 10*math.Log10(energy/total), peak, albumID, LoudnessEBUR128)
+	}
+}
+
+// replayGain is the loudness a file's tags declare, in the units stored in
+// the songs table.
+type replayGain struct {
+	trackLoudness, trackPeak, albumLoudness, albumPeak sql.NullFloat64
+}
+
+// readReplayGain reads REPLAYGAIN_* tags, or Opus R128_*_GAIN tags, as
+// loudness and peaks. ok is false when the file has no track gain.
+func readReplayGain(meta tag.Metadata) (rg replayGain, ok bool) {
+	raw := textTags(meta)
+	gain := func(key string) sql.NullFloat64 {
+		s := strings.TrimSpace(raw[key])
+		s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(s, "dB"), "DB"))
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return sql.NullFloat64{}
+		}
+		return sql.NullFloat64{Float64: models.ReplayGainReference - v, Valid: true}
+	}
+	peak := func(key string) sql.NullFloat64 {
+		v, err := strconv.ParseFloat(strings.TrimSpace(raw[key]), 64)
+		if err != nil || v <= 0 || math.IsInf(v, 0) {
+			return sql.NullFloat64{}
+		}
+		return sql.NullFloat64{Float64: v, Valid: true}
+	}
+	// R128 gains are Q7.8 fixed point dB relative to -23 LUFS
+	r128 := func(key string) sql.NullFloat64 {
+		v, err := strconv.Atoi(strings.TrimSpace(raw[key]))
+		if err != nil {
+			return sql.NullFloat64{}
+		}
+		return sql.NullFloat64{Float64: r128Reference - float64(v)/256, Valid: true}
+	}
+
+	rg = replayGain{
+		trackLoudness: gain("replaygain_track_gain"),
+		trackPeak:     peak("replaygain_track_peak"),
+		albumLoudness: gain("replaygain_album_gain"),
+		albumPeak:     peak("replaygain_album_peak"),
+	}
+	if !rg.trackLoudness.Valid {
+		rg.trackLoudness = r128("r128_track_gain")
+		if !rg.albumLoudness.Valid {
+			rg.albumLoudness = r128("r128_album_gain")
+		}
+	}
+	return rg, rg.trackLoudness.Valid
+}
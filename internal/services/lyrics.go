@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aunali321/korus/internal/db"
+)
+
+// Lyrics sources stored in songs.lyrics_source. Lyrics fetched from a
+// provider carry the provider's name instead.
+const (
+	LyricsEmbedded = "embedded"
+	LyricsSidecar  = "sidecar"
+)
+
+// lyricsRetry is how long a provider lookup that found nothing is trusted
+const lyricsRetry = 7 * 24 * time.Hour
+
+// ErrLyricsNotFound is returned by a LyricsProvider that has no lyrics for a song
+var ErrLyricsNotFound = errors.New("lyrics not found")
+
+// Lyrics are a song's plain and LRC-synced lyrics and where they came from
+type Lyrics struct {
+	Plain        string
+	Synced       string
+	Source       string
+	Instrumental bool
+}
+
+// LyricsQuery describes the song a LyricsProvider should look up
+type LyricsQuery struct {
+	Title    string
+	Artist   string
+	Album    string
+	Duration int // seconds
+}
+
+// LyricsProvider looks up lyrics for songs whose files carry none
+type LyricsProvider interface {
+	// Name is stored as the source of the lyrics it returns
+	Name() string
+	// Lookup returns ErrLyricsNotFound when it has no lyrics for the song
+	Lookup(ctx context.Context, q LyricsQuery) (Lyrics, error)
+}
+
+// LRCLibProvider looks lyrics up on LRCLIB (lrclib.net) or a self-hosted
+// instance with the same API.
+type LRCLibProvider struct {
+	client  *http.Client
+	baseURL string
+}
+
+func NewLRCLibProvider(baseURL string) *LRCLibProvider {
+	return &LRCLibProvider{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (p *LRCLibProvider) Name() string { return "lrclib" }
+
+type lrclibRecord struct {
+	Duration     float64 `json:"duration"`
+	Instrumental bool    `json:"instrumental"`
+	PlainLyrics  string  `json:"plainLyrics"`
+	SyncedLyrics string  `json:"syncedLyrics"`
+}
+
+// Lookup asks for an exact signature match first and falls back to a search,
+// taking the result closest in duration.
+func (p *LRCLibProvider) Lookup(ctx context.Context, q LyricsQuery) (Lyrics, error) {
+	params := url.Values{"track_name": {q.Title}, "artist_name": {q.Artist}}
+	if q.Album != "" {
+		params.Set("album_name", q.Album)
+	}
+	if q.Duration > 0 {
+		params.Set("duration", strconv.Itoa(q.Duration))
+	}
+	var rec lrclibRecord
+	err := p.get(ctx, "/api/get?"+params.Encode(), &rec)
+	if errors.Is(err, ErrLyricsNotFound) {
+		var results []lrclibRecord
+		search := url.Values{"track_name": {q.Title}, "artist_name": {q.Artist}}
+		if err := p.get(ctx, "/api/search?"+search.Encode(), &results); err != nil {
+			return Lyrics{}, err
+		}
+		best := -1.0
+		for _, r := range results {
+			delta := r.Duration - float64(q.Duration)
+			if delta < 0 {
+				delta = -delta
+			}
+			// Versions more than a few seconds apart are likely different
+			// recordings whose synced lyrics wouldn't line up
+			if q.Duration > 0 && delta > 3 {
+				continue
+			}
+			if best < 0 || delta < best {
+				rec, best = r, delta
+			}
+		}
+		if best < 0 {
+			return Lyrics{}, ErrLyricsNotFound
+		}
+	} else if err != nil {
+		return Lyrics{}, err
+	}
+	if !rec.Instrumental && rec.PlainLyrics == "" && rec.SyncedLyrics == "" {
+		return Lyrics{}, ErrLyricsNotFound
+	}
+	return Lyrics{Plain: rec.PlainLyrics, Synced: rec.SyncedLyrics, Instrumental: rec.Instrumental}, nil
+}
+
+func (p *LRCLibProvider) get(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Korus (https://github.com/Aunali321/korus)")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrLyricsNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lrclib status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// LyricsService serves song lyrics, filling in missing ones from a provider
+// and caching them in the songs table.
+type LyricsService struct {
+	db       *sql.DB
+	provider LyricsProvider
+}
+
+// NewLyricsService creates the service. provider may be nil, in which case
+// only lyrics read from the files are served.
+func NewLyricsService(conn *sql.DB, provider LyricsProvider) *LyricsService {
+	return &LyricsService{db: conn, provider: provider}
+}
+
+// Get returns a song's lyrics. It returns sql.ErrNoRows when the song does
+// not exist or is not readable with access.
+func (l *LyricsService) Get(ctx context.Context, access db.Access, songID int64) (Lyrics, error) {
+	var lyr Lyrics
+	var title string
+	var album, artist sql.NullString
+	var fetchedAt sql.NullTime
+	var durationMs sql.NullInt64
+	err := l.db.QueryRowContext(ctx, `
+		SELECT s.title, COALESCE(s.lyrics, ''), COALESCE(s.lyrics_synced, ''), COALESCE(s.lyrics_source, ''), s.lyrics_fetched_at,
+		       s.duration_ms, al.title,
+		       COALESCE((SELECT a.name FROM song_artists sa JOIN artists a ON a.id = sa.artist_id
+		                 WHERE sa.song_id = s.id ORDER BY sa.position LIMIT 1), ar.name)
+		FROM songs s
+		`+db.SongJoins+`
+		WHERE s.id = ? AND `+access.Filter("s"), songID).Scan(&title, &lyr.Plain, &lyr.Synced, &lyr.Source, &fetchedAt, &durationMs, &album, &artist)
+	if err != nil {
+		return lyr, err
+	}
+	if lyr.Plain != "" || lyr.Synced != "" || l.provider == nil || (fetchedAt.Valid && time.Since(fetchedAt.Time) < lyricsRetry) {
+		return lyr, nil
+	}
+
+	found, err := l.provider.Lookup(ctx, LyricsQuery{Title: title, Artist: artist.String, Album: album.String, Duration: int(durationMs.Int64 / 1000)})
+	if err != nil && !errors.Is(err, ErrLyricsNotFound) {
+		// Serve what we have; the next request tries again
+		log.Printf("lyrics for song %d: %v", songID, err)
+		return lyr, nil
+	}
+	if err == nil {
+		lyr = found
+		lyr.Source = l.provider.Name()
+	}
+	_, err = l.db.ExecContext(ctx, `
+		UPDATE songs SET lyrics = ?, lyrics_synced = ?, lyrics_source = NULLIF(?, ''), lyrics_fetched_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, lyr.Plain, lyr.Synced, lyr.Source, songID)
+	return lyr, err
+}
+
+// lyricsSidecars returns the lyrics files next to an audio file: files named
+// like it, or like the song's title, with an .lrc or .txt extension.
+func lyricsSidecars(path, title string) []string {
+	dir := filepath.Dir(path)
+	stems := []string{strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	if title != "" && !strings.ContainsAny(title, `/\`) && title != stems[0] {
+		stems = append(stems, title)
+	}
+	var found []string
+	for _, stem := range stems {
+		for _, ext := range []string{".lrc", ".LRC", ".txt", ".TXT"} {
+			candidate := filepath.Join(dir, stem+ext)
+			if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+				found = append(found, candidate)
+			}
+		}
+	}
+	return found
+}
+
+// sidecarLyricsMtime returns the newest mtime of an audio file's lyrics
+// files in Unix nanoseconds, or 0 when there are none.
+func sidecarLyricsMtime(path, title string) int64 {
+	var newest int64
+	for _, f := range lyricsSidecars(path, title) {
+		if info, err := os.Stat(f); err == nil {
+			newest = max(newest, info.ModTime().UnixNano())
+		}
+	}
+	return newest
+}
+
+// readSidecarLyrics reads an audio file's lyrics files. An .lrc without
+// timestamps, or a .txt, provides plain lyrics.
+func readSidecarLyrics(path, title string) (plain, synced string) {
+	for _, f := range lyricsSidecars(path, title) {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(string(b), "\ufeff"))
+		if text == "" {
+			continue
+		}
+		if isLRCFormat(text) {
+			if synced == "" {
+				synced = text
+			}
+		} else if plain == "" {
+			plain = text
+		}
+	}
+	return plain, synced
+}
+
+func isLyricsFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".lrc" || ext == ".txt"
+}
+
+// lyricsFileSongs returns the audio files a lyrics file belongs to: those
+// named like it, and known songs in its folder titled like it. Cue tracks
+// don't read lyrics files named after their title.
+func (s *ScannerService) lyricsFileSongs(ctx context.Context, path string) []string {
+	dir := filepath.Dir(path)
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var files []string
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if !e.IsDir() && isAudioFile(e.Name()) && strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())) == stem {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT file_path FROM songs WHERE title = ? AND source_path IS NULL AND file_path LIKE ? ESCAPE '\'`, stem, db.EscapeLike(dir+string(filepath.Separator))+"%")
+	if err != nil {
+		return files
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		if rows.Scan(&p) == nil && filepath.Dir(p) == dir {
+			files = append(files, p)
+		}
+	}
+	return files
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -15,13 +14,6 @@ type MusicBrainzService struct {
 	baseURL   string
 }
 
-type ListenBrainzService struct {
-	client  *http.Client
-	token   string
-	user    string
-	baseURL string
-}
-
 func NewMusicBrainzService(agent string) *MusicBrainzService {
 	return &MusicBrainzService{
 		client:    &http.Client{Timeout: 10 * time.Second},
@@ -30,15 +22,6 @@ func NewMusicBrainzService(agent string) *MusicBrainzService {
 	}
 }
 
-func NewListenBrainzService(token, user string) *ListenBrainzService {
-	return &ListenBrainzService{
-		client:  &http.Client{Timeout: 10 * time.Second},
-		token:   token,
-		user:    user,
-		baseURL: "https://api.listenbrainz.org/1",
-	}
-}
-
 func (m *MusicBrainzService) Enrich(ctx context.Context, entity, id string) (string, error) {
 	// Minimal stub: fetch by MBID if provided.
 	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/%s/%s?fmt=json", m.baseURL, entity, id), nil)
@@ -63,29 +46,3 @@ func (m *MusicBrainzService) Enrich(ctx context.Context, entity, id string) (str
 	}
 	return "", fmt.Errorf("id not found")
 }
-
-func (l *ListenBrainzService) SubmitListen(ctx context.Context, track string, listenedAt int64) error {
-	if l.token == "" || l.user == "" {
-		return nil
-	}
-	body := fmt.Sprintf(`{"listen_type":"single","payload":[{"listened_at":%d,"track_metadata":{"artist_name":"","track_name":"%s","additional_info":{"media_player":"korus"}}}]}`, listenedAt, escape(track))
-	req, err := http.NewRequestWithContext(ctx, "POST", l.baseURL+"/submit-listens", strings.NewReader(body))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Token "+l.token)
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := l.client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("listenbrainz status %d", resp.StatusCode)
-	}
-	return nil
-}
-
-func escape(s string) string {
-	return strings.ReplaceAll(s, `"`, `\"`)
-}
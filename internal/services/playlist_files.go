@@ -0,0 +1,455 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Aunali321/korus/internal/db"
+)
+
+// Playlist file formats supported for export and import
+const (
+	PlaylistM3U8 = "m3u8"
+	PlaylistXSPF = "xspf"
+	PlaylistJSPF = "jspf"
+)
+
+// PlaylistFileEntry is one track of a playlist file. Duration is in seconds.
+type PlaylistFileEntry struct {
+	Path     string
+	Title    string
+	Artist   string
+	Album    string
+	MBID     string
+	ISRC     string
+	Duration int
+}
+
+// PlaylistFormat returns the playlist file format named by format, or else
+// implied by filename's extension. It returns "" when neither is known.
+func PlaylistFormat(format, filename string) string {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	}
+	switch strings.ToLower(format) {
+	case "m3u", "m3u8":
+		return PlaylistM3U8
+	case "xspf":
+		return PlaylistXSPF
+	case "jspf", "json":
+		return PlaylistJSPF
+	}
+	return ""
+}
+
+// ParsePlaylistFile reads a playlist file, returning its title (which may be
+// empty) and entries.
+func ParsePlaylistFile(format string, r io.Reader) (string, []PlaylistFileEntry, error) {
+	switch format {
+	case PlaylistM3U8:
+		return parseM3U8(r)
+	case PlaylistXSPF:
+		var doc xspfPlaylist
+		if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+			return "", nil, fmt.Errorf("parse xspf: %w", err)
+		}
+		entries := make([]PlaylistFileEntry, 0, len(doc.Tracks))
+		for _, t := range doc.Tracks {
+			entries = append(entries, t.entry())
+		}
+		return doc.Title, entries, nil
+	case PlaylistJSPF:
+		var doc jspfDocument
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return "", nil, fmt.Errorf("parse jspf: %w", err)
+		}
+		entries := make([]PlaylistFileEntry, 0, len(doc.Playlist.Tracks))
+		for _, t := range doc.Playlist.Tracks {
+			entries = append(entries, t.entry())
+		}
+		return doc.Playlist.Title, entries, nil
+	}
+	return "", nil, fmt.Errorf("unsupported playlist format %q", format)
+}
+
+// WritePlaylistFile writes entries as a playlist file
+func WritePlaylistFile(w io.Writer, format, title, creator string, entries []PlaylistFileEntry) error {
+	switch format {
+	case PlaylistM3U8:
+		bw := bufio.NewWriter(w)
+		fmt.Fprintf(bw, "#EXTM3U\n#PLAYLIST:%s\n", title)
+		for _, e := range entries {
+			fmt.Fprintf(bw, "#EXTINF:%d,%s - %s\n%s\n", e.Duration, e.Artist, e.Title, e.Path)
+		}
+		return bw.Flush()
+	case PlaylistXSPF:
+		doc := xspfPlaylist{Xmlns: "http://xspf.org/ns/0/", Version: "1", Title: title, Creator: creator}
+		for _, e := range entries {
+			doc.Tracks = append(doc.Tracks, newXSPFTrack(e))
+		}
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		return enc.Encode(doc)
+	case PlaylistJSPF:
+		doc := jspfDocument{Playlist: jspfPlaylist{Title: title, Creator: creator, Tracks: []xspfTrack{}}}
+		for _, e := range entries {
+			doc.Playlist.Tracks = append(doc.Playlist.Tracks, newXSPFTrack(e))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	}
+	return fmt.Errorf("unsupported playlist format %q", format)
+}
+
+func parseM3U8(r io.Reader) (string, []PlaylistFileEntry, error) {
+	var title string
+	var entries []PlaylistFileEntry
+	var pending PlaylistFileEntry
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>,<artist> - <title>
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			duration, name, _ := strings.Cut(info, ",")
+			if d, err := strconv.Atoi(strings.TrimSpace(duration)); err == nil && d > 0 {
+				pending.Duration = d
+			}
+			if artist, title, ok := strings.Cut(name, " - "); ok {
+				pending.Artist, pending.Title = strings.TrimSpace(artist), strings.TrimSpace(title)
+			} else {
+				pending.Title = strings.TrimSpace(name)
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Path = fileLocation(line)
+			entries = append(entries, pending)
+			pending = PlaylistFileEntry{}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", nil, fmt.Errorf("parse m3u8: %w", err)
+	}
+	return title, entries, nil
+}
+
+// fileLocation turns a file:// URI into a path and leaves other locations alone
+func fileLocation(loc string) string {
+	if u, err := url.Parse(loc); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return loc
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Xmlns   string      `xml:"xmlns,attr,omitempty"`
+	Version string      `xml:"version,attr,omitempty"`
+	Title   string      `xml:"title,omitempty"`
+	Creator string      `xml:"creator,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+// xspfTrack is a track in both XSPF and JSPF, which is XSPF as JSON
+type xspfTrack struct {
+	Location   jspfStrings `xml:"location,omitempty" json:"location,omitempty"`
+	Identifier jspfStrings `xml:"identifier,omitempty" json:"identifier,omitempty"`
+	Title      string      `xml:"title,omitempty" json:"title,omitempty"`
+	Creator    string      `xml:"creator,omitempty" json:"creator,omitempty"`
+	Album      string      `xml:"album,omitempty" json:"album,omitempty"`
+	Duration   int         `xml:"duration,omitempty" json:"duration,omitempty"`
+}
+
+type jspfDocument struct {
+	Playlist jspfPlaylist `json:"playlist"`
+}
+
+type jspfPlaylist struct {
+	Title   string      `json:"title,omitempty"`
+	Creator string      `json:"creator,omitempty"`
+	Tracks  []xspfTrack `json:"track"`
+}
+
+// jspfStrings decodes a JSON string or array of strings, since JSPF writers
+// (ListenBrainz among them) disagree on which one identifier and location are.
+type jspfStrings []string
+
+func (s *jspfStrings) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*s = jspfStrings{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return errors.New("expected a string or an array of strings")
+	}
+	*s = many
+	return nil
+}
+
+const (
+	recordingPrefix = "https://musicbrainz.org/recording/"
+	isrcPrefix      = "isrc:"
+)
+
+var mbidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+func newXSPFTrack(e PlaylistFileEntry) xspfTrack {
+	t := xspfTrack{Title: e.Title, Creator: e.Artist, Album: e.Album, Duration: e.Duration * 1000}
+	if e.Path != "" {
+		t.Location = jspfStrings{(&url.URL{Scheme: "file", Path: e.Path}).String()}
+	}
+	if e.MBID != "" {
+		t.Identifier = append(t.Identifier, recordingPrefix+e.MBID)
+	}
+	if e.ISRC != "" {
+		t.Identifier = append(t.Identifier, isrcPrefix+e.ISRC)
+	}
+	return t
+}
+
+func (t xspfTrack) entry() PlaylistFileEntry {
+	e := PlaylistFileEntry{Title: t.Title, Artist: t.Creator, Album: t.Album, Duration: t.Duration / 1000}
+	if len(t.Location) > 0 {
+		e.Path = fileLocation(t.Location[0])
+	}
+	for _, id := range t.Identifier {
+		lower := strings.ToLower(id)
+		switch {
+		case strings.Contains(lower, "musicbrainz.org/recording/"):
+			e.MBID = strings.ToLower(mbidPattern.FindString(id))
+		case strings.HasPrefix(lower, isrcPrefix), strings.HasPrefix(lower, "urn:isrc:"):
+			e.ISRC = strings.ToUpper(id[strings.LastIndex(id, ":")+1:])
+		}
+	}
+	return e
+}
+
+// PlaylistImportMiss is an imported entry that matched no library song
+type PlaylistImportMiss struct {
+	Index  int    `json:"index"`
+	Title  string `json:"title,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// PlaylistFileEntries returns the readable songs of a playlist, in order, as
+// playlist file entries. A cue track's path is the audio file it is cut from,
+// as its own file_path is a virtual one no other player can open; its title
+// tells it apart from the file's other tracks when read back.
+func PlaylistFileEntries(ctx context.Context, conn *sql.DB, access db.Access, playlistID int64) ([]PlaylistFileEntry, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT COALESCE(s.source_path, s.file_path), s.title, al.title, COALESCE(s.mbid, ''), COALESCE(s.isrc, ''), COALESCE(s.duration_ms, 0) / 1000,
+		       COALESCE((SELECT GROUP_CONCAT(a.name, ', ') FROM song_artists sa JOIN artists a ON a.id = sa.artist_id WHERE sa.song_id = s.id), ar.name, '')
+		FROM playlist_songs ps
+		JOIN songs s ON s.id = ps.song_id
+		`+db.SongJoins+`
+		WHERE ps.playlist_id = ? AND `+access.Filter("s")+`
+		ORDER BY ps.position
+	`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []PlaylistFileEntry
+	for rows.Next() {
+		var e PlaylistFileEntry
+		var album sql.NullString
+		if err := rows.Scan(&e.Path, &e.Title, &album, &e.MBID, &e.ISRC, &e.Duration, &e.Artist); err != nil {
+			return nil, err
+		}
+		e.Album = album.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// MatchPlaylistEntries resolves playlist file entries to songs readable with
+// access. Each entry is tried by MBID, then ISRC, then path (exactly, then by
+// its last few path components, as other machines keep music elsewhere), then
+// artist and title. The result holds a song ID per entry, 0 when unmatched.
+func MatchPlaylistEntries(ctx context.Context, conn *sql.DB, access db.Access, entries []PlaylistFileEntry) []int64 {
+	m := playlistMatcher{db: conn, filter: access.Filter("s")}
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		var id int64
+		if e.MBID != "" {
+			id = m.one(ctx, `s.mbid = ? COLLATE NOCASE`, e.MBID)
+		}
+		if id == 0 && e.ISRC != "" {
+			id = m.one(ctx, `s.isrc = ? COLLATE NOCASE`, e.ISRC)
+		}
+		if id == 0 {
+			id = m.byPath(ctx, e.Path, e.Title)
+		}
+		if id == 0 {
+			id = m.byTitle(ctx, e)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+type playlistMatcher struct {
+	db     *sql.DB
+	filter string
+}
+
+// one returns the song matching cond, or 0 when none or several do
+func (m playlistMatcher) one(ctx context.Context, cond string, args ...any) int64 {
+	rows, err := m.db.QueryContext(ctx, `SELECT s.id FROM songs s WHERE `+cond+` AND `+m.filter+` LIMIT 2`, args...)
+	if err != nil {
+		return 0
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) != 1 {
+		return 0
+	}
+	return ids[0]
+}
+
+// byPath matches p exactly, then by its last few path components. title
+// picks the track when p is a file a cue sheet splits.
+func (m playlistMatcher) byPath(ctx context.Context, p, title string) int64 {
+	if p == "" {
+		return 0
+	}
+	if id := m.inFile(ctx, `= ?`, p, title); id > 0 {
+		return id
+	}
+	// Windows players such as foobar2000 write backslashes
+	parts := strings.Split(strings.Trim(path.Clean(strings.ReplaceAll(p, `\`, "/")), "/"), "/")
+	for n := min(3, len(parts)); n >= 1; n-- {
+		suffix := "/" + strings.Join(parts[len(parts)-n:], "/")
+		if id := m.inFile(ctx, `LIKE ? ESCAPE '\'`, "%"+db.EscapeLike(suffix), title); id > 0 {
+			return id
+		}
+	}
+	return 0
+}
+
+// inFile returns the song whose file path matches op with arg. Failing that
+// it looks among the cue tracks cut from a matching file for the one titled
+// title, as exported playlists list those by their audio file.
+func (m playlistMatcher) inFile(ctx context.Context, op, arg, title string) int64 {
+	if id := m.one(ctx, `s.file_path `+op, arg); id > 0 {
+		return id
+	}
+	title = normalizeMatch(title)
+	if title == "" {
+		return 0
+	}
+	rows, err := m.db.QueryContext(ctx, `SELECT s.id, s.title FROM songs s WHERE s.source_path `+op+` AND `+m.filter, arg)
+	if err != nil {
+		return 0
+	}
+	defer rows.Close()
+	var match int64
+	for rows.Next() {
+		var id int64
+		var candidate string
+		if rows.Scan(&id, &candidate) != nil || normalizeMatch(candidate) != title {
+			continue
+		}
+		if match != 0 {
+			return 0
+		}
+		match = id
+	}
+	return match
+}
+
+// byTitle matches on normalized title and artist, preferring the candidate
+// closest in duration when several match.
+func (m playlistMatcher) byTitle(ctx context.Context, e PlaylistFileEntry) int64 {
+	title := normalizeMatch(e.Title)
+	if title == "" {
+		return 0
+	}
+	core := strings.TrimSpace(bracketPattern.ReplaceAllString(e.Title, ""))
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT s.id, s.title, COALESCE(s.duration_ms, 0) / 1000,
+		       COALESCE((SELECT GROUP_CONCAT(a.name, ' / ') FROM artists a
+		                 WHERE a.id = al.artist_id OR a.id IN (SELECT artist_id FROM song_artists WHERE song_id = s.id)), '')
+		FROM songs s
+		JOIN albums al ON al.id = s.album_id
+		WHERE s.title LIKE ? ESCAPE '\' AND `+m.filter+`
+		LIMIT 200
+	`, "%"+db.EscapeLike(core)+"%")
+	if err != nil {
+		return 0
+	}
+	defer rows.Close()
+	artist := normalizeMatch(e.Artist)
+	var best int64
+	bestDelta := -1
+	for rows.Next() {
+		var id int64
+		var candidate, artists string
+		var duration int
+		if rows.Scan(&id, &candidate, &duration, &artists) != nil || normalizeMatch(candidate) != title {
+			continue
+		}
+		// Either side may list more artists than the other
+		if names := normalizeMatch(artists); artist != "" && !strings.Contains(names, artist) && (names == "" || !strings.Contains(artist, names)) {
+			continue
+		}
+		delta := 0
+		if e.Duration > 0 {
+			delta = max(duration-e.Duration, e.Duration-duration)
+		}
+		if bestDelta < 0 || delta < bestDelta {
+			best, bestDelta = id, delta
+		}
+	}
+	return best
+}
+
+var bracketPattern = regexp.MustCompile(`\s*[(\[][^)\]]*[)\]]`)
+
+// normalizeMatch lowercases s and drops bracketed parts ("(Remastered)"),
+// featured artists and punctuation, so near-identical tags compare equal.
+func normalizeMatch(s string) string {
+	s = strings.ToLower(bracketPattern.ReplaceAllString(s, ""))
+	for _, sep := range []string{" feat. ", " feat ", " ft. ", " featuring "} {
+		if i := strings.Index(s, sep); i >= 0 {
+			s = s[:i]
+		}
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseM3U8(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		wantTitle   string
+		wantEntries []PlaylistFileEntry
+	}{
+		{
+			name:      "extended",
+			text:      "\ufeff#EXTM3U\n#PLAYLIST:Road Trip\n#EXTINF:215,The Band - Opening\n/music/a.flac\n\n#EXTINF:-1,Untitled Song\nb.mp3\n",
+			wantTitle: "Road Trip",
+			wantEntries: []PlaylistFileEntry{
+				{Path: "/music/a.flac", Artist: "The Band", Title: "Opening", Duration: 215},
+				{Path: "b.mp3", Title: "Untitled Song"},
+			},
+		},
+		{
+			name: "plain paths",
+			text: "a.flac\r\n  b.flac  \r\n",
+			wantEntries: []PlaylistFileEntry{
+				{Path: "a.flac"},
+				{Path: "b.flac"},
+			},
+		},
+		{
+			name: "file URIs and other comments",
+			text: "#EXTM3U\n#EXTALB:Ignored\n#EXTINF:60,A - B - C\nfile:///music/Some%20Song.flac\nhttps://example.com/stream.mp3\n",
+			wantEntries: []PlaylistFileEntry{
+				{Path: "/music/Some Song.flac", Artist: "A", Title: "B - C", Duration: 60},
+				{Path: "https://example.com/stream.mp3"},
+			},
+		},
+		{
+			name: "EXTINF without a path",
+			text: "#EXTM3U\n#EXTINF:60,A - B\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, entries, err := parseM3U8(strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatalf("parseM3U8: %v", err)
+			}
+			if title != tt.wantTitle {
+				t.Errorf("title = %q, want %q", title, tt.wantTitle)
+			}
+			if !reflect.DeepEqual(entries, tt.wantEntries) {
+				t.Errorf("entries = %+v, want %+v", entries, tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestNormalizeMatch(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Hello World", "helloworld"},
+		{"Hello World (Remastered 2011)", "helloworld"},
+		{"Hello World [Live]", "helloworld"},
+		{"Hello, World!", "helloworld"},
+		{"Song feat. Someone", "song"},
+		{"Song ft. Someone", "song"},
+		{"Song Featuring Someone", "song"},
+		{"Feature Presentation", "featurepresentation"},
+		{"Ça Plane Pour Moi", "çaplanepourmoi"},
+		{"99 Luftballons", "99luftballons"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeMatch(tt.in); got != tt.want {
+			t.Errorf("normalizeMatch(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
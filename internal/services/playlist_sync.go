@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Aunali321/korus/internal/db"
+)
+
+// ErrPlaylistConflict is returned when a synced playlist's file changed on
+// disk since Korus last read or wrote it. Writing the playlist back would
+// discard those changes; a rescan loads them instead.
+var ErrPlaylistConflict = errors.New("playlist file changed on disk since it was last synced; rescan to load it")
+
+// mirrored reports whether path is inside the playlist mirror folder. Those
+// files are written by Korus and must not be imported back.
+func (s *ScannerService) mirrored(path string) bool {
+	return s.mirrorDir != "" && pathWithin(s.mirrorDir, path)
+}
+
+// CheckPlaylistFile returns ErrPlaylistConflict when two-way sync is enabled
+// and the playlist's file was changed outside Korus since it was last synced.
+func (s *ScannerService) CheckPlaylistFile(ctx context.Context, playlistID int64) error {
+	if !s.playlistSync {
+		return nil
+	}
+	var source sql.NullString
+	var synced sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, `SELECT source_path, source_mtime FROM playlists WHERE id = ?`, playlistID).Scan(&source, &synced); err != nil || !source.Valid {
+		return nil
+	}
+	info, err := os.Stat(source.String)
+	if err != nil {
+		// A missing file is simply written again
+		return nil
+	}
+	if synced.Valid && info.ModTime().UnixNano() != synced.Int64 {
+		return ErrPlaylistConflict
+	}
+	return nil
+}
+
+// SyncPlaylistFile writes a playlist's songs to disk after it changed. An
+// imported playlist is written back to its own file when two-way sync is
+// enabled; a user-created one is mirrored into the mirror folder when that
+// is configured. Other playlists, including smart ones, are left alone.
+func (s *ScannerService) SyncPlaylistFile(ctx context.Context, playlistID int64) error {
+	var name, owner string
+	var source, mirror, rules sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT p.name, u.username, p.source_path, p.mirror_path, p.rules
+		FROM playlists p JOIN users u ON u.id = p.user_id WHERE p.id = ?
+	`, playlistID).Scan(&name, &owner, &source, &mirror, &rules)
+	if err != nil {
+		return err
+	}
+
+	if source.Valid {
+		if !s.playlistSync {
+			return nil
+		}
+		if err := s.CheckPlaylistFile(ctx, playlistID); err != nil {
+			return err
+		}
+		mtime, err := s.writeM3U8(ctx, playlistID, name, source.String)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.ExecContext(ctx, `UPDATE playlists SET source_mtime = ? WHERE id = ?`, mtime, playlistID)
+		return err
+	}
+
+	if s.mirrorDir == "" || rules.Valid {
+		return nil
+	}
+	target := filepath.Join(s.mirrorDir, safeFilename(owner), safeFilename(name)+".m3u8")
+	var taken int
+	_ = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM playlists WHERE mirror_path = ? AND id != ?`, target, playlistID).Scan(&taken)
+	if taken > 0 {
+		target = strings.TrimSuffix(target, ".m3u8") + " (" + strconv.FormatInt(playlistID, 10) + ").m3u8"
+	}
+	if _, err := s.writeM3U8(ctx, playlistID, name, target); err != nil {
+		return err
+	}
+	if mirror.Valid && mirror.String != target {
+		_ = os.Remove(mirror.String)
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE playlists SET mirror_path = ? WHERE id = ?`, target, playlistID)
+	return err
+}
+
+// RemovePlaylistMirror deletes the mirrored file of a playlist that is about
+// to be deleted.
+func (s *ScannerService) RemovePlaylistMirror(ctx context.Context, playlistID int64) {
+	var mirror sql.NullString
+	if err := s.db.QueryRowContext(ctx, `SELECT mirror_path FROM playlists WHERE id = ?`, playlistID).Scan(&mirror); err == nil && mirror.Valid {
+		_ = os.Remove(mirror.String)
+	}
+}
+
+// writeM3U8 atomically replaces path with the playlist's songs, using paths
+// relative to the playlist's folder, and returns the new file's mtime.
+func (s *ScannerService) writeM3U8(ctx context.Context, playlistID int64, name, path string) (int64, error) {
+	entries, err := PlaylistFileEntries(ctx, s.db, db.Access{All: true}, playlistID)
+	if err != nil {
+		return 0, err
+	}
+	dir := filepath.Dir(path)
+	for i, e := range entries {
+		if rel, err := filepath.Rel(dir, e.Path); err == nil {
+			entries[i].Path = rel
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("create playlist folder: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".korus-playlist-*")
+	if err != nil {
+		return 0, fmt.Errorf("write playlist: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_ = tmp.Chmod(0644)
+	if err := WritePlaylistFile(tmp, PlaylistM3U8, name, "", entries); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("write playlist: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("write playlist: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("write playlist: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.ModTime().UnixNano(), nil
+}
+
+// safeFilename replaces characters that are not allowed in file names
+func safeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "playlist"
+	}
+	return name
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+var errNoAudioStream = errors.New("no audio stream")
+
+// probedTags is the tag.Metadata of a file dhowden/tag can't read (APEv2 in
+// WavPack, APE and TAK, ASF in WMA, Matroska, AIFF and DFF), built from the
+// format and audio stream tags ffprobe reports. Keys are lowercased, the way
+// textTags expects Vorbis comments, and ASF's "WM/" prefix is dropped.
+type probedTags map[string]string
+
+// probeTags reads a file's tags with ffprobe. It fails when ffprobe can't
+// open the file or finds no audio stream in it.
+func (s *ScannerService) probeTags(ctx context.Context, path string) (probedTags, error) {
+	cmd := exec.CommandContext(ctx, s.ffprobePath, "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", path)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+	var data struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string            `json:"codec_type"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &data); err != nil {
+		return nil, fmt.Errorf("ffprobe output: %w", err)
+	}
+
+	tags := probedTags{}
+	add := func(m map[string]string) {
+		for k, v := range m {
+			k = strings.TrimPrefix(strings.ToLower(k), "wm/")
+			if _, ok := tags[k]; !ok {
+				tags[k] = v
+			}
+		}
+	}
+	// Container tags first; Ogg-style formats keep theirs on the stream
+	add(data.Format.Tags)
+	for _, stream := range data.Streams {
+		if stream.CodecType == "audio" {
+			add(stream.Tags)
+			return tags, nil
+		}
+	}
+	return nil, errNoAudioStream
+}
+
+func (p probedTags) first(keys ...string) string {
+	for _, k := range keys {
+		if v := strings.TrimSpace(p[k]); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// position reads a "3" or "3/12" number tag, taking the total from the
+// separate total keys when it isn't given inline.
+func (p probedTags) position(keys []string, totalKeys []string) (int, int) {
+	n, total, _ := strings.Cut(p.first(keys...), "/")
+	if total == "" {
+		total = p.first(totalKeys...)
+	}
+	x, _ := strconv.Atoi(strings.TrimSpace(n))
+	y, _ := strconv.Atoi(strings.TrimSpace(total))
+	return x, y
+}
+
+func (p probedTags) Format() tag.Format     { return tag.UnknownFormat }
+func (p probedTags) FileType() tag.FileType { return tag.UnknownFileType }
+func (p probedTags) Title() string          { return p.first("title") }
+func (p probedTags) Album() string          { return p.first("album", "albumtitle") }
+func (p probedTags) Artist() string         { return p.first("artist", "author", "performer") }
+func (p probedTags) AlbumArtist() string {
+	return p.first("album_artist", "albumartist", "album artist")
+}
+func (p probedTags) Composer() string      { return p.first("composer") }
+func (p probedTags) Genre() string         { return p.first("genre") }
+func (p probedTags) Lyrics() string        { return p.first("lyrics", "unsyncedlyrics") }
+func (p probedTags) Comment() string       { return p.first("comment") }
+func (p probedTags) Picture() *tag.Picture { return nil }
+
+// Year reads the year from a date such as "2003" or "2003-05-01"
+func (p probedTags) Year() int {
+	date := p.first("date", "year", "date_released", "originaldate")
+	if len(date) < 4 {
+		return 0
+	}
+	y, _ := strconv.Atoi(date[:4])
+	return y
+}
+
+func (p probedTags) Track() (int, int) {
+	return p.position([]string{"track", "tracknumber"}, []string{"tracktotal", "totaltracks"})
+}
+
+func (p probedTags) Disc() (int, int) {
+	return p.position([]string{"disc", "discnumber", "partofset"}, []string{"disctotal", "totaldiscs"})
+}
+
+func (p probedTags) Raw() map[string]interface{} {
+	raw := make(map[string]interface{}, len(p))
+	for k, v := range p {
+		raw[k] = v
+	}
+	return raw
+}
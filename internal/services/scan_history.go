@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons a file failed to scan, stored in scan_errors.reason
+const (
+	ScanErrorUnreadable   = "unreadable"      // the file couldn't be opened
+	ScanErrorTags         = "unreadable_tags" // no tag reader understood it
+	ScanErrorMissingTitle = "missing_title"
+	ScanErrorProbe        = "ffprobe_failed"
+	ScanErrorDB           = "db_error"
+)
+
+// scanHistoryKeep is how many scans, with their errors, are kept
+const scanHistoryKeep = 50
+
+// scanStatusColumns are the scan_status columns scanScanStatus reads
+const scanStatusColumns = `id, status, phase, progress, started_at, completed_at, total, current_file, added, updated, removed, failed`
+
+// scanFailure is an ingest error with the reason recorded for it. Errors
+// without one come from the database.
+type scanFailure struct {
+	reason string
+	err    error
+}
+
+func (f *scanFailure) Error() string { return f.err.Error() }
+func (f *scanFailure) Unwrap() error { return f.err }
+
+func scanFailureReason(err error) string {
+	var f *scanFailure
+	if errors.As(err, &f) {
+		return f.reason
+	}
+	return ScanErrorDB
+}
+
+// ScanError is a file a scan failed to read
+type ScanError struct {
+	Path      string    `json:"path"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newScan records a running scan and drops the oldest scans beyond
+// scanHistoryKeep.
+func (s *ScannerService) newScan(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO scan_status(status, phase, progress, total) VALUES ('running', 'scanning', 0, 0)`)
+	if err != nil {
+		return 0, fmt.Errorf("insert scan status: %w", err)
+	}
+	scanID, _ := res.LastInsertId()
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM scan_errors WHERE scan_id <= ?`, scanID-scanHistoryKeep)
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM scan_status WHERE id <= ?`, scanID-scanHistoryKeep)
+	return scanID, nil
+}
+
+// ErrNoScanRunning is returned by CancelScan when there is nothing to cancel
+var ErrNoScanRunning = errors.New("no scan running")
+
+// errScannerClosed is returned for scans started after Close
+var errScannerClosed = errors.New("scanner is shutting down")
+
+// beginScan returns the context of a scan about to run, which CancelScan and
+// Close cancel. The caller holds the scanning flag.
+func (s *ScannerService) beginScan() (context.Context, error) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	if s.closed {
+		atomic.StoreInt32(&s.scanning, 0)
+		return nil, errScannerClosed
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancelScan = cancel
+	s.scanWG.Add(1)
+	return ctx, nil
+}
+
+// endScan releases what beginScan took once the scan has stopped
+func (s *ScannerService) endScan() {
+	s.scanMu.Lock()
+	s.cancelScan()
+	s.cancelScan = nil
+	s.scanMu.Unlock()
+	atomic.StoreInt32(&s.scanning, 0)
+	s.scanWG.Done()
+}
+
+// finishScan records how a scan ended: cancelled when ctx was, failed with
+// err, or else completed having found total files.
+func (s *ScannerService) finishScan(ctx context.Context, scanID int64, total int, err error) {
+	cancelled := ctx.Err() != nil
+	ctx = context.WithoutCancel(ctx)
+	switch {
+	case cancelled:
+		_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET status='cancelled', completed_at=? WHERE id=?`, time.Now(), scanID)
+		log.Printf("scan: cancelled")
+	case err != nil:
+		_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET status='failed', completed_at=? WHERE id=?`, time.Now(), scanID)
+	default:
+		_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET status='completed', phase='completed', progress=?, completed_at=? WHERE id=?`, total, time.Now(), scanID)
+	}
+	s.publishScan(ctx, scanID)
+}
+
+// CancelScan stops the running scan. Files already ingested are kept but
+// nothing is cleaned up, since the scan didn't see the whole library; the
+// scan is marked cancelled once its workers have stopped.
+func (s *ScannerService) CancelScan() error {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	if s.cancelScan == nil {
+		return ErrNoScanRunning
+	}
+	s.cancelScan()
+	return nil
+}
+
+// Close cancels the running scan, and any ffprobe or enrichment requests it
+// is waiting on, then waits for it to record that until ctx is done. No
+// scans start after Close.
+func (s *ScannerService) Close(ctx context.Context) error {
+	s.scanMu.Lock()
+	s.closed = true
+	s.scanMu.Unlock()
+	s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.scanWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CancelInterruptedScans marks scans left running by a previous process,
+// which was stopped without a chance to finish them, as cancelled.
+func (s *ScannerService) CancelInterruptedScans(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE scan_status SET status='cancelled', completed_at=? WHERE status='running'`, time.Now())
+	return err
+}
+
+// setPhase moves a scan to its next phase
+func (s *ScannerService) setPhase(ctx context.Context, scanID int64, phase string) {
+	_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET phase = ? WHERE id = ?`, phase, scanID)
+	s.publishScan(ctx, scanID)
+}
+
+// publishScan sends a scan's status to the event stream. The file being
+// read may be in a library a user can't see, so only admins are sent it.
+func (s *ScannerService) publishScan(ctx context.Context, scanID int64) {
+	if s.events == nil {
+		return
+	}
+	st, err := scanScanStatus(s.db.QueryRowContext(ctx, `SELECT `+scanStatusColumns+` FROM scan_status WHERE id = ?`, scanID))
+	if err == nil {
+		redacted := st
+		redacted.CurrentFile = ""
+		s.events.Publish(Event{Type: EventScanStatus, Data: st, Admins: true, Redacted: redacted})
+	}
+}
+
+// publishSongs sends the songs a scan added to or removed from a library to
+// the event stream
+func (s *ScannerService) publishSongs(eventType string, libraryID int64, songIDs []int64) {
+	if len(songIDs) == 0 {
+		return
+	}
+	s.events.Publish(Event{Type: eventType, Data: SongsEvent{LibraryID: libraryID, SongIDs: songIDs}, LibraryID: libraryID})
+}
+
+// recordFailure logs a file a scan couldn't ingest
+func (s *ScannerService) recordFailure(ctx context.Context, scanID int64, path string, err error) {
+	log.Printf("scan: %s: %v", path, err)
+	_, _ = s.db.ExecContext(ctx, `INSERT INTO scan_errors(scan_id, path, reason, message) VALUES (?, ?, ?, ?)`,
+		scanID, path, scanFailureReason(err), err.Error())
+}
+
+// History returns recent scans, newest first
+func (s *ScannerService) History(ctx context.Context, limit, offset int) ([]ScanStatus, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+scanStatusColumns+` FROM scan_status ORDER BY id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scans := []ScanStatus{}
+	for rows.Next() {
+		st, err := scanScanStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		scans = append(scans, st)
+	}
+	return scans, rows.Err()
+}
+
+// Errors returns the files a scan failed to read, by path. It returns
+// sql.ErrNoRows when the scan doesn't exist.
+func (s *ScannerService) Errors(ctx context.Context, scanID int64) ([]ScanError, error) {
+	var exists int
+	if err := s.db.QueryRowContext(ctx, `SELECT 1 FROM scan_status WHERE id = ?`, scanID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT path, reason, message, created_at FROM scan_errors WHERE scan_id = ? ORDER BY path
+	`, scanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scanErrors := []ScanError{}
+	for rows.Next() {
+		var e ScanError
+		if err := rows.Scan(&e.Path, &e.Reason, &e.Message, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		scanErrors = append(scanErrors, e)
+	}
+	return scanErrors, rows.Err()
+}
+
+// scanScanStatus scans a scan_status row selected with scanStatusColumns
+func scanScanStatus(row interface{ Scan(...any) error }) (ScanStatus, error) {
+	var st ScanStatus
+	var currentFile sql.NullString
+	err := row.Scan(&st.ID, &st.Status, &st.Phase, &st.Progress, &st.StartedAt, &st.CompletedAt, &st.Total, &currentFile,
+		&st.Added, &st.Updated, &st.Removed, &st.Failed)
+	st.CurrentFile = currentFile.String
+	return st, err
+}
@@ -1,10 +1,11 @@
 package services
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,6 +17,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +26,9 @@ import (
 
 	"github.com/dhowden/tag"
 	"github.com/fsnotify/fsnotify"
+
+	"github.com/Aunali321/korus/internal/db"
+	"github.com/Aunali321/korus/internal/models"
 )
 
 // lrcPattern matches LRC timestamp format [mm:ss] or [mm:ss.xx]
@@ -33,7 +38,10 @@ func isLRCFormat(text string) bool {
 	return lrcPattern.MatchString(text)
 }
 
+// ScanStatus is a scan's progress. Added, Updated and Failed count audio
+// files, leaving out unchanged files a rescan skipped; Removed counts songs.
 type ScanStatus struct {
+	ID          int64      `json:"id"`
 	Status      string     `json:"status"`
 	Phase       string     `json:"phase"`
 	Progress    int        `json:"progress"`
@@ -41,12 +49,14 @@ type ScanStatus struct {
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 	CurrentFile string     `json:"current_file,omitempty"`
 	Total       int        `json:"total"`
+	Added       int        `json:"added"`
+	Updated     int        `json:"updated"`
+	Removed     int        `json:"removed"`
+	Failed      int        `json:"failed"`
 }
 
 type ScannerService struct {
 	db              *sql.DB
-	mediaRoot       string
-	excludePattern  *regexp.Regexp
 	scanEmbedded    bool
 	ffprobePath     string
 	ffmpegPath      string
@@ -58,104 +68,506 @@ type ScannerService struct {
 	enrichEnabled   bool
 	metadataService *MetadataService
 	artistImgCache  string
+	contentHash     bool
+	playlistSync    bool
+	mirrorDir       string
+	coverPriority   []string
+	artistImages    []string
+	events          *EventBus
+	// rewatch asks Watch to re-read the library roots after they change
+	rewatch chan struct{}
+
+	// ctx outlives requests and is cancelled by Close; each scan runs on a
+	// child of it that CancelScan cancels
+	ctx        context.Context
+	stop       context.CancelFunc
+	scanWG     sync.WaitGroup
+	scanMu     sync.Mutex
+	cancelScan context.CancelFunc
+	closed     bool
 }
 
-func NewScannerService(db *sql.DB, mediaRoot, ffprobePath, ffmpegPath, exclude string, scanEmbeddedCover bool, watch bool, workers int, coverCachePath string, autoPlaylists bool, enrichEnabled bool, metadataURL string) *ScannerService {
-	var re *regexp.Regexp
-	if exclude != "" {
-		re = regexp.MustCompile(exclude)
-	}
+// ScannerConfig holds the scanner's settings, as read from the server config
+type ScannerConfig struct {
+	FFprobePath       string
+	FFmpegPath        string
+	ScanEmbeddedCover bool
+	Watch             bool
+	Workers           int
+	CoverCachePath    string
+	AutoPlaylists     bool
+	EnrichEnabled     bool
+	MetadataURL       string
+	ContentHash       bool
+	PlaylistSync      bool
+	// MirrorDir is where playlists are mirrored as M3U8 files, if set
+	MirrorDir     string
+	CoverPriority []string
+	ArtistImages  []string
+}
+
+func NewScannerService(db *sql.DB, events *EventBus, cfg ScannerConfig) *ScannerService {
+	workers := cfg.Workers
 	if workers < 1 {
 		workers = 8
 	}
+	coverCachePath := cfg.CoverCachePath
 	if coverCachePath == "" {
 		coverCachePath = "./cache/covers"
 	}
 
+	mirrorDir := cfg.MirrorDir
+	if mirrorDir != "" {
+		if abs, err := filepath.Abs(mirrorDir); err == nil {
+			mirrorDir = abs
+		}
+	}
+
 	var metaSvc *MetadataService
-	if enrichEnabled && metadataURL != "" {
-		metaSvc = NewMetadataService(metadataURL)
+	if cfg.EnrichEnabled && cfg.MetadataURL != "" {
+		metaSvc = NewMetadataService(cfg.MetadataURL)
 	}
+	ctx, stop := context.WithCancel(context.Background())
 
 	return &ScannerService{
 		db:              db,
-		mediaRoot:       mediaRoot,
-		excludePattern:  re,
-		scanEmbedded:    scanEmbeddedCover,
-		ffprobePath:     ffprobePath,
-		ffmpegPath:      ffmpegPath,
-		watchEnabled:    watch,
+		scanEmbedded:    cfg.ScanEmbeddedCover,
+		ffprobePath:     cfg.FFprobePath,
+		ffmpegPath:      cfg.FFmpegPath,
+		watchEnabled:    cfg.Watch,
 		workers:         workers,
 		coverCachePath:  coverCachePath,
-		autoPlaylists:   autoPlaylists,
-		enrichEnabled:   enrichEnabled,
+		autoPlaylists:   cfg.AutoPlaylists,
+		enrichEnabled:   cfg.EnrichEnabled,
 		metadataService: metaSvc,
 		artistImgCache:  filepath.Join(coverCachePath, "artists"),
+		contentHash:     cfg.ContentHash,
+		playlistSync:    cfg.PlaylistSync,
+		mirrorDir:       mirrorDir,
+		coverPriority:   lowerAll(cfg.CoverPriority),
+		artistImages:    lowerAll(cfg.ArtistImages),
+		events:          events,
+		rewatch:         make(chan struct{}, 1),
+		ctx:             ctx,
+		stop:            stop,
 	}
 }
 
 func (s *ScannerService) IsScanning() bool { return atomic.LoadInt32(&s.scanning) == 1 }
 
-func (s *ScannerService) StartScan(ctx context.Context) (int64, error) {
+// StartScan scans one library in the background, or every library when
+// libraryID is 0. Files whose size and mtime match what was recorded last
+// time are skipped unless full is set.
+func (s *ScannerService) StartScan(ctx context.Context, libraryID int64, full bool) (int64, error) {
 	if !atomic.CompareAndSwapInt32(&s.scanning, 0, 1) {
 		return 0, errors.New("scan already running")
 	}
+	scanCtx, err := s.beginScan()
+	if err != nil {
+		return 0, err
+	}
 
 	// Insert scan status row immediately so it's visible to status queries
-	res, err := s.db.ExecContext(ctx, `INSERT INTO scan_status(status, phase, progress, total) VALUES ('running', 'scanning', 0, 0)`)
+	scanID, err := s.newScan(ctx)
 	if err != nil {
-		atomic.StoreInt32(&s.scanning, 0)
-		return 0, fmt.Errorf("insert scan status: %w", err)
+		s.endScan()
+		return 0, err
 	}
-	scanID, _ := res.LastInsertId()
 
 	// Run the actual scan in a goroutine
-	go s.runScan(scanID)
+	go s.runScan(scanCtx, scanID, libraryID, full)
 
 	return scanID, nil
 }
 
-func (s *ScannerService) runScan(scanID int64) {
-	defer atomic.StoreInt32(&s.scanning, 0)
-	ctx := context.Background()
+func (s *ScannerService) runScan(ctx context.Context, scanID int64, libraryID int64, full bool) {
+	defer s.endScan()
+
+	libraries, err := s.loadLibraries(ctx, libraryID)
+	if err != nil {
+		log.Printf("scan: load libraries: %v", err)
+		s.finishScan(ctx, scanID, 0, err)
+		return
+	}
+	if len(libraries) == 0 {
+		log.Printf("scan: no libraries to scan")
+		s.finishScan(ctx, scanID, 0, errors.New("no libraries to scan"))
+		return
+	}
 
-	var files []string
-	var playlists []string
-	err := filepath.WalkDir(s.mediaRoot, func(path string, d fs.DirEntry, err error) error {
+	var total int
+	for _, lib := range libraries {
+		n, err := s.scanLibrary(ctx, scanID, lib, full)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("scan: library %q failed: %v", lib.Name, err)
+			}
+			s.finishScan(ctx, scanID, total, err)
+			return
+		}
+		total += n
+	}
+	s.refreshSmartPlaylists(ctx)
+
+	s.finishScan(ctx, scanID, total, nil)
+	log.Printf("scan: completed successfully")
+}
+
+// scanLibrary walks one library, ingests changed files and removes songs
+// that are gone from it. It returns the number of audio files found.
+func (s *ScannerService) scanLibrary(ctx context.Context, scanID int64, lib libraryRoot, full bool) (int, error) {
+	log.Printf("scan: scanning library %q at %s", lib.Name, lib.RootPath)
+	s.setPhase(ctx, scanID, "scanning")
+
+	files, playlists, err := s.collectFiles(lib.RootPath, lib.exclude)
+	if err != nil {
+		return 0, err
+	}
+
+	seenSongs, enrichInfos := s.ingestFiles(ctx, scanID, lib.ID, files, full)
+	s.processIngested(ctx, scanID, enrichInfos)
+	s.refreshArtwork(ctx, lib.ID, nil)
+	// A cancelled scan hasn't seen every song, so nothing may be cleaned up
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("scan: starting cleanup phase")
+	s.setPhase(ctx, scanID, "cleanup")
+	removed, err := s.cleanup(ctx, lib.ID, seenSongs)
+	if err != nil {
+		return 0, fmt.Errorf("cleanup: %w", err)
+	}
+	_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET removed = removed + ? WHERE id = ?`, removed, scanID)
+	log.Printf("scan: cleanup complete")
+
+	if s.autoPlaylists {
+		log.Printf("scan: starting playlist import")
+		s.setPhase(ctx, scanID, "playlists")
+		s.importPlaylists(ctx, lib.RootPath, playlists)
+		log.Printf("scan: playlist import complete")
+	}
+
+	_, _ = s.db.ExecContext(ctx, `UPDATE libraries SET last_scanned_at = ? WHERE id = ?`, time.Now(), lib.ID)
+	return len(files), nil
+}
+
+// libraryRoot is a library with its exclude pattern compiled for scanning.
+type libraryRoot struct {
+	models.Library
+	exclude *regexp.Regexp
+}
+
+// excluded reports whether path matches the library's exclude pattern.
+func (l libraryRoot) excluded(path string) bool {
+	return l.exclude != nil && l.exclude.MatchString(path)
+}
+
+// pathWithin reports whether path is root or inside it.
+func pathWithin(root, path string) bool {
+	root = filepath.Clean(root)
+	path = filepath.Clean(path)
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// loadLibraries returns the library with the given id, or every library
+// when id is 0. Libraries with an invalid exclude pattern are skipped.
+func (s *ScannerService) loadLibraries(ctx context.Context, id int64) ([]libraryRoot, error) {
+	libraries, err := db.GetLibraries(ctx, s.db, db.Access{All: true})
+	if err != nil {
+		return nil, err
+	}
+	var res []libraryRoot
+	for _, l := range libraries {
+		if id != 0 && l.ID != id {
+			continue
+		}
+		lib := libraryRoot{Library: l}
+		if l.ExcludePattern != "" {
+			re, err := regexp.Compile(l.ExcludePattern)
+			if err != nil {
+				log.Printf("scan: library %q has an invalid exclude pattern: %v", l.Name, err)
+				continue
+			}
+			lib.exclude = re
+		}
+		res = append(res, lib)
+	}
+	return res, nil
+}
+
+// RemoveLibrary deletes a library with its songs and albums, then prunes
+// artists and genres nothing refers to anymore.
+func (s *ScannerService) RemoveLibrary(ctx context.Context, id int64) error {
+	if s.IsScanning() {
+		return errors.New("scan already running")
+	}
+	if err := db.DeleteLibrary(ctx, s.db, id); err != nil {
+		return err
+	}
+	s.Rewatch()
+	return s.pruneOrphans(ctx)
+}
+
+// scheduleInterval is how often Schedule checks for libraries that are due.
+const scheduleInterval = time.Minute
+
+// Schedule scans each library whose scan_interval_minutes has passed since
+// it was last scanned. Libraries with an interval of 0 are only scanned on
+// demand.
+func (s *ScannerService) Schedule(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			libraries, err := db.GetLibraries(ctx, s.db, db.Access{All: true})
+			if err != nil {
+				continue
+			}
+			for _, l := range libraries {
+				if l.ScanIntervalMinutes <= 0 {
+					continue
+				}
+				if l.LastScannedAt != nil && time.Since(*l.LastScannedAt) < time.Duration(l.ScanIntervalMinutes)*time.Minute {
+					continue
+				}
+				// One scan at a time; the rest are picked up on later ticks
+				if _, err := s.StartScan(ctx, l.ID, false); err == nil {
+					log.Printf("scan: scheduled scan of library %q", l.Name)
+				}
+				break
+			}
+		}
+	}
+}
+
+// StartPathScan rescans only the given files and directories, as reported
+// by the watcher. Paths that no longer exist have their songs removed;
+// directories are walked. Unchanged files are still skipped.
+func (s *ScannerService) StartPathScan(ctx context.Context, paths []string) (int64, error) {
+	if !atomic.CompareAndSwapInt32(&s.scanning, 0, 1) {
+		return 0, errors.New("scan already running")
+	}
+	scanCtx, err := s.beginScan()
+	if err != nil {
+		return 0, err
+	}
+
+	scanID, err := s.newScan(ctx)
+	if err != nil {
+		s.endScan()
+		return 0, err
+	}
+
+	go s.runPathScan(scanCtx, scanID, paths)
+
+	return scanID, nil
+}
+
+func (s *ScannerService) runPathScan(ctx context.Context, scanID int64, paths []string) {
+	defer s.endScan()
+
+	libraries, err := s.loadLibraries(ctx, 0)
+	if err != nil {
+		s.finishScan(ctx, scanID, 0, err)
+		return
+	}
+
+	var removed []string
+	var total int
+	for _, lib := range libraries {
+		var files, playlists, artDirs []string
+		seenFiles := map[string]struct{}{}
+		for _, path := range paths {
+			if !pathWithin(lib.RootPath, path) || lib.excluded(path) {
+				continue
+			}
+			if isImageFile(path) {
+				// A cover or artist image changed, was added or removed:
+				// re-pick the art of the albums in and below its folder
+				artDirs = append(artDirs, filepath.Dir(path))
+				continue
+			}
+			if isLyricsFile(path) || isCueFile(path) {
+				// A lyrics file or cue sheet changed, was added or removed:
+				// rescan the songs it belongs to
+				related := cueFileSongs(path)
+				if isLyricsFile(path) {
+					related = s.lyricsFileSongs(ctx, path)
+				}
+				for _, f := range related {
+					if _, ok := seenFiles[f]; !ok && !lib.excluded(f) {
+						seenFiles[f] = struct{}{}
+						files = append(files, f)
+					}
+				}
+				continue
+			}
+			if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+				removed = append(removed, path)
+				continue
+			}
+			found, foundPlaylists, err := s.collectFiles(path, lib.exclude)
+			if err != nil {
+				log.Printf("scan: walk %s: %v", path, err)
+				continue
+			}
+			for _, f := range found {
+				if _, ok := seenFiles[f]; !ok {
+					seenFiles[f] = struct{}{}
+					files = append(files, f)
+				}
+			}
+			playlists = append(playlists, foundPlaylists...)
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				artDirs = append(artDirs, path)
+			}
+		}
+		if len(files) == 0 && len(playlists) == 0 && len(artDirs) == 0 {
+			continue
+		}
+		log.Printf("scan: targeted rescan of %d files in library %q", len(files), lib.Name)
+
+		_, enrichInfos := s.ingestFiles(ctx, scanID, lib.ID, files, false)
+		s.processIngested(ctx, scanID, enrichInfos)
+		total += len(files)
+		if len(artDirs) > 0 {
+			s.refreshArtwork(ctx, lib.ID, artDirs)
+		}
+
+		if s.autoPlaylists && len(playlists) > 0 {
+			s.setPhase(ctx, scanID, "playlists")
+			for _, path := range playlists {
+				if err := s.importM3U(ctx, path); err != nil {
+					log.Printf("import playlist %s: %v", path, err)
+				}
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		s.finishScan(ctx, scanID, total, nil)
+		return
+	}
+
+	s.setPhase(ctx, scanID, "cleanup")
+	var removedSongs int
+	for _, path := range removed {
+		removedSongs += s.removePath(ctx, path)
+	}
+	_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET removed = removed + ? WHERE id = ?`, removedSongs, scanID)
+	if err := s.pruneOrphans(ctx); err != nil {
+		log.Printf("scan: cleanup failed: %v", err)
+	}
+	s.refreshSmartPlaylists(ctx)
+
+	s.finishScan(ctx, scanID, total, nil)
+	log.Printf("scan: targeted rescan of %d paths completed (%d files, %d removed)", len(paths), total, len(removed))
+}
+
+// refreshSmartPlaylists re-evaluates every smart playlist against the
+// library as it stands after a scan.
+func (s *ScannerService) refreshSmartPlaylists(ctx context.Context) {
+	if err := db.RefreshSmartPlaylists(ctx, s.db, 0); err != nil {
+		log.Printf("scan: smart playlists: %v", err)
+	}
+}
+
+// removePath deletes the songs at path, including the cue tracks read from
+// it, or below it when path was a directory, along with a playlist imported
+// from it. It returns the number of songs deleted.
+func (s *ScannerService) removePath(ctx context.Context, path string) int {
+	below, belowArgs := underDir("file_path", path)
+	rows, err := s.db.QueryContext(ctx, `SELECT id, COALESCE(library_id, 0) FROM songs WHERE file_path = ? OR source_path = ? OR `+below,
+		append([]any{path, path}, belowArgs...)...)
+	if err != nil {
+		return 0
+	}
+	var ids []int64
+	byLibrary := map[int64][]int64{}
+	for rows.Next() {
+		var id, libraryID int64
+		if rows.Scan(&id, &libraryID) == nil {
+			ids = append(ids, id)
+			byLibrary[libraryID] = append(byLibrary[libraryID], id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		_, _ = s.db.ExecContext(ctx, `DELETE FROM songs WHERE id = ?`, id)
+		_, _ = s.db.ExecContext(ctx, `DELETE FROM songs_fts WHERE rowid = ?`, id)
+	}
+	for libraryID, songIDs := range byLibrary {
+		s.publishSongs(EventSongsRemoved, libraryID, songIDs)
+	}
+	if s.autoPlaylists {
+		below, belowArgs := underDir("source_path", path)
+		_, _ = s.db.ExecContext(ctx, `DELETE FROM playlists WHERE source_path = ? OR `+below, append([]any{path}, belowArgs...)...)
+	}
+	return len(ids)
+}
+
+// underDir returns a condition matching the paths in column below dir, and
+// its arguments. It compares a range of strings rather than taking a substr
+// of the path, as substr counts characters and paths are matched by bytes.
+func underDir(column, dir string) (string, []any) {
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+	// Every path starting with prefix sorts before prefix followed by the
+	// highest code point
+	return "(" + column + " >= ? AND " + column + " < ?)", []any{prefix, prefix + "\U0010FFFF"}
+}
+
+// collectFiles walks root (a directory or a single file) and returns the
+// audio and playlist files in it, honouring the exclude pattern.
+func (s *ScannerService) collectFiles(root string, exclude *regexp.Regexp) (files, playlists []string, err error) {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 		if d.IsDir() {
-			if s.excludePattern != nil && s.excludePattern.MatchString(path) {
+			if exclude != nil && exclude.MatchString(path) {
 				return fs.SkipDir
 			}
 			return nil
 		}
-		if s.excludePattern != nil && s.excludePattern.MatchString(path) {
+		if exclude != nil && exclude.MatchString(path) {
 			return nil
 		}
 		if isAudioFile(path) {
 			files = append(files, path)
-		} else if s.autoPlaylists && isPlaylistFile(path) {
+		} else if s.autoPlaylists && isPlaylistFile(path) && !s.mirrored(path) {
 			playlists = append(playlists, path)
 		}
 		return nil
 	})
-	if err != nil {
-		_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET status='failed' WHERE id=?`, scanID)
-		return
-	}
+	return files, playlists, err
+}
 
+// ingestFiles runs a library's files through the worker pool, reporting
+// progress on the scan_status row. It returns the songs seen (including
+// skipped unchanged files) and the newly ingested songs for enrichment.
+// Once ctx is cancelled the remaining files are skipped, though what was
+// ingested is still counted.
+func (s *ScannerService) ingestFiles(ctx context.Context, scanID int64, libraryID int64, files []string, full bool) (map[int64]struct{}, []songEnrichInfo) {
+	statusCtx := context.WithoutCancel(ctx)
 	// Update total count now that we know it
 	_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET total=? WHERE id=?`, len(files), scanID)
 
 	var mu sync.Mutex
 	seenSongs := map[int64]struct{}{}
-	seenAlbums := map[int64]struct{}{}
-	seenArtists := map[int64]struct{}{}
 	var enrichInfos []songEnrichInfo
+	var addedSongs []int64
 
-	var processedCount int64
+	knownSongs, err := s.loadKnownSongs(ctx)
+	if err != nil {
+		log.Printf("scan: loading fingerprints failed, rescanning everything: %v", err)
+	}
+
+	var processedCount, skippedCount, addedCount, updatedCount, failedCount int64
 	var currentFile atomic.Value
 	currentFile.Store("")
 
@@ -169,8 +581,9 @@ func (s *ScannerService) runScan(scanID int64) {
 			case <-progressDone:
 				count := atomic.LoadInt64(&processedCount)
 				file, _ := currentFile.Load().(string)
-				_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET current_file = ?, progress = ? WHERE id = ?`,
+				_, _ = s.db.ExecContext(statusCtx, `UPDATE scan_status SET current_file = ?, progress = ? WHERE id = ?`,
 					file, count, scanID)
+				s.publishScan(statusCtx, scanID)
 				return
 			case <-ticker.C:
 				count := atomic.LoadInt64(&processedCount)
@@ -178,6 +591,7 @@ func (s *ScannerService) runScan(scanID int64) {
 					file, _ := currentFile.Load().(string)
 					_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET current_file = ?, progress = ? WHERE id = ?`,
 						file, count, scanID)
+					s.publishScan(ctx, scanID)
 					lastReported = count
 				}
 			}
@@ -192,29 +606,52 @@ func (s *ScannerService) runScan(scanID int64) {
 		go func() {
 			defer wg.Done()
 			for file := range fileChan {
+				if ctx.Err() != nil {
+					continue
+				}
 				localSongs := map[int64]struct{}{}
 				localAlbums := map[int64]struct{}{}
 				localArtists := map[int64]struct{}{}
 
 				currentFile.Store(file)
 
-				info, err := s.ingestFile(ctx, file, localSongs, localAlbums, localArtists)
-				if err != nil {
+				// A file that moved into another library is re-read so its
+				// song and album follow it
+				prev, known := knownSongs[file]
+				fp, unchanged := s.fingerprint(ctx, file, prev, known && prev.libraryID == libraryID && !full)
+				if unchanged {
+					mu.Lock()
+					for _, id := range prev.ids {
+						seenSongs[id] = struct{}{}
+					}
+					mu.Unlock()
+					atomic.AddInt64(&skippedCount, 1)
+					atomic.AddInt64(&processedCount, 1)
+					continue
+				}
+
+				infos, err := s.ingestFile(ctx, libraryID, file, fp, localSongs, localAlbums, localArtists)
+				if err != nil && ctx.Err() != nil {
+					// Interrupted by cancellation, not a problem with the file
+					atomic.AddInt64(&processedCount, 1)
+				} else if err != nil {
+					s.recordFailure(ctx, scanID, file, err)
+					atomic.AddInt64(&failedCount, 1)
 					atomic.AddInt64(&processedCount, 1)
 				} else {
+					if known {
+						atomic.AddInt64(&updatedCount, 1)
+					} else {
+						atomic.AddInt64(&addedCount, 1)
+					}
 					mu.Lock()
 					for id := range localSongs {
 						seenSongs[id] = struct{}{}
+						if !slices.Contains(prev.ids, id) {
+							addedSongs = append(addedSongs, id)
+						}
 					}
-					for id := range localAlbums {
-						seenAlbums[id] = struct{}{}
-					}
-					for id := range localArtists {
-						seenArtists[id] = struct{}{}
-					}
-					if info != nil {
-						enrichInfos = append(enrichInfos, *info)
-					}
+					enrichInfos = append(enrichInfos, infos...)
 					mu.Unlock()
 
 					atomic.AddInt64(&processedCount, 1)
@@ -232,14 +669,31 @@ func (s *ScannerService) runScan(scanID int64) {
 
 	close(progressDone)
 	time.Sleep(100 * time.Millisecond)
+	log.Printf("scan: %d files, %d unchanged and skipped, %d failed", len(files), atomic.LoadInt64(&skippedCount), atomic.LoadInt64(&failedCount))
+	_, _ = s.db.ExecContext(statusCtx, `UPDATE scan_status SET added = added + ?, updated = updated + ?, failed = failed + ? WHERE id = ?`,
+		atomic.LoadInt64(&addedCount), atomic.LoadInt64(&updatedCount), atomic.LoadInt64(&failedCount), scanID)
+	s.publishSongs(EventSongsAdded, libraryID, addedSongs)
 
+	return seenSongs, enrichInfos
+}
+
+// processIngested runs enrichment (or the conservative fallback) for newly
+// ingested songs and then reconciles album artists.
+func (s *ScannerService) processIngested(ctx context.Context, scanID int64, enrichInfos []songEnrichInfo) {
 	// Enrich songs with metadata (if enabled)
-	if s.enrichEnabled && len(enrichInfos) > 0 {
-		_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET phase = 'enriching' WHERE id = ?`, scanID)
+	if s.enrichEnabled && len(enrichInfos) > 0 && ctx.Err() == nil {
+		s.setPhase(ctx, scanID, "enriching")
 		s.enrichSongs(ctx, scanID, enrichInfos)
-	} else if len(enrichInfos) > 0 {
+	} else if len(enrichInfos) > 0 && ctx.Err() == nil {
 		// Fallback to conservative parsing when enrichment is disabled
-		_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET phase = 'processing' WHERE id = ?`, scanID)
+		s.setPhase(ctx, scanID, "processing")
+		s.fallbackArtistParsing(ctx, scanID, enrichInfos, nil)
+	}
+	if ctx.Err() != nil {
+		// Songs ingested before the scan was cancelled still need their
+		// artists. Conservative parsing is local, quick and skips songs
+		// that already have them.
+		ctx = context.WithoutCancel(ctx)
 		s.fallbackArtistParsing(ctx, scanID, enrichInfos, nil)
 	}
 
@@ -248,50 +702,210 @@ func (s *ScannerService) runScan(scanID int64) {
 	// This must run after enrichment/fallback so song_artists is populated,
 	// and before cleanup so phantom artists become orphan-eligible.
 	log.Printf("scan: reconciling album artists")
-	_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET phase = 'reconciling' WHERE id = ?`, scanID)
+	s.setPhase(ctx, scanID, "reconciling")
 	if err := s.reconcileAlbumArtists(ctx); err != nil {
 		log.Printf("scan: reconciliation failed: %v", err)
 	}
+}
 
-	log.Printf("scan: starting cleanup phase")
-	_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET phase = 'cleanup' WHERE id = ?`, scanID)
-	if err := s.cleanup(ctx, seenSongs, seenAlbums, seenArtists); err != nil {
-		log.Printf("scan: cleanup failed: %v", err)
-		_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET status='failed' WHERE id=?`, scanID)
-		return
+// fileFingerprint identifies a version of a file so rescans can skip files
+// that haven't changed. mtime is in Unix nanoseconds; hash is the hex
+// SHA-256 of the contents and only set when content hashing is enabled.
+// lyrics is the newest mtime of the file's lyrics sidecars, so adding or
+// editing an .lrc or .txt counts as a change too; cue is the mtime of its
+// cue sheet.
+type fileFingerprint struct {
+	mtime  int64
+	size   int64
+	hash   string
+	lyrics int64
+	cue    int64
+}
+
+// knownSong is what a previous scan recorded for an audio file. ids holds
+// every song read from it, one per track when a cue sheet split it.
+type knownSong struct {
+	ids       []int64
+	libraryID int64
+	title     string
+	fp        fileFingerprint
+}
+
+// loadKnownSongs returns the recorded fingerprint of every audio file by
+// path. Songs ingested before fingerprints existed have a zero fingerprint
+// and never match, so they are re-read once.
+func (s *ScannerService) loadKnownSongs(ctx context.Context) (map[string]knownSong, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, COALESCE(library_id, 0), CASE WHEN source_path IS NULL THEN title ELSE '' END, COALESCE(source_path, file_path),
+		       COALESCE(file_mtime, 0), COALESCE(file_size, 0), COALESCE(content_hash, ''), COALESCE(lyrics_mtime, 0), COALESCE(cue_mtime, 0)
+		FROM songs
+	`)
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("scan: cleanup complete")
+	defer rows.Close()
+	known := map[string]knownSong{}
+	for rows.Next() {
+		var k knownSong
+		var id int64
+		var path string
+		if err := rows.Scan(&id, &k.libraryID, &k.title, &path, &k.fp.mtime, &k.fp.size, &k.fp.hash, &k.fp.lyrics, &k.fp.cue); err != nil {
+			return nil, err
+		}
+		if prev, ok := known[path]; ok {
+			k.ids = prev.ids
+		}
+		k.ids = append(k.ids, id)
+		known[path] = k
+	}
+	return known, rows.Err()
+}
 
-	if s.autoPlaylists {
-		log.Printf("scan: starting playlist import")
-		_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET phase = 'playlists' WHERE id = ?`, scanID)
-		s.importPlaylists(ctx, playlists)
-		log.Printf("scan: playlist import complete")
+// fingerprint stats path and, when compare is set, reports whether it still
+// matches prev. Size and mtime are checked first; with content hashing on, a
+// file whose mtime moved but whose bytes are identical (touch, copy) also
+// counts as unchanged and gets its stored mtime refreshed.
+func (s *ScannerService) fingerprint(ctx context.Context, path string, prev knownSong, compare bool) (fileFingerprint, bool) {
+	var fp fileFingerprint
+	info, err := os.Stat(path)
+	if err != nil {
+		return fp, false
+	}
+	fp.mtime = info.ModTime().UnixNano()
+	fp.size = info.Size()
+	fp.lyrics = sidecarLyricsMtime(path, prev.title)
+	fp.cue = cueSheetMtime(path)
+	if compare && (prev.fp.lyrics != fp.lyrics || prev.fp.cue != fp.cue) {
+		return fp, false
+	}
+	if compare && prev.fp.size == fp.size && prev.fp.mtime == fp.mtime {
+		return fp, true
+	}
+	if !s.contentHash {
+		return fp, false
 	}
+	fp.hash, err = hashFile(path)
+	if err != nil {
+		return fp, false
+	}
+	if compare && prev.fp.size == fp.size && prev.fp.hash == fp.hash {
+		_, _ = s.db.ExecContext(ctx, `UPDATE songs SET file_mtime = ? WHERE COALESCE(source_path, file_path) = ?`, fp.mtime, path)
+		return fp, true
+	}
+	return fp, false
+}
 
-	now := time.Now()
-	_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET status='completed', phase='completed', progress=?, completed_at=? WHERE id=?`, len(files), now, scanID)
-	log.Printf("scan: completed successfully")
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (s *ScannerService) ingestFile(ctx context.Context, path string, seenSongs map[int64]struct{}, seenAlbums map[int64]struct{}, seenArtists map[int64]struct{}) (*songEnrichInfo, error) {
+// ingestFile reads an audio file into the library: as one song, or as one
+// song per track when a cue sheet splits it. Songs of the file that no
+// longer exist, such as the whole-file song once a cue sheet appears, are
+// removed.
+func (s *ScannerService) ingestFile(ctx context.Context, libraryID int64, path string, fp fileFingerprint, seenSongs map[int64]struct{}, seenAlbums map[int64]struct{}, seenArtists map[int64]struct{}) ([]songEnrichInfo, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("open file: %w", err)
+		return nil, &scanFailure{ScanErrorUnreadable, fmt.Errorf("open file: %w", err)}
 	}
 	defer f.Close()
-	meta, err := tag.ReadFrom(f)
+	var meta tag.Metadata
+	meta, err = tag.ReadFrom(f)
+	if err != nil {
+		// dhowden/tag only reads ID3, MP4, FLAC, Ogg and DSF tags
+		probed, probeErr := s.probeTags(ctx, path)
+		if probeErr != nil {
+			reason := ScanErrorProbe
+			if errors.Is(probeErr, errNoAudioStream) {
+				reason = ScanErrorTags
+			}
+			return nil, &scanFailure{reason, fmt.Errorf("read tags: %w (%v)", err, probeErr)}
+		}
+		meta = probed
+	}
+
+	var infos []songEnrichInfo
+	sheet := readCueSheet(path, meta)
+	if len(sheet.tracks) == 0 {
+		info, err := s.ingestSong(ctx, libraryID, path, meta, nil, fp, seenSongs, seenAlbums, seenArtists)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	} else {
+		audio := s.probe(ctx, path)
+		for _, track := range sheet.tracks {
+			info, err := s.ingestSong(ctx, libraryID, path, meta, &cueSong{cueTrack: track, sheet: &sheet, audio: audio}, fp, seenSongs, seenAlbums, seenArtists)
+			if err != nil {
+				log.Printf("cue track %d of %s: %v", track.number, path, err)
+				continue
+			}
+			infos = append(infos, *info)
+		}
+	}
+	s.removeStaleSongs(ctx, libraryID, path, seenSongs)
+	return infos, nil
+}
+
+// removeStaleSongs deletes the songs read from path that aren't in seen
+func (s *ScannerService) removeStaleSongs(ctx context.Context, libraryID int64, path string, seen map[int64]struct{}) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM songs WHERE file_path = ? OR source_path = ?`, path, path)
 	if err != nil {
-		return nil, fmt.Errorf("read tags: %w", err)
+		return
+	}
+	var stale []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			if _, ok := seen[id]; !ok {
+				stale = append(stale, id)
+			}
+		}
 	}
+	rows.Close()
+	for _, id := range stale {
+		_, _ = s.db.ExecContext(ctx, `DELETE FROM songs WHERE id = ?`, id)
+		_, _ = s.db.ExecContext(ctx, `DELETE FROM songs_fts WHERE rowid = ?`, id)
+	}
+	s.publishSongs(EventSongsRemoved, libraryID, stale)
+}
+
+// ingestSong upserts one song read from the audio file at path: the whole
+// file, or the cue track cue. A cue track takes its title, performer and
+// ISRC from the sheet and is stored under a virtual file_path with the file
+// as its source_path.
+func (s *ScannerService) ingestSong(ctx context.Context, libraryID int64, path string, meta tag.Metadata, cue *cueSong, fp fileFingerprint, seenSongs map[int64]struct{}, seenAlbums map[int64]struct{}, seenArtists map[int64]struct{}) (*songEnrichInfo, error) {
+	var err error
+	songPath := path
 	artistName := meta.Artist()
 	albumTitle := meta.Album()
 	title := meta.Title()
+	if cue != nil {
+		songPath = cueTrackPath(path, cue.number)
+		title = cue.title
+		if cue.performer != "" {
+			artistName = cue.performer
+		} else if cue.sheet.performer != "" {
+			artistName = cue.sheet.performer
+		}
+		if cue.sheet.title != "" {
+			albumTitle = cue.sheet.title
+		}
+	}
 
 	// Check for invalid/placeholder metadata and try fallbacks
 	if isInvalidMetadata(artistName) || isInvalidMetadata(albumTitle) || isInvalidMetadata(title) {
 		// Try stream-level tags (opus/ogg files)
-		streamMeta := s.probeStreamTags(path)
+		streamMeta := s.probeStreamTags(ctx, path)
 
 		if isInvalidMetadata(artistName) && !isInvalidMetadata(streamMeta.Artist) {
 			artistName = streamMeta.Artist
@@ -322,34 +936,44 @@ func (s *ScannerService) ingestFile(ctx context.Context, path string, seenSongs
 
 	// Final validation - need at least title
 	if title == "" {
-		return nil, errors.New("missing metadata")
+		return nil, &scanFailure{ScanErrorMissingTitle, errors.New("missing title")}
 	}
 
-	// Extract ISRC for enrichment
+	// Extract ISRC for enrichment and playlist import matching
 	isrc := extractISRC(meta)
+	if cue != nil {
+		isrc = cue.isrc
+	}
 
 	// Use album artist for grouping if available, otherwise use artist
 	albumArtist := meta.AlbumArtist()
+	if albumArtist == "" && cue != nil {
+		albumArtist = cue.sheet.performer
+	}
 	if albumArtist == "" {
 		albumArtist = artistName
 	}
 
-	var artistID int64
-	err = s.db.QueryRowContext(ctx, `SELECT id FROM artists WHERE name = ?`, albumArtist).Scan(&artistID)
+	mb := extractMusicBrainzIDs(meta)
+	if cue != nil {
+		// The file's recording and artists aren't the track's
+		mb.track, mb.artists = "", nil
+	}
+	albumArtistMBID := firstOf(mb.albumArtists)
+	if albumArtistMBID == "" && meta.AlbumArtist() == "" {
+		albumArtistMBID = firstOf(mb.artists)
+	}
+	artistID, err := s.findOrCreateArtist(ctx, albumArtist, albumArtistMBID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			res, err := s.db.ExecContext(ctx, `INSERT INTO artists(name) VALUES (?)`, albumArtist)
-			if err != nil {
-				return nil, err
-			}
-			artistID, _ = res.LastInsertId()
-		} else {
-			return nil, err
-		}
+		return nil, err
 	}
 	seenArtists[artistID] = struct{}{}
 
-	// Album identity lookup. Order matters:
+	// Album identity lookup, within the file's library. Order matters:
+	//   0. If the file carries a MusicBrainz release ID, the album with that
+	//      ID. Steps 1-3 then only match albums without an ID or with the
+	//      same one, so distinct releases sharing a title stay apart; the
+	//      album found adopts the ID.
 	//   1. If this file is already known, reuse its album. This is what makes
 	//      re-scans idempotent across reconciliation: an album whose artist_id
 	//      was reconciled to NULL (compilation) would otherwise miss the
@@ -362,20 +986,36 @@ func (s *ScannerService) ingestFile(ctx context.Context, path string, seenSongs
 	//      track to it.
 	//   4. Otherwise create a new album row.
 	var albumID int64
-	err = s.db.QueryRowContext(ctx, `SELECT album_id FROM songs WHERE file_path = ?`, path).Scan(&albumID)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return nil, err
+	err = sql.ErrNoRows
+	if mb.album != "" {
+		err = s.db.QueryRowContext(ctx, `SELECT id FROM albums WHERE mbid = ? COLLATE NOCASE AND library_id = ?`, mb.album, libraryID).Scan(&albumID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
 	}
+	// Matches albums the release ID may be stored on
+	const sameRelease = `(? = '' OR al.mbid IS NULL OR al.mbid = ? COLLATE NOCASE)`
 	if albumID == 0 {
-		err = s.db.QueryRowContext(ctx, `SELECT id FROM albums WHERE artist_id = ? AND title = ?`, artistID, albumTitle).Scan(&albumID)
+		err = s.db.QueryRowContext(ctx, `
+			SELECT s.album_id FROM songs s JOIN albums al ON al.id = s.album_id
+			WHERE s.file_path = ? AND s.library_id = ? AND `+sameRelease,
+			songPath, libraryID, mb.album, mb.album).Scan(&albumID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+	if albumID == 0 {
+		err = s.db.QueryRowContext(ctx, `SELECT id FROM albums al WHERE artist_id = ? AND title = ? AND library_id = ? AND `+sameRelease,
+			artistID, albumTitle, libraryID, mb.album, mb.album).Scan(&albumID)
 	}
 	if albumID == 0 && errors.Is(err, sql.ErrNoRows) {
-		err = s.db.QueryRowContext(ctx, `SELECT id FROM albums WHERE artist_id IS NULL AND title = ?`, albumTitle).Scan(&albumID)
+		err = s.db.QueryRowContext(ctx, `SELECT id FROM albums al WHERE artist_id IS NULL AND title = ? AND library_id = ? AND `+sameRelease,
+			albumTitle, libraryID, mb.album, mb.album).Scan(&albumID)
 	}
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			year := meta.Year()
-			res, err := s.db.ExecContext(ctx, `INSERT INTO albums(artist_id, title, year) VALUES (?, ?, ?)`, artistID, albumTitle, year)
+			res, err := s.db.ExecContext(ctx, `INSERT INTO albums(artist_id, title, year, library_id) VALUES (?, ?, ?, ?)`, artistID, albumTitle, year, libraryID)
 			if err != nil {
 				return nil, err
 			}
@@ -384,27 +1024,76 @@ func (s *ScannerService) ingestFile(ctx context.Context, path string, seenSongs
 			return nil, err
 		}
 	}
+	if mb.album != "" || mb.releaseGroup != "" {
+		_, _ = s.db.ExecContext(ctx, `
+			UPDATE albums SET mbid = COALESCE(NULLIF(?, ''), mbid), release_group_mbid = COALESCE(NULLIF(?, ''), release_group_mbid) WHERE id = ?
+		`, mb.album, mb.releaseGroup, albumID)
+	}
 	seenAlbums[albumID] = struct{}{}
 
 	trackNo, _ := meta.Track()
-	audioMeta := s.probe(path)
-
-	var existingLyrics, existingSynced, existingMBID string
-	_ = s.db.QueryRowContext(ctx, `SELECT lyrics, lyrics_synced, COALESCE(mbid, '') FROM songs WHERE file_path = ?`, path).Scan(&existingLyrics, &existingSynced, &existingMBID)
-
-	rawLyrics := meta.Lyrics()
-	lyrics := existingLyrics
-	lyricsSynced := existingSynced
-
-	// Detect LRC format and store in correct column
-	if rawLyrics != "" {
+	discNo, discTotal := meta.Disc()
+	discSubtitle := extractDiscSubtitle(meta)
+	var audioMeta audioMetadata
+	var sourcePath string
+	var cueStart, cueEnd int
+	if cue != nil {
+		trackNo = cue.number
+		audioMeta = cue.audio
+		end := cue.endMs
+		if end == 0 {
+			end = audioMeta.DurationMs
+		}
+		audioMeta.DurationMs = max(end-cue.startMs, 0)
+		sourcePath, cueStart, cueEnd = path, cue.startMs, cue.endMs
+	} else {
+		audioMeta = s.probe(ctx, path)
+	}
+
+	var existingLyrics, existingSynced, existingSource, existingMBID, existingLoudness string
+	var existingMtime, existingSize, existingCue int64
+	_ = s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(lyrics, ''), COALESCE(lyrics_synced, ''), COALESCE(lyrics_source, ''), COALESCE(mbid, ''),
+		       COALESCE(loudness_source, ''), COALESCE(file_mtime, 0), COALESCE(file_size, 0), COALESCE(cue_mtime, 0)
+		FROM songs WHERE file_path = ?
+	`, songPath).Scan(&existingLyrics, &existingSynced, &existingSource, &existingMBID, &existingLoudness, &existingMtime, &existingSize, &existingCue)
+
+	// Embedded lyrics go in the column matching their format. Sidecar files
+	// take precedence over them, per column. A cue track's lyrics come from
+	// the provider: the file's embedded and sidecar lyrics are the whole
+	// file's.
+	var lyrics, lyricsSynced, lyricsSource string
+	if rawLyrics := meta.Lyrics(); rawLyrics != "" && cue == nil {
 		if isLRCFormat(rawLyrics) {
 			lyricsSynced = rawLyrics
 		} else {
 			lyrics = rawLyrics
 		}
+		lyricsSource = LyricsEmbedded
+	}
+	if cue != nil {
+		// Matches what fingerprint sees for a file whose known title is ""
+		fp.lyrics = sidecarLyricsMtime(path, "")
+	} else {
+		if plain, synced := readSidecarLyrics(path, title); plain != "" || synced != "" {
+			if plain != "" {
+				lyrics = plain
+			}
+			if synced != "" {
+				lyricsSynced = synced
+			}
+			lyricsSource = LyricsSidecar
+		}
+		fp.lyrics = sidecarLyricsMtime(path, title)
+	}
+	// Lyrics a provider filled in stay until the file brings its own
+	if lyricsSource == "" && existingSource != LyricsEmbedded && existingSource != LyricsSidecar {
+		lyrics, lyricsSynced, lyricsSource = existingLyrics, existingSynced, existingSource
+	}
+	mbid := mb.track
+	if mbid == "" {
+		mbid = existingMBID
 	}
-	mbid := existingMBID
 
 	// UPSERT, NOT INSERT OR REPLACE. SQLite's REPLACE deletes the existing
 	// row and inserts a new one when there's a PK/UNIQUE conflict, which
@@ -413,48 +1102,130 @@ func (s *ScannerService) ingestFile(ctx context.Context, path string, seenSongs
 	// file. ON CONFLICT DO UPDATE updates the row in place; FK references
 	// stay intact.
 	_, err = s.db.ExecContext(ctx, `
-		INSERT INTO songs(album_id, title, track_number, duration_ms, sample_rate, bit_depth, channels, file_path, lyrics, lyrics_synced, mbid)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''))
+		INSERT INTO songs(album_id, title, track_number, disc_number, disc_total, disc_subtitle, duration_ms, sample_rate, bit_depth, channels, file_path, lyrics, lyrics_synced, lyrics_source, mbid,
+			file_mtime, file_size, content_hash, lyrics_mtime, library_id, isrc, source_path, cue_start_ms, cue_end_ms, cue_mtime, added_at)
+		VALUES (?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, NULLIF(?, ''), ?, ?, NULLIF(?, ''),
+			NULLIF(?, ''), CASE WHEN ? != '' THEN ? END, NULLIF(?, 0), NULLIF(?, 0), CURRENT_TIMESTAMP)
 		ON CONFLICT(file_path) DO UPDATE SET
 			album_id = excluded.album_id,
+			library_id = excluded.library_id,
 			title = excluded.title,
 			track_number = excluded.track_number,
+			disc_number = excluded.disc_number,
+			disc_total = excluded.disc_total,
+			disc_subtitle = excluded.disc_subtitle,
 			duration_ms = excluded.duration_ms,
 			sample_rate = excluded.sample_rate,
 			bit_depth = excluded.bit_depth,
 			channels = excluded.channels,
 			lyrics = excluded.lyrics,
 			lyrics_synced = excluded.lyrics_synced,
-			mbid = COALESCE(excluded.mbid, songs.mbid)
-	`, albumID, title, trackNo, audioMeta.DurationMs, audioMeta.SampleRate, audioMeta.BitDepth, audioMeta.Channels, path, lyrics, lyricsSynced, mbid)
+			lyrics_source = excluded.lyrics_source,
+			mbid = COALESCE(excluded.mbid, songs.mbid),
+			file_mtime = excluded.file_mtime,
+			file_size = excluded.file_size,
+			content_hash = excluded.content_hash,
+			lyrics_mtime = excluded.lyrics_mtime,
+			isrc = COALESCE(excluded.isrc, songs.isrc),
+			source_path = excluded.source_path,
+			cue_start_ms = excluded.cue_start_ms,
+			cue_end_ms = excluded.cue_end_ms,
+			cue_mtime = excluded.cue_mtime
+	`, albumID, title, trackNo, discNo, discTotal, discSubtitle, audioMeta.DurationMs, audioMeta.SampleRate, audioMeta.BitDepth, audioMeta.Channels, songPath, lyrics, lyricsSynced, lyricsSource, mbid,
+		fp.mtime, fp.size, fp.hash, fp.lyrics, libraryID, isrc, sourcePath, sourcePath, cueStart, cueEnd, fp.cue)
 	if err != nil {
 		return nil, fmt.Errorf("insert song: %w", err)
 	}
 
 	var songID int64
-	if err := s.db.QueryRowContext(ctx, `SELECT id FROM songs WHERE file_path = ?`, path).Scan(&songID); err == nil {
+	if err := s.db.QueryRowContext(ctx, `SELECT id FROM songs WHERE file_path = ?`, songPath).Scan(&songID); err == nil {
 		seenSongs[songID] = struct{}{}
 		// Delete old FTS entry and insert new one (FTS5 contentless tables don't support ON CONFLICT)
 		_, _ = s.db.ExecContext(ctx, `DELETE FROM songs_fts WHERE rowid = ?`, songID)
 		_, _ = s.db.ExecContext(ctx, `INSERT INTO songs_fts (rowid, song_id, title, artist_name, album_title)
 			VALUES (?, ?, ?, ?, ?)`, songID, songID, title, artistName, albumTitle)
-	}
-
-	if s.scanEmbedded {
-		coverPath, err := s.extractCover(ctx, path, albumID)
-		if err == nil && coverPath != "" {
-			_, _ = s.db.ExecContext(ctx, `UPDATE albums SET cover_path = ? WHERE id = ?`, coverPath, albumID)
+		genre := meta.Genre()
+		if genre == "" && cue != nil {
+			genre = cue.sheet.genre
+		}
+		if err := s.setSongGenres(ctx, songID, splitGenres(genre)); err != nil {
+			log.Printf("genres for %s: %v", songPath, err)
+		}
+		// A file's ReplayGain tags describe the whole file, not its cue tracks
+		if rg, ok := readReplayGain(meta); ok && cue == nil {
+			_, _ = s.db.ExecContext(ctx, `
+				UPDATE songs SET track_loudness = ?, track_peak = ?, album_loudness = ?, album_peak = ?, loudness_source = ? WHERE id = ?
+			`, rg.trackLoudness, rg.trackPeak, rg.albumLoudness, rg.albumPeak, LoudnessTags, songID)
+		} else if existingLoudness == LoudnessTags || (existingMtime != 0 && (existingMtime != fp.mtime || existingSize != fp.size || existingCue != fp.cue)) {
+			// Queue the song for loudness analysis, and its album for a new
+			// album loudness once it's measured
+			_, _ = s.db.ExecContext(ctx, `
+				UPDATE songs SET track_loudness = NULL, track_peak = NULL, album_loudness = NULL, album_peak = NULL, loudness_source = NULL WHERE id = ?
+			`, songID)
+			_, _ = s.db.ExecContext(ctx, `
+				UPDATE songs SET album_loudness = NULL, album_peak = NULL WHERE album_id = ? AND loudness_source = ?
+			`, albumID, LoudnessEBUR128)
 		}
 	}
 
+	s.updateAlbumCover(ctx, path, albumID)
+	s.updateArtistImage(ctx, path, libraryID, artistID)
+
 	return &songEnrichInfo{
-		songID:     songID,
-		isrc:       isrc,
-		artistName: artistName,
-		filePath:   path,
+		songID:      songID,
+		isrc:        isrc,
+		artistName:  artistName,
+		artistMBIDs: mb.artists,
+		filePath:    songPath,
 	}, nil
 }
 
+// genreSeparators are the delimiters taggers use to pack several genres into
+// one field. ID3v2.4 separates multiple values with a NUL byte. Commas and
+// slashes are left alone, since they're part of genres like "R&B/Soul".
+var genreSeparators = regexp.MustCompile(`\s*[;|\x00]\s*`)
+
+// splitGenres splits a raw genre tag like "Rock; Indie" into distinct,
+// trimmed genre names, dropping case-insensitive duplicates.
+func splitGenres(raw string) []string {
+	var genres []string
+	seen := make(map[string]struct{})
+	for _, g := range genreSeparators.Split(raw, -1) {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		key := strings.ToLower(g)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		genres = append(genres, g)
+	}
+	return genres
+}
+
+// setSongGenres replaces a song's genre links. Genre rows are shared and
+// matched case-insensitively, so "rock" and "Rock" are the same genre.
+func (s *ScannerService) setSongGenres(ctx context.Context, songID int64, genres []string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM song_genres WHERE song_id = ?`, songID); err != nil {
+		return err
+	}
+	for _, name := range genres {
+		if _, err := s.db.ExecContext(ctx, `INSERT INTO genres(name) VALUES (?) ON CONFLICT(name) DO NOTHING`, name); err != nil {
+			return err
+		}
+		var genreID int64
+		if err := s.db.QueryRowContext(ctx, `SELECT id FROM genres WHERE name = ?`, name).Scan(&genreID); err != nil {
+			return err
+		}
+		if _, err := s.db.ExecContext(ctx, `INSERT OR IGNORE INTO song_genres(song_id, genre_id) VALUES (?, ?)`, songID, genreID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // reconcileAlbumArtists derives albums.artist_id from song_artists.
 //
 // Two-tier rule based on confidence:
@@ -553,15 +1324,17 @@ func (s *ScannerService) reconcileAlbumArtists(ctx context.Context) error {
 	return nil
 }
 
-func (s *ScannerService) cleanup(ctx context.Context, songs map[int64]struct{}, albums map[int64]struct{}, artists map[int64]struct{}) error {
+// cleanup removes the songs of a library that were not seen during its scan,
+// then anything left orphaned. Other libraries are untouched.
+func (s *ScannerService) cleanup(ctx context.Context, libraryID int64, songs map[int64]struct{}) (int, error) {
 	// Collect IDs to delete first, then delete in separate operations
 	// This avoids holding open cursors while writing
 
 	// Remove songs not seen
 	var songsToDelete []int64
-	rows, err := s.db.QueryContext(ctx, `SELECT id FROM songs`)
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM songs WHERE library_id = ?`, libraryID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	for rows.Next() {
 		var id int64
@@ -577,26 +1350,16 @@ func (s *ScannerService) cleanup(ctx context.Context, songs map[int64]struct{},
 		_, _ = s.db.ExecContext(ctx, `DELETE FROM songs WHERE id = ?`, id)
 		_, _ = s.db.ExecContext(ctx, `DELETE FROM songs_fts WHERE rowid = ?`, id)
 	}
+	s.publishSongs(EventSongsRemoved, libraryID, songsToDelete)
 
-	// Remove albums not seen
-	var albumsToDelete []int64
-	albRows, err := s.db.QueryContext(ctx, `SELECT id FROM albums`)
-	if err != nil {
-		return err
-	}
-	for albRows.Next() {
-		var id int64
-		if err := albRows.Scan(&id); err == nil {
-			if _, ok := albums[id]; !ok {
-				albumsToDelete = append(albumsToDelete, id)
-			}
-		}
-	}
-	albRows.Close()
+	return len(songsToDelete), s.pruneOrphans(ctx)
+}
 
-	for _, id := range albumsToDelete {
-		_, _ = s.db.ExecContext(ctx, `DELETE FROM albums WHERE id = ?`, id)
-	}
+// pruneOrphans removes albums left without songs and the artists and genres
+// nothing refers to anymore. Targeted rescans use it in place of the seen-set
+// cleanup, which needs a walk of the whole library.
+func (s *ScannerService) pruneOrphans(ctx context.Context) error {
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM albums WHERE id NOT IN (SELECT DISTINCT album_id FROM songs WHERE album_id IS NOT NULL)`)
 
 	// Remove artists not seen AND not referenced in song_artists.
 	// Artists created by enrichment are linked via song_artists, so we must preserve them.
@@ -622,22 +1385,24 @@ func (s *ScannerService) cleanup(ctx context.Context, songs map[int64]struct{},
 		_, _ = s.db.ExecContext(ctx, `DELETE FROM artists WHERE id = ?`, id)
 	}
 
+	// Remove genres no song refers to anymore
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM genres WHERE id NOT IN (SELECT DISTINCT genre_id FROM song_genres)`)
+
 	return nil
 }
 
 func (s *ScannerService) Status(ctx context.Context) (ScanStatus, error) {
-	var st ScanStatus
-	var currentFile sql.NullString
-	err := s.db.QueryRowContext(ctx, `
-		SELECT status, phase, progress, started_at, completed_at, total, current_file FROM scan_status ORDER BY id DESC LIMIT 1
-	`).Scan(&st.Status, &st.Phase, &st.Progress, &st.StartedAt, &st.CompletedAt, &st.Total, &currentFile)
-	if err != nil {
-		return st, err
-	}
-	st.CurrentFile = currentFile.String
-	return st, nil
+	return scanScanStatus(s.db.QueryRowContext(ctx, `SELECT `+scanStatusColumns+` FROM scan_status ORDER BY id DESC LIMIT 1`))
 }
 
+// watchDebounce is how long the watcher waits for changes to settle before
+// rescanning the paths it collected.
+const watchDebounce = 2 * time.Second
+
+// Watch rescans changed paths as fsnotify reports them. Events are collected
+// until the library has been quiet for watchDebounce, then only those paths
+// are ingested or removed. New directories are watched as they appear, and
+// library roots as Rewatch reports them.
 func (s *ScannerService) Watch(ctx context.Context) error {
 	if !s.watchEnabled {
 		return nil
@@ -647,17 +1412,13 @@ func (s *ScannerService) Watch(ctx context.Context) error {
 		return err
 	}
 	defer w.Close()
-	filepath.WalkDir(s.mediaRoot, func(path string, d fs.DirEntry, err error) error {
-		if err == nil && d.IsDir() {
-			// Skip hidden directories
-			if strings.HasPrefix(d.Name(), ".") && path != s.mediaRoot {
-				return fs.SkipDir
-			}
-			_ = w.Add(path)
-		}
-		return nil
-	})
-	debounce := time.NewTimer(time.Second * 5)
+	roots, err := s.syncWatches(ctx, w, nil)
+	if err != nil {
+		return err
+	}
+
+	pending := map[string]struct{}{}
+	debounce := time.NewTimer(watchDebounce)
 	debounce.Stop()
 	for {
 		select {
@@ -668,13 +1429,33 @@ func (s *ScannerService) Watch(ctx context.Context) error {
 			if strings.Contains(ev.Name, "/.") || strings.HasSuffix(ev.Name, ".db") {
 				continue
 			}
-			if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 {
-				debounce.Reset(time.Second * 5)
+			if ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					s.addWatches(w, ev.Name)
+				}
 			}
+			pending[ev.Name] = struct{}{}
+			debounce.Reset(watchDebounce)
 		case <-debounce.C:
-			// Only trigger if not already scanning
-			if !s.IsScanning() {
-				go s.StartScan(context.Background())
+			// A running scan will not see these changes, so try again later
+			if s.IsScanning() {
+				debounce.Reset(watchDebounce)
+				continue
+			}
+			paths := collapsePaths(pending)
+			if _, err := s.StartPathScan(context.Background(), paths); err != nil {
+				debounce.Reset(watchDebounce)
+				continue
+			}
+			pending = map[string]struct{}{}
+		case <-s.rewatch:
+			if next, err := s.syncWatches(ctx, w, roots); err != nil {
+				log.Printf("scanner watch: %v", err)
+			} else {
+				roots = next
 			}
 		case err := <-w.Errors:
 			return err
@@ -682,6 +1463,88 @@ func (s *ScannerService) Watch(ctx context.Context) error {
 	}
 }
 
+// Rewatch makes Watch re-read the library roots, so libraries created,
+// re-rooted or deleted since it started are watched or dropped.
+func (s *ScannerService) Rewatch() {
+	select {
+	case s.rewatch <- struct{}{}:
+	default:
+	}
+}
+
+// syncWatches watches the roots of the libraries that aren't in roots yet
+// and stops watching the directories of roots no library has anymore. It
+// returns the roots now watched.
+func (s *ScannerService) syncWatches(ctx context.Context, w *fsnotify.Watcher, roots map[string]struct{}) (map[string]struct{}, error) {
+	libraries, err := s.loadLibraries(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]struct{}{}
+	for _, lib := range libraries {
+		root := filepath.Clean(lib.RootPath)
+		current[root] = struct{}{}
+		if _, ok := roots[root]; !ok {
+			s.addWatches(w, root)
+		}
+	}
+	below := func(path string, roots map[string]struct{}) bool {
+		for root := range roots {
+			if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+	dropped := map[string]struct{}{}
+	for root := range roots {
+		if _, ok := current[root]; !ok {
+			dropped[root] = struct{}{}
+		}
+	}
+	if len(dropped) > 0 {
+		for _, path := range w.WatchList() {
+			if below(path, dropped) && !below(path, current) {
+				_ = w.Remove(path)
+			}
+		}
+	}
+	return current, nil
+}
+
+// addWatches watches root and every non-hidden directory below it.
+func (s *ScannerService) addWatches(w *fsnotify.Watcher, root string) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			// Skip hidden directories
+			if strings.HasPrefix(d.Name(), ".") && path != root {
+				return fs.SkipDir
+			}
+			_ = w.Add(path)
+		}
+		return nil
+	})
+}
+
+// collapsePaths drops paths that sit below another pending path, since
+// rescanning a directory already covers everything in it.
+func collapsePaths(pending map[string]struct{}) []string {
+	paths := make([]string, 0, len(pending))
+	for p := range pending {
+		covered := false
+		for dir := filepath.Dir(p); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if _, ok := pending[dir]; ok {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
 type audioMetadata struct {
 	DurationMs int
 	SampleRate int
@@ -689,8 +1552,8 @@ type audioMetadata struct {
 	Channels   int
 }
 
-func (s *ScannerService) probe(path string) audioMetadata {
-	cmd := exec.Command(s.ffprobePath, "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", path)
+func (s *ScannerService) probe(ctx context.Context, path string) audioMetadata {
+	cmd := exec.CommandContext(ctx, s.ffprobePath, "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", path)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	_ = cmd.Run()
@@ -745,8 +1608,8 @@ type streamTags struct {
 }
 
 // probeStreamTags extracts tags from audio stream (useful for opus files where tags are stream-level)
-func (s *ScannerService) probeStreamTags(path string) streamTags {
-	cmd := exec.Command(s.ffprobePath, "-v", "quiet", "-print_format", "json", "-show_streams", path)
+func (s *ScannerService) probeStreamTags(ctx context.Context, path string) streamTags {
+	cmd := exec.CommandContext(ctx, s.ffprobePath, "-v", "quiet", "-print_format", "json", "-show_streams", path)
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	_ = cmd.Run()
@@ -795,18 +1658,9 @@ func (s *ScannerService) extractCover(ctx context.Context, src string, albumID i
 		return "", err
 	}
 	outPath := filepath.Join(s.coverCachePath, fmt.Sprintf("%d.jpg", albumID))
-	// Extract and resize to 500x500, compress as JPEG with quality 85
-	cmd := exec.CommandContext(ctx, s.ffmpegPath,
-		"-y", "-i", src,
-		"-an",                                                                                       // No audio
-		"-vf", "scale=500:500:force_original_aspect_ratio=decrease,pad=500:500:(ow-iw)/2:(oh-ih)/2", // Resize and pad to 500x500
-		"-frames:v", "1", // Just one frame
-		"-q:v", "2", // JPEG quality (2 is high quality, smaller file)
-		outPath)
-	if err := cmd.Run(); err != nil {
-		return "", err
-	}
-	if _, err := os.Stat(outPath); err != nil {
+	// src is an image file or an audio file with embedded art; either way the
+	// cached cover is a JPEG of at most 1024x1024 that thumbnails derive from
+	if err := renderImage(ctx, s.ffmpegPath, src, outPath, coverMaxSize, "jpeg"); err != nil {
 		return "", err
 	}
 	return outPath, nil
@@ -815,7 +1669,8 @@ func (s *ScannerService) extractCover(ctx context.Context, src string, albumID i
 func isAudioFile(path string) bool {
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
-	case ".mp3", ".flac", ".m4a", ".aac", ".ogg", ".wav", ".opus":
+	case ".mp3", ".flac", ".m4a", ".aac", ".ogg", ".wav", ".opus",
+		".wv", ".ape", ".tak", ".aif", ".aiff", ".aifc", ".dsf", ".dff", ".mka", ".wma":
 		return true
 	default:
 		return false
@@ -827,7 +1682,7 @@ func isPlaylistFile(path string) bool {
 	return ext == ".m3u" || ext == ".m3u8"
 }
 
-func (s *ScannerService) importPlaylists(ctx context.Context, m3uFiles []string) {
+func (s *ScannerService) importPlaylists(ctx context.Context, root string, m3uFiles []string) {
 	seenPaths := make(map[string]struct{})
 	for _, path := range m3uFiles {
 		seenPaths[path] = struct{}{}
@@ -835,7 +1690,7 @@ func (s *ScannerService) importPlaylists(ctx context.Context, m3uFiles []string)
 			log.Printf("import playlist %s: %v", path, err)
 		}
 	}
-	s.cleanupPlaylists(ctx, seenPaths)
+	s.cleanupPlaylists(ctx, root, seenPaths)
 }
 
 func (s *ScannerService) importM3U(ctx context.Context, path string) error {
@@ -844,26 +1699,35 @@ func (s *ScannerService) importM3U(ctx context.Context, path string) error {
 		return fmt.Errorf("open m3u: %w", err)
 	}
 	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat m3u: %w", err)
+	}
+	mtime := info.ModTime().UnixNano()
 
-	var trackPaths []string
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		trackPath := line
-		if !filepath.IsAbs(trackPath) {
-			trackPath = filepath.Join(filepath.Dir(path), trackPath)
+	// With two-way sync, a file that hasn't changed since it was last read
+	// or written holds nothing new, while the playlist may hold edits that
+	// re-importing would discard.
+	if s.playlistSync {
+		var synced sql.NullInt64
+		err := s.db.QueryRowContext(ctx, `SELECT source_mtime FROM playlists WHERE source_path = ?`, path).Scan(&synced)
+		if err == nil && synced.Valid && synced.Int64 == mtime {
+			return nil
 		}
-		trackPath = filepath.Clean(trackPath)
-		trackPaths = append(trackPaths, trackPath)
 	}
-	if err := scanner.Err(); err != nil {
+
+	_, entries, err := parseM3U8(f)
+	if err != nil {
 		return fmt.Errorf("scan m3u: %w", err)
 	}
+	for i, e := range entries {
+		if !filepath.IsAbs(e.Path) {
+			e.Path = filepath.Join(filepath.Dir(path), e.Path)
+		}
+		entries[i].Path = filepath.Clean(e.Path)
+	}
 
-	if len(trackPaths) == 0 {
+	if len(entries) == 0 {
 		return nil
 	}
 
@@ -891,20 +1755,23 @@ func (s *ScannerService) importM3U(ctx context.Context, path string) error {
 		_, _ = s.db.ExecContext(ctx, `DELETE FROM playlist_songs WHERE playlist_id = ?`, playlistID)
 	}
 
-	for i, trackPath := range trackPaths {
-		var songID int64
-		err := s.db.QueryRowContext(ctx, `SELECT id FROM songs WHERE file_path = ?`, trackPath).Scan(&songID)
-		if err != nil {
+	m := playlistMatcher{db: s.db, filter: db.Access{All: true}.Filter("s")}
+	for i, e := range entries {
+		songID := m.inFile(ctx, `= ?`, e.Path, e.Title)
+		if songID == 0 {
 			continue
 		}
-		_, _ = s.db.ExecContext(ctx, `INSERT OR IGNORE INTO playlist_songs(playlist_id, song_id, position) VALUES(?, ?, ?)`,
+		_, _ = s.db.ExecContext(ctx, `INSERT OR IGNORE INTO playlist_songs(playlist_id, song_id, position, added_at) VALUES(?, ?, ?, CURRENT_TIMESTAMP)`,
 			playlistID, songID, i+1)
 	}
+	_, _ = s.db.ExecContext(ctx, `UPDATE playlists SET source_mtime = ? WHERE id = ?`, mtime, playlistID)
 
 	return nil
 }
 
-func (s *ScannerService) cleanupPlaylists(ctx context.Context, seenPaths map[string]struct{}) {
+// cleanupPlaylists removes imported playlists under root whose file was not
+// seen. Playlists imported from other libraries are left alone.
+func (s *ScannerService) cleanupPlaylists(ctx context.Context, root string, seenPaths map[string]struct{}) {
 	rows, err := s.db.QueryContext(ctx, `SELECT id, source_path FROM playlists WHERE source_path IS NOT NULL`)
 	if err != nil {
 		return
@@ -917,7 +1784,7 @@ func (s *ScannerService) cleanupPlaylists(ctx context.Context, seenPaths map[str
 		if err := rows.Scan(&id, &sourcePath); err != nil {
 			continue
 		}
-		if _, ok := seenPaths[sourcePath]; !ok {
+		if _, ok := seenPaths[sourcePath]; !ok && pathWithin(root, sourcePath) {
 			_, _ = s.db.ExecContext(ctx, `DELETE FROM playlists WHERE id = ?`, id)
 		}
 	}
@@ -925,10 +1792,11 @@ func (s *ScannerService) cleanupPlaylists(ctx context.Context, seenPaths map[str
 
 // songEnrichInfo holds info needed for enrichment
 type songEnrichInfo struct {
-	songID     int64
-	isrc       string
-	artistName string
-	filePath   string
+	songID      int64
+	isrc        string
+	artistName  string
+	artistMBIDs []string
+	filePath    string
 }
 
 // extractISRC extracts ISRC from tag metadata
@@ -956,6 +1824,115 @@ func extractISRC(meta tag.Metadata) string {
 	return ""
 }
 
+// textTags returns a file's text tags by lowercased name. ID3 user-defined
+// text frames (TXXX) are listed under their description, the way Vorbis
+// comments and MP4 freeform atoms name them.
+func textTags(meta tag.Metadata) map[string]string {
+	raw := map[string]string{}
+	for k, v := range meta.Raw() {
+		switch v := v.(type) {
+		case string:
+			raw[strings.ToLower(k)] = v
+		case *tag.Comm:
+			if strings.HasPrefix(k, "TXX") {
+				raw[strings.ToLower(v.Description)] = v.Text
+			}
+		}
+	}
+	return raw
+}
+
+// extractDiscSubtitle returns the title of the disc a song is on, from ID3's
+// TSST frame or a DISCSUBTITLE / SETSUBTITLE tag.
+func extractDiscSubtitle(meta tag.Metadata) string {
+	raw := textTags(meta)
+	for _, key := range []string{"tsst", "discsubtitle", "setsubtitle"} {
+		if v := strings.TrimSpace(strings.Trim(raw[key], "\x00")); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// musicBrainzIDs are the MusicBrainz IDs Picard and other taggers write
+type musicBrainzIDs struct {
+	track        string // recording
+	album        string // release
+	releaseGroup string
+	artists      []string
+	albumArtists []string
+}
+
+// extractMusicBrainzIDs reads MusicBrainz IDs from Vorbis comments
+// (MUSICBRAINZ_ALBUMID), ID3 TXXX frames and MP4 atoms ("MusicBrainz Album
+// Id"), and ID3's UFID frame for the recording.
+func extractMusicBrainzIDs(meta tag.Metadata) musicBrainzIDs {
+	raw := map[string]string{}
+	for k, v := range textTags(meta) {
+		k = strings.NewReplacer(" ", "", "_", "", "/", "").Replace(k)
+		raw[k] = v
+	}
+	for k, v := range meta.Raw() {
+		if u, ok := v.(*tag.UFID); ok && strings.HasPrefix(k, "UFI") && u.Provider == "http://musicbrainz.org" {
+			raw["musicbrainztrackid"] = string(u.Identifier)
+		}
+	}
+	ids := func(key string) []string {
+		return mbidPattern.FindAllString(strings.ToLower(raw[key]), -1)
+	}
+	return musicBrainzIDs{
+		track:        firstOf(ids("musicbrainztrackid")),
+		album:        firstOf(ids("musicbrainzalbumid")),
+		releaseGroup: firstOf(ids("musicbrainzreleasegroupid")),
+		artists:      ids("musicbrainzartistid"),
+		albumArtists: ids("musicbrainzalbumartistid"),
+	}
+}
+
+func firstOf(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// findOrCreateArtist returns the artist with a MusicBrainz ID, or by name.
+// An artist found by name without an ID adopts mbid; one with a different ID
+// is another artist of the same name, so a new artist is created.
+func (s *ScannerService) findOrCreateArtist(ctx context.Context, name, mbid string) (int64, error) {
+	var id int64
+	if mbid != "" {
+		err := s.db.QueryRowContext(ctx, `SELECT id FROM artists WHERE mbid = ? COLLATE NOCASE ORDER BY id LIMIT 1`, mbid).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+		err = s.db.QueryRowContext(ctx, `SELECT id FROM artists WHERE name = ? AND mbid IS NULL ORDER BY id LIMIT 1`, name).Scan(&id)
+		if err == nil {
+			_, err = s.db.ExecContext(ctx, `UPDATE artists SET mbid = ? WHERE id = ?`, mbid, id)
+			return id, err
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	} else {
+		err := s.db.QueryRowContext(ctx, `SELECT id FROM artists WHERE name = ? ORDER BY mbid IS NOT NULL, id LIMIT 1`, name).Scan(&id)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO artists(name, mbid) VALUES (?, NULLIF(?, ''))`, name, mbid)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
 // featurePatterns are patterns that indicate a featured artist
 var featurePatterns = []string{
 	" feat. ", " feat ", " ft. ", " featuring ",
@@ -1268,6 +2245,7 @@ func (s *ScannerService) fallbackArtistParsing(ctx context.Context, scanID int64
 
 	// Update phase and total for processing
 	_, _ = s.db.ExecContext(ctx, `UPDATE scan_status SET phase = 'processing', progress = 0, total = ? WHERE id = ?`, len(songsToProcess), scanID)
+	s.publishScan(ctx, scanID)
 
 	processed := 0
 	for _, song := range songsToProcess {
@@ -1301,18 +2279,15 @@ func (s *ScannerService) fallbackArtistParsing(ctx context.Context, scanID int64
 		artists := parseArtistsConservative(song.artistName)
 
 		for pos, artistName := range artists {
-			var artistID int64
-			err := s.db.QueryRowContext(ctx, `SELECT id FROM artists WHERE name = ?`, artistName).Scan(&artistID)
+			// Artist IDs can only be paired with names when the tag lists
+			// as many of them as we found names
+			var mbid string
+			if len(song.artistMBIDs) == len(artists) {
+				mbid = song.artistMBIDs[pos]
+			}
+			artistID, err := s.findOrCreateArtist(ctx, artistName, mbid)
 			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					res, err := s.db.ExecContext(ctx, `INSERT INTO artists(name) VALUES (?)`, artistName)
-					if err != nil {
-						continue
-					}
-					artistID, _ = res.LastInsertId()
-				} else {
-					continue
-				}
+				continue
 			}
 
 			role := "primary"
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	_ "modernc.org/sqlite"
+)
+
+func TestSplitGenres(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"", nil},
+		{"Rock", []string{"Rock"}},
+		{"Rock; Indie", []string{"Rock", "Indie"}},
+		{"Rock|Indie | Pop", []string{"Rock", "Indie", "Pop"}},
+		{"Rock\x00Indie", []string{"Rock", "Indie"}},
+		{"Rock; rock; ROCK", []string{"Rock"}},
+		{" ; Jazz ;; ", []string{"Jazz"}},
+		{"R&B/Soul", []string{"R&B/Soul"}},
+		{"Drum & Bass/Jungle; Techno", []string{"Drum & Bass/Jungle", "Techno"}},
+		{"Rock, Pop & Roll", []string{"Rock, Pop & Roll"}},
+	}
+	for _, tt := range tests {
+		if got := splitGenres(tt.raw); !slices.Equal(got, tt.want) {
+			t.Errorf("splitGenres(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestRemovePath(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		wantSongs     []int64 // left after removing path
+		wantPlaylists []string
+	}{
+		{
+			name:          "ascii directory",
+			path:          "/music/Air",
+			wantSongs:     []int64{3, 4, 5, 6, 7},
+			wantPlaylists: []string{"/music/Björk/mix.m3u8", "/music/Björk Live/live.m3u8"},
+		},
+		{
+			name:          "non-ascii directory, not its sibling",
+			path:          "/music/Björk/",
+			wantSongs:     []int64{1, 2, 5, 6, 7},
+			wantPlaylists: []string{"/music/Air/mix.m3u8", "/music/Björk Live/live.m3u8"},
+		},
+		{
+			name:          "file with cue tracks",
+			path:          "/music/Sigur Rós/album.flac",
+			wantSongs:     []int64{1, 2, 3, 4, 5},
+			wantPlaylists: []string{"/music/Air/mix.m3u8", "/music/Björk/mix.m3u8", "/music/Björk Live/live.m3u8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "korus.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer database.Close()
+			_, err = database.Exec(`
+				CREATE TABLE songs (id INTEGER PRIMARY KEY, library_id INTEGER, file_path TEXT, source_path TEXT);
+				CREATE TABLE songs_fts (title TEXT);
+				CREATE TABLE playlists (id INTEGER PRIMARY KEY, source_path TEXT);
+				INSERT INTO songs (id, library_id, file_path, source_path) VALUES
+					(1, 1, '/music/Air/a.flac', NULL),
+					(2, 1, '/music/Air/Moon Safari/b.flac', NULL),
+					(3, 1, '/music/Björk/c.flac', NULL),
+					(4, 1, '/music/Björk/Début/d.flac', NULL),
+					(5, 1, '/music/Björk Live/e.flac', NULL),
+					(6, 1, '/music/Sigur Rós/album.flac#1', '/music/Sigur Rós/album.flac'),
+					(7, 1, '/music/Sigur Rós/album.flac#2', '/music/Sigur Rós/album.flac');
+				INSERT INTO playlists (source_path) VALUES
+					('/music/Air/mix.m3u8'), ('/music/Björk/mix.m3u8'), ('/music/Björk Live/live.m3u8');
+			`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := &ScannerService{db: database, autoPlaylists: true}
+			removed := s.removePath(context.Background(), tt.path)
+			if want := 7 - len(tt.wantSongs); removed != want {
+				t.Errorf("removePath() = %d, want %d", removed, want)
+			}
+
+			var songs []int64
+			rows, err := database.Query(`SELECT id FROM songs ORDER BY id`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rows.Next() {
+				var id int64
+				rows.Scan(&id)
+				songs = append(songs, id)
+			}
+			rows.Close()
+			if !slices.Equal(songs, tt.wantSongs) {
+				t.Errorf("songs left = %v, want %v", songs, tt.wantSongs)
+			}
+
+			var playlists []string
+			rows, err = database.Query(`SELECT source_path FROM playlists ORDER BY id`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rows.Next() {
+				var path string
+				rows.Scan(&path)
+				playlists = append(playlists, path)
+			}
+			rows.Close()
+			if !slices.Equal(playlists, tt.wantPlaylists) {
+				t.Errorf("playlists left = %q, want %q", playlists, tt.wantPlaylists)
+			}
+		})
+	}
+}
+
+func TestSyncWatches(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"music/Air", "music/.hidden", "books/Dune", "kids"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	database, err := sql.Open("sqlite", filepath.Join(dir, "korus.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	_, err = database.Exec(`
+		CREATE TABLE libraries (id INTEGER PRIMARY KEY, name TEXT, root_path TEXT, exclude_pattern TEXT DEFAULT '',
+			scan_interval_minutes INTEGER DEFAULT 0, auto_grant BOOLEAN DEFAULT 0, last_scanned_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+		CREATE TABLE songs (id INTEGER PRIMARY KEY, library_id INTEGER);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	s := &ScannerService{db: database}
+	steps := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "initial library",
+			sql:  `INSERT INTO libraries (id, name, root_path) VALUES (1, 'Music', '` + filepath.Join(dir, "music") + `/')`,
+			want: []string{"music", "music/Air"},
+		},
+		{
+			name: "library created",
+			sql:  `INSERT INTO libraries (id, name, root_path) VALUES (2, 'Books', '` + filepath.Join(dir, "books") + `')`,
+			want: []string{"books", "books/Dune", "music", "music/Air"},
+		},
+		{
+			name: "library re-rooted",
+			sql:  `UPDATE libraries SET root_path = '` + filepath.Join(dir, "kids") + `' WHERE id = 2`,
+			want: []string{"kids", "music", "music/Air"},
+		},
+		{
+			name: "library deleted",
+			sql:  `DELETE FROM libraries WHERE id = 1`,
+			want: []string{"kids"},
+		},
+	}
+
+	var roots map[string]struct{}
+	for _, step := range steps {
+		if _, err := database.Exec(step.sql); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if roots, err = s.syncWatches(context.Background(), w, roots); err != nil {
+			t.Fatalf("%s: syncWatches: %v", step.name, err)
+		}
+		var got []string
+		for _, path := range w.WatchList() {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, rel)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, step.want) {
+			t.Errorf("%s: watching %q, want %q", step.name, got, step.want)
+		}
+	}
+}
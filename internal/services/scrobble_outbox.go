@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Aunali321/korus/internal/models"
+)
+
+// Scrobble destinations
+const (
+	ScrobbleListenBrainz = "listenbrainz"
+	ScrobbleLastFM       = "lastfm"
+)
+
+const (
+	scrobbleMaxAttempts = 12
+	scrobbleBaseBackoff = 30 * time.Second
+	scrobbleMaxBackoff  = 6 * time.Hour
+	scrobbleBatch       = 50
+)
+
+// scrobbleAccountQueries count a user's accounts that accept scrobbles
+var scrobbleAccountQueries = map[string]string{
+	ScrobbleListenBrainz: `SELECT COUNT(*) FROM listenbrainz_accounts WHERE user_id = ? AND submit_listens = 1`,
+	ScrobbleLastFM:       `SELECT COUNT(*) FROM lastfm_accounts WHERE user_id = ? AND scrobble = 1`,
+}
+
+// RateLimitError is returned when a scrobbling service asks to slow down
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry in %s", e.RetryAfter)
+}
+
+// statusError is an unexpected HTTP status from a scrobbling service
+type statusError struct {
+	service string
+	code    int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s status %d", e.service, e.code)
+}
+
+// ScrobbleService queues listens for ListenBrainz and Last.fm in
+// scrobble_outbox, one row per listen and destination, and submits them in
+// the background so outages and rate limits don't lose them.
+type ScrobbleService struct {
+	db           *sql.DB
+	listenBrainz *ListenBrainzService
+	lastFM       *LastFMService
+	wake         chan struct{}
+}
+
+// NewScrobbleService creates the outbox. Either service may be nil when
+// disabled.
+func NewScrobbleService(conn *sql.DB, lb *ListenBrainzService, lastFM *LastFMService) *ScrobbleService {
+	return &ScrobbleService{
+		db:           conn,
+		listenBrainz: lb,
+		lastFM:       lastFM,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Enqueue queues a finished play for every destination the user linked and
+// whose scrobble rule it meets.
+func (s *ScrobbleService) Enqueue(ctx context.Context, userID, songID int64, startedAt time.Time, listened int) error {
+	var durationMs int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(duration_ms, 0) FROM songs WHERE id = ?`, songID).Scan(&durationMs); err != nil {
+		return err
+	}
+	var destinations []string
+	if s.listenBrainz != nil && s.listenBrainz.Qualifies(durationMs, listened) {
+		destinations = append(destinations, ScrobbleListenBrainz)
+	}
+	if s.lastFM != nil && s.lastFM.Qualifies(durationMs, listened) {
+		destinations = append(destinations, ScrobbleLastFM)
+	}
+	for _, dest := range destinations {
+		if err := s.Queue(ctx, userID, songID, dest, startedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Queue queues a listen for one destination, regardless of how much of the
+// song was heard. Users without a linked account there are skipped.
+func (s *ScrobbleService) Queue(ctx context.Context, userID, songID int64, destination string, startedAt time.Time) error {
+	var linked int
+	if err := s.db.QueryRowContext(ctx, scrobbleAccountQueries[destination], userID).Scan(&linked); err != nil || linked == 0 {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO scrobble_outbox(user_id, song_id, destination, listened_at, next_attempt_at) VALUES (?, ?, ?, ?, ?)
+	`, userID, songID, destination, startedAt.Unix(), time.Now().Unix()); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+func (s *ScrobbleService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run submits due scrobbles until ctx is done
+func (s *ScrobbleService) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		for s.flush(ctx) == scrobbleBatch {
+			// A full batch means there may be more due
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+type pendingScrobble struct {
+	id, userID, songID int64
+	destination        string
+	listenedAt         int64
+	attempts           int
+}
+
+// flush submits one batch of due scrobbles and returns how many it tried
+func (s *ScrobbleService) flush(ctx context.Context) int {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, song_id, destination, listened_at, attempts
+		FROM scrobble_outbox
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY listened_at
+		LIMIT ?
+	`, time.Now().Unix(), scrobbleBatch)
+	if err != nil {
+		log.Printf("scrobble outbox: %v", err)
+		return 0
+	}
+	var due []pendingScrobble
+	for rows.Next() {
+		var p pendingScrobble
+		if err := rows.Scan(&p.id, &p.userID, &p.songID, &p.destination, &p.listenedAt, &p.attempts); err == nil {
+			due = append(due, p)
+		}
+	}
+	rows.Close()
+
+	// Rate limits apply per account, so once one is hit the account's other
+	// scrobbles wait too
+	limited := map[string]time.Time{}
+	for _, p := range due {
+		key := fmt.Sprintf("%s/%d", p.destination, p.userID)
+		if until, ok := limited[key]; ok {
+			s.reschedule(ctx, p, until, "rate limited")
+			continue
+		}
+		err := s.submit(ctx, p)
+		var rateLimited *RateLimitError
+		switch {
+		case err == nil:
+			_, _ = s.db.ExecContext(ctx, `DELETE FROM scrobble_outbox WHERE id = ?`, p.id)
+		case errors.As(err, &rateLimited):
+			limited[key] = time.Now().Add(rateLimited.RetryAfter)
+			s.reschedule(ctx, p, limited[key], err.Error())
+		case permanentScrobbleError(err) || p.attempts+1 >= scrobbleMaxAttempts:
+			_, _ = s.db.ExecContext(ctx, `
+				UPDATE scrobble_outbox SET status = 'failed', attempts = attempts + 1, last_error = ? WHERE id = ?
+			`, err.Error(), p.id)
+		default:
+			backoff := min(scrobbleBaseBackoff<<p.attempts, scrobbleMaxBackoff)
+			s.reschedule(ctx, p, time.Now().Add(backoff), err.Error())
+		}
+	}
+	return len(due)
+}
+
+func (s *ScrobbleService) submit(ctx context.Context, p pendingScrobble) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	startedAt := time.Unix(p.listenedAt, 0)
+	switch {
+	case p.destination == ScrobbleListenBrainz && s.listenBrainz != nil:
+		return s.listenBrainz.SubmitListen(ctx, p.userID, p.songID, startedAt)
+	case p.destination == ScrobbleLastFM && s.lastFM != nil:
+		return s.lastFM.Scrobble(ctx, p.userID, p.songID, startedAt)
+	}
+	return fmt.Errorf("%s is disabled", p.destination)
+}
+
+func (s *ScrobbleService) reschedule(ctx context.Context, p pendingScrobble, at time.Time, message string) {
+	_, _ = s.db.ExecContext(ctx, `
+		UPDATE scrobble_outbox SET attempts = attempts + 1, next_attempt_at = ?, last_error = ? WHERE id = ?
+	`, at.Unix(), message, p.id)
+}
+
+// permanentScrobbleError reports whether retrying err can't help: the song
+// is gone, the account's credentials were rejected, or the service refused
+// the request itself.
+func permanentScrobbleError(err error) bool {
+	var status *statusError
+	var lfErr lastFMError
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, ErrListenBrainzToken):
+		return true
+	case errors.As(err, &status):
+		return status.code >= 400 && status.code < 500
+	case errors.As(err, &lfErr):
+		return lfErr.Code != lastFMOperationFailed && lfErr.Code != lastFMOffline && lfErr.Code != lastFMTemporary
+	}
+	return false
+}
+
+// Outbox returns the user's pending and failed scrobbles, oldest first
+func (s *ScrobbleService) Outbox(ctx context.Context, userID int64) ([]models.Scrobble, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.id, o.song_id, s.title, o.destination, o.listened_at, o.status, o.attempts, o.next_attempt_at, o.last_error
+		FROM scrobble_outbox o
+		LEFT JOIN songs s ON s.id = o.song_id
+		WHERE o.user_id = ?
+		ORDER BY o.listened_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scrobbles := []models.Scrobble{}
+	for rows.Next() {
+		var sc models.Scrobble
+		var title, lastError sql.NullString
+		var listenedAt, nextAttempt int64
+		if err := rows.Scan(&sc.ID, &sc.SongID, &title, &sc.Destination, &listenedAt, &sc.Status, &sc.Attempts, &nextAttempt, &lastError); err != nil {
+			return nil, err
+		}
+		sc.Title = title.String
+		sc.ListenedAt = time.Unix(listenedAt, 0).UTC()
+		if sc.Status == "pending" {
+			next := time.Unix(nextAttempt, 0).UTC()
+			sc.NextAttemptAt = &next
+		}
+		sc.LastError = lastError.String
+		scrobbles = append(scrobbles, sc)
+	}
+	return scrobbles, rows.Err()
+}
+
+// Replay retries the user's scrobbles with the given IDs, or all of them when
+// none are given, right away and with a fresh attempt count.
+func (s *ScrobbleService) Replay(ctx context.Context, userID int64, ids []int64) (int64, error) {
+	query := `UPDATE scrobble_outbox SET status = 'pending', attempts = 0, next_attempt_at = ? WHERE user_id = ?`
+	args := []any{time.Now().Unix(), userID}
+	if len(ids) > 0 {
+		query += ` AND id IN (` + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		s.notify()
+	}
+	return n, nil
+}
+
+// Discard removes one of the user's scrobbles without submitting it. It
+// returns sql.ErrNoRows when there is no such scrobble.
+func (s *ScrobbleService) Discard(ctx context.Context, userID, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM scrobble_outbox WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -22,14 +22,20 @@ type SearchResult struct {
 	Albums    []models.Album    `json:"albums"`
 	Artists   []models.Artist   `json:"artists"`
 	Playlists []models.Playlist `json:"playlists"`
+	// Genres is a facet over every song matching the query (ignoring the
+	// genre filter and pagination); SongCount is the number of matches.
+	Genres []models.Genre `json:"genres"`
 }
 
-func (s *SearchService) Search(ctx context.Context, q string, limit, offset int) (SearchResult, error) {
+// Search runs a search over the libraries readable with access. A non-zero
+// genreID restricts songs to that genre.
+func (s *SearchService) Search(ctx context.Context, access db.Access, q string, genreID int64, limit, offset int) (SearchResult, error) {
 	res := SearchResult{
 		Songs:     []models.Song{},
 		Albums:    []models.Album{},
 		Artists:   []models.Artist{},
 		Playlists: []models.Playlist{},
+		Genres:    []models.Genre{},
 	}
 	if q == "" {
 		return res, nil
@@ -42,9 +48,10 @@ func (s *SearchService) Search(ctx context.Context, q string, limit, offset int)
 		FROM songs_fts fts
 		JOIN songs s ON s.id = fts.rowid
 		JOIN albums al ON al.id = s.album_id
-		WHERE songs_fts MATCH ?
+		WHERE songs_fts MATCH ? AND `+access.Filter("s")+`
+		  AND (? = 0 OR s.id IN (SELECT song_id FROM song_genres WHERE genre_id = ?))
 		LIMIT ? OFFSET ?
-	`, q, limit, offset)
+	`, q, genreID, genreID, limit, offset)
 	if err != nil {
 		return res, fmt.Errorf("search songs: %w", err)
 	}
@@ -66,10 +73,30 @@ func (s *SearchService) Search(ctx context.Context, q string, limit, offset int)
 	// Populate artists for songs from song_artists
 	_ = db.PopulateSongArtists(ctx, s.db, res.Songs)
 
+	facetRows, err := s.db.QueryContext(ctx, `
+		SELECT g.id, g.name, COUNT(*) AS matches
+		FROM songs_fts fts
+		JOIN songs s ON s.id = fts.rowid
+		JOIN song_genres sg ON sg.song_id = s.id
+		JOIN genres g ON g.id = sg.genre_id
+		WHERE songs_fts MATCH ? AND `+access.Filter("s")+`
+		GROUP BY g.id
+		ORDER BY matches DESC, g.name COLLATE NOCASE
+	`, q)
+	if err == nil {
+		defer facetRows.Close()
+		for facetRows.Next() {
+			var g models.Genre
+			if err := facetRows.Scan(&g.ID, &g.Name, &g.SongCount); err == nil {
+				res.Genres = append(res.Genres, g)
+			}
+		}
+	}
+
 	// Artists
 	artistRows, err := s.db.QueryContext(ctx, `
 		SELECT id, name, bio, image_path, mbid, created_at
-		FROM artists WHERE name LIKE ? LIMIT ? OFFSET ?
+		FROM artists ar WHERE name LIKE ? AND `+access.ArtistFilter("ar")+` LIMIT ? OFFSET ?
 	`, "%"+q+"%", limit, offset)
 	if err == nil {
 		defer artistRows.Close()
@@ -92,11 +119,11 @@ func (s *SearchService) Search(ctx context.Context, q string, limit, offset int)
 	}
 	// Albums - join with artists
 	albumRows, err := s.db.QueryContext(ctx, `
-		SELECT al.id, al.artist_id, al.title, al.year, al.cover_path, al.mbid, al.created_at,
+		SELECT al.id, al.artist_id, al.title, al.year, al.cover_path, COALESCE(al.cover_color, ''), COALESCE(al.cover_blurhash, ''), al.mbid, al.created_at,
 		       ar.id, ar.name
 		FROM albums al
 		LEFT JOIN artists ar ON ar.id = al.artist_id
-		WHERE al.title LIKE ? LIMIT ? OFFSET ?
+		WHERE al.title LIKE ? AND `+access.Filter("al")+` LIMIT ? OFFSET ?
 	`, "%"+q+"%", limit, offset)
 	if err == nil {
 		defer albumRows.Close()
@@ -107,7 +134,7 @@ func (s *SearchService) Search(ctx context.Context, q string, limit, offset int)
 			var artistID sql.NullInt64
 			var artistName sql.NullString
 			var al models.Album
-			if err := albumRows.Scan(&al.ID, &albumArtistID, &al.Title, &year, &al.CoverPath, &mbid, &al.CreatedAt,
+			if err := albumRows.Scan(&al.ID, &albumArtistID, &al.Title, &year, &al.CoverPath, &al.CoverColor, &al.CoverBlurhash, &mbid, &al.CreatedAt,
 				&artistID, &artistName); err == nil {
 				if albumArtistID.Valid {
 					al.ArtistID = &albumArtistID.Int64
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Aunali321/korus/internal/db"
+)
+
+// smartRefreshDelay is how long plays are gathered before a user's smart
+// playlists are re-evaluated, so a listening session costs one refresh
+// rather than one per song
+const smartRefreshDelay = 30 * time.Second
+
+// SmartPlaylistRefresher re-evaluates a user's smart playlists in the
+// background after they play something, off the request that recorded it.
+type SmartPlaylistRefresher struct {
+	db      *sql.DB
+	mu      sync.Mutex
+	pending map[int64]time.Time // user ID to when their refresh is due
+}
+
+func NewSmartPlaylistRefresher(db *sql.DB) *SmartPlaylistRefresher {
+	return &SmartPlaylistRefresher{db: db, pending: map[int64]time.Time{}}
+}
+
+// Schedule queues a refresh of userID's smart playlists. Plays before it is
+// due are folded into it. Scheduling on a nil refresher does nothing.
+func (r *SmartPlaylistRefresher) Schedule(userID int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pending[userID]; !ok {
+		r.pending[userID] = time.Now().Add(smartRefreshDelay)
+	}
+}
+
+// Run refreshes smart playlists as they fall due until ctx is done
+func (r *SmartPlaylistRefresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(smartRefreshDelay / 6)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.flush(ctx)
+		}
+	}
+}
+
+func (r *SmartPlaylistRefresher) flush(ctx context.Context) {
+	now := time.Now()
+	var due []int64
+	r.mu.Lock()
+	for userID, at := range r.pending {
+		if !at.After(now) {
+			due = append(due, userID)
+			delete(r.pending, userID)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, userID := range due {
+		if err := db.RefreshSmartPlaylists(ctx, r.db, userID); err != nil && ctx.Err() == nil {
+			log.Printf("smart playlists for user %d: %v", userID, err)
+		}
+	}
+}